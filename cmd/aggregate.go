@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/dneil5648/dd-logs-cli/handlers"
+	"github.com/spf13/cobra"
+)
+
+var (
+	aggQuery   string
+	aggFrom    string
+	aggTo      string
+	aggGroupBy []string
+	aggCompute []string
+	aggLimit   int64
+	aggIndex   []string
+	aggFormat  string
+	aggOutput  string
+)
+
+var aggregateCmd = &cobra.Command{
+	Use:   "aggregate",
+	Short: "Group matching logs by facets and compute counts or measures",
+	Long: `Run a query through Datadog's Logs Aggregation API and write a small table
+of buckets instead of the logs themselves: one row per combination of the
+--group-by facets, with a column per --compute.
+
+Computes:
+  count                     Number of logs (the default)
+  cardinality:<facet>       Distinct values of a facet
+  sum|min|max|avg|median:<measure>
+  pc75|pc90|pc95|pc98|pc99:<measure>
+
+Facets and measures are written as in the Datadog UI: service, status,
+@http.status_code. Each facet keeps its top 10 values unless --limit is
+given; the top values are chosen by the first compute.`,
+	Example: `  # Errors per service over the last day
+  ddlogs aggregate -q "status:error" --group-by service --from 24h
+
+  # p99 latency and request count per service and status code, as JSON
+  ddlogs aggregate -q "service:api" --group-by service,@http.status_code \
+    --compute count --compute pc99:@duration --from 1h -f json`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		aggFormat = profileFormat(cmd, aggFormat)
+		if aggFormat != "csv" && aggFormat != "json" {
+			return fmt.Errorf("--format must be csv or json")
+		}
+		if aggLimit < 0 {
+			return fmt.Errorf("--limit must not be negative")
+		}
+		var computes []handlers.AggregateCompute
+		for _, spec := range aggCompute {
+			c, err := handlers.ParseCompute(spec)
+			if err != nil {
+				return fmt.Errorf("--compute: %w", err)
+			}
+			computes = append(computes, c)
+		}
+
+		handler, err := newHandler()
+		if err != nil {
+			return err
+		}
+		return handler.Aggregate(handlers.AggregateOptions{
+			Query:      profileQuery(aggQuery),
+			From:       aggFrom,
+			To:         aggTo,
+			OutputFile: aggOutput,
+			Quiet:      quiet,
+			Format:     aggFormat,
+			GroupBy:    aggGroupBy,
+			Compute:    computes,
+			Limit:      aggLimit,
+			Indexes:    aggIndex,
+		})
+	},
+}
+
+func init() {
+	addQueryFlags(aggregateCmd, &aggQuery, "Datadog logs query string", false)
+	aggregateCmd.Flags().StringVar(&aggFrom, "from", "15m", "Start of time range: a duration ago (15m, 24h, 7d, 2w, 1mo) or an absolute time (RFC 3339, date, epoch)")
+	aggregateCmd.Flags().StringVar(&aggTo, "to", "now", "End of time range: now, a duration ago, or an absolute time")
+	aggregateCmd.Flags().StringSliceVar(&aggGroupBy, "group-by", nil, "Facet to group by, e.g. service or @http.status_code (repeatable or comma-separated)")
+	aggregateCmd.Flags().StringArrayVar(&aggCompute, "compute", []string{"count"}, "Value per bucket: count, or function:measure such as avg:@duration (repeatable)")
+	aggregateCmd.Flags().Int64Var(&aggLimit, "limit", 0, "Values kept per group-by facet (0: the API default of 10)")
+	aggregateCmd.Flags().StringArrayVar(&aggIndex, "index", nil, "Only aggregate logs in this index (repeatable)")
+	aggregateCmd.Flags().StringVarP(&aggFormat, "format", "f", "csv", "Output format: csv or json")
+	aggregateCmd.Flags().StringVarP(&aggOutput, "output", "o", "", "Output file path (default: stdout)")
+	rootCmd.AddCommand(aggregateCmd)
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dneil5648/dd-logs-cli/handlers"
+	"github.com/spf13/cobra"
+)
+
+var (
+	archiveQuery   string
+	archiveDir     string
+	archiveFormat  string
+	archiveFrom    string
+	archiveLag     time.Duration
+	archiveKeep    string
+	archiveCompact string
+)
+
+var archiveCmd = &cobra.Command{
+	Use:   "archive",
+	Short: "Maintain a self-hosted log archive",
+	Long: `Keep a long-term archive of the logs matching a query, in a local
+directory or in S3, laid out for Athena, Spark, Trino, DuckDB and other
+engines that read Hive-style partitions.`,
+}
+
+var archiveRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Export new logs to the archive, compact it and apply retention",
+	Long: `Bring an archive up to date. Run it from cron or a scheduler as often as
+new logs should land; each run:
+
+  1. exports the logs since the last run, one part per UTC day, to
+     dt=YYYY-MM-DD/part-<start>.parquet (or .ndjson.gz). Each part's log
+     count is checked against Datadog's aggregation API, and the archive
+     only moves forward past parts that matched. The newest --lag of logs,
+     which Datadog may still be indexing, is left for the next run.
+  2. merges the parts smaller than --compact-below of each complete day
+     into one, so frequent runs do not leave thousands of small files.
+     Parquet parts are merged with the union of their columns.
+  3. deletes the days entirely older than --keep.
+
+The first run starts at --from; later runs continue from where the last one
+stopped, recorded in _archive.json in the archive. An archive holds one
+query in one format. A run that fails or is interrupted leaves the archive
+consistent, and the next run picks up from the last part written.
+
+--dir is a local directory or s3://<bucket>/<prefix>, using the AWS
+credentials and region of the environment.`,
+	Example: `  # Keep a year of production logs in S3, run every 15 minutes
+  ddlogs archive run -q "env:prod" --dir s3://acme-logs/prod --keep 365d --from 1d
+
+  # A local NDJSON archive of the last 30 days
+  ddlogs archive run -q "service:web" --dir ./web-archive -f ndjson --keep 30d --from 30d`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		archiveFormat = profileFormat(cmd, archiveFormat)
+		if archiveFormat != "parquet" && archiveFormat != "ndjson" {
+			return fmt.Errorf("--format must be parquet or ndjson")
+		}
+		if archiveLag < 0 {
+			return fmt.Errorf("--lag must not be negative")
+		}
+		var compactBelow int64
+		if archiveCompact != "0" {
+			var err error
+			if compactBelow, err = handlers.ParseByteSize(archiveCompact); err != nil {
+				return fmt.Errorf("--compact-below: %w", err)
+			}
+		}
+		handler, err := newHandler()
+		if err != nil {
+			return err
+		}
+		return handler.ArchiveRun(handlers.ArchiveOptions{
+			Query:        profileQuery(archiveQuery),
+			Dir:          archiveDir,
+			Format:       archiveFormat,
+			From:         archiveFrom,
+			Lag:          archiveLag,
+			Keep:         archiveKeep,
+			CompactBelow: compactBelow,
+			Quiet:        quiet,
+		})
+	},
+}
+
+func init() {
+	addQueryFlags(archiveRunCmd, &archiveQuery, "Datadog logs query string", false)
+	archiveRunCmd.Flags().StringVar(&archiveDir, "dir", "", "Archive location: a local directory or s3://<bucket>/<prefix> (required)")
+	archiveRunCmd.Flags().StringVarP(&archiveFormat, "format", "f", "parquet", "Part format: parquet or ndjson (gzip-compressed)")
+	archiveRunCmd.Flags().StringVar(&archiveFrom, "from", "1d", "Where the first run starts: a duration ago or an absolute time; later runs continue from the last")
+	archiveRunCmd.Flags().DurationVar(&archiveLag, "lag", handlers.DefaultArchiveLag, "Leave logs newer than this for the next run")
+	archiveRunCmd.Flags().StringVar(&archiveKeep, "keep", "", "Delete days older than this, e.g. 365d (default: keep everything)")
+	archiveRunCmd.Flags().StringVar(&archiveCompact, "compact-below", "64MB", "Merge the parts of a complete day smaller than this (0 disables)")
+	archiveRunCmd.MarkFlagRequired("dir")
+	archiveCmd.AddCommand(archiveRunCmd)
+	rootCmd.AddCommand(archiveCmd)
+}
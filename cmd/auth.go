@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/zalando/go-keyring"
+	"golang.org/x/term"
+)
+
+// keyringService is the service name ddlogs credentials are stored under in
+// the OS keyring. Each entry's user is "<profile>/<field>".
+const keyringService = "ddlogs"
+
+var (
+	authSite string
+
+	// keyringWarned keeps an unavailable keyring from warning once per key.
+	keyringWarned bool
+)
+
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "Manage stored Datadog credentials",
+}
+
+var authLoginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Store API and application keys in the OS keyring",
+	Long: `Prompt for a Datadog API key and application key and store them in the OS
+keyring (macOS Keychain, Windows Credential Manager, or the Secret Service on
+Linux) instead of plaintext environment variables or config files.
+
+Credentials are stored per profile: --profile (or DD_PROFILE) selects which,
+defaulting to "default". Every command then uses them whenever DD_API_KEY /
+DD_APP_KEY are not set and the config profile has no keys.`,
+	Example: `  ddlogs auth login
+  ddlogs auth login --profile eu --site datadoghq.eu`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		in := bufio.NewReader(os.Stdin)
+		apiKey, err := promptSecret(in, "API key: ")
+		if err != nil {
+			return err
+		}
+		appKey, err := promptSecret(in, "Application key: ")
+		if err != nil {
+			return err
+		}
+		if apiKey == "" || appKey == "" {
+			return fmt.Errorf("both keys are required")
+		}
+
+		for field, value := range map[string]string{"api_key": apiKey, "app_key": appKey, "site": authSite} {
+			if value == "" {
+				continue
+			}
+			if err := keyring.Set(keyringService, keyringUser(field), value); err != nil {
+				return fmt.Errorf("saving %s to the keyring: %w", field, err)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "Credentials for profile %q saved to the OS keyring\n", activeProfileName)
+		return nil
+	},
+}
+
+var authValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check that the API and application keys work and can read logs",
+	Long: `Check the credentials every command would use (environment, config profile
+or keyring) against Datadog, without running a query:
+
+  - whether the API key and the application key are valid for the site
+  - which organization they belong to
+  - whether the application key can read logs: its logs_read_data scope, or
+    for an unscoped key, the permissions of the user who owns it
+
+Exits non-zero if any check fails, which makes it useful for debugging 403
+responses and as a pre-flight step in scripts.`,
+	// A failed check is the report itself, not a usage mistake.
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		handler, err := newHandler()
+		if err != nil {
+			return err
+		}
+		r := handler.ValidateKeys()
+
+		ok := true
+		check := func(label string, good bool, detail string, err error) {
+			switch {
+			case err != nil:
+				fmt.Printf("%-16s %s\n", label, err)
+				ok = false
+			case good:
+				fmt.Printf("%-16s %s\n", label, detail)
+			default:
+				fmt.Printf("%-16s invalid\n", label)
+				ok = false
+			}
+		}
+
+		fmt.Printf("%-16s %s\n", "Site:", handler.Site)
+		check("API key:", r.APIKeyValid, "valid ("+redactKey(handler.ApiKey)+")", r.APIKeyErr)
+		appDetail := "valid (" + redactKey(handler.AppKey) + ")"
+		if r.AppKeyName != "" {
+			appDetail = fmt.Sprintf("valid (%s, %q)", redactKey(handler.AppKey), r.AppKeyName)
+		}
+		check("Application key:", r.AppKeyValid, appDetail, r.AppKeyErr)
+		check("Organization:", true, fmt.Sprintf("%s (public ID %s)", r.Org, r.OrgID), r.OrgErr)
+
+		if r.AppKeyValid {
+			how := "unscoped key, owner's roles"
+			if r.Scoped {
+				how = "key scopes: " + strings.Join(r.Scopes, ", ")
+			}
+			detail := "granted (" + how + ")"
+			if !r.LogsRead && r.ScopeErr == nil {
+				fmt.Printf("%-16s missing (%s)\n", "logs_read_data:", how)
+				ok = false
+			} else {
+				check("logs_read_data:", true, detail, r.ScopeErr)
+			}
+		}
+
+		if !ok {
+			return fmt.Errorf("credentials for profile %q failed validation", activeProfileName)
+		}
+		return nil
+	},
+}
+
+// redactKey shows only the last four characters of a key.
+func redactKey(key string) string {
+	if len(key) <= 4 {
+		return "****"
+	}
+	return "..." + key[len(key)-4:]
+}
+
+// promptSecret prints prompt to stderr and reads one line, without echo
+// when stdin is a terminal.
+func promptSecret(in *bufio.Reader, prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("reading input: %w", err)
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	line, err := in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("reading input: %w", err)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// keyringUser is the keyring entry name for field of the active profile.
+func keyringUser(field string) string {
+	return activeProfileName + "/" + field
+}
+
+// keyringValue reads field of the active profile from the keyring. A
+// missing entry or an unavailable keyring yields "".
+func keyringValue(field string) string {
+	v, err := keyring.Get(keyringService, keyringUser(field))
+	if err != nil && !errors.Is(err, keyring.ErrNotFound) && !keyringWarned {
+		fmt.Fprintf(os.Stderr, "warning: reading credentials from the keyring: %v\n", err)
+		keyringWarned = true
+	}
+	return v
+}
+
+func init() {
+	authLoginCmd.Flags().StringVar(&authSite, "site", "", "Datadog site to store with the keys (e.g. datadoghq.eu)")
+	authCmd.AddCommand(authLoginCmd)
+	authCmd.AddCommand(authValidateCmd)
+	rootCmd.AddCommand(authCmd)
+}
@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dneil5648/dd-logs-cli/handlers"
+	"github.com/spf13/cobra"
+)
+
+var (
+	backfillQuery      string
+	backfillFrom       string
+	backfillTo         string
+	backfillChunk      time.Duration
+	backfillSink       string
+	backfillFormat     string
+	backfillCheckpoint string
+	backfillLint       bool
+	backfillParallel   int
+	backfillWindow     string
+)
+
+var backfillCmd = &cobra.Command{
+	Use:   "backfill",
+	Short: "Export a long time range to a sink in checkpointed, verified chunks",
+	Long: `Export every log matching a query between two absolute times, one --chunk
+at a time, to a sink.
+
+Each chunk is written to a temporary file and renamed into place only once
+complete, so the sink never holds a partial chunk. After delivery the number
+of logs written is compared with the count reported by Datadog's aggregation
+API for the same window, and a checkpoint is saved. If the backfill is
+interrupted or fails, running the same command again resumes at the first
+chunk that was not delivered. A chunk committed just before the run stopped
+is recognized and not delivered twice.
+
+With --parallel N, N chunks are fetched and delivered at once, each by a
+worker with its own sink. The checkpoint records every delivered chunk, not
+just how far a single cursor got, so a parallel backfill that dies resumes
+only the chunks that are still missing.
+
+With --run-window "01:00-05:00 UTC", chunks are only started inside that
+daily window, to keep heavy backfills to off-peak hours. When the window
+closes, chunks in progress are finished and checkpointed and the backfill
+pauses until the window reopens, then carries on by itself. The zone is UTC,
+an IANA name (America/New_York) or omitted for local time; a window such as
+22:00-04:00 runs past midnight.
+
+When every chunk has been delivered a completeness report is printed. The
+command exits non-zero if any chunk's count did not match; running it again
+delivers those chunks again, replacing what the sink holds for them.
+
+Sinks:
+  file://<dir>   One file per chunk in dir, named after the chunk start
+                 (e.g. 20240501T000000Z.ndjson). The checkpoint is stored in
+                 dir/.backfill.json unless --checkpoint is given.
+  delta://<dir>  A Delta Lake table in dir, created if needed (-f parquet).
+                 Each chunk is one Parquet data file added in a commit of
+                 its own, so Spark, Trino, DuckDB and other lakehouse
+                 engines see it as soon as it is delivered. New attributes
+                 become new columns; attribute names with characters Delta
+                 does not allow (space ,;{}()=) have them replaced with _.
+                 The checkpoint is stored in dir/.backfill.json.
+  delta+s3://<bucket>/<prefix>
+                 The same, for a table in S3, using the AWS credentials of
+                 the environment. Commits use S3 conditional writes, so
+                 several backfills can add to one table at once.
+
+Other sinks can be registered with handlers.RegisterSink; they share the
+same batching, retries, checkpoint and count verification, and need an
+explicit --checkpoint.
+
+Times are absolute: RFC 3339 (2024-05-01T00:00:00Z), a UTC date
+(2024-05-01), or Unix epoch seconds/milliseconds.`,
+	Example: `  # One NDJSON file per hour for the first week of May
+  ddlogs backfill -q "service:web" --from 2024-05-01 --to 2024-05-08 --chunk 1h --sink file://./web-may
+
+  # Parquet, six-hour chunks
+  ddlogs backfill -q "env:prod" --from 2024-05-01T00:00:00Z --to 2024-05-02T00:00:00Z --chunk 6h -f parquet --sink file:///data/prod
+
+  # Append May to a Delta Lake table in S3
+  ddlogs backfill -q "env:prod" --from 2024-05-01 --to 2024-06-01 --chunk 1h -f parquet \
+    --sink delta+s3://archive/logs/prod --checkpoint prod-may.json
+
+  # A month in hourly chunks, eight at a time
+  ddlogs backfill -q "service:web" --from 2024-05-01 --to 2024-06-01 --chunk 1h --parallel 8 --sink file://./web-may
+
+  # Only run between 1am and 5am UTC, pausing in between
+  ddlogs backfill -q "env:prod" --from 2024-01-01 --to 2024-07-01 --chunk 1h --run-window "01:00-05:00 UTC" --sink file://./prod-h1`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		from, ok := handlers.ParseAbsoluteTime(backfillFrom)
+		if !ok {
+			return fmt.Errorf("--from must be an absolute time, got %q", backfillFrom)
+		}
+		to, ok := handlers.ParseAbsoluteTime(backfillTo)
+		if !ok {
+			return fmt.Errorf("--to must be an absolute time, got %q", backfillTo)
+		}
+		if !from.Before(to) {
+			return fmt.Errorf("--from must be before --to")
+		}
+		if backfillChunk <= 0 {
+			return fmt.Errorf("--chunk must be positive")
+		}
+		if backfillParallel < 1 {
+			return fmt.Errorf("--parallel must be at least 1")
+		}
+		var window *handlers.RunWindow
+		if backfillWindow != "" {
+			var err error
+			if window, err = handlers.ParseRunWindow(backfillWindow); err != nil {
+				return fmt.Errorf("--run-window: %w", err)
+			}
+		}
+		backfillFormat = profileFormat(cmd, backfillFormat)
+		if backfillFormat != "csv" && backfillFormat != "json" && backfillFormat != "ndjson" && backfillFormat != "parquet" {
+			return fmt.Errorf("--format must be csv, json, ndjson or parquet")
+		}
+
+		query := profileQuery(backfillQuery)
+		if err := lintQuery(query, backfillFrom, backfillTo, backfillLint); err != nil {
+			return err
+		}
+
+		handler, err := newHandler()
+		if err != nil {
+			return err
+		}
+		return handler.Backfill(handlers.BackfillOptions{
+			Query:      query,
+			From:       from,
+			To:         to,
+			Format:     backfillFormat,
+			Chunk:      backfillChunk,
+			Sink:       backfillSink,
+			Checkpoint: backfillCheckpoint,
+			Parallel:   backfillParallel,
+			RunWindow:  window,
+			Quiet:      quiet,
+		})
+	},
+}
+
+func init() {
+	addQueryFlags(backfillCmd, &backfillQuery, "Datadog logs query string", false)
+	backfillCmd.Flags().StringVar(&backfillFrom, "from", "", "Start of the range (required)")
+	backfillCmd.Flags().StringVar(&backfillTo, "to", "", "End of the range (required)")
+	backfillCmd.Flags().DurationVar(&backfillChunk, "chunk", time.Hour, "Size of each exported window")
+	backfillCmd.Flags().StringVar(&backfillSink, "sink", "", "Destination, e.g. file://./out (required)")
+	backfillCmd.Flags().StringVarP(&backfillFormat, "format", "f", "ndjson", "Chunk format: csv, json, ndjson or parquet")
+	backfillCmd.Flags().StringVar(&backfillCheckpoint, "checkpoint", "", "Checkpoint file (default <sink dir>/.backfill.json for file:// and delta:// sinks)")
+	backfillCmd.Flags().IntVar(&backfillParallel, "parallel", 1, "Chunks fetched and delivered at once")
+	backfillCmd.Flags().StringVar(&backfillWindow, "run-window", "", "Only start chunks inside this daily window, e.g. \"01:00-05:00 UTC\"")
+	backfillCmd.Flags().BoolVar(&backfillLint, "strict-query", false, "Refuse to run queries with expensive patterns instead of warning")
+	backfillCmd.MarkFlagRequired("from")
+	backfillCmd.MarkFlagRequired("to")
+	backfillCmd.MarkFlagRequired("sink")
+	rootCmd.AddCommand(backfillCmd)
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/dneil5648/dd-logs-cli/handlers"
+	"github.com/spf13/cobra"
+)
+
+var (
+	batchFile     string
+	batchParallel int
+	batchFrom     string
+	batchTo       string
+)
+
+var batchCmd = &cobra.Command{
+	Use:   "batch",
+	Short: "Run the exports listed in a YAML manifest",
+	Long: `Run a set of named exports from a YAML manifest, each with its own query,
+time range, format and output file, and print a summary of them all:
+
+  parallel: 3            # how many run at once (default 1)
+  defaults:              # filled into every query that leaves them out
+    from: 2024-05-01T14:00:00Z
+    to: 2024-05-01T16:00:00Z
+    format: csv
+  queries:
+    - name: checkout-errors
+      query: service:checkout status:error
+      output: review/checkout-errors.csv
+    - name: payments
+      query: service:payments
+      format: parquet
+      output: review/payments.parquet
+      indexes: [main]
+      limit: 100000
+      sort: desc
+
+Formats are csv, json, ndjson, parquet, xlsx and md; an output ending in .gz
+or .zst is compressed. Output directories are created as needed. A failing
+query does not stop the others, and batch exits non-zero if any failed.
+
+--from and --to replace the time range of every query, so one manifest can
+be rerun for each incident window.`,
+	Example: `  # Run the incident review exports
+  ddlogs batch -f incident-review.yaml
+
+  # The same exports for another incident, four at a time
+  ddlogs batch -f incident-review.yaml --from 2024-06-12T09:30:00Z --to 2024-06-12T11:00:00Z --parallel 4`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if batchParallel < 0 {
+			return fmt.Errorf("--parallel must not be negative")
+		}
+		manifest, err := handlers.LoadBatchManifest(batchFile)
+		if err != nil {
+			return err
+		}
+		for i := range manifest.Queries {
+			q := &manifest.Queries[i]
+			q.Query = profileQuery(q.Query)
+			if batchFrom != "" {
+				q.From = batchFrom
+			}
+			if batchTo != "" {
+				q.To = batchTo
+			}
+		}
+		handler, err := newHandler()
+		if err != nil {
+			return err
+		}
+		return handler.Batch(manifest, batchParallel, quiet)
+	},
+}
+
+func init() {
+	batchCmd.Flags().StringVarP(&batchFile, "file", "f", "", "YAML manifest listing the queries to run (required)")
+	batchCmd.Flags().IntVar(&batchParallel, "parallel", 0, "Queries to run at once (default: the manifest's parallel, or 1)")
+	batchCmd.Flags().StringVar(&batchFrom, "from", "", "Start of time range for every query, replacing the manifest's")
+	batchCmd.Flags().StringVar(&batchTo, "to", "", "End of time range for every query, replacing the manifest's")
+	batchCmd.MarkFlagRequired("file")
+	rootCmd.AddCommand(batchCmd)
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/dneil5648/dd-logs-cli/handlers"
+	"github.com/spf13/cobra"
+)
+
+var (
+	checkQuery string
+	checkFrom  string
+	checkTo    string
+	checkIndex []string
+	checkWarn  int64
+	checkCrit  int64
+)
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Alert when more logs match a query than a threshold allows",
+	Long: `Count the logs matching a query, like count, and compare the count with
+thresholds, as a Nagios-style check for cron, Nagios, Icinga, Sensu or any
+monitoring agent that runs plugins.
+
+One status line is printed on stdout, with the count as performance data:
+
+  LOGS CRITICAL - 14 logs match "status:error service:payments" in the last 5m (critical above 10) | count=14;5;10;0;
+
+The exit code is the result:
+
+  0  OK        the count is at most --warn-count and --max-count
+  1  WARNING   the count is above --warn-count
+  2  CRITICAL  the count is above --max-count
+  3  UNKNOWN   the check could not run, e.g. a flag is missing or invalid,
+               the config is unreadable or the API failed; the status
+               line gives the reason
+
+At least one of --warn-count and --max-count is required.`,
+	Example: `  # Critical above 10 payment errors in the last 5 minutes
+  ddlogs check -q "status:error service:payments" --from 5m --max-count 10
+
+  # Warn above 100 timeouts in the last hour, critical above 500
+  ddlogs check -q "service:api timeout" --from 1h --warn-count 100 --max-count 500`,
+	// Every outcome, failures included, is a status line and an exit code;
+	// monitoring agents read nothing else. Errors cobra raises before RunE,
+	// such as a bad flag, config or --query-file, are turned into UNKNOWN by
+	// Execute.
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// An unset threshold is disabled.
+		warning, critical := int64(-1), int64(-1)
+		if cmd.Flags().Changed("warn-count") {
+			warning = checkWarn
+		}
+		if cmd.Flags().Changed("max-count") {
+			critical = checkCrit
+		}
+		if checkWarn < 0 || checkCrit < 0 {
+			return checkUnknown(fmt.Errorf("--warn-count and --max-count must not be negative"))
+		}
+		if warning >= 0 && critical >= 0 && warning > critical {
+			return checkUnknown(fmt.Errorf("--warn-count must not be above --max-count"))
+		}
+		handler, err := newHandler()
+		if err != nil {
+			return checkUnknown(err)
+		}
+		state, err := handler.Check(handlers.CheckOptions{
+			Query:    profileQuery(checkQuery),
+			From:     checkFrom,
+			To:       checkTo,
+			Indexes:  checkIndex,
+			Warning:  warning,
+			Critical: critical,
+		})
+		if err != nil {
+			return checkUnknown(err)
+		}
+		if state != handlers.CheckOK {
+			return exitCodeError{code: state}
+		}
+		return nil
+	},
+}
+
+// checkUnknown prints err as an UNKNOWN status line and ends check with exit
+// code 3.
+func checkUnknown(err error) error {
+	handlers.PrintCheckUnknown(err)
+	return exitCodeError{code: handlers.CheckUnknown}
+}
+
+func init() {
+	addQueryFlags(checkCmd, &checkQuery, "Datadog logs query string", false)
+	checkCmd.Flags().StringVar(&checkFrom, "from", "5m", "Start of time range: a duration ago (5m, 1h, 1d) or an absolute time")
+	checkCmd.Flags().StringVar(&checkTo, "to", "now", "End of time range: now, a duration ago, or an absolute time")
+	checkCmd.Flags().StringArrayVar(&checkIndex, "index", nil, "Only count logs in this index (repeatable)")
+	checkCmd.Flags().Int64Var(&checkWarn, "warn-count", 0, "Exit 1 (WARNING) when more logs than this match")
+	checkCmd.Flags().Int64Var(&checkCrit, "max-count", 0, "Exit 2 (CRITICAL) when more logs than this match")
+	checkCmd.MarkFlagsOneRequired("warn-count", "max-count")
+	rootCmd.AddCommand(checkCmd)
+}
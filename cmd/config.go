@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dneil5648/dd-logs-cli/handlers"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// profile is one named set of credentials and defaults from the config file.
+type profile struct {
+	Site   string `mapstructure:"site"`
+	APIKey string `mapstructure:"api_key"`
+	AppKey string `mapstructure:"app_key"`
+
+	// Format is the output format used when --format is not given.
+	Format string `mapstructure:"format"`
+
+	// QueryPrefix is ANDed with every query sent to Datadog, e.g. "env:prod".
+	QueryPrefix string `mapstructure:"query_prefix"`
+
+	// FallbackSites are sites the org is replicated to, tried in order when
+	// log queries against Site keep failing with 5xx.
+	FallbackSites []string `mapstructure:"fallback_sites"`
+}
+
+var (
+	configFile    string
+	profileName   string
+	activeProfile profile
+
+	// activeProfileName names the selected profile, even when the config
+	// file does not define it; keyring entries are stored under it.
+	activeProfileName = "default"
+
+	// profileChosen is set when --profile or DD_PROFILE picked the profile,
+	// in which case only its own credentials are used, never DD_* variables.
+	profileChosen bool
+
+	// profileFound is set when the active profile is defined in the config
+	// file or has credentials in the keyring.
+	profileFound bool
+)
+
+// defaultConfigFile returns ~/.ddlogs/config.yaml.
+func defaultConfigFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".ddlogs", "config.yaml")
+	}
+	return filepath.Join(home, ".ddlogs", "config.yaml")
+}
+
+// loadConfig reads the config file, if there is one, and selects the
+// profile named by --profile, DD_PROFILE, default_profile or "default", in
+// that order. A missing file or default profile is not an error, since
+// credentials can still come from environment variables or the keyring, but
+// a profile picked with --profile or DD_PROFILE must be defined in the file
+// or the keyring, unless newProfile is set for a command that creates it.
+//
+//	default_profile: prod
+//	profiles:
+//	  prod:
+//	    site: datadoghq.com
+//	    api_key: ...
+//	    app_key: ...
+//	    format: json
+//	    query_prefix: env:prod
+//	    fallback_sites: [datadoghq.eu]
+func loadConfig(newProfile bool) error {
+	v := viper.New()
+	v.SetConfigFile(configFile)
+	v.SetConfigType("yaml")
+	name := firstNonEmpty(profileName, os.Getenv("DD_PROFILE"))
+	profileChosen = name != ""
+	activeProfileName = firstNonEmpty(name, "default")
+
+	if err := v.ReadInConfig(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("reading config %s: %w", configFile, err)
+	} else if err == nil {
+		if name == "" {
+			name = firstNonEmpty(v.GetString("default_profile"), "default")
+			activeProfileName = name
+		}
+		if v.IsSet("profiles." + name) {
+			if err := v.UnmarshalKey("profiles."+name, &activeProfile); err != nil {
+				return fmt.Errorf("decoding profile %q: %w", name, err)
+			}
+			profileFound = true
+		}
+	}
+
+	if !profileFound && profileChosen {
+		// The profile may exist only in the keyring (ddlogs auth login).
+		profileFound = keyringValue("api_key") != "" || keyringValue("app_key") != "" || keyringValue("site") != ""
+	}
+	if !profileFound && profileChosen && !newProfile {
+		return profileNotFound()
+	}
+	return nil
+}
+
+// profileNotFound is the error for a profile picked with --profile or
+// DD_PROFILE that neither the config file nor the keyring defines.
+func profileNotFound() error {
+	return fmt.Errorf("profile %q not found in %s or the OS keyring", activeProfileName, configFile)
+}
+
+// profileQuery applies the active profile's query prefix to query.
+func profileQuery(query string) string {
+	if activeProfile.QueryPrefix == "" {
+		return query
+	}
+	return handlers.AndQuery(activeProfile.QueryPrefix, query)
+}
+
+// profileFormat returns the active profile's format when the command's
+// --format flag was not given explicitly, and format otherwise.
+func profileFormat(cmd *cobra.Command, format string) string {
+	if activeProfile.Format == "" || cmd.Flags().Changed("format") {
+		return format
+	}
+	return activeProfile.Format
+}
+
+// firstNonEmpty returns the first of values that is not empty.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"github.com/dneil5648/dd-logs-cli/handlers"
+	"github.com/spf13/cobra"
+)
+
+var (
+	countQuery string
+	countFrom  string
+	countTo    string
+	countIndex []string
+)
+
+var countCmd = &cobra.Command{
+	Use:   "count",
+	Short: "Print how many logs match a query",
+	Long: `Print the number of logs matching a query in a time range, using one
+request to Datadog's aggregation API. No events are downloaded, so counting
+a day of errors costs the same as counting a minute of them.
+
+The count is printed alone on stdout, for use in scripts.`,
+	Example: `  # How many errors in the last 24 hours
+  ddlogs count -q "status:error" --from 24h
+
+  # Only in the main index
+  ddlogs count -q "service:auth" --from 7d --index main`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		handler, err := newHandler()
+		if err != nil {
+			return err
+		}
+		return handler.Count(handlers.CountOptions{
+			Query:   profileQuery(countQuery),
+			From:    countFrom,
+			To:      countTo,
+			Indexes: countIndex,
+		})
+	},
+}
+
+func init() {
+	addQueryFlags(countCmd, &countQuery, "Datadog logs query string", false)
+	countCmd.Flags().StringVar(&countFrom, "from", "15m", "Start of time range: a duration ago (15m, 24h, 7d, 2w, 1mo) or an absolute time (RFC 3339, date, epoch)")
+	countCmd.Flags().StringVar(&countTo, "to", "now", "End of time range: now, a duration ago, or an absolute time")
+	countCmd.Flags().StringArrayVar(&countIndex, "index", nil, "Only count logs in this index (repeatable)")
+	rootCmd.AddCommand(countCmd)
+}
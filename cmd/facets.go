@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/dneil5648/dd-logs-cli/handlers"
+	"github.com/spf13/cobra"
+)
+
+var (
+	facetsQuery  string
+	facetsFrom   string
+	facetsTo     string
+	facetsIndex  []string
+	facetsSample int
+	facetsFormat string
+	facetsOutput string
+)
+
+var facetsCmd = &cobra.Command{
+	Use:   "facets",
+	Short: "List the attributes and tags found in matching logs",
+	Long: `List the fields of the logs matching a query, to find the names to use in
+queries and --columns without opening the Datadog UI:
+
+  NAME                SOURCE     TYPE    LOGS    SERVICES          EXAMPLE
+  host                reserved   string  100%    web,api,checkout  i-0a1b2c3d
+  env                 tag        string  100%    web,api,checkout  prod
+  @http.status_code   attribute  number  62.5%   web,api           200
+  @usr.id             attribute  string  8.1%    checkout          u_1842
+
+Reserved attributes (host, service, status) come first, then tag keys, then
+every attribute path, with nested objects spelled out with dots. Each has
+the types of its values (several when logs disagree), the share of sampled
+logs carrying it, the services it came from (most first) and an example.
+
+In a query, an attribute is @name:value and a tag name:value; in --columns
+an attribute is @name and a tag tag:name.
+
+The Logs API has no endpoint listing an org's facets, so they are discovered
+from --sample logs spread evenly over the time range, like search
+--discover-schema. Fields only a few logs carry may be missed: widen the
+range, raise --sample, or narrow the query to the logs of interest.
+
+Formats: table (default), csv, json.`,
+	Example: `  # Fields of the last day's logs
+  ddlogs facets
+
+  # Fields of one service, as JSON
+  ddlogs facets -q "service:checkout" --from 7d -f json
+
+  # A larger sample, for fields rare logs carry
+  ddlogs facets -q "source:nginx" --sample 5000 -o nginx-facets.csv -f csv`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if facetsFormat != "table" && facetsFormat != "csv" && facetsFormat != "json" {
+			return fmt.Errorf("invalid format %q: must be table, csv or json", facetsFormat)
+		}
+		if facetsSample < 1 {
+			return fmt.Errorf("--sample must be at least 1")
+		}
+		query := facetsQuery
+		if query == "" {
+			query = "*"
+		}
+		handler, err := newHandler()
+		if err != nil {
+			return err
+		}
+		return handler.Facets(handlers.FacetsOptions{
+			Query:      profileQuery(query),
+			From:       facetsFrom,
+			To:         facetsTo,
+			Indexes:    facetsIndex,
+			Sample:     facetsSample,
+			Format:     facetsFormat,
+			OutputFile: facetsOutput,
+			Quiet:      quiet,
+		})
+	},
+}
+
+func init() {
+	addQueryFlags(facetsCmd, &facetsQuery, "Datadog logs query string selecting the logs to sample (default: all logs)", true)
+	facetsCmd.Flags().StringVar(&facetsFrom, "from", "24h", "Start of time range: a duration ago (15m, 24h, 7d, 2w, 1mo) or an absolute time (RFC 3339, date, epoch)")
+	facetsCmd.Flags().StringVar(&facetsTo, "to", "now", "End of time range: now, a duration ago, or an absolute time")
+	facetsCmd.Flags().StringArrayVar(&facetsIndex, "index", nil, "Only sample logs in this index (repeatable)")
+	facetsCmd.Flags().IntVar(&facetsSample, "sample", handlers.DefaultDiscoverSample, "Number of logs to sample across the time range")
+	facetsCmd.Flags().StringVarP(&facetsFormat, "format", "f", "table", "Output format: table, csv, json")
+	facetsCmd.Flags().StringVarP(&facetsOutput, "output", "o", "", "Output file path (default: stdout)")
+	rootCmd.AddCommand(facetsCmd)
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/dneil5648/dd-logs-cli/grpcapi"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	grpccreds "google.golang.org/grpc/credentials"
+)
+
+var (
+	grpcListen string
+	grpcToken  string
+	grpcCert   string
+	grpcKey    string
+)
+
+var grpcServeCmd = &cobra.Command{
+	Use:   "grpc-serve",
+	Short: "Serve log searches over gRPC with this profile's credentials",
+	Long: `Run a gRPC server that searches Datadog logs on behalf of its clients, with
+the active profile's API and application keys. Internal services query logs
+through this one credentialed gateway instead of each holding Datadog keys.
+
+The service is ddlogs.v1.Logs, defined in grpcapi/ddlogs.proto. Its
+QueryLogs RPC takes a query, a --from/--to style window, indexes, a limit and
+a sort order, and streams the results back as they are fetched: one message
+per page of structured logs or, with format set to csv, json or ndjson, the
+export ddlogs search would write, in chunks. Fetching uses the same pipeline
+as search, with its retries, rate-limit handling and fallback sites; a client
+that cancels stops the fetch.
+
+Access:
+  --token (or DDLOGS_GRPC_TOKEN) requires every call to send the metadata
+  "authorization: Bearer <token>". --tls-cert and --tls-key serve TLS.
+  Without them anyone who can reach --listen searches with your keys, so
+  bind to localhost or a private network.
+
+Every call is logged to stderr when it ends. Ctrl-C or SIGTERM stops
+accepting calls and waits for running ones to finish.`,
+	Example: `  # Serve on localhost:50051
+  ddlogs grpc-serve
+
+  # Serve a private network with TLS and a shared token
+  DDLOGS_GRPC_TOKEN=s3cret ddlogs grpc-serve --listen 10.0.0.5:50051 --tls-cert server.crt --tls-key server.key
+
+  # Query it with grpcurl
+  grpcurl -plaintext -import-path grpcapi -proto ddlogs.proto \
+    -d '{"query": "service:api status:error", "from": "1h"}' \
+    localhost:50051 ddlogs.v1.Logs/QueryLogs`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if (grpcCert == "") != (grpcKey == "") {
+			return fmt.Errorf("--tls-cert and --tls-key must be used together")
+		}
+		if grpcToken == "" {
+			grpcToken = os.Getenv("DDLOGS_GRPC_TOKEN")
+		}
+		handler, err := newHandler()
+		if err != nil {
+			return err
+		}
+
+		var opts []grpc.ServerOption
+		if grpcCert != "" {
+			creds, err := grpccreds.NewServerTLSFromFile(grpcCert, grpcKey)
+			if err != nil {
+				return fmt.Errorf("loading TLS certificate: %w", err)
+			}
+			opts = append(opts, grpc.Creds(creds))
+		}
+		if grpcToken != "" {
+			opts = append(opts, grpc.StreamInterceptor(grpcapi.TokenAuth(grpcToken)))
+		}
+		server := grpc.NewServer(opts...)
+		grpcapi.RegisterLogsServer(server, grpcapi.NewServer(handler))
+
+		lis, err := net.Listen("tcp", grpcListen)
+		if err != nil {
+			return err
+		}
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(sigs)
+		go func() {
+			<-sigs
+			fmt.Fprintln(os.Stderr, "Shutting down; waiting for running calls")
+			server.GracefulStop()
+		}()
+
+		fmt.Fprintf(os.Stderr, "Serving ddlogs.v1.Logs on %s (profile %s)\n", lis.Addr(), activeProfileName)
+		return server.Serve(lis)
+	},
+}
+
+func init() {
+	grpcServeCmd.Flags().StringVar(&grpcListen, "listen", "localhost:50051", "Address to serve on")
+	grpcServeCmd.Flags().StringVar(&grpcToken, "token", "", "Require this bearer token on every call (default $DDLOGS_GRPC_TOKEN)")
+	grpcServeCmd.Flags().StringVar(&grpcCert, "tls-cert", "", "Serve TLS with this certificate (PEM)")
+	grpcServeCmd.Flags().StringVar(&grpcKey, "tls-key", "", "Private key for --tls-cert (PEM)")
+	rootCmd.AddCommand(grpcServeCmd)
+}
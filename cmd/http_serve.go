@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dneil5648/dd-logs-cli/httpapi"
+	"github.com/spf13/cobra"
+)
+
+var (
+	httpListen   string
+	httpTokens   []string
+	httpMaxLimit int
+	httpMaxTime  time.Duration
+	httpCert     string
+	httpKey      string
+)
+
+var httpServeCmd = &cobra.Command{
+	Use:   "http-serve",
+	Short: "Serve log searches over HTTP as NDJSON or Server-Sent Events",
+	Long: `Run an HTTP server that searches Datadog logs on behalf of its clients, with
+the active profile's API and application keys: a small self-hosted log
+export service for dashboards and scripts that hold no Datadog keys.
+
+POST /query with a JSON body:
+
+  {"query": "service:api status:error", "from": "1h", "to": "now",
+   "indexes": ["main"], "limit": 1000, "descending": false,
+   "format": "ndjson"}
+
+Only query is required; from and to take --from/--to syntax (default 15m
+and now). Results stream back as they are fetched, page by page, using the
+same pipeline as search with its retries and rate-limit handling:
+
+  ndjson   one log per line, as the Logs API returns it (the default)
+  sse      Server-Sent Events: a "log" event per log, then "done" with the
+           count; also chosen by Accept: text/event-stream
+
+A failure after the first log is reported in the body, as a final
+{"error": ...} line or an "error" event, since the 200 status was already
+sent. GET /healthz answers "ok".
+
+Limits and Access:
+  --max-limit caps the logs per request, also for requests that ask for
+  none; --max-time ends requests that stream longer. --token (repeatable,
+  or DDLOGS_HTTP_TOKEN, comma-separated) requires "Authorization: Bearer
+  <token>" on every query; --tls-cert and --tls-key serve HTTPS. Without a
+  token anyone who can reach --listen searches with your keys, so bind to
+  localhost or a private network.
+
+Every request is logged to stderr when it ends. Ctrl-C or SIGTERM stops
+accepting requests and waits for running ones to finish.`,
+	Example: `  # Serve on localhost:8080
+  ddlogs http-serve
+
+  # Stream NDJSON with curl
+  curl -N localhost:8080/query -d '{"query": "service:api status:error", "from": "1h"}'
+
+  # Server-Sent Events, for a browser EventSource polyfill or a dashboard
+  curl -N -H 'Accept: text/event-stream' localhost:8080/query -d '{"query": "env:prod", "limit": 100}'
+
+  # Shared service with tokens and caps
+  ddlogs http-serve --listen :8443 --token "$DASH_TOKEN" --token "$CRON_TOKEN" \
+    --max-limit 50000 --max-time 5m --tls-cert server.crt --tls-key server.key`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if (httpCert == "") != (httpKey == "") {
+			return fmt.Errorf("--tls-cert and --tls-key must be used together")
+		}
+		if httpMaxLimit < 0 || httpMaxTime < 0 {
+			return fmt.Errorf("--max-limit and --max-time must not be negative")
+		}
+		if len(httpTokens) == 0 && os.Getenv("DDLOGS_HTTP_TOKEN") != "" {
+			httpTokens = strings.Split(os.Getenv("DDLOGS_HTTP_TOKEN"), ",")
+		}
+		for _, t := range httpTokens {
+			if strings.TrimSpace(t) == "" {
+				return fmt.Errorf("--token must not be empty")
+			}
+		}
+		handler, err := newHandler()
+		if err != nil {
+			return err
+		}
+
+		server := &http.Server{
+			Handler: httpapi.NewServer(handler, httpapi.Config{
+				Tokens:      httpTokens,
+				MaxLimit:    httpMaxLimit,
+				MaxDuration: httpMaxTime,
+			}),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		lis, err := net.Listen("tcp", httpListen)
+		if err != nil {
+			return err
+		}
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(sigs)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			<-sigs
+			fmt.Fprintln(os.Stderr, "Shutting down; waiting for running requests")
+			server.Shutdown(context.Background())
+		}()
+
+		scheme := "http"
+		if httpCert != "" {
+			scheme = "https"
+		}
+		fmt.Fprintf(os.Stderr, "Serving POST %s://%s/query (profile %s)\n", scheme, lis.Addr(), activeProfileName)
+		if httpCert != "" {
+			err = server.ServeTLS(lis, httpCert, httpKey)
+		} else {
+			err = server.Serve(lis)
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		<-done
+		return nil
+	},
+}
+
+func init() {
+	httpServeCmd.Flags().StringVar(&httpListen, "listen", "localhost:8080", "Address to serve on")
+	httpServeCmd.Flags().StringArrayVar(&httpTokens, "token", nil, "Require this bearer token on every query (repeatable; default $DDLOGS_HTTP_TOKEN, comma-separated)")
+	httpServeCmd.Flags().IntVar(&httpMaxLimit, "max-limit", 100000, "Most logs returned per request, also for requests without a limit (0: no cap)")
+	httpServeCmd.Flags().DurationVar(&httpMaxTime, "max-time", 15*time.Minute, "End requests that stream longer than this (0: no limit)")
+	httpServeCmd.Flags().StringVar(&httpCert, "tls-cert", "", "Serve HTTPS with this certificate (PEM)")
+	httpServeCmd.Flags().StringVar(&httpKey, "tls-key", "", "Private key for --tls-cert (PEM)")
+	rootCmd.AddCommand(httpServeCmd)
+}
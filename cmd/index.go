@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var (
+	indexQuery string
+	indexFrom  string
+	indexTo    string
+	indexDir   string
+)
+
+var indexCmd = &cobra.Command{
+	Use:   "index",
+	Short: "Download logs into a local full-text index",
+	Long: `Download Datadog logs matching a query into a local Bleve full-text index.
+
+Pages are fetched exactly as with search, but instead of being written to a
+file they are indexed on disk under --index-dir. Once a large incident window
+has been downloaded, "ddlogs local search" can explore it repeatedly without
+calling the Datadog API again.
+
+Running index again against the same directory adds to the existing index.
+Logs are keyed by their Datadog ID, so overlapping windows do not create
+duplicates.
+
+Time Range (--from / --to):
+  Same relative durations as search, e.g. --from 24h --to 1h.`,
+	Example: `  # Index the last 6 hours of errors for offline exploration
+  ddlogs index -q "status:error" --from 6h --index-dir ./incident-idx
+
+  # Then search it locally
+  ddlogs local search -q "message:timeout" --index-dir ./incident-idx`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		handler, err := newHandler()
+		if err != nil {
+			return err
+		}
+		return handler.Index(profileQuery(indexQuery), indexFrom, indexTo, indexDir, quiet)
+	},
+}
+
+func init() {
+	addQueryFlags(indexCmd, &indexQuery, "Datadog logs query string", false)
+	indexCmd.Flags().StringVar(&indexFrom, "from", "15m", "Start of time range as a relative duration (e.g. 15m, 1h, 24h, 72h)")
+	indexCmd.Flags().StringVar(&indexTo, "to", "now", "End of time range (e.g. 5m, now)")
+	indexCmd.Flags().StringVar(&indexDir, "index-dir", "", "Directory of the local index (required)")
+	indexCmd.MarkFlagRequired("index-dir")
+	rootCmd.AddCommand(indexCmd)
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/dneil5648/dd-logs-cli/handlers"
+	"github.com/spf13/cobra"
+)
+
+var (
+	invQuery    string
+	invFrom     string
+	invTo       string
+	invOutput   string
+	invTemplate string
+	invPrint    bool
+	invLimit    int
+	invTop      int
+	invBucket   time.Duration
+	invTZ       string
+	invRedact   []string
+	invNoRedact bool
+)
+
+var investigateCmd = &cobra.Command{
+	Use:   "investigate",
+	Short: "Render matching logs into a Markdown investigation brief",
+	Long: `Read the newest logs matching a query and write a Markdown investigation
+brief, ready for pasting into an LLM or a postmortem document:
+
+  - the window, how many logs matched and how many were errors
+  - a timeline of logs and errors per interval (--bucket, or about 24 rows)
+  - the top error patterns: messages of status error, critical, alert,
+    emergency or fatal, grouped with numbers, IDs, IP addresses and quoted
+    values masked, with their services, hosts, first and last occurrence
+    and the newest example
+  - the hosts and services with the most errors
+
+Up to --limit logs are read, newest first; the match count covers the whole
+window. Emails, card numbers, AWS access key IDs, bearer tokens and key=value
+secrets are replaced with [REDACTED] before anything is counted or quoted;
+--redact adds patterns and --no-default-redact keeps only those.
+
+--prompt-template renders the brief with a Go text/template of your own
+instead, for a team's postmortem layout or an LLM prompt around the data.
+Start from the built-in one with --print-template. It can use .Query,
+.From, .To, .Matched, .Analyzed, .Truncated, .Errors, .FirstError,
+.LastError, .Timeline (.Start, .Count, .Errors), .TopErrors (.Pattern,
+.Count, .Services, .Hosts, .First, .Last, .Example, .ExampleID), .Statuses,
+.Hosts and .Services (.Name, .Count, .Errors, .Services), and the functions
+md (escape for a table cell), join, inc, pct, bar and json.`,
+	Example: `  # Brief on the last hour of checkout errors
+  ddlogs investigate -q "service:checkout status:error" --from 1h -o brief.md
+
+  # Start a postmortem from the team's own layout
+  ddlogs investigate --print-template > postmortem.md.tmpl
+  ddlogs investigate -q "env:prod" --from 2024-05-01T14:00:00Z --to 2024-05-01T16:00:00Z \
+    --prompt-template postmortem.md.tmpl --tz America/New_York -o postmortem.md`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if invPrint {
+			fmt.Print(handlers.DefaultBriefTemplate)
+			return nil
+		}
+		if invQuery == "" {
+			return fmt.Errorf("required flag \"query\" or \"query-file\" not set")
+		}
+		if invLimit < 0 || invTop < 0 || invBucket < 0 {
+			return fmt.Errorf("--limit, --top and --bucket must not be negative")
+		}
+		opts := handlers.InvestigateOptions{
+			From:       invFrom,
+			To:         invTo,
+			OutputFile: invOutput,
+			Limit:      invLimit,
+			Top:        invTop,
+			Bucket:     invBucket,
+		}
+		if invTemplate != "" {
+			text, err := os.ReadFile(invTemplate)
+			if err != nil {
+				return fmt.Errorf("--prompt-template: %w", err)
+			}
+			if opts.Template, err = handlers.ParseBriefTemplate(string(text)); err != nil {
+				return fmt.Errorf("--prompt-template: %w", err)
+			}
+		}
+		if invTZ != "" {
+			var err error
+			if opts.TimeZone, err = handlers.ParseTimeZone(invTZ); err != nil {
+				return fmt.Errorf("--tz: %w", err)
+			}
+		}
+		if !invNoRedact {
+			opts.Redact = append(opts.Redact, handlers.DefaultRedactions...)
+		}
+		for _, p := range invRedact {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return fmt.Errorf("--redact: %w", err)
+			}
+			opts.Redact = append(opts.Redact, re)
+		}
+		handler, err := newHandler()
+		if err != nil {
+			return err
+		}
+		opts.Query = profileQuery(invQuery)
+		return handler.Investigate(opts)
+	},
+}
+
+func init() {
+	addQueryFlags(investigateCmd, &invQuery, "Datadog logs query string", true)
+	investigateCmd.Flags().StringVar(&invFrom, "from", "1h", "Start of time range: a duration ago (15m, 24h, 7d, 2w, 1mo) or an absolute time (RFC 3339, date, epoch)")
+	investigateCmd.Flags().StringVar(&invTo, "to", "now", "End of time range: now, a duration ago, or an absolute time")
+	investigateCmd.Flags().StringVarP(&invOutput, "output", "o", "", "Output file path (default: stdout)")
+	investigateCmd.Flags().StringVar(&invTemplate, "prompt-template", "", "Go template file to render the brief with instead of the built-in one")
+	investigateCmd.Flags().BoolVar(&invPrint, "print-template", false, "Print the built-in template, as a starting point for --prompt-template, and exit")
+	investigateCmd.Flags().IntVar(&invLimit, "limit", handlers.DefaultInvestigateLimit, "Most logs to read, newest first")
+	investigateCmd.Flags().IntVar(&invTop, "top", 10, "Error patterns, hosts and services to list")
+	investigateCmd.Flags().DurationVar(&invBucket, "bucket", 0, "Timeline interval, e.g. 5m (default: about 24 rows)")
+	investigateCmd.Flags().StringVar(&invTZ, "tz", "", "Show times in this time zone: an IANA name such as America/New_York, local or UTC")
+	investigateCmd.Flags().StringArrayVar(&invRedact, "redact", nil, "Also replace matches of this regular expression with [REDACTED] (repeatable)")
+	investigateCmd.Flags().BoolVar(&invNoRedact, "no-default-redact", false, "Do not redact emails, card numbers, keys and tokens; only --redact patterns")
+	rootCmd.AddCommand(investigateCmd)
+}
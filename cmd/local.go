@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/dneil5648/dd-logs-cli/handlers"
+	"github.com/spf13/cobra"
+)
+
+var (
+	localQuery    string
+	localIndexDir string
+	localLimit    int
+	localOutput   string
+	localFormat   string
+
+	localArchiveQuery string
+	localArchiveDir   string
+	localFrom         string
+	localTo           string
+)
+
+var localCmd = &cobra.Command{
+	Use:   "local",
+	Short: "Work with logs downloaded to a local index or archive",
+}
+
+var localSearchCmd = &cobra.Command{
+	Use:   "search",
+	Short: "Search a local index built by ddlogs index",
+	Long: `Search a local Bleve index built by "ddlogs index" without calling the Datadog API.
+
+Queries use Bleve query-string syntax. Fixed columns (host, service, status,
+message, tags) and custom attributes are all searchable by name; attributes
+are addressed without the leading @, e.g. http.status_code:500.
+
+  service:web status:error      both terms should match
+  +service:web -status:info     require / exclude a term
+  message:"connection reset"    phrase match
+  http.status_code:>=500        numeric range
+
+Results are written oldest first using the same CSV/JSON writers as search.`,
+	Example: `  # All indexed errors from the web service, CSV to stdout
+  ddlogs local search -q "+service:web +status:error" --index-dir ./incident-idx
+
+  # First 50 timeouts as JSON
+  ddlogs local search -q "message:timeout" --index-dir ./incident-idx --limit 50 -f json`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if localFormat != "csv" && localFormat != "json" && localFormat != "ndjson" {
+			return fmt.Errorf("--format must be csv, json or ndjson")
+		}
+		return handlers.SearchIndex(localIndexDir, localQuery, localLimit, localOutput, localFormat, quiet)
+	},
+}
+
+var localQueryCmd = &cobra.Command{
+	Use:   "query",
+	Short: "Search an archive kept by ddlogs archive run with a Datadog query",
+	Long: `Search the Parquet or NDJSON parts of an archive kept by "ddlogs archive run"
+without calling the Datadog API, so logs past Datadog's retention can be
+investigated with the same query language. Only the days of the archive
+overlapping --from..--to are read.
+
+Queries use the subset of Datadog search syntax that can be evaluated
+locally:
+
+  timeout "connection reset"     free text and phrases in the message
+  service:web status:error       host, service, status and tags (env:prod)
+  @http.status_code:500          attributes, by dotted path
+  @http.url:*/checkout*          wildcards (* and ?) in any value
+  @duration:>=1000 @n:[1 TO 5]   numeric comparisons and ranges
+  @user.id:*                     the attribute exists
+  a OR b, -a, NOT (a OR b)       boolean operators and grouping
+  service:(web OR api)           a key applied to several values
+
+Free text, reserved attributes and tags ignore case; attribute values are
+matched exactly. Results are written oldest first using the same writers as
+search.
+
+--dir is a local directory or s3://<bucket>/<prefix>, using the AWS
+credentials and region of the environment.`,
+	Example: `  # Errors of the web service in the first week of January
+  ddlogs local query --dir ./archive -q "service:web status:error" --from 2024-01-01 --to 2024-01-07
+
+  # Slow checkout requests from an S3 archive, as Parquet
+  ddlogs local query --dir s3://acme-logs/prod -q "@http.url:*checkout* @duration:>2000" --from 2023-11-01 -f parquet -o slow.parquet`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch localFormat {
+		case "csv", "json", "ndjson", "parquet", "xlsx", "md":
+		default:
+			return fmt.Errorf("--format must be csv, json, ndjson, parquet, xlsx or md")
+		}
+		return handlers.LocalQuery(handlers.LocalQueryOptions{
+			Dir:        localArchiveDir,
+			Query:      localArchiveQuery,
+			From:       localFrom,
+			To:         localTo,
+			Limit:      localLimit,
+			OutputFile: localOutput,
+			Format:     localFormat,
+			Quiet:      quiet,
+		})
+	},
+}
+
+func init() {
+	addQueryFlags(localSearchCmd, &localQuery, "Bleve query string (default: match all)", true)
+	localSearchCmd.Flags().StringVar(&localIndexDir, "index-dir", "", "Directory of the local index (required)")
+	localSearchCmd.Flags().IntVar(&localLimit, "limit", 0, "Maximum number of logs to return (default: all)")
+	localSearchCmd.Flags().StringVarP(&localOutput, "output", "o", "", "Output file path (default: stdout)")
+	localSearchCmd.Flags().StringVarP(&localFormat, "format", "f", "csv", "Output format: csv, json or ndjson")
+	localSearchCmd.MarkFlagRequired("index-dir")
+	localCmd.AddCommand(localSearchCmd)
+
+	addQueryFlags(localQueryCmd, &localArchiveQuery, "Datadog logs query string (default: match all)", true)
+	localQueryCmd.Flags().StringVar(&localArchiveDir, "dir", "", "Archive location: a local directory or s3://<bucket>/<prefix> (required)")
+	localQueryCmd.Flags().StringVar(&localFrom, "from", "", "Start of time range: a duration ago or an absolute time (default: the start of the archive)")
+	localQueryCmd.Flags().StringVar(&localTo, "to", "now", "End of time range: now, a duration ago, or an absolute time")
+	localQueryCmd.Flags().IntVar(&localLimit, "limit", 0, "Maximum number of logs to return (default: all)")
+	localQueryCmd.Flags().StringVarP(&localOutput, "output", "o", "", "Output file path (default: stdout)")
+	localQueryCmd.Flags().StringVarP(&localFormat, "format", "f", "csv", "Output format: csv, json, ndjson, parquet, xlsx or md")
+	localQueryCmd.MarkFlagRequired("dir")
+	localCmd.AddCommand(localQueryCmd)
+	rootCmd.AddCommand(localCmd)
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"regexp"
+	"syscall"
+	"time"
+
+	"github.com/dneil5648/dd-logs-cli/handlers"
+	"github.com/dneil5648/dd-logs-cli/mcpapi"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/spf13/cobra"
+)
+
+var (
+	mcpMaxRows  int
+	mcpMaxCell  int
+	mcpMaxRange time.Duration
+	mcpMaxTime  time.Duration
+	mcpRedact   []string
+	mcpNoRedact bool
+	mcpClass    string
+	mcpMaxCls   string
+)
+
+var mcpServeCmd = &cobra.Command{
+	Use:   "mcp-serve",
+	Short: "Serve log searches to AI assistants over the Model Context Protocol",
+	Long: `Run a Model Context Protocol (MCP) server on stdin/stdout, so AI assistants
+such as Claude Desktop, Cursor or any other MCP client can query Datadog logs
+through this binary with the active profile's keys. The client starts the
+server itself; configure it with the command "ddlogs" and the argument
+"mcp-serve".
+
+Tools:
+  search_logs      the logs matching a query and window, as CSV (compact and
+                   easy for a model to read) or NDJSON
+  count_logs       how many logs match, without fetching any
+  aggregate_logs   counts or measures (avg, pc99, ...) per group of facet
+                   values, optionally as a timeseries
+
+Guardrails the assistant cannot lift:
+  --max-rows caps the logs per search and the values per group-by facet.
+  --max-cell cuts long messages and attribute values. --max-range refuses
+  wider windows and --max-time ends slow calls.
+  Emails, card numbers, AWS access key IDs, bearer tokens and key=value
+  secrets (password=, token=, api_key=, ...) are replaced with [REDACTED] in
+  search results and aggregation tables; --redact adds patterns of your own
+  and --no-default-redact keeps only those.
+  --classification and --max-classification remove columns from search
+  results as for search, and refuse aggregations over them.
+
+stdout carries the protocol; every tool call is logged to stderr.`,
+	Example: `  # Claude Desktop: claude_desktop_config.json
+  {"mcpServers": {"datadog-logs": {"command": "ddlogs", "args": ["mcp-serve"]}}}
+
+  # A tighter budget and an extra pattern for internal customer IDs
+  ddlogs mcp-serve --max-rows 100 --max-cell 300 --redact 'cust_[0-9a-f]{12}'
+
+  # Apply the team's data-handling policy
+  ddlogs --profile prod mcp-serve --classification policy.yaml --max-classification internal`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if mcpMaxRows < 0 || mcpMaxCell < 0 || mcpMaxRange < 0 || mcpMaxTime < 0 {
+			return fmt.Errorf("--max-rows, --max-cell, --max-range and --max-time must not be negative")
+		}
+		config := mcpapi.Config{
+			MaxRows:     mcpMaxRows,
+			MaxCellLen:  mcpMaxCell,
+			MaxRange:    mcpMaxRange,
+			MaxDuration: mcpMaxTime,
+		}
+		if !mcpNoRedact {
+			config.Redact = append(config.Redact, handlers.DefaultRedactions...)
+		}
+		for _, p := range mcpRedact {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return fmt.Errorf("--redact: %w", err)
+			}
+			config.Redact = append(config.Redact, re)
+		}
+		if (mcpClass == "") != (mcpMaxCls == "") {
+			return fmt.Errorf("--classification and --max-classification must be used together")
+		}
+		if mcpClass != "" {
+			var err error
+			if config.MaxClassification, err = handlers.ParseClassificationLevel(mcpMaxCls); err != nil {
+				return fmt.Errorf("--max-classification: %w", err)
+			}
+			if config.Classification, err = handlers.LoadClassification(mcpClass); err != nil {
+				return err
+			}
+		}
+		handler, err := newHandler()
+		if err != nil {
+			return err
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		fmt.Fprintf(os.Stderr, "Serving MCP on stdio (profile %s)\n", activeProfileName)
+		err = mcpapi.NewServer(handler, config).Run(ctx, &mcp.StdioTransport{})
+		if err != nil && ctx.Err() == nil && !errors.Is(err, mcp.ErrConnectionClosed) {
+			return err
+		}
+		return nil
+	},
+}
+
+func init() {
+	mcpServeCmd.Flags().IntVar(&mcpMaxRows, "max-rows", 500, "Most logs per search, and values per group-by facet (0: no cap)")
+	mcpServeCmd.Flags().IntVar(&mcpMaxCell, "max-cell", 1000, "Cut messages and attribute values to this many characters (0: no cut)")
+	mcpServeCmd.Flags().DurationVar(&mcpMaxRange, "max-range", 7*24*time.Hour, "Refuse windows longer than this (0: no limit)")
+	mcpServeCmd.Flags().DurationVar(&mcpMaxTime, "max-time", 2*time.Minute, "End tool calls that run longer than this (0: no limit)")
+	mcpServeCmd.Flags().StringArrayVar(&mcpRedact, "redact", nil, "Also replace matches of this regular expression with [REDACTED] (repeatable)")
+	mcpServeCmd.Flags().BoolVar(&mcpNoRedact, "no-default-redact", false, "Do not redact emails, card numbers, keys and tokens; only --redact patterns")
+	mcpServeCmd.Flags().StringVar(&mcpClass, "classification", "", "YAML file assigning columns public, internal or confidential")
+	mcpServeCmd.Flags().StringVar(&mcpMaxCls, "max-classification", "", "Remove columns classified above this level: public, internal or confidential")
+	rootCmd.AddCommand(mcpServeCmd)
+}
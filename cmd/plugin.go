@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// pluginPrefix names external subcommands: an executable ddlogs-foo on PATH
+// runs as "ddlogs foo".
+const pluginPrefix = "ddlogs-"
+
+// runPlugin runs args as an external subcommand when its first argument
+// after any global flags (--profile, --config, ...) is not a built-in
+// command and a ddlogs-<name> executable is on PATH. The plugin gets the
+// remaining arguments, inherits stdin/stdout/stderr, and finds the active
+// profile's credentials in its environment:
+//
+//	DD_API_KEY, DD_APP_KEY, DD_SITE  resolved as for built-in commands
+//	DD_PROFILE                       the active profile's name
+//	DDLOGS_CONFIG                    the config file in use
+//	DDLOGS_BIN                       this ddlogs executable, to call back
+//
+// ok is false when args are not a plugin invocation; code is the plugin's
+// exit status.
+func runPlugin(args []string) (code int, ok bool) {
+	i := 0
+	for i < len(args) && strings.HasPrefix(args[i], "-") && args[i] != "--" {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		f := rootCmd.PersistentFlags().Lookup(name)
+		if f == nil {
+			return 0, false
+		}
+		i++
+		if !hasValue && f.Value.Type() != "bool" {
+			i++
+		}
+	}
+	if i >= len(args) {
+		return 0, false
+	}
+	name := args[i]
+	if name == "help" || name == "completion" || strings.HasPrefix(name, "-") {
+		return 0, false
+	}
+	if cmd, _, err := rootCmd.Find([]string{name}); err == nil && cmd != rootCmd {
+		return 0, false
+	}
+	path, err := exec.LookPath(pluginPrefix + name)
+	if err != nil {
+		return 0, false
+	}
+
+	if err := rootCmd.PersistentFlags().Parse(args[:i]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1, true
+	}
+	if err := loadConfig(false); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1, true
+	}
+	apiKey, appKey, site, err := credentials()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1, true
+	}
+	env := append(os.Environ(),
+		"DD_API_KEY="+apiKey,
+		"DD_APP_KEY="+appKey,
+		"DD_SITE="+site,
+		"DD_PROFILE="+activeProfileName,
+		"DDLOGS_CONFIG="+configFile,
+	)
+	if self, err := os.Executable(); err == nil {
+		env = append(env, "DDLOGS_BIN="+self)
+	}
+
+	plugin := exec.Command(path, args[i+1:]...)
+	plugin.Env = env
+	plugin.Stdin, plugin.Stdout, plugin.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := plugin.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: running %s: %v\n", path, err)
+		return 1, true
+	}
+	// Ctrl-C reaches the plugin through the terminal; ddlogs waits for it
+	// to exit rather than dying first, and passes SIGTERM on.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		for sig := range sigs {
+			if sig == syscall.SIGTERM {
+				plugin.Process.Signal(sig)
+			}
+		}
+	}()
+	if err := plugin.Wait(); err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && exit.ExitCode() > 0 {
+			return exit.ExitCode(), true
+		}
+		fmt.Fprintf(os.Stderr, "Error: running %s: %v\n", path, err)
+		return 1, true
+	}
+	return 0, true
+}
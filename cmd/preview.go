@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/dneil5648/dd-logs-cli/handlers"
+	"github.com/spf13/cobra"
+)
+
+var (
+	previewQuery  string
+	previewFrom   string
+	previewTo     string
+	previewEvents int
+)
+
+var previewCmd = &cobra.Command{
+	Use:   "preview",
+	Short: "Show a few matching logs, their attributes and the match count",
+	Long: `Fetch a single page of the newest logs matching a query and print a quick
+look at it, for iterating on a query before running a full export:
+
+  - the first --events logs, pretty-printed (time, status, service, host,
+    message and top-level attributes)
+  - every attribute path seen on the page, with its type and how many of
+    the sampled logs carry it
+  - the total number of matching logs in the time range
+
+Only one page of logs and one count request are made, however many logs
+match.`,
+	Example: `  ddlogs preview -q "service:web status:error"
+  ddlogs preview -q "@http.status_code:>=500" --from 24h -n 5`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if previewEvents < 1 {
+			return fmt.Errorf("--events must be at least 1")
+		}
+		handler, err := newHandler()
+		if err != nil {
+			return err
+		}
+		return handler.Preview(handlers.PreviewOptions{
+			Query:  profileQuery(previewQuery),
+			From:   previewFrom,
+			To:     previewTo,
+			Events: previewEvents,
+		})
+	},
+}
+
+func init() {
+	addQueryFlags(previewCmd, &previewQuery, "Datadog logs query string", false)
+	previewCmd.Flags().StringVar(&previewFrom, "from", "15m", "Start of time range: a duration ago (15m, 24h, 7d, 2w, 1mo) or an absolute time (RFC 3339, date, epoch)")
+	previewCmd.Flags().StringVar(&previewTo, "to", "now", "End of time range: now, a duration ago, or an absolute time")
+	previewCmd.Flags().IntVarP(&previewEvents, "events", "n", 20, "Number of logs to print")
+	rootCmd.AddCommand(previewCmd)
+}
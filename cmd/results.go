@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/dneil5648/dd-logs-cli/store"
+	"github.com/spf13/cobra"
+)
+
+var resultsPath bool
+
+var resultsCmd = &cobra.Command{
+	Use:   "results",
+	Short: "Manage exports saved with search --store",
+	Long: `Manage the local results store.
+
+"ddlogs search --store" saves each export under a fingerprint of its query,
+time window and format instead of an ad-hoc file name. Running the same search
+again reuses the stored export rather than downloading it twice.
+
+The store lives in ~/.ddlogs/results unless DDLOGS_RESULTS_DIR is set.`,
+}
+
+var resultsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List stored exports, newest first",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := openStore()
+		if err != nil {
+			return err
+		}
+		entries, err := s.List()
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "FINGERPRINT\tCREATED\tFORMAT\tSIZE\tFROM\tTO\tQUERY")
+		for _, e := range entries {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+				e.Fingerprint, e.CreatedAt.Local().Format("2006-01-02 15:04"), e.Format, e.Size, e.From, e.To, e.Query)
+		}
+		return tw.Flush()
+	},
+}
+
+var resultsOpenCmd = &cobra.Command{
+	Use:   "open <fingerprint>",
+	Short: "Write a stored export to stdout",
+	Long: `Write a stored export to stdout, or print its path with --path.
+
+The fingerprint may be abbreviated to any unique prefix.`,
+	Example: `  ddlogs results open 3f9a | head
+  duckdb -c "select * from '$(ddlogs results open 3f9a --path)'"`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := openStore()
+		if err != nil {
+			return err
+		}
+		e, err := s.Find(args[0])
+		if err != nil {
+			return err
+		}
+		if resultsPath {
+			fmt.Println(s.DataPath(e))
+			return nil
+		}
+
+		f, err := os.Open(s.DataPath(e))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(os.Stdout, f)
+		return err
+	},
+}
+
+var resultsRmCmd = &cobra.Command{
+	Use:   "rm <fingerprint>...",
+	Short: "Delete stored exports",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := openStore()
+		if err != nil {
+			return err
+		}
+		for _, prefix := range args {
+			e, err := s.Find(prefix)
+			if err != nil {
+				return err
+			}
+			if err := s.Remove(e); err != nil {
+				return fmt.Errorf("removing %s: %w", e.Fingerprint, err)
+			}
+			fmt.Fprintf(os.Stderr, "Removed %s\n", e.Fingerprint)
+		}
+		return nil
+	},
+}
+
+func openStore() (*store.Store, error) {
+	dir, err := store.DefaultDir()
+	if err != nil {
+		return nil, err
+	}
+	return store.New(dir), nil
+}
+
+func init() {
+	resultsOpenCmd.Flags().BoolVar(&resultsPath, "path", false, "Print the export's file path instead of its contents")
+	resultsCmd.AddCommand(resultsListCmd, resultsOpenCmd, resultsRmCmd)
+	rootCmd.AddCommand(resultsCmd)
+}
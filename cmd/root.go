@@ -1,11 +1,29 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"strings"
 
+	"github.com/dneil5648/dd-logs-cli/handlers"
 	"github.com/spf13/cobra"
 )
 
+// maxRetries is the --max-retries flag, applied to every handler.
+var maxRetries int
+
+// quiet is the --quiet flag: no progress or summary lines on stderr.
+var quiet bool
+
+// queryFile is the --query-file flag of the commands that take a query.
+var queryFile string
+
+// faults holds the hidden --inject-* flags for testing tools that wrap
+// ddlogs against API failures.
+var faults handlers.FaultInjection
+
 var rootCmd = &cobra.Command{
 	Use:   "ddlogs",
 	Short: "A CLI for querying Datadog logs",
@@ -17,15 +35,209 @@ Environment Variables:
   DD_APP_KEY   (required)  Your Datadog Application key
   DD_SITE      (optional)  Datadog site (default: datadoghq.com)
                            Examples: datadoghq.eu, us3.datadoghq.com, us5.datadoghq.com
+  DD_PROFILE   (optional)  Config profile to use, like --profile
+
+  Keys can instead be stored in the OS keyring with "ddlogs auth login"; they
+  are used when neither the environment nor the config profile has them.
+
+Config File (~/.ddlogs/config.yaml, or --config):
+  Holds named profiles of credentials and defaults. The profile is chosen by
+  --profile, then DD_PROFILE, then default_profile, then "default". A
+  profile chosen with --profile or DD_PROFILE must be defined in the config
+  file or stored with "ddlogs auth login", and only its own keys and site
+  are used, never DD_API_KEY/DD_APP_KEY/DD_SITE; otherwise those variables
+  override it. Flags always override the profile's defaults.
+
+    default_profile: prod
+    profiles:
+      prod:
+        site: datadoghq.com
+        api_key: <api key>
+        app_key: <app key>
+        format: json             # used when --format is not given
+        query_prefix: env:prod   # ANDed with every query
+        fallback_sites:          # tried in order if log queries keep
+          - datadoghq.eu         # failing with 5xx (read-only commands)
+      eu:
+        site: datadoghq.eu
+        api_key: <api key>
+        app_key: <app key>
+
+Plugins:
+  Any executable named ddlogs-<name> on PATH runs as "ddlogs <name>", with
+  the remaining arguments. It receives the active profile's credentials in
+  DD_API_KEY, DD_APP_KEY and DD_SITE, the profile name in DD_PROFILE, the
+  config file in DDLOGS_CONFIG and the path of ddlogs itself in DDLOGS_BIN.
+  Built-in commands take precedence.
 
 Quick Start:
   export DD_API_KEY="your-api-key"
   export DD_APP_KEY="your-app-key"
   ddlogs search -q "service:web" --from 1h`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := loadConfig(cmd == authLoginCmd); err != nil {
+			return err
+		}
+		return readQuery(cmd)
+	},
 }
 
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	if code, ok := runPlugin(os.Args[1:]); ok {
+		os.Exit(code)
+	}
+	if cmd, err := rootCmd.ExecuteC(); err != nil {
+		if errors.Is(err, handlers.ErrInterrupted) {
+			os.Exit(130)
+		}
+		if cmd == checkCmd {
+			// check reports every failure as UNKNOWN, including those
+			// before its RunE; its own are already exitCodeErrors.
+			var exit exitCodeError
+			if !errors.As(err, &exit) {
+				err = checkUnknown(err)
+			}
+		}
+		var exit exitCodeError
+		if errors.As(err, &exit) {
+			os.Exit(exit.code)
+		}
 		os.Exit(1)
 	}
 }
+
+// exitCodeError ends ddlogs with code, for commands whose exit status is
+// their result. The command prints its own output; the error is not
+// printed.
+type exitCodeError struct {
+	code int
+}
+
+func (e exitCodeError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+// newHandler builds a DDHandler from the DD_API_KEY, DD_APP_KEY and DD_SITE
+// environment variables and the active config profile, falling back to keys
+// stored in the OS keyring by "ddlogs auth login".
+func newHandler() (*handlers.DDHandler, error) {
+	return credentialedHandler(true)
+}
+
+// newIntakeHandler is newHandler for the Logs Intake API, which takes only
+// an API key.
+func newIntakeHandler() (*handlers.DDHandler, error) {
+	return credentialedHandler(false)
+}
+
+func credentialedHandler(needAppKey bool) (*handlers.DDHandler, error) {
+	apiKey, appKey, site, err := credentials()
+	if err != nil {
+		return nil, err
+	}
+	// A chosen profile does not read the environment, so it is not offered.
+	apiHint, appHint := "set DD_API_KEY, ", "set DD_APP_KEY, "
+	if profileChosen {
+		apiHint, appHint = "", ""
+	}
+	if apiKey == "" {
+		return nil, fmt.Errorf("no API key for profile %q: %sadd api_key to the config profile, or run \"ddlogs auth login\"", activeProfileName, apiHint)
+	}
+	if appKey == "" && needAppKey {
+		return nil, fmt.Errorf("no application key for profile %q: %sadd app_key to the config profile, or run \"ddlogs auth login\"", activeProfileName, appHint)
+	}
+
+	handler := handlers.NewDDHandler(site, apiKey, appKey)
+	handler.MaxRetries = maxRetries
+	handler.FallbackSites = activeProfile.FallbackSites
+	if faults.RateLimitRate > 0 || faults.TimeoutRate > 0 {
+		if faults.RateLimitRate < 0 || faults.TimeoutRate < 0 || faults.RateLimitRate+faults.TimeoutRate > 1 {
+			return nil, fmt.Errorf("--inject-429-rate and --inject-timeout-rate must be between 0 and 1 and add up to at most 1")
+		}
+		handler.InjectFaults(faults)
+	}
+	return handler, nil
+}
+
+// addQueryFlags adds --query, bound to query, and --query-file to cmd.
+// One of them is required unless optional is set.
+func addQueryFlags(cmd *cobra.Command, query *string, usage string, optional bool) {
+	cmd.Flags().StringVarP(query, "query", "q", "", usage+", or - to read it from stdin")
+	cmd.Flags().StringVar(&queryFile, "query-file", "", "Read the query from this file (- for stdin), e.g. one kept in version control")
+	cmd.MarkFlagsMutuallyExclusive("query", "query-file")
+	if !optional {
+		cmd.MarkFlagsOneRequired("query", "query-file")
+	}
+}
+
+// readQuery sets cmd's --query from --query-file, or from stdin for -q -,
+// with trailing newlines trimmed.
+func readQuery(cmd *cobra.Command) error {
+	flag := cmd.Flags().Lookup("query")
+	if flag == nil {
+		return nil
+	}
+	var text []byte
+	var err error
+	source := "--query-file " + queryFile
+	switch {
+	case cmd.Flags().Changed("query-file") && queryFile != "-":
+		text, err = os.ReadFile(queryFile)
+	case cmd.Flags().Changed("query-file"), flag.Value.String() == "-":
+		source = "stdin"
+		text, err = io.ReadAll(os.Stdin)
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading the query from %s: %w", source, err)
+	}
+	query := strings.TrimRight(string(text), "\r\n")
+	if strings.TrimSpace(query) == "" {
+		return fmt.Errorf("the query from %s is empty", source)
+	}
+	return flag.Value.Set(query)
+}
+
+// credentials resolves the API key, application key and site of the active
+// profile as newHandler uses them. The keys are "" when none are configured;
+// the site defaults to datadoghq.com. A profile picked with --profile or
+// DD_PROFILE takes its credentials from the config file and the keyring
+// only, and is an error when neither defines it.
+func credentials() (apiKey, appKey, site string, err error) {
+	if profileChosen && !profileFound {
+		return "", "", "", profileNotFound()
+	}
+	apiKey, appKey, site = activeProfile.APIKey, activeProfile.AppKey, activeProfile.Site
+	if !profileChosen {
+		apiKey = firstNonEmpty(os.Getenv("DD_API_KEY"), apiKey)
+		appKey = firstNonEmpty(os.Getenv("DD_APP_KEY"), appKey)
+		site = firstNonEmpty(os.Getenv("DD_SITE"), site)
+	}
+
+	if apiKey == "" || appKey == "" {
+		apiKey = firstNonEmpty(apiKey, keyringValue("api_key"))
+		appKey = firstNonEmpty(appKey, keyringValue("app_key"))
+		site = firstNonEmpty(site, keyringValue("site"))
+	}
+	if site == "" {
+		site = "datadoghq.com"
+	}
+	return apiKey, appKey, site, nil
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", defaultConfigFile(), "Config file with named profiles")
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", "", "Config profile to use (default $DD_PROFILE, then default_profile)")
+	rootCmd.PersistentFlags().BoolVar(&quiet, "quiet", false, "Print no progress or summary lines on stderr; errors and warnings still appear")
+	rootCmd.PersistentFlags().IntVar(&maxRetries, "max-retries", handlers.DefaultMaxRetries, "Retries for a log fetch failing with 429, 5xx or a network error (0 disables)")
+
+	// Failure injection, for testing wrappers' retry and alerting
+	// behavior; hidden from help.
+	rootCmd.PersistentFlags().Float64Var(&faults.RateLimitRate, "inject-429-rate", 0, "Fraction of log API requests answered with a synthetic 429")
+	rootCmd.PersistentFlags().Float64Var(&faults.TimeoutRate, "inject-timeout-rate", 0, "Fraction of log API requests failed with a synthetic timeout")
+	rootCmd.PersistentFlags().Int64Var(&faults.Seed, "inject-seed", 1, "Seed choosing which requests fail, for reproducible runs")
+	for _, name := range []string{"inject-429-rate", "inject-timeout-rate", "inject-seed"} {
+		rootCmd.PersistentFlags().MarkHidden(name)
+	}
+}
@@ -1,19 +1,110 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/dneil5648/dd-logs-cli/handlers"
+	"github.com/dneil5648/dd-logs-cli/store"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/term"
 )
 
 var (
-	searchQuery  string
-	searchFrom   string
-	searchTo     string
-	searchOutput string
-	searchFormat string
+	searchQuery   string
+	searchFrom    string
+	searchTo      string
+	searchOutput  string
+	searchGzip    string
+	searchRotSz   string
+	searchRotate  int64
+	searchRotRow  int
+	searchFormat  string
+	searchStore   bool
+	searchFresh   bool
+	searchStats   string
+	searchStable  bool
+	searchRaw     bool
+	searchCurl    bool
+	searchExpl    bool
+	searchDry     bool
+	searchStrict  bool
+	searchLint    bool
+	searchCkpt    string
+	searchResume  bool
+	searchToken   string
+	searchLimit   int
+	searchSort    string
+	searchPar     int
+	searchShard   time.Duration
+	searchCols    string
+	searchColumn  []string
+	searchCSVSafe bool
+	searchXLMode  bool
+	searchPrune   bool
+	searchLong    bool
+	searchDSet    bool
+	searchProg    string
+	searchDisc    bool
+	searchSample  int
+	searchRescan  bool
+	searchPrint   bool
+	searchTypes   string
+	searchTable   string
+	searchCache   string
+	searchSchema  string
+	searchEmit    string
+	searchLocale  string
+	searchLoc     *handlers.CSVLocale
+	searchTZ      string
+	searchZone    *time.Location
+	searchTimeFm  string
+	searchTimeF   *handlers.TimeFormat
+	searchFlat    int
+	searchMaxMsg  int
+	searchMaxCel  int
+	searchTrunc   string
+	searchColW    int
+	searchTableW  int
+	searchTmpl    string
+	searchTpl     *template.Template
+	searchLLM     bool
+	searchTokens  int
+	searchTokzr   string
+	searchTok     *handlers.Tokenizer
+	searchIndex   []string
+	searchReject  string
+	searchRoute   string
+	searchRoutes  string
+	searchSplit   string
+	searchTeam    string
+	searchTags    string
+	searchK8sNS   string
+	searchK8sDep  string
+	searchK8sCol  bool
+	searchTrail   string
+	searchWindow  time.Duration
+	searchIOC     string
+	searchIOCHit  bool
+	searchXform   []string
+	searchGrep    string
+	searchGrepV   string
+	searchGrepRe  *regexp.Regexp
+	searchGrepVR  *regexp.Regexp
+	searchClass   string
+	searchMaxCls  string
+	searchSumCmd  string
+	searchWatch   time.Duration
+
+	// searchPolicy is the loaded --classification config, if any.
+	searchPolicy    *handlers.Classification
+	searchMaxPolicy handlers.ClassificationLevel
 )
 
 var searchCmd = &cobra.Command{
@@ -29,7 +120,83 @@ Output Formats:
   csv   (default)  Flat columns, token-efficient for LLM analysis.
                    Fixed columns: timestamp, host, service, status, message, tags.
                    Custom attributes (@fields) are auto-discovered and added as columns.
+                   Columns seen in earlier exports of the same query are cached
+                   (~/.ddlogs/schemas) and reused, so headers stay stable;
+                   --refresh-schema rediscovers them.
+                   --excel adds a UTF-8 byte order mark and CRLF line
+                   endings and keeps long numeric IDs as text, so the file
+                   opens correctly in Excel by double-click.
   json             Full structured JSON array, preserves all nesting.
+  ndjson           JSON Lines: one compact object per line, flushed per page.
+                   Pipe-friendly for jq, BigQuery and log shippers.
+                   For both JSON formats, --stable-json sorts every object's
+                   keys so two exports can be diffed meaningfully, and --raw
+                   writes each event byte-for-byte as the API returned it,
+                   with no SDK decoding or re-encoding (forensics).
+  parquet          Apache Parquet, for loading into Athena/DuckDB/Spark.
+                   Same columns as CSV, but typed: timestamp is a
+                   TIMESTAMP(MILLIS) and attributes are DOUBLE, BOOLEAN or
+                   STRING as inferred from the first page. A row group is
+                   written every ~100k rows as pages arrive. Use with -o.
+                   An attribute with values of several types is written as
+                   STRING by default; --type-conflicts split writes one
+                   column per type (attr__number, attr__string, ...) and
+                   --type-conflicts error fails the export instead. Either
+                   samples the time range first, like --discover-schema.
+                   With --dataset, -o is a directory each run adds a part
+                   file to, for an archive built from incremental runs: a
+                   part keeps the columns, order and types of the parts
+                   before it and adds new attributes as nullable columns.
+  duckdb           Loads the export into a table (--table, default "logs")
+                   of the DuckDB database file given with -o, replacing
+                   that table but leaving others alone, ready for SQL. The
+                   columns and types are those of parquet. Needs the duckdb
+                   CLI on PATH.
+  xlsx             Excel workbook for sharing with people who work in
+                   spreadsheets: the CSV columns on one sheet, with a bold
+                   frozen header row, columns sized to their contents, a
+                   real date-time (UTC) for timestamp and numbers for
+                   numeric attributes. A sheet holds up to 1,048,575 logs;
+                   use --rotate-rows to split larger exports.
+  md               GitHub-flavored Markdown table with the CSV columns, to
+                   paste into incident docs and pull requests. Pipes and
+                   line breaks are escaped so each log is one row;
+                   --max-col-width 60 cuts long cells, ending them with "…".
+  table            Aligned columns for reading in a terminal: timestamp,
+                   status, service, host, any --k8s-* tag columns, and the
+                   message cut to the terminal's width. Columns are sized
+                   from the first page and capped by --max-col-width
+                   (default 40). Custom attributes are left out. When
+                   stdout is not a terminal, or with -o, --store, --route-by
+                   or --split-by, the export is written as CSV instead.
+  template         Each log rendered through the Go template given with
+                   --template, one per line: {{.Timestamp}}, {{.ID}},
+                   {{.Host}}, {{.Service}}, {{.Status}}, {{.Message}},
+                   {{.Tags}}, {{.Attr "http.status_code"}} for a custom
+                   attribute by name or dotted path, {{.Tag "env"}} for a
+                   tag, and {{json .Attributes}} for JSON.
+  llm              Compact CSV sized to paste into a language model's
+                   context (also --llm): columns empty in every log are
+                   dropped, columns and tags shared by every log move to
+                   one "# same in every log:" line above the header,
+                   messages are cut to 500 characters and other values to
+                   200 (--max-message-len/--max-cell-len change that), and
+                   rows stop at --max-tokens (default 32000) as estimated
+                   for --tokenizer cl100k, o200k, claude or llama3. Fetching
+                   stops once the budget is reached, and a last comment
+                   line says so.
+
+  CSV and JSON output is compressed as it is written when -o ends in .gz
+  (gzip) or .zst (zstd), or with --compress gzip|zstd (also to stdout). The
+  compressor is flushed after every page, so an interrupted export is still
+  readable up to the last page. Route files are compressed by their own
+  extensions.
+
+  --rotate-size 500MB or --rotate-rows 1000000 splits the export into
+  numbered files: -o logs.csv writes logs-0001.csv, logs-0002.csv, ...
+  Every CSV file gets its own header (with the columns of the files before
+  it) and every JSON file is a complete array. Sizes are measured before
+  compression; --rotate-size does not apply to parquet or xlsx.
 
 Time Range (--from / --to):
   Both flags accept duration strings relative to now. The value is sent to the
@@ -40,10 +207,10 @@ Time Range (--from / --to):
     15m      15 minutes ago (default for --from)
     1h       1 hour ago
     6h       6 hours ago
-    24h      1 day ago
-    72h      3 days ago
-    168h     7 days ago
-    720h     30 days ago
+    1d       1 day ago (same as 24h)
+    3d       3 days ago
+    1w       7 days ago
+    1mo      30 days ago
 
   --to defaults to "now" (the current time). Set it to a duration to define an
   end boundary in the past, creating a fixed window:
@@ -51,11 +218,248 @@ Time Range (--from / --to):
     --from 48h --to 24h    logs from 2 days ago to 1 day ago
     --from 2h  --to 30m    logs from 2 hours ago to 30 minutes ago
 
-  Note: Go durations use "h" for hours and "m" for minutes. There is no "d" unit,
-  so use 24h for 1 day, 168h for 7 days, etc.
+  Units are s, m, h, d (days), w (weeks) and mo (30-day months), and can be
+  combined: 1d12h, 1w3d. Values using d/w/mo are normalized before being
+  sent, e.g. --from 1mo becomes "now-30d".
+
+  Absolute times are detected automatically and sent as RFC 3339 in UTC:
+    2024-05-01T00:00:00Z       RFC 3339, any offset, optional fractional seconds
+    2024-05-01                 a date, taken as midnight UTC
+    1714521600                 Unix epoch seconds
+    1714521600000              Unix epoch milliseconds
+
+    --from 2024-05-01T00:00:00Z --to 2024-05-02T00:00:00Z
+    --from 2024-05-01 --to 6h  absolute start, relative end
+
+Time Zone (--tz):
+  Timestamps are written in UTC. --tz renders them in another zone instead,
+  with its offset (2024-04-30T20:00:00-04:00), to line logs up with an
+  on-call timeline: an IANA name such as America/New_York, "local" for this
+  machine's zone, or UTC. It applies to the csv, json, ndjson, md, table,
+  xlsx and template formats and to --locale date layouts; parquet and
+  duckdb store instants and are unaffected. --from/--to are not changed by
+  it: a bare date is still midnight UTC.
+
+Summaries (--summarize-cmd):
+  Once the export is written, --summarize-cmd runs a shell command with the
+  export on its stdin (uncompressed, whether it went to -o or stdout) and
+  prints what the command writes after the run's summary on stderr, e.g.
+  an incident digest from a local LLM CLI. DDLOGS_QUERY, DDLOGS_FROM,
+  DDLOGS_TO and DDLOGS_FORMAT are set for it. A command that fails fails
+  the search. Text formats only; not with --store, routing, rotation or
+  checkpoints.
+
+Time Format (--time-format):
+  Timestamps are written as RFC 3339, with whole seconds in the tabular
+  formats and milliseconds in json and ndjson. --time-format writes them
+  the way the tool reading the export expects instead:
+    rfc3339        2024-05-01T00:00:03Z
+    rfc3339nano    2024-05-01T00:00:03.127Z, keeping milliseconds
+    epoch          1714521603, whole seconds since 1970 (Splunk, awk)
+    epoch-ms       1714521603127, milliseconds (pandas unit="ms", JavaScript)
+    a Go layout    "2006-01-02 15:04:05.000", written in the reference time
+                   Mon Jan 2 15:04:05 MST 2006 (spreadsheets, databases)
+  Epoch values are numbers in json and ndjson, everything else a string.
+  It applies to the csv, json, ndjson, md, table, llm and template formats
+  and overrides --locale's date layout; layouts follow --tz.
+
+Results Store (--store):
+  Instead of -o, save the export in the local results store under a
+  fingerprint of its query, time window and format. If that fingerprint is
+  already stored the download is skipped; pass --refresh to replace it.
+  Manage stored exports with "ddlogs results list|open|rm".
+
+Column Profile (--column-stats):
+  Write a JSON profile of every column seen in the results alongside the
+  export: type, null count, min/max, distinct count and the top values. The
+  profile covers all attributes, including ones that first appear after the
+  CSV header was written. Distinct counts are exact up to 10,000 values.
+
+Column Pruning (--auto-prune):
+  Drop the CSV columns that are empty in every row, or hold the same value
+  in every row, and list them with their value on stderr. Useful when
+  attribute discovery adds dozens of columns only a few logs fill. Rows
+  are held in a temporary file and written once the export ends, so the
+  output cannot be resumed and stdout sees nothing until then.
+
+Long Format (--long-format):
+  Write CSV with one row per log_id, attribute and value instead of one
+  column per attribute, leaving out empty values. The header is always
+  log_id,attribute,value, however many attributes the services in the
+  export use, which suits BI tools that pivot or filter on attribute.
+  The fixed columns come first, then tag columns and attributes, sorted;
+  --columns limits the rows to the columns listed.
+
+Resumable Exports (--checkpoint / --resume):
+  For long exports, --checkpoint export.state records the pagination
+  cursor, page number, rows written and output size after every page. If
+  the export is interrupted, re-run the same command with --resume: the
+  output is truncated to the last checkpoint and appended to from there, so
+  no log is lost or repeated. The time range is pinned to absolute times when
+  the export starts, so relative --from values do not drift. The checkpoint
+  is deleted once the export completes. Requires --output with csv, json or
+  ndjson.
+
+  Ctrl-C or SIGTERM stops any export gracefully: fetched pages are written,
+  the output is closed out so it stays valid, and the last cursor is printed
+  before exiting with status 130.
+
+  Without --checkpoint, an export to a file that fails or is interrupted
+  prints a resume token instead: re-run the same command with
+  --resume-token <token> to continue it the same way. The token carries the
+  query, pinned time range, cursor and output size, so there is no file to
+  manage.
+
+Query Linting (--strict-query):
+  Before fetching, the query is checked for patterns that are slow on Flex
+  storage, with a narrower alternative for each: leading wildcards
+  (@path:*checkout), and free-text terms or queries without a positive
+  filter over windows longer than a day. Findings are printed as warnings;
+  with --strict-query they stop the export instead.
+
+Strict Mode (--strict):
+  By default the writers coerce rather than fail. With --strict any lossy
+  write aborts the export instead:
+    - an event that does not match the Logs API schema
+    - invalid UTF-8 that would be replaced during encoding
+    - CSV only: an attribute that first appears after the header was written
+  The offending event and the reason are appended to --reject-file.
+  Without --strict, --reject-file collects logs a --transform plugin
+  fails on and the export carries on past them.
+
+Team Scope (--team):
+  Look up the services owned by a team in the Datadog Service Catalog and
+  restrict the query to them, e.g. --team payments -q "status:error" runs
+  service:(billing OR checkout OR ledger) (status:error). Team names match
+  the "team" field of each service definition, case-insensitively. Requires
+  an application key with Service Catalog read access.
+
+Tag Filters (--tags):
+  A comma-separated list of key:value tags, * wildcards allowed, compiled
+  into the query: --tags 'env:prod,region:us-*,env:staging' adds
+  env:(prod OR staging) region:us-*. Every pattern is first checked against
+  the tags the org's hosts report, so a typo fails immediately with the
+  values that do exist instead of silently exporting nothing.
+
+Kubernetes (--k8s-namespace / --k8s-deployment / --k8s-columns):
+  Shortcuts for the tags the Datadog Agent puts on container logs:
+  --k8s-namespace payments adds kube_namespace:payments and
+  --k8s-deployment api adds kube_deployment:api to the query. Either one
+  also adds kube_namespace, pod_name and kube_container_name columns to CSV
+  and Parquet output; --k8s-columns adds just the columns.
+
+CloudTrail Correlation (--correlate-cloudtrail):
+  Join the exported logs with AWS CloudTrail events into one chronological
+  timeline for security investigations. The value is a CloudTrail file (the
+  {"Records": [...]} envelope or one record per line, optionally gzipped), a
+  directory of them, or an s3://bucket/prefix location read with the
+  standard AWS credential chain.
+
+  A log and an event are correlated when they are within --correlate-window
+  of each other and share the client IP (network.client.ip, client_ip, ip)
+  or a principal (usr.id, usr.name, usr.email, user vs. the event's ARN,
+  user name or role session name). Output has one row per log or event:
+  time, source, id, principal, ip, event, matches (counterpart IDs) and
+  match_on (ip, principal or ip+principal). Formats: csv, json, ndjson.
+
+Threat Intel (--ioc-file / --ioc-only):
+  Match every string attribute, at any depth, against a list of indicators:
+  one IP, CIDR range or domain per line (# starts a comment). Domains also
+  match their subdomains, and URLs are matched by host. Each log gets an
+  ioc_match attribute (a column in CSV/Parquet) with the reason, e.g.
+  "network.client.ip=203.0.113.7 (cidr 203.0.113.0/24)", or empty if
+  nothing matched. --ioc-only keeps just the matching logs.
+
+Classification (--classification / --max-classification):
+  Enforce a data-handling policy at export time. --classification is a YAML
+  file assigning each column a level of public, internal or confidential:
+
+    default: internal        # unlisted columns (omit for public)
+    columns:
+      host: public
+      service: public
+      usr.email: confidential
+      network.client.*: confidential
+
+  Columns are named as in CSV output; nested attributes inherit their
+  parent's level unless listed. Columns above --max-classification are
+  removed from every output, including --column-stats and --ioc-file
+  matches, and the removed columns are listed on stderr.
+
+Truncation (--max-message-len / --max-cell-len / --truncation-log):
+  Keep exports of chatty services manageable: messages longer than
+  --max-message-len characters and string attributes (at any depth) longer
+  than --max-cell-len are cut and end with "…". When the export finishes,
+  stderr reports how many values were cut in each column:
+
+    Truncation: cut 1482 value(s) in 1390 log(s): message 1377, error.stack 105
+
+  --truncation-log also writes a CSV of id,column,original_length with a
+  row per cut value, so the full events can be fetched again.
+
+Routing (--route-by / --route-map):
+  Split one export across several files by the value of a field, in a
+  single API pass (e.g. one file per tenant). --route-by names the field:
+  "@org_id" for a custom attribute (dotted paths reach nested objects), or a
+  fixed column such as "service". --route-map is a YAML file:
+
+    routes:
+      acme: acme.csv
+      globex: globex.csv
+    default: other.csv    # optional; unmatched logs are dropped without it
+
+  Each file is written in --format. Replaces --output.
+
+  --split-by needs no map: every distinct value of the field gets its own
+  file in the --output directory, named after the value (out/web.csv,
+  out/api.csv; logs without a value go to _none.csv). Each file has its
+  own header.
+
+Parallel Fetching (--parallel / --shard):
+  Pagination follows one cursor, so a sequential export has a single request
+  in flight. --parallel N splits the time range into --shard windows (1h by
+  default) and fetches N of them at once, each with its own cursor. Shards
+  are written one after another in time order, so the output is the same as
+  a sequential export; logs exactly on a shard boundary are deduplicated. Up
+  to N shards are held in memory, so pick a smaller --shard for very dense
+  queries. Cannot be combined with --limit or --checkpoint.
+
+Watch Mode (--watch):
+  --watch 60s keeps the export running once the time range is written:
+  every 60s it fetches the logs since the newest one written and appends
+  them to the output, a continuous export without a daemon. Each poll
+  re-reads the last 30s so late-indexed logs are not missed; logs already
+  written are dropped by ID. Ctrl-C stops it and closes the output out
+  cleanly. Requires --to now and a streaming format (csv, json, ndjson or
+  template); cannot be combined with --limit, --sort desc, --parallel,
+  --store, --checkpoint, --resume-token, --auto-prune or --summarize-cmd.
+  Unlike tail, it first exports --from..now and keeps every search option.
+
+Debugging (--explain / --print-curl / --dry-run):
+  --explain prints how every flag was translated before the export starts:
+  storage tier, sort, indexes, page size, and the time range resolved to
+  absolute instants in both UTC and local time.
+
+  --print-curl prints an equivalent curl command for the first page. Keys
+  appear as $DD_API_KEY / $DD_APP_KEY, so the command is safe to share and can
+  be replayed to compare against the raw API or the UI.
+
+  --dry-run prints the request body of the first page as JSON on stdout
+  (query, from and to as sent, storage tier, indexes, sort and page size)
+  and exits without fetching or writing any output. Relative times are sent
+  as-is for Datadog to resolve; stderr notes what they resolve to now.
+  --team and --tags still look up their services and tags first.
 
 Progress:
-  A live status line on stderr shows: page number, log count, elapsed time, and rate.`,
+  A live status line on stderr shows: page number, log count, elapsed time, and rate.
+  When stderr is not a terminal, e.g. redirected to a file or under CI, it is
+  printed as a plain line every 10 seconds instead of redrawn in place.
+  --quiet turns it and the summary after the export off.
+  --progress json writes a JSON object per line instead, for wrapper scripts
+  and dashboards: {"event":"page","page":3,"total":15000,"elapsed":4.2,
+  "rate":3571.4,"cursor":"..."} after every page and an event "done" with
+  the same fields at the end. total is the logs fetched so far; cursor is
+  omitted with --parallel. Other stderr lines are plain text.`,
 	Example: `  # Search last hour, CSV to stdout
   ddlogs search -q "service:web" --from 1h
 
@@ -68,38 +472,753 @@ Progress:
   # JSON output
   ddlogs search -q "host:prod-*" --from 30m -f json
 
+  # Pick CSV columns and their order
+  ddlogs search -q "service:web" --columns timestamp,service,message,@http.status_code
+
+  # Pin later exports to the header of the first
+  ddlogs search -q "service:web" --from 1d -o day1.csv --emit-schema web.schema.json
+  ddlogs search -q "service:web" --from 2d --to 1d -o day2.csv --schema web.schema.json
+
+  # Only the main and audit indexes
+  ddlogs search -q "service:auth" --index main --index audit
+
+  # Sample the first 500 matches before a full export
+  ddlogs search -q "service:api" --from 7d --limit 500 -f json
+
+  # Only the 100 most recent errors
+  ddlogs search -q "status:error" --from 7d --sort desc --limit 100
+
+  # Cap messages at 2000 characters and record which logs were cut
+  ddlogs search -q "service:api" --from 24h --max-message-len 2000 --truncation-log cut.csv -o api.csv
+
+  # A week of logs, 8 hours fetched at once
+  ddlogs search -q "service:api" --from 7d --parallel 8 -f parquet -o week.parquet
+
+  # A month of NDJSON, zstd-compressed on the fly
+  ddlogs search -q "service:api" --from 30d -f ndjson -o api.ndjson.zst
+
+  # A week of CSV in files of about 500 MB (logs-0001.csv, logs-0002.csv, ...)
+  ddlogs search -q "service:api" --from 7d --rotate-size 500MB -o logs.csv
+
+  # Parquet for DuckDB/Athena
+  ddlogs search -q "service:api" --from 24h -f parquet -o api.parquet
+
+  # Straight into a DuckDB table, then query it
+  ddlogs search -q "service:api" --from 24h -f duckdb --table api_logs -o analytics.duckdb
+  duckdb analytics.duckdb -c "select status, count(*) from api_logs group by 1"
+
+  # Add the last hour to a Parquet dataset, e.g. from an hourly cron job
+  ddlogs search -q "service:api" --from 1h -f parquet --dataset -o archive/api/
+
+  # Parquet with mixed-type attributes split into one column per type
+  ddlogs search -q "service:api" --from 24h -f parquet --type-conflicts split -o api.parquet
+
+  # Errors whose message matches a regex, without health checks
+  ddlogs search -q "service:api status:error" --from 1h --grep '(?i)timeout' --grep-v healthcheck
+
+  # One line per log, in your own layout
+  ddlogs search -q "status:error" --from 1h --template '{{.Timestamp.Format "15:04:05"}} {{.Service}} {{.Attr "http.status_code"}} {{.Message}}'
+
+  # NDJSON piped into jq
+  ddlogs search -q "status:error" --from 1h -f ndjson | jq -r .attributes.message
+
   # Custom time window (30 min ago to 5 min ago)
-  ddlogs search -q "service:api" --from 30m --to 5m -o logs.csv`,
+  ddlogs search -q "service:api" --from 30m --to 5m -o logs.csv
+
+  # Errors from every service the payments team owns
+  ddlogs search -q "status:error" --from 1h --team payments
+
+  # Errors from one deployment, with pod/namespace/container columns
+  ddlogs search -q "status:error" --from 1h --k8s-namespace payments --k8s-deployment api
+
+  # Timeline of auth logs and CloudTrail for the same users/IPs
+  ddlogs search -q "service:auth" --from 6h --correlate-cloudtrail s3://trail-bucket/AWSLogs/123456789012/CloudTrail/us-east-1/2024/05/01/ -o timeline.csv
+
+  # Last night's errors on the on-call's clock
+  ddlogs search -q "status:error" --from 12h --format table --tz America/New_York
+
+  # Recent errors, sized to paste into a chat with a model
+  ddlogs search -q "service:api status:error" --from 1h --llm --max-tokens 8000 | pbcopy
+
+  # Export the last hour's errors and have a local model write the digest
+  ddlogs search -q "status:error" --from 1h --llm -o errors.csv --summarize-cmd 'ollama run llama3 "Summarize these $DDLOGS_QUERY logs as an incident digest"'
+
+  # Mask card numbers with a WebAssembly plugin before writing
+  ddlogs search -q "service:checkout" --from 1h --transform mask-cards.wasm -o checkout.csv
+
+  # Only the logs touching known-bad IPs or domains
+  ddlogs search -q "source:nginx" --from 24h --ioc-file indicators.txt --ioc-only -o hits.csv
+
+  # One file per tenant
+  ddlogs search -q "service:billing" --from 24h --route-by @org_id --route-map routes.yaml
+
+  # One file per service: out/web.csv, out/api.csv, ...
+  ddlogs search -q "env:prod" --from 24h --split-by service -o out
+
+  # Export the last hour of errors, then keep appending new ones every minute
+  ddlogs search -q "status:error" --from 1h -o errors.ndjson -f ndjson --watch 60s
+
+  # Save into the results store, then read it back
+  ddlogs search -q "status:error" --from 24h --store
+  ddlogs results list`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		apiKey := os.Getenv("DD_API_KEY")
-		appKey := os.Getenv("DD_APP_KEY")
-		site := os.Getenv("DD_SITE")
+		searchFormat = profileFormat(cmd, searchFormat)
+		if cmd.Flags().Changed("template") && !cmd.Flags().Changed("format") {
+			searchFormat = "template"
+		}
+		if searchLLM {
+			if cmd.Flags().Changed("format") && searchFormat != "llm" {
+				return fmt.Errorf("--llm writes its own format and cannot be combined with --format %s", searchFormat)
+			}
+			searchFormat = "llm"
+		}
+		if searchFormat != "csv" && searchFormat != "json" && searchFormat != "ndjson" && searchFormat != "parquet" && searchFormat != "duckdb" && searchFormat != "xlsx" && searchFormat != "md" && searchFormat != "table" && searchFormat != "template" && searchFormat != "llm" {
+			return fmt.Errorf("--format must be csv, json, ndjson, parquet, duckdb, xlsx, md, table, template or llm")
+		}
+		searchTpl = nil
+		if searchFormat == "template" {
+			if searchTmpl == "" {
+				return fmt.Errorf("--format template requires --template")
+			}
+			tmpl, err := handlers.ParseLogTemplate(searchTmpl)
+			if err != nil {
+				return fmt.Errorf("--template: %w", err)
+			}
+			if searchStore {
+				return fmt.Errorf("--format template cannot be combined with --store")
+			}
+			searchTpl = tmpl
+		} else if searchTmpl != "" {
+			return fmt.Errorf("--template requires --format template")
+		}
+		searchTok = nil
+		if searchFormat == "llm" {
+			if searchStore || searchRaw || searchRouted() || searchTrail != "" || searchCkpt != "" || searchToken != "" || searchRotSz != "" || searchRotRow > 0 {
+				return fmt.Errorf("--llm cannot be combined with --store, --raw, --route-by, --split-by, --correlate-cloudtrail, --checkpoint, --resume-token or --rotate-*")
+			}
+			if searchTokens <= 0 {
+				return fmt.Errorf("--max-tokens must be positive")
+			}
+			var err error
+			if searchTok, err = handlers.ParseTokenizer(searchTokzr); err != nil {
+				return fmt.Errorf("--tokenizer: %w", err)
+			}
+			// Long stack traces and payloads would crowd out other logs.
+			if !cmd.Flags().Changed("max-message-len") {
+				searchMaxMsg = 500
+			}
+			if !cmd.Flags().Changed("max-cell-len") {
+				searchMaxCel = 200
+			}
+		} else if cmd.Flags().Changed("max-tokens") || cmd.Flags().Changed("tokenizer") {
+			return fmt.Errorf("--max-tokens and --tokenizer require --llm")
+		}
+		if searchColW < 0 {
+			return fmt.Errorf("--max-col-width must not be negative")
+		}
+		if searchColW > 0 && searchFormat != "md" && searchFormat != "table" {
+			return fmt.Errorf("--max-col-width requires --format md or table")
+		}
+		searchTableW = 0
+		if searchFormat == "table" {
+			// A table is for reading in a terminal; anywhere else the
+			// same export is written as CSV.
+			width, _, err := term.GetSize(int(os.Stdout.Fd()))
+			if err != nil || searchOutput != "" || searchStore || searchRouted() || searchGzip != "" || searchTrail != "" {
+				searchFormat = "csv"
+			} else {
+				searchTableW = width
+			}
+		}
+		if cmd.Flags().Changed("table") && searchFormat != "duckdb" {
+			return fmt.Errorf("--table requires --format duckdb")
+		}
 
-		if apiKey == "" {
-			return fmt.Errorf("DD_API_KEY environment variable is required")
+		isJSON := searchFormat == "json" || searchFormat == "ndjson"
+		if searchStable && !isJSON {
+			return fmt.Errorf("--stable-json requires --format json or ndjson")
+		}
+		if searchRaw && (!isJSON || searchStable || searchStats != "") {
+			return fmt.Errorf("--raw requires --format json or ndjson and cannot be combined with --stable-json or --column-stats")
+		}
+		if searchStore && searchOutput != "" {
+			return fmt.Errorf("--store and --output are mutually exclusive")
+		}
+		codec, err := handlers.OutputCompression(searchOutput, searchGzip)
+		if err != nil {
+			return fmt.Errorf("--compress: %w", err)
+		}
+		if codec != "" && (searchFormat == "parquet" || searchFormat == "xlsx" || searchStore || searchCkpt != "" || searchToken != "") {
+			return fmt.Errorf("--compress (or a .gz/.zst --output) cannot be combined with parquet or xlsx format, --store, --checkpoint or --resume-token")
+		}
+		if searchTrail != "" && (searchFormat == "parquet" || searchFormat == "xlsx" || searchFormat == "md" || searchFormat == "template" || searchRaw || searchRouted()) {
+			return fmt.Errorf("--correlate-cloudtrail requires csv, json or ndjson and cannot be combined with --raw, --route-by or --split-by")
+		}
+		if searchIOC != "" && searchRaw {
+			return fmt.Errorf("--ioc-file cannot be combined with --raw")
+		}
+		if searchIOCHit && searchIOC == "" {
+			return fmt.Errorf("--ioc-only requires --ioc-file")
+		}
+		if len(searchXform) > 0 && searchRaw {
+			return fmt.Errorf("--transform cannot be combined with --raw")
+		}
+		if !searchStrict && !cmd.Flags().Changed("reject-file") {
+			// Outside strict mode the reject file only collects logs a
+			// transform fails on, and only when one is named.
+			searchReject = ""
+		}
+		searchGrepRe, searchGrepVR = nil, nil
+		if searchGrep != "" || searchGrepV != "" {
+			if searchRaw || searchStore {
+				return fmt.Errorf("--grep and --grep-v cannot be combined with --raw or --store")
+			}
+			if searchGrep != "" {
+				if searchGrepRe, err = regexp.Compile(searchGrep); err != nil {
+					return fmt.Errorf("--grep: %w", err)
+				}
+			}
+			if searchGrepV != "" {
+				if searchGrepVR, err = regexp.Compile(searchGrepV); err != nil {
+					return fmt.Errorf("--grep-v: %w", err)
+				}
+			}
+		}
+		if searchMaxCls != "" && searchClass == "" {
+			return fmt.Errorf("--max-classification requires --classification")
+		}
+		if searchClass != "" {
+			if searchRaw {
+				return fmt.Errorf("--classification cannot be combined with --raw")
+			}
+			if searchMaxCls == "" {
+				return fmt.Errorf("--classification requires --max-classification")
+			}
+			var err error
+			if searchMaxPolicy, err = handlers.ParseClassificationLevel(searchMaxCls); err != nil {
+				return fmt.Errorf("--max-classification: %w", err)
+			}
+			if searchPolicy, err = handlers.LoadClassification(searchClass); err != nil {
+				return err
+			}
 		}
-		if appKey == "" {
-			return fmt.Errorf("DD_APP_KEY environment variable is required")
+		if searchCols != "" {
+			if searchFormat != "csv" {
+				return fmt.Errorf("--columns only applies to csv format")
+			}
+			if searchTagColumns() != nil || searchStore {
+				return fmt.Errorf("--columns cannot be combined with --store or --k8s-* tag columns")
+			}
+			var err error
+			if searchColumn, err = handlers.ParseColumns(searchCols); err != nil {
+				return fmt.Errorf("--columns: %w", err)
+			}
 		}
-		if site == "" {
-			site = "datadoghq.com"
+		if searchSchema != "" {
+			if searchFormat != "csv" || searchCols != "" {
+				return fmt.Errorf("--schema requires csv format and cannot be combined with --columns")
+			}
+			if searchTagColumns() != nil || searchStore {
+				return fmt.Errorf("--schema cannot be combined with --store or --k8s-* tag columns")
+			}
+			var err error
+			if searchColumn, err = handlers.LoadCSVSchema(searchSchema); err != nil {
+				return err
+			}
+		}
+		if searchEmit != "" && (searchFormat != "csv" || searchRouted() || searchTrail != "") {
+			return fmt.Errorf("--emit-schema requires csv format and cannot be combined with --route-by, --split-by or --correlate-cloudtrail")
+		}
+		if searchSample < 0 {
+			return fmt.Errorf("--discover-sample must not be negative")
+		}
+		if searchSample > 0 {
+			searchDisc = true
+		}
+		if searchRescan && searchFormat != "csv" {
+			return fmt.Errorf("--refresh-schema only applies to csv format")
+		}
+		if searchFormat == "csv" && searchColumn == nil && !searchRouted() && searchTrail == "" {
+			if dir, err := handlers.DefaultSchemaCacheDir(); err == nil {
+				searchCache = dir
+			}
+		}
+		switch searchTypes {
+		case "", handlers.TypeConflictWiden:
+		case handlers.TypeConflictSplit, handlers.TypeConflictError:
+			searchDisc = true
+		default:
+			return fmt.Errorf("--type-conflicts must be widen, split or error")
+		}
+		if searchTypes != "" && searchFormat != "parquet" && searchFormat != "duckdb" {
+			return fmt.Errorf("--type-conflicts requires --format parquet or duckdb")
+		}
+		if searchDisc && ((searchFormat != "csv" && searchFormat != "parquet" && searchFormat != "duckdb") || searchCols != "" || searchSchema != "" || searchStore || searchRouted() || searchTrail != "") {
+			return fmt.Errorf("--discover-schema/--discover-sample requires csv, parquet or duckdb format and cannot be combined with --columns, --schema, --store, --route-by, --split-by or --correlate-cloudtrail")
+		}
+		if searchPrint && (searchFormat != "parquet" || searchStore || searchRouted() || searchTrail != "") {
+			return fmt.Errorf("--print-schema requires --format parquet and cannot be combined with --store, --route-by, --split-by or --correlate-cloudtrail")
+		}
+		if len(searchIndex) > 0 && searchStore {
+			return fmt.Errorf("--index cannot be combined with --store")
+		}
+		if searchFlat < 0 {
+			return fmt.Errorf("--flatten-depth must not be negative")
+		}
+		if searchFlat > 0 && (searchFormat != "csv" || searchCols != "" || searchSchema != "") {
+			return fmt.Errorf("--flatten-depth requires csv format and has no effect with --columns or --schema")
+		}
+		if searchPrune && (searchFormat != "csv" || searchCols != "" || searchSchema != "" || searchEmit != "" || searchRouted() || searchTrail != "" || searchRotSz != "" || searchRotRow > 0 || searchCkpt != "" || searchToken != "") {
+			return fmt.Errorf("--auto-prune requires csv format and cannot be combined with --columns, --schema, --emit-schema, --route-by, --split-by, --correlate-cloudtrail, --rotate-*, --checkpoint or --resume-token")
+		}
+		if searchLong && (searchFormat != "csv" || searchSchema != "" || searchEmit != "" || searchPrune || searchDisc || searchTrail != "") {
+			return fmt.Errorf("--long-format requires csv format and cannot be combined with --schema, --emit-schema, --auto-prune, --discover-schema or --correlate-cloudtrail")
+		}
+		if searchLocale != "" {
+			if searchFormat != "csv" || searchTrail != "" {
+				return fmt.Errorf("--locale requires csv format and cannot be combined with --correlate-cloudtrail")
+			}
+			var err error
+			if searchLoc, err = handlers.ParseCSVLocale(searchLocale); err != nil {
+				return fmt.Errorf("--locale: %w", err)
+			}
+		}
+		searchZone = nil
+		if searchTZ != "" {
+			if searchRaw || searchTrail != "" || searchStore || searchFormat == "parquet" || searchFormat == "duckdb" {
+				return fmt.Errorf("--tz cannot be combined with --raw, --correlate-cloudtrail, --store or the parquet and duckdb formats")
+			}
+			var err error
+			if searchZone, err = handlers.ParseTimeZone(searchTZ); err != nil {
+				return fmt.Errorf("--tz: %w", err)
+			}
+		}
+		searchTimeF = nil
+		if searchTimeFm != "" {
+			if searchRaw || searchTrail != "" || searchStore || searchFormat == "parquet" || searchFormat == "duckdb" || searchFormat == "xlsx" {
+				return fmt.Errorf("--time-format cannot be combined with --raw, --correlate-cloudtrail, --store or the parquet, duckdb and xlsx formats")
+			}
+			var err error
+			if searchTimeF, err = handlers.ParseTimeFormat(searchTimeFm); err != nil {
+				return fmt.Errorf("--time-format: %w", err)
+			}
+		}
+		if searchXLMode {
+			if searchFormat != "csv" || searchTrail != "" {
+				return fmt.Errorf("--excel requires csv format and cannot be combined with --correlate-cloudtrail")
+			}
+			// Files for double-clicking open are the ones most at risk
+			// from formula injection.
+			searchCSVSafe = true
+		}
+		if searchCSVSafe && searchFormat != "csv" {
+			return fmt.Errorf("--csv-safe/--excel-safe only applies to csv format")
+		}
+		if searchMaxMsg < 0 || searchMaxCel < 0 {
+			return fmt.Errorf("--max-message-len and --max-cell-len must not be negative")
+		}
+		if (searchMaxMsg > 0 || searchMaxCel > 0) && (searchRaw || searchTrail != "") {
+			return fmt.Errorf("--max-message-len/--max-cell-len cannot be combined with --raw or --correlate-cloudtrail")
+		}
+		if searchTrunc != "" && searchMaxMsg == 0 && searchMaxCel == 0 {
+			return fmt.Errorf("--truncation-log requires --max-message-len or --max-cell-len")
+		}
+		if searchLimit < 0 {
+			return fmt.Errorf("--limit must not be negative")
+		}
+		if searchSort != "asc" && searchSort != "desc" {
+			return fmt.Errorf("--sort must be asc or desc")
+		}
+		if searchSort == "desc" && searchStore {
+			return fmt.Errorf("--sort desc cannot be combined with --store")
+		}
+		if searchPar < 1 {
+			return fmt.Errorf("--parallel must be at least 1")
+		}
+		if searchShard <= 0 {
+			return fmt.Errorf("--shard must be positive")
+		}
+		if searchPar > 1 && (searchLimit > 0 || searchCkpt != "") {
+			return fmt.Errorf("--parallel cannot be combined with --limit or --checkpoint")
+		}
+		if searchResume && searchCkpt == "" {
+			return fmt.Errorf("--resume requires --checkpoint")
+		}
+		if searchToken != "" && (searchCkpt != "" || searchPar > 1) {
+			return fmt.Errorf("--resume-token cannot be combined with --checkpoint or --parallel")
+		}
+		if (searchCkpt != "" || searchToken != "") && (searchOutput == "" || searchFormat == "parquet" || searchFormat == "xlsx" || searchFormat == "md" || searchStore || searchRouted() || searchTrail != "" || searchStats != "") {
+			return fmt.Errorf("--checkpoint and --resume-token require --output with csv, json, ndjson or template and cannot be combined with --store, --route-by, --split-by, --correlate-cloudtrail or --column-stats")
+		}
+		if (searchRoute == "") != (searchRoutes == "") {
+			return fmt.Errorf("--route-by and --route-map must be used together")
+		}
+		if searchRoute != "" && (searchOutput != "" || searchStore || searchRaw) {
+			return fmt.Errorf("--route-by cannot be combined with --output, --store or --raw")
+		}
+		if searchSplit != "" && (searchOutput == "" || searchRoute != "" || searchRaw) {
+			return fmt.Errorf("--split-by requires --output (the directory for the files) and cannot be combined with --route-by or --raw")
+		}
+		searchRotate = 0
+		if searchRotSz != "" {
+			if searchRotate, err = handlers.ParseByteSize(searchRotSz); err != nil {
+				return fmt.Errorf("--rotate-size: %w", err)
+			}
+		}
+		if searchRotRow < 0 {
+			return fmt.Errorf("--rotate-rows must not be negative")
+		}
+		if searchRotate > 0 || searchRotRow > 0 {
+			if searchOutput == "" || searchCkpt != "" || searchToken != "" || searchTrail != "" || searchSplit != "" {
+				return fmt.Errorf("--rotate-size/--rotate-rows require --output and cannot be combined with --checkpoint, --resume-token, --correlate-cloudtrail or --split-by")
+			}
+			if searchRotate > 0 && (searchFormat == "parquet" || searchFormat == "xlsx") {
+				return fmt.Errorf("--rotate-size cannot be combined with parquet or xlsx format, which are buffered before being written; use --rotate-rows")
+			}
+		}
+		if searchFormat == "duckdb" && (searchOutput == "" || codec != "" || searchCkpt != "" || searchToken != "" || searchRouted() || searchTrail != "" || searchRotate > 0 || searchRotRow > 0) {
+			return fmt.Errorf("--format duckdb requires --output (the database file) and cannot be combined with --compress, --checkpoint, --resume-token, --route-by, --split-by, --correlate-cloudtrail or --rotate-*")
+		}
+
+		if searchProg != "text" && searchProg != "json" {
+			return fmt.Errorf("--progress must be text or json")
+		}
+		if searchProg == "json" && quiet {
+			return fmt.Errorf("--progress json cannot be combined with --quiet")
+		}
+		if searchDSet && (searchFormat != "parquet" || searchOutput == "" || searchRouted() || searchTrail != "" || searchRotate > 0 || searchRotRow > 0) {
+			return fmt.Errorf("--dataset requires --format parquet and --output (the dataset directory) and cannot be combined with --route-by, --split-by, --correlate-cloudtrail or --rotate-*")
+		}
+
+		if searchSumCmd != "" && (searchFormat == "parquet" || searchFormat == "xlsx" || searchFormat == "duckdb" || searchStore || searchRouted() || searchRotSz != "" || searchRotRow > 0 || searchCkpt != "" || searchToken != "") {
+			return fmt.Errorf("--summarize-cmd needs a text format written to one output and cannot be combined with --store, --route-by, --split-by, --rotate-*, --checkpoint or --resume-token")
 		}
 
-		if searchFormat != "csv" && searchFormat != "json" {
-			return fmt.Errorf("--format must be csv or json")
+		if searchWatch < 0 {
+			return fmt.Errorf("--watch must be positive")
+		}
+		if searchWatch > 0 && (searchTo != "now" || (searchFormat != "csv" && searchFormat != "json" && searchFormat != "ndjson" && searchFormat != "template") ||
+			searchLimit > 0 || searchSort == "desc" || searchPar > 1 || searchStore || searchCkpt != "" || searchToken != "" || searchPrune || searchSumCmd != "") {
+			return fmt.Errorf("--watch requires --to now and csv, json, ndjson or template format and cannot be combined with --limit, --sort desc, --parallel, --store, --checkpoint, --resume-token, --auto-prune or --summarize-cmd")
 		}
 
-		handler := handlers.NewDDHandler(site, apiKey, appKey)
-		return handler.Query(searchQuery, searchFrom, searchTo, searchOutput, searchFormat)
+		handler, err := newHandler()
+		if err != nil {
+			return err
+		}
+		searchQuery = profileQuery(searchQuery)
+		if searchTeam != "" {
+			services, err := handler.TeamServices(searchTeam)
+			if err != nil {
+				return err
+			}
+			if len(services) == 0 {
+				return fmt.Errorf("no services in the Service Catalog are owned by team %q", searchTeam)
+			}
+			fmt.Fprintf(os.Stderr, "Team %s owns %d service(s): %s\n", searchTeam, len(services), strings.Join(services, ", "))
+			searchQuery = handlers.ScopeQuery(searchQuery, services)
+		}
+		if searchTags != "" {
+			tags, err := handlers.ParseTagExpr(searchTags)
+			if err != nil {
+				return fmt.Errorf("--tags: %w", err)
+			}
+			if err := handler.ValidateTags(tags); err != nil {
+				return fmt.Errorf("--tags: %w", err)
+			}
+			searchQuery = handlers.AndQuery(tags.Query(), searchQuery)
+		}
+		if k8s := handlers.K8sQuery(searchK8sNS, searchK8sDep); k8s != "" {
+			searchQuery = handlers.AndQuery(k8s, searchQuery)
+		}
+		if err := lintQuery(searchQuery, searchFrom, searchTo, searchLint); err != nil {
+			return err
+		}
+		if searchDry {
+			return handler.DryRun(os.Stdout, searchOptions(searchOutput))
+		}
+		if searchPrint {
+			return handler.PrintSchema(searchOptions(""))
+		}
+		if searchStore {
+			return storeSearch(cmd, handler)
+		}
+		opts := searchOptions(searchOutput)
+		if searchRoute != "" {
+			opts.RouteBy = searchRoute
+			opts.RouteMap, err = handlers.LoadRouteMap(searchRoutes)
+			if err != nil {
+				return err
+			}
+		}
+		err = handler.Query(opts)
+		if errors.Is(err, handlers.ErrInterrupted) {
+			cmd.SilenceUsage = true
+		}
+		return err
 	},
 }
 
+// lintQuery warns on stderr about expensive patterns in query over the
+// from..to window, and fails instead when strict is set.
+func lintQuery(query, from, to string, strict bool) error {
+	lints := handlers.LintQuery(query, from, to)
+	for _, l := range lints {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", l)
+	}
+	if strict && len(lints) > 0 {
+		return fmt.Errorf("query rejected by --strict-query (%d expensive pattern(s))", len(lints))
+	}
+	return nil
+}
+
+// searchOptions collects the search flags into QueryOptions writing to outputFile.
+func searchOptions(outputFile string) handlers.QueryOptions {
+	return handlers.QueryOptions{
+		Query:           searchQuery,
+		From:            searchFrom,
+		To:              searchTo,
+		OutputFile:      outputFile,
+		Format:          searchFormat,
+		Quiet:           quiet,
+		ProgressJSON:    searchProg == "json",
+		Compress:        searchGzip,
+		RotateSize:      searchRotate,
+		RotateRows:      searchRotRow,
+		SplitBy:         searchSplit,
+		TypeConflicts:   searchTypes,
+		DuckDBTable:     searchTable,
+		ColumnStatsFile: searchStats,
+		StableJSON:      searchStable,
+		Raw:             searchRaw,
+		PrintCurl:       searchCurl,
+		Explain:         searchExpl,
+		Strict:          searchStrict,
+		RejectFile:      searchReject,
+		TagColumns:      searchTagColumns(),
+
+		CorrelateCloudTrail: searchTrail,
+		CorrelateWindow:     searchWindow,
+
+		IOCFile: searchIOC,
+		IOCOnly: searchIOCHit,
+
+		Grep:       searchGrepRe,
+		GrepV:      searchGrepVR,
+		Transforms: searchXform,
+
+		Classification:    searchPolicy,
+		MaxClassification: searchMaxPolicy,
+
+		Checkpoint: searchCkpt,
+		Resume:     searchResume,
+
+		ResumeToken: searchToken,
+
+		Indexes:    searchIndex,
+		Limit:      searchLimit,
+		Descending: searchSort == "desc",
+		Parallel:   searchPar,
+		ShardSize:  searchShard,
+		Columns:    searchColumn,
+		ExcelSafe:  searchCSVSafe,
+		Excel:      searchXLMode,
+		AutoPrune:  searchPrune,
+		LongFormat: searchLong,
+		Dataset:    searchDSet,
+
+		SchemaCacheDir: searchCache,
+		RefreshSchema:  searchRescan,
+		DiscoverSchema: searchDisc,
+		DiscoverSample: searchSample,
+		EmitSchema:     searchEmit,
+		Locale:         searchLoc,
+		TimeZone:       searchZone,
+		TimeFormat:     searchTimeF,
+		FlattenDepth:   searchFlat,
+
+		MaxMessageLen: searchMaxMsg,
+		MaxCellLen:    searchMaxCel,
+		TruncationLog: searchTrunc,
+
+		MaxColWidth: searchColW,
+		TableWidth:  searchTableW,
+
+		Template: searchTpl,
+
+		MaxTokens: searchTokens,
+		Tokenizer: searchTok,
+
+		SummarizeCmd: searchSumCmd,
+
+		Watch: searchWatch,
+	}
+}
+
+// searchRouted reports whether the export fans out to several files with
+// --route-by or --split-by.
+func searchRouted() bool {
+	return searchRoute != "" || searchSplit != ""
+}
+
+// searchTagColumns returns the tag columns requested by the --k8s-* flags.
+func searchTagColumns() []string {
+	if searchK8sCol || searchK8sNS != "" || searchK8sDep != "" {
+		return handlers.K8sTagColumns
+	}
+	return nil
+}
+
+// storeKeyExempt are the search flags that do not change what an export
+// contains, and so are left out of its store fingerprint. Every other flag
+// given is part of it.
+var storeKeyExempt = map[string]bool{
+	"query": true, "query-file": true, "from": true, "to": true, "format": true,
+	"store": true, "refresh": true, "output": true, "progress": true, "quiet": true,
+	"parallel": true, "shard": true, "max-retries": true, "config": true,
+	"print-curl": true, "explain": true, "column-stats": true, "emit-schema": true,
+	"truncation-log": true, "reject-file": true,
+}
+
+// storeSearch runs the search into the results store, skipping the download
+// when the same query, window, format and options are already stored. A
+// relative window is resolved to absolute times first, so it is fetched
+// afresh on every run and stored under the times it covered.
+func storeSearch(cmd *cobra.Command, handler *handlers.DDHandler) error {
+	s, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	from, to := searchFrom, searchTo
+	_, fromAbs := handlers.ParseAbsoluteTime(from)
+	_, toAbs := handlers.ParseAbsoluteTime(to)
+	if !fromAbs || !toAbs {
+		start, end, err := handlers.ResolveTimeRange(from, to)
+		if err != nil {
+			return err
+		}
+		from, to = start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339)
+	}
+	var options []string
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if !storeKeyExempt[f.Name] {
+			options = append(options, f.Name+"="+f.Value.String())
+		}
+	})
+	if profileChosen {
+		options = append(options, "profile="+activeProfileName)
+	}
+
+	fp := store.Fingerprint(searchQuery, from, to, searchFormat, options)
+	existing, err := s.Get(fp)
+	if err != nil {
+		return err
+	}
+	if existing != nil && !searchFresh {
+		fmt.Fprintf(os.Stderr, "Already stored as %s (created %s), skipping download; pass --refresh to re-run\n",
+			fp, existing.CreatedAt.Local().Format(time.RFC3339))
+		return nil
+	}
+
+	entry := &store.Entry{
+		Fingerprint: fp,
+		Query:       searchQuery,
+		From:        from,
+		To:          to,
+		Format:      searchFormat,
+		Options:     options,
+	}
+	path, err := s.Path(entry)
+	if err != nil {
+		return err
+	}
+	opts := searchOptions(path)
+	opts.From, opts.To = from, to
+	if err := handler.Query(opts); err != nil {
+		return err
+	}
+	if err := s.Save(entry); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Stored as %s\n", fp)
+	return nil
+}
+
 func init() {
-	searchCmd.Flags().StringVarP(&searchQuery, "query", "q", "", "Datadog logs query string (required)")
-	searchCmd.Flags().StringVar(&searchFrom, "from", "15m", "Start of time range as a relative duration (e.g. 15m, 1h, 24h, 72h)")
-	searchCmd.Flags().StringVar(&searchTo, "to", "now", "End of time range (e.g. 5m, now)")
+	addQueryFlags(searchCmd, &searchQuery, "Datadog logs query string", false)
+	searchCmd.Flags().StringVar(&searchFrom, "from", "15m", "Start of time range: a duration ago (15m, 24h, 7d, 2w, 1mo) or an absolute time (RFC 3339, date, epoch)")
+	searchCmd.Flags().StringVar(&searchTo, "to", "now", "End of time range: now, a duration ago, or an absolute time")
 	searchCmd.Flags().StringVarP(&searchOutput, "output", "o", "", "Output file path (default: stdout)")
-	searchCmd.Flags().StringVarP(&searchFormat, "format", "f", "csv", "Output format: csv or json")
-	searchCmd.MarkFlagRequired("query")
+	searchCmd.Flags().StringVarP(&searchFormat, "format", "f", "csv", "Output format: csv, json, ndjson, parquet, duckdb, xlsx, md, table, template or llm")
+	searchCmd.Flags().StringVar(&searchGzip, "compress", "", "Compress the output: gzip or zstd (default: from an --output ending in .gz or .zst)")
+	searchCmd.Flags().StringVar(&searchRotSz, "rotate-size", "", "Start a new numbered output file (logs-0001.csv, ...) every this many bytes, e.g. 500MB")
+	searchCmd.Flags().IntVar(&searchRotRow, "rotate-rows", 0, "Start a new numbered output file (logs-0001.csv, ...) every this many logs")
+	searchCmd.Flags().StringVar(&searchCols, "columns", "", "Comma-separated CSV columns in order, e.g. timestamp,service,@http.status_code,tag:env (skips discovery)")
+	searchCmd.Flags().StringVar(&searchSchema, "schema", "", "Pin the CSV header to the columns of this schema file (see --emit-schema)")
+	searchCmd.Flags().StringVar(&searchEmit, "emit-schema", "", "After the export, write its CSV header as a schema file for --schema")
+	searchCmd.Flags().BoolVar(&searchPrune, "auto-prune", false, "Drop CSV columns that are empty or hold the same value in every row; output is written once the export ends")
+	searchCmd.Flags().IntVar(&searchFlat, "flatten-depth", 0, "Expand nested attribute objects into dotted CSV columns down to this many levels (0: one JSON cell per attribute)")
+	searchCmd.Flags().BoolVar(&searchDisc, "discover-schema", false, "Sample the time range before exporting so the CSV header includes attributes first seen on later pages")
+	searchCmd.Flags().IntVar(&searchSample, "discover-sample", 0, fmt.Sprintf("Logs sampled across the time range for the CSV header; implies --discover-schema (default %d)", handlers.DefaultDiscoverSample))
+	searchCmd.Flags().BoolVar(&searchPrint, "print-schema", false, "Sample the time range and print the Parquet schema (types, nullability, type conflicts) without exporting")
+	searchCmd.Flags().StringVar(&searchTable, "table", handlers.DefaultDuckDBTable, "Table to create (or replace) in a --format duckdb database")
+	searchCmd.Flags().StringVar(&searchProg, "progress", "text", "Progress on stderr: text (a status line) or json (an event per page, as JSON lines)")
+	searchCmd.Flags().BoolVar(&searchDSet, "dataset", false, "With --format parquet, treat --output as a dataset directory and add the export to it as a new part file")
+	searchCmd.Flags().StringVar(&searchTypes, "type-conflicts", "", "Parquet attributes with values of several types: widen (to STRING), split (one column per type) or error (default widen)")
+	searchCmd.Flags().BoolVar(&searchRescan, "refresh-schema", false, "Ignore the CSV columns cached from earlier exports of this query and cache this export's instead")
+	searchCmd.Flags().IntVar(&searchMaxMsg, "max-message-len", 0, "Cut messages longer than this many characters, ending them with … (0: no limit)")
+	searchCmd.Flags().IntVar(&searchMaxCel, "max-cell-len", 0, "Cut string attributes longer than this many characters, ending them with … (0: no limit)")
+	searchCmd.Flags().IntVar(&searchColW, "max-col-width", 0, "With --format md, cut cells longer than this many characters, ending them with …; with --format table, cap every column but message (0: no limit for md, 40 for table)")
+	searchCmd.Flags().BoolVar(&searchLLM, "llm", false, "Write compact CSV sized for an LLM's context: empty and constant columns trimmed, long values cut, capped at --max-tokens (same as --format llm)")
+	searchCmd.Flags().IntVar(&searchTokens, "max-tokens", handlers.DefaultMaxTokens, "Token budget of --llm output; fetching stops once it is reached")
+	searchCmd.Flags().StringVar(&searchTokzr, "tokenizer", "cl100k", "Tokenizer --max-tokens is estimated for: cl100k, o200k, claude or llama3")
+	searchCmd.Flags().DurationVar(&searchWatch, "watch", 0, "After the export, poll every this often for new logs and append them until Ctrl-C, e.g. 60s")
+	searchCmd.Flags().StringVar(&searchSumCmd, "summarize-cmd", "", "Shell command to pipe the finished export into (e.g. a local LLM CLI); its output is printed as the run's summary")
+	searchCmd.Flags().StringVar(&searchTmpl, "template", "", `Go template each log is rendered with for --format template, e.g. '{{.Timestamp}} {{.Service}} {{.Message}}' (implies --format template)`)
+	searchCmd.Flags().StringVar(&searchTrunc, "truncation-log", "", "With --max-message-len/--max-cell-len, write the ID, column and original length of every cut value to this CSV file")
+	searchCmd.Flags().StringVar(&searchLocale, "locale", "", "Format CSV for spreadsheets of this locale, e.g. de-DE: delimiter, decimal comma, date layout")
+	searchCmd.Flags().StringVar(&searchTZ, "tz", "", "Render timestamps in this time zone: an IANA name such as America/New_York, local or UTC")
+	searchCmd.Flags().StringVar(&searchTimeFm, "time-format", "", "Write timestamps as rfc3339 (default), rfc3339nano, epoch, epoch-ms or a Go layout such as '2006-01-02 15:04:05.000'")
+	searchCmd.Flags().BoolVar(&searchCSVSafe, "csv-safe", false, "Prefix CSV cells starting with =, +, -, @ with ' so spreadsheets do not run them as formulas, and write control characters as \\u escapes")
+	searchCmd.Flags().BoolVar(&searchCSVSafe, "excel-safe", false, "Same as --csv-safe")
+	searchCmd.Flags().BoolVar(&searchLong, "long-format", false, "Write CSV as one row per log_id, attribute and value instead of one column per attribute")
+	searchCmd.Flags().BoolVar(&searchXLMode, "excel", false, "Write CSV that opens correctly in Excel by double-click: UTF-8 BOM, CRLF line endings, long numeric IDs kept as text (implies --csv-safe)")
+	searchCmd.Flags().StringArrayVar(&searchIndex, "index", nil, "Only search this log index (repeatable, e.g. --index main --index audit)")
+	searchCmd.Flags().IntVar(&searchLimit, "limit", 0, "Stop after fetching this many logs (0: no limit)")
+	searchCmd.Flags().StringVar(&searchSort, "sort", "asc", "Order by timestamp: asc (oldest first) or desc (newest first)")
+	searchCmd.Flags().IntVar(&searchPar, "parallel", 1, "Fetch this many --shard windows of the time range at once, merged back in timestamp order")
+	searchCmd.Flags().DurationVar(&searchShard, "shard", handlers.DefaultShardSize, "With --parallel, the length of each concurrently fetched window")
+	searchCmd.Flags().BoolVar(&searchStore, "store", false, "Save the export in the local results store instead of --output")
+	searchCmd.Flags().BoolVar(&searchFresh, "refresh", false, "With --store, re-download even if the export is already stored")
+	searchCmd.Flags().StringVar(&searchStats, "column-stats", "", "Also write a JSON column profile to this path")
+	searchCmd.Flags().BoolVar(&searchStable, "stable-json", false, "Sort object keys in JSON output so exports diff cleanly")
+	searchCmd.Flags().BoolVar(&searchRaw, "raw", false, "Write events exactly as the API returned them (json/ndjson only)")
+	searchCmd.Flags().BoolVar(&searchCurl, "print-curl", false, "Print an equivalent curl command (keys redacted) to stderr")
+	searchCmd.Flags().BoolVar(&searchDry, "dry-run", false, "Print the API request body the export would send, as JSON, and exit without fetching")
+	searchCmd.Flags().BoolVar(&searchExpl, "explain", false, "Print how flags map to the API request (resolved times, tier, sort) to stderr")
+	searchCmd.Flags().BoolVar(&searchStrict, "strict", false, "Fail on any dropped, malformed or lossily-encoded event")
+	searchCmd.Flags().StringVar(&searchReject, "reject-file", "rejects.ndjson", "With --strict, where the offending event is written; with --transform, where logs a plugin fails on go instead of stopping the export")
+	searchCmd.Flags().StringVar(&searchCkpt, "checkpoint", "", "Record the cursor and progress here after every page so the export can be resumed")
+	searchCmd.Flags().BoolVar(&searchResume, "resume", false, "With --checkpoint, continue an interrupted export, appending to --output")
+	searchCmd.Flags().StringVar(&searchToken, "resume-token", "", "Continue a failed export from the token it printed, appending to --output")
+	searchCmd.Flags().BoolVar(&searchLint, "strict-query", false, "Refuse to run queries with expensive patterns instead of warning")
+	searchCmd.Flags().StringVar(&searchRoute, "route-by", "", "Field that picks each log's output file, e.g. @org_id or service")
+	searchCmd.Flags().StringVar(&searchRoutes, "route-map", "", "YAML file mapping --route-by values to output files")
+	searchCmd.Flags().StringVar(&searchSplit, "split-by", "", "Write one file per distinct value of this field (@attr or service) into the --output directory")
+	searchCmd.Flags().StringVar(&searchTeam, "team", "", "Limit the query to services the Service Catalog lists as owned by this team")
+	searchCmd.Flags().StringVar(&searchTags, "tags", "", "Comma-separated key:value tags (wildcards allowed), validated against the org's tags")
+	searchCmd.Flags().StringVar(&searchK8sNS, "k8s-namespace", "", "Only logs from this Kubernetes namespace (kube_namespace tag)")
+	searchCmd.Flags().StringVar(&searchK8sDep, "k8s-deployment", "", "Only logs from this Kubernetes deployment (kube_deployment tag)")
+	searchCmd.Flags().BoolVar(&searchK8sCol, "k8s-columns", false, "Add kube_namespace, pod_name and kube_container_name columns")
+	searchCmd.Flags().StringVar(&searchTrail, "correlate-cloudtrail", "", "CloudTrail file, directory or s3://bucket/prefix to merge into a correlated timeline")
+	searchCmd.Flags().DurationVar(&searchWindow, "correlate-window", 5*time.Minute, "Max time between a log and a CloudTrail event for them to correlate")
+	searchCmd.Flags().StringVar(&searchIOC, "ioc-file", "", "File of IPs, CIDRs and domains to flag in an ioc_match column")
+	searchCmd.Flags().BoolVar(&searchIOCHit, "ioc-only", false, "With --ioc-file, keep only logs that match an indicator")
+	searchCmd.Flags().StringVar(&searchGrep, "grep", "", "Only write logs whose message matches this regular expression (Go syntax, e.g. (?i)timeout)")
+	searchCmd.Flags().StringVar(&searchGrepV, "grep-v", "", "Leave out logs whose message matches this regular expression")
+	searchCmd.Flags().StringArrayVar(&searchXform, "transform", nil, "Pass every log through this WebAssembly transform plugin, which can rewrite or drop it (repeatable, applied in order)")
+	searchCmd.Flags().StringVar(&searchClass, "classification", "", "YAML file assigning columns public, internal or confidential")
+	searchCmd.Flags().StringVar(&searchMaxCls, "max-classification", "", "Remove columns classified above this level: public, internal or confidential")
 	rootCmd.AddCommand(searchCmd)
 }
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dneil5648/dd-logs-cli/handlers"
+	"github.com/spf13/cobra"
+)
+
+var (
+	submitInput    string
+	submitFormat   string
+	submitService  string
+	submitSource   string
+	submitHost     string
+	submitTags     []string
+	submitBatch    int
+	submitInterval time.Duration
+)
+
+var submitCmd = &cobra.Command{
+	Use:   "submit",
+	Short: "Send logs to Datadog from stdin or a file",
+	Long: `Read logs line by line from stdin (or --input) and send them to the Datadog
+Logs Intake API in batches, for shipping logs from a server without an agent
+or for round-trip testing of pipelines and queries.
+
+Input (--format):
+  auto    (default) Lines holding a JSON object are sent as structured logs,
+          any other line as a plain message.
+  ndjson  Every line must be a JSON object.
+  text    Every line is a message, even if it looks like JSON.
+
+  In a JSON object, message, ddsource, ddtags, hostname and service are the
+  log's reserved fields and every other key an attribute. Logs exported by
+  "ddlogs search -f ndjson" are recognised and sent back with their message,
+  host, service, status, tags and attributes.
+
+  --service, --source and --host fill the logs that do not set their own;
+  --tags are added to every log.
+
+Batching:
+  A request carries up to --batch-size logs (at most 1000) and 5MB. A batch
+  that is not full is sent after --flush-interval, so piping a growing file
+  ships lines as they come. Rate limits and server errors are retried like
+  search; on Ctrl-C the lines read so far are sent before stopping.
+
+The intake only accepts logs whose timestamp is at most 18 hours old: older
+logs (including those of an old export) are dropped. Submitting needs an API
+key only, no application key.`,
+	Example: `  # Ship an application's log file
+  ddlogs submit -i app.log --service checkout --source python --tags env:staging,team:payments
+
+  # Follow a file as it grows
+  tail -F /var/log/app.log | ddlogs submit --service checkout --host "$(hostname)"
+
+  # Round trip: export recent logs and send them to a test org
+  ddlogs search -q "service:checkout" --from 1h -f ndjson -o checkout.ndjson
+  ddlogs --profile sandbox submit -i checkout.ndjson -f ndjson`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if submitFormat != "auto" && submitFormat != "ndjson" && submitFormat != "text" {
+			return fmt.Errorf("invalid format %q: must be auto, ndjson or text", submitFormat)
+		}
+		if submitBatch < 1 || submitBatch > handlers.MaxSubmitBatch {
+			return fmt.Errorf("--batch-size must be between 1 and %d", handlers.MaxSubmitBatch)
+		}
+		if submitInterval <= 0 {
+			return fmt.Errorf("--flush-interval must be positive")
+		}
+		handler, err := newIntakeHandler()
+		if err != nil {
+			return err
+		}
+		return handler.Submit(handlers.SubmitOptions{
+			Input:         submitInput,
+			Format:        submitFormat,
+			Service:       submitService,
+			Source:        submitSource,
+			Host:          submitHost,
+			Tags:          submitTags,
+			BatchSize:     submitBatch,
+			FlushInterval: submitInterval,
+			Quiet:         quiet,
+		})
+	},
+}
+
+func init() {
+	submitCmd.Flags().StringVarP(&submitInput, "input", "i", "", "File to read logs from (default: stdin)")
+	submitCmd.Flags().StringVarP(&submitFormat, "format", "f", "auto", "Input format: auto, ndjson, text")
+	submitCmd.Flags().StringVar(&submitService, "service", "", "Service of logs that do not set one")
+	submitCmd.Flags().StringVar(&submitSource, "source", "", "Source (ddsource) of logs that do not set one, e.g. nginx or python")
+	submitCmd.Flags().StringVar(&submitHost, "host", "", "Hostname of logs that do not set one")
+	submitCmd.Flags().StringSliceVar(&submitTags, "tags", nil, "Tags added to every log, e.g. env:staging (repeatable or comma-separated)")
+	submitCmd.Flags().IntVar(&submitBatch, "batch-size", handlers.MaxSubmitBatch, "Logs per request (at most 1000)")
+	submitCmd.Flags().DurationVar(&submitInterval, "flush-interval", handlers.DefaultSubmitFlushInterval, "Send a batch that is not full after this long")
+	rootCmd.AddCommand(submitCmd)
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dneil5648/dd-logs-cli/handlers"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tailQuery    string
+	tailFormat   string
+	tailInterval time.Duration
+	tailLag      time.Duration
+	tailState    string
+	tailOutput   string
+	tailSchema   string
+	tailRotate   bool
+	tailSafe     bool
+)
+
+var tailCmd = &cobra.Command{
+	Use:   "tail",
+	Short: "Stream new logs as they arrive",
+	Long: `Continuously stream logs matching a query to stdout, similar to the Datadog
+Live Tail UI.
+
+The Logs Search API is polled every --interval. Each poll re-reads the last
+--lag of logs so entries that are indexed late are not missed; logs already
+printed are deduplicated by ID. Runs until interrupted with Ctrl-C, at which
+point the output is closed out cleanly (e.g. the JSON array is terminated).
+
+With --state-file, the IDs emitted within the lag window and the newest
+timestamp are saved after every poll. A restarted tail resumes from that
+point instead of from "now", without re-emitting logs near the boundary, so
+downstream consumers see each log once across restarts. With --output, a
+resumed tail appends to the file the last run was writing (the highest
+numbered one after rotations) under the CSV header already there; a JSON
+array cannot be appended to, so use ndjson or csv.
+
+Output uses the same writers as search. For CSV, columns are discovered from
+the first poll that returns logs.
+
+Schema Drift (CSV):
+  Attributes that first appear after the CSV header was written have no
+  column. --schema-log appends each such change (time, log ID, new columns)
+  as a JSON line to a sidecar file. --rotate-on-schema-change instead closes
+  the --output file and continues in the next numbered file (logs.1.csv,
+  logs.2.csv, ...) whose header adds the new columns; combined with
+  --schema-log the sidecar records which file each change started.`,
+	Example: `  # Follow errors from the web service
+  ddlogs tail -q "service:web status:error"
+
+  # Poll every 5 seconds, JSON output
+  ddlogs tail -q "service:api" --interval 5s -f json
+
+  # Survive restarts without duplicates or gaps
+  ddlogs tail -q "service:api" -f json --state-file api.tail.state
+
+  # Long-running CSV export that follows new attributes
+  ddlogs tail -q "service:api" -o api.csv --rotate-on-schema-change --schema-log api.schema.ndjson`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tailFormat = profileFormat(cmd, tailFormat)
+		if tailFormat != "csv" && tailFormat != "json" && tailFormat != "ndjson" {
+			return fmt.Errorf("--format must be csv, json or ndjson")
+		}
+		if tailInterval <= 0 {
+			return fmt.Errorf("--interval must be positive")
+		}
+		if tailRotate && (tailOutput == "" || tailFormat != "csv") {
+			return fmt.Errorf("--rotate-on-schema-change requires --output with csv format")
+		}
+		if tailSafe && tailFormat != "csv" {
+			return fmt.Errorf("--csv-safe only applies to csv format")
+		}
+		if tailSchema != "" && tailFormat != "csv" {
+			return fmt.Errorf("--schema-log only applies to csv format")
+		}
+		if tailState != "" && tailOutput != "" && tailFormat == "json" {
+			return fmt.Errorf("--state-file with --output requires csv or ndjson format: a resumed tail appends to the file, and a JSON array cannot be appended to")
+		}
+
+		handler, err := newHandler()
+		if err != nil {
+			return err
+		}
+		return handler.Tail(handlers.TailOptions{
+			Query:     profileQuery(tailQuery),
+			Format:    tailFormat,
+			Interval:  tailInterval,
+			Lag:       tailLag,
+			StateFile: tailState,
+
+			OutputFile:           tailOutput,
+			SchemaLog:            tailSchema,
+			RotateOnSchemaChange: tailRotate,
+			ExcelSafe:            tailSafe,
+		})
+	},
+}
+
+func init() {
+	addQueryFlags(tailCmd, &tailQuery, "Datadog logs query string", false)
+	tailCmd.Flags().StringVarP(&tailFormat, "format", "f", "csv", "Output format: csv, json or ndjson")
+	tailCmd.Flags().DurationVar(&tailInterval, "interval", 10*time.Second, "Delay between polls")
+	tailCmd.Flags().DurationVar(&tailLag, "lag", 30*time.Second, "How far back each poll re-reads to catch late-indexed logs")
+	tailCmd.Flags().StringVar(&tailState, "state-file", "", "Persist the dedup window here and resume from it on restart")
+	tailCmd.Flags().StringVarP(&tailOutput, "output", "o", "", "Write logs to this file instead of stdout")
+	tailCmd.Flags().StringVar(&tailSchema, "schema-log", "", "Append CSV schema changes (new attributes) to this file as JSON lines")
+	tailCmd.Flags().BoolVar(&tailRotate, "rotate-on-schema-change", false, "Start a new numbered --output file with an extended header when new attributes appear (csv)")
+	tailCmd.Flags().BoolVar(&tailSafe, "csv-safe", false, "Prefix CSV cells starting with =, +, -, @ with ' so spreadsheets do not run them as formulas, and write control characters as \\u escapes")
+	tailCmd.Flags().BoolVar(&tailSafe, "excel-safe", false, "Same as --csv-safe")
+	rootCmd.AddCommand(tailCmd)
+}
@@ -0,0 +1,254 @@
+// Package conformance checks log writers against golden events that have
+// tripped up output formats before: unicode, huge messages, deeply nested
+// attributes, nulls and delimiter characters. The handlers package runs its
+// json, ndjson and csv writers through Check in its tests, and a new output
+// format is added there with a decoder for it:
+//
+//	func TestConformanceMyFormat(t *testing.T) {
+//		if err := conformance.Check(formatWriter("myformat"), decodeMyFormat); err != nil {
+//			t.Fatal(err)
+//		}
+//	}
+package conformance
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// Writer is the streaming interface every output format implements. Start is
+// called once before the first log, FlushPage after every fetched page and
+// End once after the last.
+type Writer interface {
+	Start()
+	WriteLog(log datadogV2.Log) error
+	FlushPage() error
+	End() error
+}
+
+// Record is one event as read back from a writer's output. Fields a format
+// does not carry are left zero and are not compared: an empty ID or a zero
+// Timestamp is skipped, and a nil Attributes map skips attribute checks.
+// Message and Service are always compared.
+type Record struct {
+	ID        string
+	Timestamp time.Time
+	Service   string
+	Host      string
+	Status    string
+	Message   string
+
+	// Attributes holds the event's top-level custom attributes, decoded
+	// from the output. Numbers should be json.Number (or any value that
+	// encodes to the same JSON as the original) so large integers compare
+	// exactly.
+	Attributes map[string]interface{}
+}
+
+// Decoder reads a writer's complete output back into records, in the order
+// they were written.
+type Decoder func(output []byte) ([]Record, error)
+
+// Check writes every golden event through a writer built by newWriter, in
+// pages of uneven size, and verifies that no call fails or panics and that
+// decode reads back every event unchanged. The io.Writer passed to newWriter
+// is a *bufio.Writer, flushed after End. All problems found are returned
+// together.
+func Check(newWriter func(io.Writer) Writer, decode Decoder) error {
+	events := Events()
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	w := newWriter(bw)
+
+	if err := call("Start", func() error { w.Start(); return nil }); err != nil {
+		return err
+	}
+	for _, page := range pages(len(events)) {
+		for _, ev := range events[page[0]:page[1]] {
+			if err := call("WriteLog("+ev.Name+")", func() error { return w.WriteLog(ev.Log) }); err != nil {
+				return err
+			}
+		}
+		if err := call("FlushPage", w.FlushPage); err != nil {
+			return err
+		}
+	}
+	if err := call("End", w.End); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	out := buf.Bytes()
+	if len(out) == 0 {
+		return errors.New("writer produced no output")
+	}
+	records, err := decode(out)
+	if err != nil {
+		return fmt.Errorf("decoding output: %w", err)
+	}
+	if len(records) != len(events) {
+		return fmt.Errorf("decoded %d record(s), want %d", len(records), len(events))
+	}
+	var errs []error
+	for i, ev := range events {
+		for _, problem := range compare(ev.Log, records[i]) {
+			errs = append(errs, fmt.Errorf("event %q: %s", ev.Name, problem))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// call runs one writer method, turning a panic into an error.
+func call(name string, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s panicked: %v", name, r)
+		}
+	}()
+	if err := fn(); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// pages splits n events into pages of 1, 2, 3, ... events, so writers that
+// buffer until the first page or per page are exercised at each boundary.
+func pages(n int) [][2]int {
+	var out [][2]int
+	for start, size := 0, 1; start < n; size++ {
+		end := min(start+size, n)
+		out = append(out, [2]int{start, end})
+		start = end
+	}
+	return out
+}
+
+// compare lists how got differs from the golden log want.
+func compare(want datadogV2.Log, got Record) []string {
+	var problems []string
+	attrs := want.GetAttributes()
+	field := func(name, want, got string) {
+		if want != got {
+			problems = append(problems, fmt.Sprintf("%s = %s, want %s", name, abbreviate(got), abbreviate(want)))
+		}
+	}
+	field("message", attrs.GetMessage(), got.Message)
+	field("service", attrs.GetService(), got.Service)
+	if got.ID != "" {
+		field("id", want.GetId(), got.ID)
+	}
+	if got.Host != "" || attrs.GetHost() == "" {
+		field("host", attrs.GetHost(), got.Host)
+	}
+	if got.Status != "" || attrs.GetStatus() == "" {
+		field("status", attrs.GetStatus(), got.Status)
+	}
+	if !got.Timestamp.IsZero() {
+		if ts := attrs.GetTimestamp(); !ts.Truncate(time.Second).Equal(got.Timestamp.Truncate(time.Second)) {
+			problems = append(problems, fmt.Sprintf("timestamp = %s, want %s", got.Timestamp.Format(time.RFC3339Nano), ts.Format(time.RFC3339Nano)))
+		}
+	}
+	if got.Attributes != nil {
+		for key, v := range attrs.GetAttributes() {
+			gv, ok := got.Attributes[key]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("attribute %q missing", key))
+				continue
+			}
+			wantJSON, _ := json.Marshal(v)
+			gotJSON, _ := json.Marshal(gv)
+			if !bytes.Equal(wantJSON, gotJSON) {
+				problems = append(problems, fmt.Sprintf("attribute %q = %s, want %s", key, abbreviate(string(gotJSON)), abbreviate(string(wantJSON))))
+			}
+		}
+	}
+	return problems
+}
+
+// abbreviate shortens s for an error message, so a mismatched huge message
+// does not flood the output.
+func abbreviate(s string) string {
+	const max = 80
+	if len(s) <= max {
+		return fmt.Sprintf("%q", s)
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%q... (%d bytes)", s[:cut], len(s))
+}
+
+// DecodeJSON is a Decoder for output made of Datadog log objects, either as
+// one JSON array or as NDJSON, such as the json and ndjson formats.
+func DecodeJSON(output []byte) ([]Record, error) {
+	dec := json.NewDecoder(bytes.NewReader(output))
+	dec.UseNumber()
+	var records []Record
+	add := func(raw json.RawMessage) error {
+		var log struct {
+			ID         string `json:"id"`
+			Attributes struct {
+				Timestamp  time.Time              `json:"timestamp"`
+				Service    string                 `json:"service"`
+				Host       string                 `json:"host"`
+				Status     string                 `json:"status"`
+				Message    string                 `json:"message"`
+				Attributes map[string]interface{} `json:"attributes"`
+			} `json:"attributes"`
+		}
+		d := json.NewDecoder(bytes.NewReader(raw))
+		d.UseNumber()
+		if err := d.Decode(&log); err != nil {
+			return err
+		}
+		a := log.Attributes
+		if a.Attributes == nil {
+			a.Attributes = map[string]interface{}{}
+		}
+		records = append(records, Record{
+			ID:         log.ID,
+			Timestamp:  a.Timestamp,
+			Service:    a.Service,
+			Host:       a.Host,
+			Status:     a.Status,
+			Message:    a.Message,
+			Attributes: a.Attributes,
+		})
+		return nil
+	}
+
+	if trimmed := bytes.TrimSpace(output); len(trimmed) > 0 && trimmed[0] == '[' {
+		var arr []json.RawMessage
+		if err := dec.Decode(&arr); err != nil {
+			return nil, err
+		}
+		for _, raw := range arr {
+			if err := add(raw); err != nil {
+				return nil, err
+			}
+		}
+		return records, nil
+	}
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if err := add(raw); err != nil {
+			return nil, err
+		}
+	}
+}
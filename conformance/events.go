@@ -0,0 +1,129 @@
+package conformance
+
+import (
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// hugeMessageSize is the length of the huge-message event's message, well
+// past the 64 KiB token limit of bufio.Scanner and most line readers.
+const hugeMessageSize = 1 << 20
+
+// Event is one golden log, named for error messages.
+type Event struct {
+	Name string
+	Log  datadogV2.Log
+}
+
+// Events returns the golden events Check writes, in order. Each call builds
+// fresh values, so writers may modify the logs they are given.
+func Events() []Event {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	n := 0
+	event := func(name, service, host, status, message string, attrs map[string]interface{}, tags ...string) Event {
+		n++
+		a := datadogV2.NewLogAttributes()
+		a.SetTimestamp(base.Add(time.Duration(n) * 1500 * time.Millisecond))
+		a.SetMessage(message)
+		if service != "" {
+			a.SetService(service)
+		}
+		if host != "" {
+			a.SetHost(host)
+		}
+		if status != "" {
+			a.SetStatus(status)
+		}
+		if attrs != nil {
+			a.SetAttributes(attrs)
+		}
+		if tags != nil {
+			a.SetTags(tags)
+		}
+		log := datadogV2.NewLog()
+		log.SetId("conformance-" + name)
+		log.SetAttributes(*a)
+		return Event{Name: name, Log: *log}
+	}
+
+	return []Event{
+		event("plain", "web", "host-1", "info", "GET /health 200",
+			map[string]interface{}{"http_status": 200, "path": "/health"},
+			"env:prod", "team:core"),
+
+		event("unicode", "сервис", "hôte-2", "warn",
+			"用户登录失败 🔒 — مرحبا — e\u0301 — \u200d👩\u200d💻 — \u00a0nbsp",
+			map[string]interface{}{"ユーザー": "名前", "emoji": "🚀✨", "rtl": "שלום"},
+			"région:eu", "emoji:🔥"),
+
+		event("huge-message", "batch", "host-3", "error",
+			hugeMessage(),
+			map[string]interface{}{"size": hugeMessageSize}),
+
+		event("nested-attributes", "api", "host-4", "info", "nested",
+			map[string]interface{}{
+				"http": map[string]interface{}{
+					"request": map[string]interface{}{
+						"headers": map[string]interface{}{
+							"user-agent": "curl/8.0",
+							"accept":     []interface{}{"text/html", "application/json"},
+						},
+						"method": "POST",
+					},
+					"status_code": 201,
+				},
+				"items":       []interface{}{map[string]interface{}{"id": 1}, map[string]interface{}{"id": 2, "tags": []interface{}{}}},
+				"empty":       map[string]interface{}{},
+				"empty_list":  []interface{}{},
+				"dotted.name": "a key with a dot",
+			}),
+
+		event("nulls", "", "", "", "",
+			map[string]interface{}{
+				"missing": nil,
+				"nested":  map[string]interface{}{"value": nil, "list": []interface{}{nil, "x", nil}},
+				"blank":   "",
+			}),
+
+		event("no-attributes", "cron", "host-5", "info", "no custom attributes", nil),
+
+		event("delimiters", "csv,service", "host\t6", "info",
+			"comma, \"quotes\", 'single'\nnew line\r\ncrlf\ttab; semi | pipe  trailing  ",
+			map[string]interface{}{
+				"formula":   "=HYPERLINK(\"http://example.com\")",
+				"plus":      "+1",
+				"backslash": `C:\path\to\file`,
+				"html":      "<script>alert(1)</script> & more",
+				"comma,key": "value, with comma",
+			},
+			"key:value,with,commas"),
+
+		event("numbers", "metrics", "host-7", "info", "numbers",
+			map[string]interface{}{
+				"big_int":        int64(9007199254740993),
+				"negative":       -42,
+				"float":          0.1,
+				"exponent":       1.5e-300,
+				"large":          1e21,
+				"zero":           0,
+				"bool_true":      true,
+				"bool_false":     false,
+				"numeric_string": "007",
+			}),
+	}
+}
+
+// hugeMessage builds a multi-line message of hugeMessageSize bytes that mixes
+// multi-byte characters, so truncation at a byte boundary shows up.
+func hugeMessage() string {
+	var b strings.Builder
+	b.Grow(hugeMessageSize)
+	line := "stack frame ünïcödé 日本 at com.example.Service.handle(Service.java:42)\n"
+	for b.Len()+len(line) <= hugeMessageSize {
+		b.WriteString(line)
+	}
+	b.WriteString(strings.Repeat("x", hugeMessageSize-b.Len()))
+	return b.String()
+}
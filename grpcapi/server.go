@@ -0,0 +1,165 @@
+// Package grpcapi serves Datadog log searches over gRPC for ddlogs
+// grpc-serve. ddlogs.pb.go and ddlogs_grpc.pb.go are generated from
+// ddlogs.proto; clients in other languages generate theirs from it too.
+package grpcapi
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative ddlogs.proto
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"github.com/dneil5648/dd-logs-cli/handlers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Server implements the Logs service with one DDHandler, so every client
+// searches with its credentials.
+type Server struct {
+	UnimplementedLogsServer
+	handler *handlers.DDHandler
+}
+
+// NewServer returns a Logs service searching through h.
+func NewServer(h *handlers.DDHandler) *Server {
+	return &Server{handler: h}
+}
+
+// QueryLogs streams the logs matching req, one response per page, or the
+// export in req.Format in chunks. Each call is logged to stderr when it
+// ends.
+func (s *Server) QueryLogs(req *QueryLogsRequest, stream Logs_QueryLogsServer) error {
+	if req.Query == "" {
+		return status.Error(codes.InvalidArgument, "query is required")
+	}
+	switch req.Format {
+	case "", "csv", "json", "ndjson":
+	default:
+		return status.Errorf(codes.InvalidArgument, "format must be empty, csv, json or ndjson, not %q", req.Format)
+	}
+	if req.Limit < 0 {
+		return status.Error(codes.InvalidArgument, "limit must not be negative")
+	}
+	opts := handlers.QueryOptions{
+		Query:      req.Query,
+		From:       req.From,
+		To:         req.To,
+		Indexes:    req.Indexes,
+		Limit:      int(req.Limit),
+		Descending: req.Descending,
+		Format:     req.Format,
+	}
+	if opts.From == "" {
+		opts.From = "15m"
+	}
+	if opts.To == "" {
+		opts.To = "now"
+	}
+
+	ctx := stream.Context()
+	start := time.Now()
+	sent := 0
+	var err error
+	if req.Format == "" {
+		err = s.handler.StreamLogs(ctx, opts, func(logs []datadogV2.Log) error {
+			resp := &QueryLogsResponse{Logs: make([]*Log, 0, len(logs))}
+			for _, log := range logs {
+				msg, err := logMessage(log)
+				if err != nil {
+					return err
+				}
+				resp.Logs = append(resp.Logs, msg)
+			}
+			sent += len(logs)
+			return stream.Send(resp)
+		})
+	} else {
+		_, err = s.handler.StreamOutput(ctx, opts, chunkWriter{stream, &sent})
+	}
+	if err != nil && ctx.Err() != nil {
+		err = status.FromContextError(ctx.Err()).Err()
+	}
+
+	result := "ok"
+	if err != nil {
+		result = err.Error()
+	}
+	unit := "logs"
+	if req.Format != "" {
+		unit = "bytes"
+	}
+	fmt.Fprintf(os.Stderr, "%s QueryLogs %q %s..%s: %d %s in %.1fs, %s\n",
+		clientAddr(ctx), opts.Query, opts.From, opts.To, sent, unit, time.Since(start).Seconds(), result)
+	return err
+}
+
+// chunkWriter sends everything written to it as data responses; n counts
+// the bytes sent.
+type chunkWriter struct {
+	stream Logs_QueryLogsServer
+	n      *int
+}
+
+func (w chunkWriter) Write(p []byte) (int, error) {
+	if err := w.stream.Send(&QueryLogsResponse{Data: p}); err != nil {
+		return 0, err
+	}
+	*w.n += len(p)
+	return len(p), nil
+}
+
+// logMessage converts a log to its protobuf message.
+func logMessage(log datadogV2.Log) (*Log, error) {
+	attrs := log.GetAttributes()
+	custom, err := structpb.NewStruct(attrs.GetAttributes())
+	if err != nil {
+		return nil, fmt.Errorf("converting attributes of log %s: %w", log.GetId(), err)
+	}
+	msg := &Log{
+		Id:         log.GetId(),
+		Host:       attrs.GetHost(),
+		Service:    attrs.GetService(),
+		Status:     attrs.GetStatus(),
+		Message:    attrs.GetMessage(),
+		Tags:       attrs.GetTags(),
+		Attributes: custom,
+	}
+	if t, ok := attrs.GetTimestampOk(); ok && t != nil {
+		msg.Timestamp = timestamppb.New(*t)
+	}
+	return msg, nil
+}
+
+// clientAddr names the client of an RPC for the log line.
+func clientAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+	return "-"
+}
+
+// TokenAuth rejects streams that do not carry "authorization: Bearer
+// <token>" metadata.
+func TokenAuth(token string) grpc.StreamServerInterceptor {
+	want := []byte("Bearer " + token)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		for _, got := range md.Get("authorization") {
+			if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(got)), want) == 1 {
+				return handler(srv, ss)
+			}
+		}
+		return status.Error(codes.Unauthenticated, "missing or wrong bearer token")
+	}
+}
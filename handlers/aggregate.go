@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// AggregateOptions configures an Aggregate.
+type AggregateOptions struct {
+	Query      string
+	From       string
+	To         string
+	OutputFile string
+
+	// Format is csv or json.
+	Format string
+
+	// GroupBy are the facets buckets are split by, e.g. service or
+	// @http.status_code. Without any, a single bucket covers every log.
+	GroupBy []string
+
+	// Compute are the values calculated for each bucket.
+	Compute []AggregateCompute
+
+	// Limit, when positive, is the number of values kept per GroupBy facet
+	// (the API's default is 10), the top ones by the first compute.
+	Limit int64
+
+	// Indexes, when set, restricts the aggregation to these log indexes.
+	Indexes []string
+
+	// Quiet keeps progress and the bucket count off stderr.
+	Quiet bool
+
+	// Rollup, when set, is a bucket interval such as 5m: every compute
+	// becomes a timeseries and the output has one row per interval (and
+	// group), led by a time column.
+	Rollup string
+}
+
+// AggregateCompute is one --compute value: an aggregation function and,
+// for every function but count, the measure it applies to.
+type AggregateCompute struct {
+	Aggregation datadogV2.LogsAggregationFunction
+	Metric      string
+}
+
+// ParseCompute parses a --compute value: "count", or a function and a
+// measure such as "avg:@duration" or "pc99:@http.response_time".
+func ParseCompute(spec string) (AggregateCompute, error) {
+	name, metric, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	fn, err := datadogV2.NewLogsAggregationFunctionFromValue(strings.ToLower(name))
+	if err != nil {
+		var names []string
+		for _, v := range new(datadogV2.LogsAggregationFunction).GetAllowedValues() {
+			names = append(names, string(v))
+		}
+		return AggregateCompute{}, fmt.Errorf("unknown aggregation %q (supported: %s)", name, strings.Join(names, ", "))
+	}
+	switch {
+	case *fn == datadogV2.LOGSAGGREGATIONFUNCTION_COUNT && metric != "":
+		return AggregateCompute{}, fmt.Errorf("%q: count takes no measure", spec)
+	case *fn != datadogV2.LOGSAGGREGATIONFUNCTION_COUNT && metric == "":
+		return AggregateCompute{}, fmt.Errorf("%q: %s needs a measure, e.g. %s:@duration", spec, *fn, *fn)
+	}
+	return AggregateCompute{Aggregation: *fn, Metric: metric}, nil
+}
+
+// label names c's column in the output: count, or avg(@duration).
+func (c AggregateCompute) label() string {
+	if c.Metric == "" {
+		return string(c.Aggregation)
+	}
+	return fmt.Sprintf("%s(%s)", c.Aggregation, c.Metric)
+}
+
+// Aggregate runs opts through the Logs Aggregation API and writes one row
+// per bucket: the group-by values followed by each compute, or with a
+// Rollup, one row per interval of each bucket. Buckets are paginated until
+// the API has returned all of them.
+func (h *DDHandler) Aggregate(opts AggregateOptions) error {
+	buckets, err := h.aggregateBuckets(h.apiContext(), opts, !opts.Quiet)
+	if err != nil {
+		return err
+	}
+
+	out, err := createOutput(opts.OutputFile, "")
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	bw := bufio.NewWriter(out)
+	if err := writeBuckets(bw, opts, buckets); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+	if opts.Quiet {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, lineStart()+"%d bucket(s)\n", len(buckets))
+	if opts.OutputFile != "" {
+		fmt.Fprintf(os.Stderr, "Output written to %s\n", opts.OutputFile)
+	}
+	return nil
+}
+
+// AggregateTo runs opts like Aggregate but writes the table to w without
+// any progress, for serving aggregations to other programs, and returns the
+// number of buckets. Cancelling ctx abandons the request.
+func (h *DDHandler) AggregateTo(ctx context.Context, opts AggregateOptions, w io.Writer) (int, error) {
+	buckets, err := h.aggregateBuckets(h.withAPIKeys(ctx), opts, false)
+	if err != nil {
+		return 0, err
+	}
+	bw := bufio.NewWriter(w)
+	if err := writeBuckets(bw, opts, buckets); err != nil {
+		return 0, err
+	}
+	return len(buckets), bw.Flush()
+}
+
+// aggregateBuckets fetches every bucket of opts, printing the page count
+// to stderr while it paginates when progress is set.
+func (h *DDHandler) aggregateBuckets(ctx context.Context, opts AggregateOptions, progress bool) ([]datadogV2.LogsAggregateBucket, error) {
+	api := h.logsAPI()
+
+	storageTier := datadogV2.LOGSSTORAGETIER_FLEX
+	body := datadogV2.LogsAggregateRequest{
+		Filter: &datadogV2.LogsQueryFilter{
+			Query:       datadog.PtrString(opts.Query),
+			From:        datadog.PtrString(toDatadogTime(opts.From)),
+			To:          datadog.PtrString(toDatadogTime(opts.To)),
+			Indexes:     opts.Indexes,
+			StorageTier: &storageTier,
+		},
+	}
+	var interval *string
+	if opts.Rollup != "" {
+		d, err := parseDuration(opts.Rollup)
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("--rollup must be an interval of at least 1s, such as 5m or 1h, got %q", opts.Rollup)
+		}
+		interval = datadog.PtrString(datadogDuration(d))
+	}
+	for _, c := range opts.Compute {
+		compute := datadogV2.LogsCompute{
+			Aggregation: c.Aggregation,
+			Type:        datadogV2.LOGSCOMPUTETYPE_TOTAL.Ptr(),
+		}
+		if interval != nil {
+			compute.Type = datadogV2.LOGSCOMPUTETYPE_TIMESERIES.Ptr()
+			compute.Interval = interval
+		}
+		if c.Metric != "" {
+			compute.Metric = datadog.PtrString(c.Metric)
+		}
+		body.Compute = append(body.Compute, compute)
+	}
+	for _, facet := range opts.GroupBy {
+		group := datadogV2.LogsGroupBy{Facet: facet}
+		if opts.Limit > 0 {
+			group.Limit = datadog.PtrInt64(opts.Limit)
+		}
+		body.GroupBy = append(body.GroupBy, group)
+	}
+
+	var buckets []datadogV2.LogsAggregateBucket
+	var line progressLine
+	for page := 1; ; page++ {
+		resp, _, err := api.AggregateLogs(ctx, body)
+		if err != nil {
+			return nil, fmt.Errorf("calling LogsApi.AggregateLogs: %w", err)
+		}
+		data := resp.GetData()
+		buckets = append(buckets, data.Buckets...)
+		meta := resp.GetMeta()
+		for _, w := range meta.Warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", w.GetTitle(), w.GetDetail())
+		}
+		after := meta.Page.GetAfter()
+		if after == "" {
+			break
+		}
+		if progress {
+			line.update("Aggregating... page %d | %d buckets", page, len(buckets))
+		}
+		body.Page = &datadogV2.LogsAggregateRequestPage{Cursor: datadog.PtrString(after)}
+	}
+	return buckets, nil
+}
+
+// writeBuckets writes the table of buckets in opts.Format.
+func writeBuckets(bw *bufio.Writer, opts AggregateOptions, buckets []datadogV2.LogsAggregateBucket) error {
+	var columns []string
+	var rows [][]interface{}
+	if opts.Rollup != "" {
+		columns, rows = timeseriesRows(opts, buckets)
+	} else {
+		columns, rows = aggregateRows(opts, buckets)
+	}
+	return writeAggregate(bw, opts.Format, columns, rows)
+}
+
+// aggregateRows returns the table of buckets: the group-by facets, then a
+// column per compute.
+func aggregateRows(opts AggregateOptions, buckets []datadogV2.LogsAggregateBucket) ([]string, [][]interface{}) {
+	columns := append([]string(nil), opts.GroupBy...)
+	for _, c := range opts.Compute {
+		columns = append(columns, c.label())
+	}
+	rows := make([][]interface{}, 0, len(buckets))
+	for _, b := range buckets {
+		row := make([]interface{}, 0, len(columns))
+		for _, facet := range opts.GroupBy {
+			row = append(row, b.By[facet])
+		}
+		for i := range opts.Compute {
+			row = append(row, bucketValue(b.Computes[fmt.Sprintf("c%d", i)]))
+		}
+		rows = append(rows, row)
+	}
+	return columns, rows
+}
+
+// timeseriesRows returns the table of a rolled-up aggregation: a row per
+// interval of each bucket, in time order, led by the interval's start.
+// Computes with no point for an interval are left empty.
+func timeseriesRows(opts AggregateOptions, buckets []datadogV2.LogsAggregateBucket) ([]string, [][]interface{}) {
+	columns := append([]string{"time"}, opts.GroupBy...)
+	for _, c := range opts.Compute {
+		columns = append(columns, c.label())
+	}
+	var rows [][]interface{}
+	for _, b := range buckets {
+		points := make(map[string][]interface{})
+		var times []string
+		for i := range opts.Compute {
+			ts := b.Computes[fmt.Sprintf("c%d", i)].LogsAggregateBucketValueTimeseries
+			if ts == nil {
+				continue
+			}
+			for _, p := range ts.Items {
+				t := p.GetTime()
+				if points[t] == nil {
+					points[t] = make([]interface{}, len(opts.Compute))
+					times = append(times, t)
+				}
+				if v, ok := p.GetValueOk(); ok {
+					points[t][i] = bucketValue(datadogV2.LogsAggregateBucketValue{LogsAggregateBucketValueSingleNumber: v})
+				}
+			}
+		}
+		sort.Strings(times)
+		for _, t := range times {
+			row := []interface{}{t}
+			for _, facet := range opts.GroupBy {
+				row = append(row, b.By[facet])
+			}
+			rows = append(rows, append(row, points[t]...))
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i][0].(string) < rows[j][0].(string) })
+	return columns, rows
+}
+
+// writeAggregate writes rows as a CSV table or a JSON array of objects
+// keyed by column.
+func writeAggregate(bw *bufio.Writer, format string, columns []string, rows [][]interface{}) error {
+	if format == "json" {
+		objects := make([]map[string]interface{}, 0, len(rows))
+		for _, row := range rows {
+			obj := make(map[string]interface{}, len(columns))
+			for i, col := range columns {
+				obj[col] = row[i]
+			}
+			objects = append(objects, obj)
+		}
+		enc := json.NewEncoder(bw)
+		enc.SetIndent("", "  ")
+		return enc.Encode(objects)
+	}
+
+	w := csv.NewWriter(bw)
+	w.Write(csvRecord(columns, false))
+	for _, row := range rows {
+		rec := make([]string, len(row))
+		for i, v := range row {
+			rec[i] = flattenValue(v)
+		}
+		w.Write(csvRecord(rec, false))
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// bucketValue unwraps a compute's value: a number, a string, or nil when
+// the bucket has none.
+func bucketValue(v datadogV2.LogsAggregateBucketValue) interface{} {
+	switch {
+	case v.LogsAggregateBucketValueSingleNumber != nil:
+		f := *v.LogsAggregateBucketValueSingleNumber
+		if f == float64(int64(f)) {
+			return int64(f)
+		}
+		return json.Number(strconv.FormatFloat(f, 'f', -1, 64))
+	case v.LogsAggregateBucketValueSingleString != nil:
+		return *v.LogsAggregateBucketValueSingleString
+	}
+	return nil
+}
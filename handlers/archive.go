@@ -0,0 +1,523 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"github.com/parquet-go/parquet-go"
+)
+
+// DefaultArchiveLag is how far behind now an archive run stops by default,
+// leaving the newest logs, which Datadog may still be indexing, for the
+// next run.
+const DefaultArchiveLag = 5 * time.Minute
+
+// archiveStateFile holds an archive's state in its root. Hive-style readers
+// ignore files starting with "_".
+const archiveStateFile = "_archive.json"
+
+// ArchiveOptions configures ArchiveRun.
+type ArchiveOptions struct {
+	Query string
+
+	// Dir is the archive: a local directory or s3://<bucket>/<prefix>.
+	Dir string
+
+	// Format is parquet or ndjson; NDJSON parts are gzip-compressed.
+	Format string
+
+	// From is where the first run starts, as a --from value. Later runs
+	// continue where the last one stopped.
+	From string
+
+	// Lag holds back the newest logs for the next run.
+	Lag time.Duration
+
+	// Keep is how long logs are kept, as a duration such as 365d; days
+	// entirely older are deleted. "" keeps everything.
+	Keep string
+
+	// CompactBelow is the size under which parts of a complete day are
+	// merged into one; 0 disables compaction.
+	CompactBelow int64
+
+	Quiet bool
+}
+
+// archiveState is the archive's _archive.json. Next is where the next run
+// starts: everything before it has been exported.
+type archiveState struct {
+	Query  string    `json:"query"`
+	Format string    `json:"format"`
+	Next   time.Time `json:"next"`
+
+	// Compacting is a compaction in progress: Parts are merged into File
+	// and deleted once File is in place. Finding it means a run stopped in
+	// between, and the deletion is finished.
+	Compacting *archiveCompaction `json:"compacting,omitempty"`
+}
+
+type archiveCompaction struct {
+	File  string   `json:"file"`
+	Parts []string `json:"parts"`
+}
+
+// ArchiveRun brings the archive at opts.Dir up to date: it exports the
+// logs since the last run into one part per UTC day under dt=YYYY-MM-DD/,
+// checking each part's count against the aggregation API, merges the small
+// parts of days that are complete, and deletes the days past retention. A
+// run that fails or is interrupted leaves the archive consistent; the next
+// one carries on from the last part written.
+func (h *DDHandler) ArchiveRun(opts ArchiveOptions) error {
+	var keep time.Duration
+	if opts.Keep != "" {
+		var err error
+		if keep, err = parseDuration(opts.Keep); err != nil || keep <= 0 {
+			return fmt.Errorf("--keep must be a positive duration such as 365d, got %q", opts.Keep)
+		}
+	}
+	store, err := openArchiveStore(opts.Dir)
+	if err != nil {
+		return err
+	}
+	state, err := loadArchiveState(store)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	if state == nil {
+		start, err := resolveTime(opts.From, now)
+		if err != nil {
+			return fmt.Errorf("--from: %w", err)
+		}
+		state = &archiveState{Query: opts.Query, Format: opts.Format, Next: start.UTC()}
+	} else if state.Query != opts.Query || state.Format != opts.Format {
+		return fmt.Errorf("%s holds a %s archive of %q; use another --dir for this query or format", store.location(""), state.Format, state.Query)
+	}
+	if err := finishCompaction(store, state); err != nil {
+		return err
+	}
+
+	sigCtx, stop := signal.NotifyContext(h.apiContext(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	api := h.logsAPI()
+
+	// Days that would be pruned straight away are not exported.
+	var cutoff time.Time
+	if keep > 0 {
+		cutoff = now.Add(-keep)
+		if first := cutoff.Truncate(day); state.Next.Before(first) {
+			state.Next = first
+		}
+	}
+	end := now.Add(-opts.Lag).Truncate(time.Second)
+	var logs, parts int
+	for _, w := range archiveWindows(state.Next, end) {
+		name, written, err := h.archiveWindow(sigCtx, api, store, opts, w)
+		if err != nil {
+			if sigCtx.Err() != nil {
+				fmt.Fprintf(os.Stderr, "\nInterrupted; the next run starts at %s\n", state.Next.Format(time.RFC3339))
+				return sigCtx.Err()
+			}
+			return fmt.Errorf("exporting %s → %s: %w", w.start.Format(time.RFC3339), w.end.Format(time.RFC3339), err)
+		}
+		if name != "" {
+			logs += written
+			parts++
+		}
+		state.Next = w.end
+		if err := saveArchiveState(store, state); err != nil {
+			return err
+		}
+	}
+
+	compacted, err := compactArchive(store, state, opts)
+	if err != nil {
+		return err
+	}
+	pruned := 0
+	if keep > 0 {
+		if pruned, err = pruneArchive(store, cutoff); err != nil {
+			return err
+		}
+	}
+	if !opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Archive %s: %d log(s) in %d new part(s), up to date through %s; compacted %d day(s), pruned %d day(s)\n",
+			store.location(""), logs, parts, state.Next.Format(time.RFC3339), compacted, pruned)
+	}
+	return nil
+}
+
+// archiveWindows splits [from, to) at each UTC midnight, so every window
+// falls in one day's partition.
+func archiveWindows(from, to time.Time) []logWindow {
+	var windows []logWindow
+	for start := from; start.Before(to); {
+		end := start.Truncate(day).Add(day)
+		if end.After(to) {
+			end = to
+		}
+		windows = append(windows, absoluteWindow(start, end))
+		start = end
+	}
+	return windows
+}
+
+// archivePartition is the partition directory of the day starting at t.
+func archivePartition(t time.Time) string {
+	return "dt=" + t.UTC().Format("2006-01-02")
+}
+
+// archiveExt is the file extension of parts in format.
+func archiveExt(format string) string {
+	if format == "ndjson" {
+		return ".ndjson.gz"
+	}
+	return "." + format
+}
+
+// archiveWindow exports one window to a part named after its start and
+// returns the part's name, or "" when no logs matched. A part whose count
+// does not match the aggregation API is not written, so the next run
+// exports the window again.
+func (h *DDHandler) archiveWindow(ctx context.Context, api *datadogV2.LogsApi, store archiveStore, opts ArchiveOptions, w logWindow) (string, int, error) {
+	f, err := os.CreateTemp(store.tempDir(), ".part-*.tmp")
+	if err != nil {
+		return "", 0, fmt.Errorf("creating part: %w", err)
+	}
+	defer os.Remove(f.Name())
+	out := io.WriteCloser(f)
+	if opts.Format == "ndjson" {
+		if out, err = compressOutput(f, "gzip"); err != nil {
+			f.Close()
+			return "", 0, err
+		}
+	}
+	bw := bufio.NewWriterSize(out, 256*1024)
+	writer := newLogWriter(QueryOptions{Format: opts.Format}, bw)
+	writer.Start()
+	written := 0
+	err = h.listAll(ctx, api, opts.Query, w.from, w.to, &fetchProgress{quiet: opts.Quiet}, func(logs []datadogV2.Log) error {
+		for _, log := range logs {
+			if err := writer.WriteLog(log); err != nil {
+				return fmt.Errorf("writing log: %w", err)
+			}
+		}
+		written += len(logs)
+		return writer.FlushPage()
+	})
+	if err == nil {
+		err = writer.End()
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	expected, err := h.countLogs(ctx, api, opts.Query, w.from, w.to, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	if int64(written) != expected {
+		return "", 0, fmt.Errorf("fetched %d logs but Datadog counts %d; the window is exported again on the next run", written, expected)
+	}
+	if written == 0 {
+		return "", 0, nil
+	}
+	name := archivePartition(w.start) + "/part-" + backfillChunkName(w.start) + archiveExt(opts.Format)
+	if err := store.put(name, f.Name()); err != nil {
+		return "", 0, fmt.Errorf("writing %s: %w", store.location(name), err)
+	}
+	if !opts.Quiet {
+		fmt.Fprintf(os.Stderr, "%s: %d logs\n", name, written)
+	}
+	return name, written, nil
+}
+
+// archiveDays groups the archive's parts by the day of their partition.
+func archiveDays(objects []archiveObject) map[time.Time][]archiveObject {
+	days := make(map[time.Time][]archiveObject)
+	for _, obj := range objects {
+		dir, _, ok := strings.Cut(obj.name, "/")
+		date, found := strings.CutPrefix(dir, "dt=")
+		if !ok || !found {
+			continue
+		}
+		t, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			continue
+		}
+		days[t] = append(days[t], obj)
+	}
+	return days
+}
+
+// compactArchive merges, in each day before state.Next, the parts smaller
+// than opts.CompactBelow into one, and returns how many days it compacted.
+func compactArchive(store archiveStore, state *archiveState, opts ArchiveOptions) (int, error) {
+	objects, err := store.list()
+	if err != nil {
+		return 0, err
+	}
+	days := archiveDays(objects)
+	var dates []time.Time
+	for d := range days {
+		dates = append(dates, d)
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	compacted := 0
+	for _, d := range dates {
+		if d.Add(day).After(state.Next) {
+			continue
+		}
+		var small []string
+		for _, obj := range days[d] {
+			if obj.size < opts.CompactBelow && strings.HasSuffix(obj.name, archiveExt(opts.Format)) {
+				small = append(small, obj.name)
+			}
+		}
+		if len(small) < 2 {
+			continue
+		}
+		sort.Strings(small)
+		file := archivePartition(d) + "/part-" + d.Format("20060102") + "-compacted" + archiveExt(opts.Format)
+		if containsString(small, file) {
+			continue
+		}
+		ok, err := compactParts(store, opts.Format, small, file)
+		if err != nil {
+			return compacted, fmt.Errorf("compacting %s: %w", archivePartition(d), err)
+		}
+		if !ok {
+			continue
+		}
+		state.Compacting = &archiveCompaction{File: file, Parts: small}
+		if err := saveArchiveState(store, state); err != nil {
+			return compacted, err
+		}
+		if err := finishCompaction(store, state); err != nil {
+			return compacted, err
+		}
+		compacted++
+		if !opts.Quiet {
+			fmt.Fprintf(os.Stderr, "%s: merged %d parts into %s\n", archivePartition(d), len(small), file)
+		}
+	}
+	return compacted, nil
+}
+
+// compactParts writes parts merged into file. NDJSON parts are gzip
+// members, which concatenate into a valid gzip file. Parquet parts are
+// rewritten with the union of their columns; it reports false, leaving the
+// parts as they are, when they type a column differently.
+func compactParts(store archiveStore, format string, parts []string, file string) (bool, error) {
+	f, err := os.CreateTemp(store.tempDir(), ".part-*.tmp")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(f.Name())
+	if format == "ndjson" {
+		err = concatParts(store, parts, f)
+	} else {
+		var ok bool
+		if ok, err = mergeParquetParts(store, parts, f); err == nil && !ok {
+			f.Close()
+			return false, nil
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := store.put(file, f.Name()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func concatParts(store archiveStore, parts []string, out io.Writer) error {
+	for _, name := range parts {
+		r, err := store.get(name)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func mergeParquetParts(store archiveStore, parts []string, out io.Writer) (bool, error) {
+	type part struct {
+		file    *parquet.File
+		closer  io.Closer
+		cleanup func()
+	}
+	var files []part
+	defer func() {
+		for _, p := range files {
+			p.closer.Close()
+			p.cleanup()
+		}
+	}()
+	group := orderedGroup{Group: parquet.Group{}}
+	kinds := make(map[string]string)
+	for _, name := range parts {
+		path, cleanup, err := store.localPath(name)
+		if err != nil {
+			return false, err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			cleanup()
+			return false, err
+		}
+		files = append(files, part{closer: f, cleanup: cleanup})
+		info, err := f.Stat()
+		if err != nil {
+			return false, err
+		}
+		pf, err := parquet.OpenFile(f, info.Size())
+		if err != nil {
+			return false, fmt.Errorf("reading %s: %w", name, err)
+		}
+		files[len(files)-1].file = pf
+		for _, field := range pf.Schema().Fields() {
+			kind := parquetNodeKind(field)
+			if have, ok := kinds[field.Name()]; ok {
+				if have != kind {
+					fmt.Fprintf(os.Stderr, "Warning: not compacting %s: column %s is %s in some parts and %s in others\n", name[:strings.Index(name, "/")], field.Name(), have, kind)
+					return false, nil
+				}
+				continue
+			}
+			kinds[field.Name()] = kind
+			group.add(field.Name(), parquetLeaf(kind))
+		}
+	}
+	schema := parquet.NewSchema("log", group)
+	w := parquet.NewWriter(out, schema)
+	for i, p := range files {
+		conv, err := parquet.Convert(schema, p.file.Schema())
+		if err != nil {
+			return false, fmt.Errorf("converting %s: %w", parts[i], err)
+		}
+		for _, rg := range p.file.RowGroups() {
+			rows := parquet.ConvertRowGroup(rg, conv).Rows()
+			_, err := parquet.CopyRows(w, rows)
+			rows.Close()
+			if err != nil {
+				return false, fmt.Errorf("copying %s: %w", parts[i], err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// finishCompaction deletes the parts of state.Compacting once its file is
+// in place, or forgets it if the file was never written.
+func finishCompaction(store archiveStore, state *archiveState) error {
+	c := state.Compacting
+	if c == nil {
+		return nil
+	}
+	r, err := store.get(c.File)
+	if err == nil {
+		r.Close()
+		for _, name := range c.Parts {
+			if err := store.remove(name); err != nil {
+				return fmt.Errorf("removing compacted part %s: %w", name, err)
+			}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	state.Compacting = nil
+	return saveArchiveState(store, state)
+}
+
+// pruneArchive deletes the days that ended before cutoff and returns how
+// many it deleted.
+func pruneArchive(store archiveStore, cutoff time.Time) (int, error) {
+	objects, err := store.list()
+	if err != nil {
+		return 0, err
+	}
+	pruned := 0
+	for d, objs := range archiveDays(objects) {
+		if d.Add(day).After(cutoff) {
+			continue
+		}
+		for _, obj := range objs {
+			if err := store.remove(obj.name); err != nil {
+				return pruned, fmt.Errorf("pruning %s: %w", obj.name, err)
+			}
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// loadArchiveState reads the archive's state, or returns nil for a new
+// archive.
+func loadArchiveState(store archiveStore) (*archiveState, error) {
+	r, err := store.get(archiveStateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading archive state: %w", err)
+	}
+	defer r.Close()
+	var state archiveState
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return nil, fmt.Errorf("reading archive state %s: %w", store.location(archiveStateFile), err)
+	}
+	return &state, nil
+}
+
+func saveArchiveState(store archiveStore, state *archiveState) error {
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(store.tempDir(), ".archive-*.tmp")
+	if err != nil {
+		return fmt.Errorf("writing archive state: %w", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = store.put(archiveStateFile, f.Name())
+	}
+	if err != nil {
+		return fmt.Errorf("writing archive state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// archiveStore is where an archive lives. Names are relative to the
+// archive root and use "/", e.g. dt=2024-05-01/part-20240501T000000Z.parquet.
+type archiveStore interface {
+	// list returns every object in the archive with its size.
+	list() ([]archiveObject, error)
+	// get opens name; a missing name is an os.ErrNotExist error.
+	get(name string) (io.ReadCloser, error)
+	// localPath is name as a local file, downloaded if need be; cleanup
+	// removes any download.
+	localPath(name string) (path string, cleanup func(), err error)
+	// tempDir is where files are written before put; "" is the system
+	// default.
+	tempDir() string
+	// put moves the local file at path to name, replacing it.
+	put(name, path string) error
+	remove(name string) error
+	location(name string) string
+}
+
+type archiveObject struct {
+	name string
+	size int64
+}
+
+// openArchiveStore opens the archive at dir: s3://<bucket>/<prefix> or a
+// local directory, created if needed.
+func openArchiveStore(dir string) (archiveStore, error) {
+	rest, ok := strings.CutPrefix(dir, "s3://")
+	if !ok {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("creating archive directory: %w", err)
+		}
+		return localArchiveStore{dir: dir}, nil
+	}
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("--dir s3:// needs a bucket")
+	}
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return &s3ArchiveStore{ctx: ctx, client: s3.NewFromConfig(cfg), bucket: bucket, prefix: prefix}, nil
+}
+
+// localArchiveStore is an archive in a local directory.
+type localArchiveStore struct {
+	dir string
+}
+
+// list skips hidden files, such as the temporary files of a run.
+func (s localArchiveStore) list() ([]archiveObject, error) {
+	var objects []archiveObject
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, archiveObject{name: filepath.ToSlash(rel), size: info.Size()})
+		return nil
+	})
+	return objects, err
+}
+
+func (s localArchiveStore) get(name string) (io.ReadCloser, error) {
+	return os.Open(s.location(name))
+}
+
+func (s localArchiveStore) localPath(name string) (string, func(), error) {
+	return s.location(name), func() {}, nil
+}
+
+func (s localArchiveStore) tempDir() string { return s.dir }
+
+func (s localArchiveStore) put(name, path string) error {
+	dest := s.location(name)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path, dest)
+}
+
+// remove also removes the partition directory once it is empty.
+func (s localArchiveStore) remove(name string) error {
+	path := s.location(name)
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != filepath.Clean(s.dir) {
+		os.Remove(dir)
+	}
+	return nil
+}
+
+func (s localArchiveStore) location(name string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(name))
+}
+
+// s3ArchiveStore is an archive under a prefix of an S3 bucket, using the
+// AWS credentials and region of the environment.
+type s3ArchiveStore struct {
+	ctx    context.Context
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func (s *s3ArchiveStore) list() ([]archiveObject, error) {
+	var objects []archiveObject
+	pages := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{Bucket: &s.bucket, Prefix: &s.prefix})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(s.ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing s3://%s/%s: %w", s.bucket, s.prefix, err)
+		}
+		for _, obj := range page.Contents {
+			var size int64
+			if obj.Size != nil {
+				size = *obj.Size
+			}
+			objects = append(objects, archiveObject{name: strings.TrimPrefix(*obj.Key, s.prefix), size: size})
+		}
+	}
+	return objects, nil
+}
+
+func (s *s3ArchiveStore) get(name string) (io.ReadCloser, error) {
+	key := s.prefix + name
+	out, err := s.client.GetObject(s.ctx, &s3.GetObjectInput{Bucket: &s.bucket, Key: &key})
+	var missing *types.NoSuchKey
+	if errors.As(err, &missing) {
+		return nil, fmt.Errorf("%s: %w", s.location(name), os.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *s3ArchiveStore) localPath(name string) (string, func(), error) {
+	body, err := s.get(name)
+	if err != nil {
+		return "", nil, err
+	}
+	defer body.Close()
+	f, err := os.CreateTemp("", "ddlogs-archive-*")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+	_, err = io.Copy(f, body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("downloading %s: %w", s.location(name), err)
+	}
+	return f.Name(), cleanup, nil
+}
+
+func (s *s3ArchiveStore) tempDir() string { return "" }
+
+// put uploads the file at path and removes it.
+func (s *s3ArchiveStore) put(name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	defer f.Close()
+	key := s.prefix + name
+	_, err = s.client.PutObject(s.ctx, &s3.PutObjectInput{Bucket: &s.bucket, Key: &key, Body: f})
+	return err
+}
+
+func (s *s3ArchiveStore) remove(name string) error {
+	key := s.prefix + name
+	_, err := s.client.DeleteObject(s.ctx, &s3.DeleteObjectInput{Bucket: &s.bucket, Key: &key})
+	return err
+}
+
+func (s *s3ArchiveStore) location(name string) string {
+	return "s3://" + s.bucket + "/" + s.prefix + name
+}
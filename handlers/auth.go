@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV1"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// logsReadScope is the permission (and application key scope) needed to
+// search logs.
+const logsReadScope = "logs_read_data"
+
+// appKeyPageSize is the number of application keys requested per page when
+// looking up the handler's key.
+const appKeyPageSize = 100
+
+// KeyReport is the outcome of ValidateKeys. Each check that could not be
+// completed leaves its error set instead of failing the whole report.
+type KeyReport struct {
+	APIKeyValid bool
+	APIKeyErr   error
+
+	AppKeyValid bool
+	AppKeyName  string
+	AppKeyErr   error
+
+	Org    string
+	OrgID  string
+	OrgErr error
+
+	// Scoped is false for a key without scopes, which inherits every
+	// permission of the user who owns it; LogsRead then reflects the
+	// owner's roles.
+	Scoped   bool
+	Scopes   []string
+	LogsRead bool
+	ScopeErr error
+}
+
+// ValidateKeys checks the handler's API key and application key against
+// Datadog, looks up the org they belong to, and reports whether the
+// application key can read logs, so a 403 can be diagnosed without running
+// a query.
+func (h *DDHandler) ValidateKeys() KeyReport {
+	var report KeyReport
+	ctx := h.apiContext()
+	client := datadog.NewAPIClient(datadog.NewConfiguration())
+
+	valid, r, err := datadogV1.NewAuthenticationApi(client).Validate(ctx)
+	if err != nil {
+		report.APIKeyErr = keyError("AuthenticationApi.Validate", r, err)
+	} else {
+		report.APIKeyValid = valid.GetValid()
+	}
+
+	orgs, r, err := datadogV1.NewOrganizationsApi(client).ListOrgs(ctx)
+	switch {
+	case err != nil:
+		report.OrgErr = keyError("OrganizationsApi.ListOrgs", r, err)
+	case len(orgs.Orgs) == 0:
+		report.OrgErr = errors.New("no organization returned")
+	default:
+		report.Org = orgs.Orgs[0].GetName()
+		report.OrgID = orgs.Orgs[0].GetPublicId()
+	}
+
+	key, err := h.findAppKey(client)
+	if err != nil {
+		report.AppKeyErr = err
+		return report
+	}
+	report.AppKeyValid = true
+	attrs := key.GetAttributes()
+	report.AppKeyName = attrs.GetName()
+
+	if scopes := attrs.GetScopes(); len(scopes) > 0 {
+		report.Scoped = true
+		report.Scopes = scopes
+		report.LogsRead = containsString(scopes, logsReadScope)
+		return report
+	}
+	owner := key.GetRelationships().OwnedBy
+	if owner == nil {
+		report.ScopeErr = errors.New("the key's owner is unknown")
+		return report
+	}
+	perms, r, err := datadogV2.NewUsersApi(client).ListUserPermissions(ctx, owner.Data.Id)
+	if err != nil {
+		report.ScopeErr = keyError("UsersApi.ListUserPermissions", r, err)
+		return report
+	}
+	for _, p := range perms.Data {
+		if p.Attributes != nil && p.Attributes.GetName() == logsReadScope {
+			report.LogsRead = true
+		}
+	}
+	return report
+}
+
+// findAppKey finds the handler's application key among the current user's
+// keys by its last four characters. The listing itself only succeeds with a
+// valid application key.
+func (h *DDHandler) findAppKey(client *datadog.APIClient) (datadogV2.PartialApplicationKey, error) {
+	api := datadogV2.NewKeyManagementApi(client)
+	last4 := h.AppKey
+	if len(last4) > 4 {
+		last4 = last4[len(last4)-4:]
+	}
+	for page := int64(0); ; page++ {
+		params := datadogV2.NewListCurrentUserApplicationKeysOptionalParameters().
+			WithPageSize(appKeyPageSize).
+			WithPageNumber(page)
+		resp, r, err := api.ListCurrentUserApplicationKeys(h.apiContext(), *params)
+		if err != nil {
+			return datadogV2.PartialApplicationKey{}, keyError("KeyManagementApi.ListCurrentUserApplicationKeys", r, err)
+		}
+		for _, key := range resp.Data {
+			if key.Attributes != nil && key.Attributes.GetLast4() == last4 {
+				return key, nil
+			}
+		}
+		if len(resp.Data) < appKeyPageSize {
+			return datadogV2.PartialApplicationKey{}, fmt.Errorf("no application key of the current user ends in %s", last4)
+		}
+	}
+}
+
+// keyError describes a failed call, turning the usual auth failures into
+// their likely cause.
+func keyError(call string, r *http.Response, err error) error {
+	if r == nil {
+		return fmt.Errorf("calling %s: %w", call, err)
+	}
+	var reason string
+	switch r.StatusCode {
+	case http.StatusUnauthorized:
+		reason = "rejected: the key is invalid or belongs to another site"
+	case http.StatusForbidden:
+		reason = "forbidden: the key lacks the permission for this call"
+	default:
+		reason = strings.ToLower(http.StatusText(r.StatusCode))
+	}
+	return fmt.Errorf("%s (%d %s)", reason, r.StatusCode, call)
+}
@@ -0,0 +1,525 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// BackfillOptions configures a Backfill run.
+type BackfillOptions struct {
+	Query  string
+	From   time.Time
+	To     time.Time
+	Format string
+
+	// Chunk is the size of each time window exported as one unit. A chunk
+	// is either fully delivered to the sink or retried on the next run.
+	Chunk time.Duration
+
+	// Sink is where chunks are delivered, as a URL whose scheme selects a
+	// registered Sink. file://<dir> is built in: each chunk becomes one
+	// file in dir. So are delta://<dir> and delta+s3://<bucket>/<prefix>,
+	// which add each chunk to a Delta Lake table.
+	Sink string
+
+	// Checkpoint is the file recording backfill progress. Defaults to
+	// .backfill.json inside the sink directory for file and local Delta
+	// sinks, and is required for other sinks.
+	Checkpoint string
+
+	// Parallel is how many chunks are delivered at once, each by a worker
+	// with its own sink. Defaults to 1.
+	Parallel int
+
+	// RunWindow, when set, limits the backfill to a daily window: no chunk
+	// is started outside it, chunks already running are finished, and the
+	// backfill waits for the window to reopen.
+	RunWindow *RunWindow
+
+	// Quiet keeps per-chunk progress and the report off stderr; a
+	// mismatched count still fails the backfill.
+	Quiet bool
+}
+
+// backfillCheckpoint is persisted after every delivered chunk. Chunks lists
+// every delivered chunk, in time order; with parallel workers they can
+// finish out of order, so Next is only the start of the first chunk that
+// has not been delivered yet, and a resumed backfill delivers every chunk
+// missing from Chunks.
+type backfillCheckpoint struct {
+	Query  string          `json:"query"`
+	From   time.Time       `json:"from"`
+	To     time.Time       `json:"to"`
+	Chunk  string          `json:"chunk"`
+	Next   time.Time       `json:"next"`
+	Chunks []backfillChunk `json:"chunks"`
+
+	// Pending are the chunks being committed: each is saved just before
+	// the sink's Commit and moved to Chunks just after. Finding one on
+	// resume means the run stopped between the two.
+	Pending []backfillChunk `json:"pending_chunks,omitempty"`
+
+	// LegacyPending is the single pending chunk of checkpoints written
+	// before chunks were delivered in parallel; it is moved to Pending on
+	// load.
+	LegacyPending *backfillChunk `json:"pending,omitempty"`
+}
+
+// backfillChunk is the outcome of one delivered chunk. File is where the
+// sink says the chunk went; Expected is the count reported by the
+// aggregation API for the same window.
+type backfillChunk struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	File     string    `json:"file"`
+	Written  int       `json:"written"`
+	Expected int64     `json:"expected"`
+}
+
+// Backfill exports [From, To) in Chunk-sized windows to a sink, up to
+// opts.Parallel chunks at once. Each chunk's log count is checked against
+// the aggregation API and the checkpoint is saved around the sink's commit
+// — pending, commit, delivered — so an interrupted or failed backfill
+// delivers only the chunks still missing when run again, and a chunk
+// committed just before a crash is not delivered twice to a sink that can
+// confirm its commits. A completeness report is printed at the end; any
+// chunk whose count did not match fails the run, and is delivered again,
+// replacing the first delivery, by the next one.
+func (h *DDHandler) Backfill(opts BackfillOptions) error {
+	sink, err := openSink(opts.Sink, opts.Format)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+	parallel := max(opts.Parallel, 1)
+	if opts.Checkpoint == "" {
+		dc, ok := sink.(defaultCheckpointer)
+		if !ok {
+			return fmt.Errorf("--checkpoint is required for sink %s", opts.Sink)
+		}
+		opts.Checkpoint = dc.DefaultCheckpoint()
+	}
+	metrics := &sinkMetrics{scheme: strings.SplitN(opts.Sink, ":", 2)[0]}
+
+	cp, err := loadBackfillCheckpoint(opts.Checkpoint)
+	if err != nil {
+		return err
+	}
+	if cp == nil {
+		cp = &backfillCheckpoint{Query: opts.Query, From: opts.From, To: opts.To, Chunk: opts.Chunk.String(), Next: opts.From}
+	} else if cp.Query != opts.Query || !cp.From.Equal(opts.From) || !cp.To.Equal(opts.To) || cp.Chunk != opts.Chunk.String() {
+		return fmt.Errorf("checkpoint %s belongs to a different backfill; remove it or pass another --checkpoint", opts.Checkpoint)
+	} else {
+		if err := recoverPending(sink, cp, opts.Checkpoint); err != nil {
+			return err
+		}
+		if !opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Resuming (%d chunk(s) already delivered)\n", cp.completed())
+		}
+	}
+
+	sigCtx, stop := signal.NotifyContext(h.apiContext(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(sigCtx)
+	defer cancel()
+	api := h.logsAPI()
+
+	windows := backfillWindows(opts.From, opts.To, opts.Chunk)
+	total := len(windows)
+	todo := cp.undelivered(windows)
+	done := total - len(todo)
+
+	// The checkpoint is shared by the workers; mu guards it and its file.
+	var mu sync.Mutex
+	var firstErr error
+	deliver := func(sink Sink, w logWindow) error {
+		chunk, err := h.backfillChunk(ctx, api, opts, sink, metrics, w.start, w.end, func(pending backfillChunk) error {
+			mu.Lock()
+			defer mu.Unlock()
+			cp.Pending = append(cp.Pending, pending)
+			return saveBackfillCheckpoint(opts.Checkpoint, cp)
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("chunk %s: %w", w.start.Format(time.RFC3339), err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		status := "ok"
+		if !chunk.complete() {
+			status = "MISMATCH"
+		}
+		done++
+		if !opts.Quiet {
+			fmt.Fprintf(os.Stderr, "[%d/%d] %s → %s: %d logs (expected %d) %s\n",
+				done, total, w.start.Format(time.RFC3339), w.end.Format(time.RFC3339), chunk.Written, chunk.Expected, status)
+		}
+		cp.delivered(chunk)
+		return saveBackfillCheckpoint(opts.Checkpoint, cp)
+	}
+
+	jobs := make(chan logWindow)
+	var wg sync.WaitGroup
+	for i := 0; i < min(parallel, len(todo)); i++ {
+		s := sink
+		if i > 0 {
+			// Sinks hold one open chunk at a time, so each worker has its own.
+			if s, err = openSink(opts.Sink, opts.Format); err != nil {
+				cancel()
+				firstErr = err
+				break
+			}
+			defer s.Close()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for w := range jobs {
+				if err := deliver(s, w); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					cancel()
+				}
+			}
+		}()
+	}
+	if parallel > 1 && len(todo) > 1 && !opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Delivering %d chunk(s), %d at a time\n", len(todo), min(parallel, len(todo)))
+	}
+feed:
+	for _, w := range todo {
+		for {
+			// closes fires when the run window closes while every worker
+			// is busy, so the chunk waits for the next window instead.
+			var closes <-chan time.Time
+			if opts.RunWindow != nil {
+				if opts.RunWindow.wait(ctx) != nil {
+					break feed
+				}
+				_, left := opts.RunWindow.state(time.Now())
+				closes = time.After(left)
+			}
+			select {
+			case jobs <- w:
+				continue feed
+			case <-closes:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if sigCtx.Err() != nil {
+		if !opts.Quiet {
+			fmt.Fprintf(os.Stderr, "\nInterrupted; run again to deliver the %d chunk(s) still missing\n", total-cp.completed())
+		}
+		return sigCtx.Err()
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	return backfillReport(cp, total, metrics, opts.Quiet)
+}
+
+// backfillWindows splits [from, to) into chunk-sized windows, the last one
+// possibly shorter.
+func backfillWindows(from, to time.Time, chunk time.Duration) []logWindow {
+	var windows []logWindow
+	for start := from; start.Before(to); start = start.Add(chunk) {
+		end := start.Add(chunk)
+		if end.After(to) {
+			end = to
+		}
+		windows = append(windows, absoluteWindow(start, end))
+	}
+	return windows
+}
+
+// undelivered returns the windows whose chunk cp does not record as
+// delivered with its expected count. A chunk whose count disagreed is
+// delivered again, replacing what the sink holds for it.
+func (cp *backfillCheckpoint) undelivered(windows []logWindow) []logWindow {
+	done := make(map[int64]bool, len(cp.Chunks))
+	for _, c := range cp.Chunks {
+		done[c.From.UnixNano()] = c.complete()
+	}
+	var todo []logWindow
+	for _, w := range windows {
+		if w.start.Before(cp.Next) || done[w.start.UnixNano()] {
+			continue
+		}
+		todo = append(todo, w)
+	}
+	return todo
+}
+
+// delivered records chunk as delivered: it leaves Pending, joins Chunks in
+// time order, replacing an earlier delivery of the same window, and Next
+// moves past every complete chunk delivered without a gap.
+func (cp *backfillCheckpoint) delivered(chunk backfillChunk) {
+	pending := cp.Pending[:0]
+	for _, p := range cp.Pending {
+		if !p.From.Equal(chunk.From) {
+			pending = append(pending, p)
+		}
+	}
+	cp.Pending = pending
+	chunks := cp.Chunks[:0]
+	for _, c := range cp.Chunks {
+		if !c.From.Equal(chunk.From) {
+			chunks = append(chunks, c)
+		}
+	}
+	cp.Chunks = append(chunks, chunk)
+	sort.Slice(cp.Chunks, func(i, j int) bool { return cp.Chunks[i].From.Before(cp.Chunks[j].From) })
+	for _, c := range cp.Chunks {
+		if c.From.Equal(cp.Next) && c.complete() {
+			cp.Next = c.To
+		}
+	}
+}
+
+// completed is the number of chunks delivered with their expected count.
+func (cp *backfillCheckpoint) completed() int {
+	n := 0
+	for _, c := range cp.Chunks {
+		if c.complete() {
+			n++
+		}
+	}
+	return n
+}
+
+// complete reports whether the chunk's count matched the API's.
+func (c backfillChunk) complete() bool {
+	return int64(c.Written) == c.Expected
+}
+
+// backfillChunk delivers one window to sink in batches, fetches the
+// expected count, and commits it once complete. beforeCommit is called with
+// the finished chunk right before the commit, to record it as pending.
+func (h *DDHandler) backfillChunk(ctx context.Context, api *datadogV2.LogsApi, opts BackfillOptions, sink Sink, m *sinkMetrics, start, end time.Time, beforeCommit func(backfillChunk) error) (backfillChunk, error) {
+	from := start.UTC().Format(apiTimeLayout)
+	to := end.UTC().Format(apiTimeLayout)
+	chunk := backfillChunk{From: start, To: end}
+
+	if err := sink.Open(SinkChunk{From: start, To: end, Name: backfillChunkName(start)}); err != nil {
+		return chunk, err
+	}
+	size := 0
+	if bs, ok := sink.(batchSizer); ok {
+		size = bs.BatchSize()
+	}
+	var pending []datadogV2.Log
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		err := h.sinkCall(ctx, m, "write", func() error { return sink.WriteBatch(pending) })
+		if err != nil {
+			return err
+		}
+		m.add(&m.batches)
+		chunk.Written += len(pending)
+		pending = pending[:0]
+		return nil
+	}
+	err := h.listAll(ctx, api, opts.Query, from, to, nil, func(logs []datadogV2.Log) error {
+		if size <= 0 {
+			pending = logs
+			return flush()
+		}
+		for _, log := range logs {
+			pending = append(pending, log)
+			if len(pending) >= size {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err == nil && size > 0 {
+		err = flush()
+	}
+	if err != nil {
+		return chunk, err
+	}
+	chunk.Expected, err = h.countLogs(ctx, api, opts.Query, from, to, nil)
+	if err != nil {
+		return chunk, err
+	}
+	if err := beforeCommit(chunk); err != nil {
+		return chunk, err
+	}
+	err = h.sinkCall(ctx, m, "commit", func() error {
+		var err error
+		chunk.File, err = sink.Commit()
+		return err
+	})
+	if err != nil {
+		return chunk, err
+	}
+	m.add(&m.commits)
+	return chunk, nil
+}
+
+// recoverPending settles the chunks left pending by a run that stopped
+// between committing them and recording them in the checkpoint. A chunk the
+// sink confirms as committed is recorded as delivered; any other is
+// delivered again, with a warning when the sink cannot tell whether the
+// first delivery landed.
+func recoverPending(sink Sink, cp *backfillCheckpoint, path string) error {
+	if len(cp.Pending) == 0 {
+		return nil
+	}
+	pending := cp.Pending
+	cp.Pending = nil
+	cc, ok := sink.(commitChecker)
+	for _, chunk := range pending {
+		sc := SinkChunk{From: chunk.From, To: chunk.To, Name: backfillChunkName(chunk.From)}
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Warning: chunk %s may already have been committed; the sink cannot confirm it, so it is delivered again\n", sc.Name)
+			continue
+		}
+		location, committed, err := cc.Committed(sc)
+		if err != nil {
+			cp.Pending = pending
+			return fmt.Errorf("checking whether chunk %s was committed: %w", sc.Name, err)
+		}
+		if !committed {
+			continue
+		}
+		chunk.File = location
+		cp.delivered(chunk)
+		fmt.Fprintf(os.Stderr, "Chunk %s was committed before the last run stopped; recorded without delivering it again\n", sc.Name)
+	}
+	return saveBackfillCheckpoint(path, cp)
+}
+
+// backfillChunkName is the sink name of the chunk starting at start.
+func backfillChunkName(start time.Time) string {
+	return start.UTC().Format("20060102T150405Z")
+}
+
+// countLogs asks the aggregation API how many logs match query between from
+// and to (Datadog time strings), in indexes or all of them when it is nil.
+func (h *DDHandler) countLogs(ctx context.Context, api *datadogV2.LogsApi, query, from, to string, indexes []string) (int64, error) {
+	storageTier := datadogV2.LOGSSTORAGETIER_FLEX
+	body := datadogV2.LogsAggregateRequest{
+		Compute: []datadogV2.LogsCompute{{
+			Aggregation: datadogV2.LOGSAGGREGATIONFUNCTION_COUNT,
+			Type:        datadogV2.LOGSCOMPUTETYPE_TOTAL.Ptr(),
+		}},
+		Filter: &datadogV2.LogsQueryFilter{
+			Query:       datadog.PtrString(query),
+			From:        datadog.PtrString(from),
+			To:          datadog.PtrString(to),
+			Indexes:     indexes,
+			StorageTier: &storageTier,
+		},
+	}
+	resp, _, err := api.AggregateLogs(h.siteContext(ctx), body)
+	if err != nil {
+		return 0, fmt.Errorf("calling LogsApi.AggregateLogs: %w", err)
+	}
+	buckets := resp.GetData().Buckets
+	if len(buckets) == 0 {
+		return 0, nil
+	}
+	v, ok := buckets[0].Computes["c0"]
+	if !ok || v.LogsAggregateBucketValueSingleNumber == nil {
+		return 0, fmt.Errorf("aggregation response has no count")
+	}
+	return int64(*v.LogsAggregateBucketValueSingleNumber), nil
+}
+
+// backfillReport prints the completeness report, unless quiet, and fails if
+// any delivered chunk's count disagreed with the API.
+func backfillReport(cp *backfillCheckpoint, total int, m *sinkMetrics, quiet bool) error {
+	var written, expected int64
+	var mismatched []backfillChunk
+	for _, c := range cp.Chunks {
+		written += int64(c.Written)
+		expected += c.Expected
+		if !c.complete() {
+			mismatched = append(mismatched, c)
+		}
+	}
+
+	if !quiet {
+		fmt.Fprintf(os.Stderr, "\nBackfill %s → %s\n", cp.From.Format(time.RFC3339), cp.To.Format(time.RFC3339))
+		fmt.Fprintf(os.Stderr, "  chunks:   %d of %d delivered\n", len(cp.Chunks), total)
+		fmt.Fprintf(os.Stderr, "  logs:     %d written, %d expected\n", written, expected)
+		fmt.Fprintf(os.Stderr, "  sink:     %s, %d batch(es), %d commit(s), %d retry(ies), %s this run\n", m.scheme, m.batches, m.commits, m.retries, m.elapsed.Round(time.Millisecond))
+		if len(mismatched) == 0 {
+			fmt.Fprintf(os.Stderr, "  complete: every chunk matched its expected count\n")
+		} else {
+			fmt.Fprintf(os.Stderr, "  incomplete chunks:\n")
+			for _, c := range mismatched {
+				fmt.Fprintf(os.Stderr, "    %s  %d written, %d expected\n", c.File, c.Written, c.Expected)
+			}
+		}
+	}
+	if len(mismatched) > 0 {
+		return fmt.Errorf("%d chunk(s) did not match the expected count; run again to deliver them again", len(mismatched))
+	}
+	return nil
+}
+
+// loadBackfillCheckpoint reads a checkpoint, returning nil if it does not
+// exist yet.
+func loadBackfillCheckpoint(path string) (*backfillCheckpoint, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading checkpoint: %w", err)
+	}
+	var cp backfillCheckpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return nil, fmt.Errorf("decoding checkpoint %s: %w", path, err)
+	}
+	if cp.LegacyPending != nil {
+		cp.Pending = append(cp.Pending, *cp.LegacyPending)
+		cp.LegacyPending = nil
+	}
+	return &cp, nil
+}
+
+// saveBackfillCheckpoint writes cp atomically, like saveTailState.
+func saveBackfillCheckpoint(path string, cp *backfillCheckpoint) error {
+	b, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("writing checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("writing checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// batchFormats are the formats a batch query can be written in: those that
+// need no options beyond an output file.
+var batchFormats = []string{"csv", "json", "ndjson", "parquet", "xlsx", "md"}
+
+// BatchManifest lists the exports "ddlogs batch" runs together.
+type BatchManifest struct {
+	// Parallel is how many queries run at once; 1 when unset.
+	Parallel int `yaml:"parallel"`
+
+	// Defaults fill in what a query leaves out.
+	Defaults BatchQuery `yaml:"defaults"`
+
+	Queries []BatchQuery `yaml:"queries"`
+}
+
+// BatchQuery is one export of a batch.
+type BatchQuery struct {
+	Name    string   `yaml:"name"`
+	Query   string   `yaml:"query"`
+	From    string   `yaml:"from"`
+	To      string   `yaml:"to"`
+	Format  string   `yaml:"format"`
+	Output  string   `yaml:"output"`
+	Indexes []string `yaml:"indexes"`
+	Limit   int      `yaml:"limit"`
+	Sort    string   `yaml:"sort"`
+}
+
+// LoadBatchManifest reads a manifest from a YAML file of the form:
+//
+//	parallel: 3
+//	defaults:
+//	  from: 2024-05-01T14:00:00Z
+//	  to: 2024-05-01T16:00:00Z
+//	  format: csv
+//	queries:
+//	  - name: checkout-errors
+//	    query: service:checkout status:error
+//	    output: review/checkout-errors.csv
+//	  - name: payments
+//	    query: service:payments
+//	    format: parquet
+//	    output: review/payments.parquet
+//
+// Defaults fill in each query's from (15m when neither sets it), to (now),
+// format (csv), indexes, limit and sort. Unknown keys are errors, so a
+// misspelled field is not silently ignored.
+func LoadBatchManifest(path string) (*BatchManifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	var m BatchManifest
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("decoding manifest %s: %w", path, err)
+	}
+	if len(m.Queries) == 0 {
+		return nil, fmt.Errorf("manifest %s has no queries", path)
+	}
+	if m.Parallel < 0 {
+		return nil, fmt.Errorf("manifest %s: parallel must not be negative", path)
+	}
+	d := m.Defaults
+	names := make(map[string]bool)
+	outputs := make(map[string]string)
+	for i := range m.Queries {
+		q := &m.Queries[i]
+		q.From = firstNonEmptyString(q.From, d.From, "15m")
+		q.To = firstNonEmptyString(q.To, d.To, "now")
+		q.Format = firstNonEmptyString(q.Format, d.Format, "csv")
+		q.Sort = firstNonEmptyString(q.Sort, d.Sort, "asc")
+		if q.Indexes == nil {
+			q.Indexes = d.Indexes
+		}
+		if q.Limit == 0 {
+			q.Limit = d.Limit
+		}
+		switch {
+		case q.Name == "":
+			return nil, fmt.Errorf("manifest %s: query %d has no name", path, i+1)
+		case names[q.Name]:
+			return nil, fmt.Errorf("manifest %s: two queries are named %q", path, q.Name)
+		case q.Query == "":
+			return nil, fmt.Errorf("manifest %s: %s has no query", path, q.Name)
+		case q.Output == "":
+			return nil, fmt.Errorf("manifest %s: %s has no output", path, q.Name)
+		case outputs[filepath.Clean(q.Output)] != "":
+			return nil, fmt.Errorf("manifest %s: %s and %s both write %s", path, outputs[filepath.Clean(q.Output)], q.Name, q.Output)
+		case !containsString(batchFormats, q.Format):
+			return nil, fmt.Errorf("manifest %s: %s has format %q (supported: csv, json, ndjson, parquet, xlsx, md)", path, q.Name, q.Format)
+		case q.Sort != "asc" && q.Sort != "desc":
+			return nil, fmt.Errorf("manifest %s: %s has sort %q (asc or desc)", path, q.Name, q.Sort)
+		case q.Limit < 0:
+			return nil, fmt.Errorf("manifest %s: %s has a negative limit", path, q.Name)
+		}
+		names[q.Name] = true
+		outputs[filepath.Clean(q.Output)] = q.Name
+	}
+	return &m, nil
+}
+
+// batchResult is how one query of a batch went.
+type batchResult struct {
+	query   BatchQuery
+	started bool
+	logs    int
+	bytes   int64
+	elapsed time.Duration
+	err     error
+}
+
+// Batch runs the queries of m, up to parallel at once (m.Parallel when
+// parallel is 0), and prints a summary of each. A failing query does not
+// stop the others; Batch fails if any did. Run one at a time, a query shows
+// its progress as search does; run in parallel, each prints one line when
+// it finishes. quiet leaves only the summary.
+func (h *DDHandler) Batch(m *BatchManifest, parallel int, quiet bool) error {
+	if parallel <= 0 {
+		parallel = max(m.Parallel, 1)
+	}
+	// A bad time range would otherwise only show once its turn came.
+	for _, q := range m.Queries {
+		if _, _, err := ResolveTimeRange(q.From, q.To); err != nil {
+			return fmt.Errorf("%s: %w", q.Name, err)
+		}
+	}
+	ctx, stop := signal.NotifyContext(h.apiContext(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	results := make([]batchResult, len(m.Queries))
+	var mu sync.Mutex
+	run := func(i int) {
+		q := m.Queries[i]
+		r := batchResult{query: q, started: true}
+		start := time.Now()
+		if dir := filepath.Dir(q.Output); dir != "." {
+			r.err = os.MkdirAll(dir, 0o755)
+		}
+		if r.err == nil {
+			r.err = h.Query(QueryOptions{
+				Query:      q.Query,
+				From:       q.From,
+				To:         q.To,
+				OutputFile: q.Output,
+				Format:     q.Format,
+				Indexes:    q.Indexes,
+				Limit:      q.Limit,
+				Descending: q.Sort == "desc",
+				Quiet:      quiet || parallel > 1,
+				written:    &r.logs,
+			})
+		}
+		r.elapsed = time.Since(start)
+		if info, err := os.Stat(q.Output); err == nil {
+			r.bytes = info.Size()
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		results[i] = r
+		if parallel > 1 && !quiet {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", q.Name, r.status())
+		}
+	}
+
+	if parallel == 1 {
+		for i, q := range m.Queries {
+			if ctx.Err() != nil {
+				break
+			}
+			if !quiet {
+				fmt.Fprintf(os.Stderr, "[%d/%d] %s\n", i+1, len(m.Queries), q.Name)
+			}
+			run(i)
+		}
+	} else {
+		if !quiet {
+			fmt.Fprintf(os.Stderr, "Running %d queries, %d at a time\n", len(m.Queries), min(parallel, len(m.Queries)))
+		}
+		sem := make(chan struct{}, parallel)
+		var wg sync.WaitGroup
+		for i := range m.Queries {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				run(i)
+			}()
+		}
+		wg.Wait()
+	}
+	return batchSummary(results, ctx.Err() != nil)
+}
+
+// status is the outcome of r for the summary.
+func (r *batchResult) status() string {
+	switch {
+	case !r.started:
+		return "not run"
+	case errors.Is(r.err, ErrInterrupted):
+		return "interrupted"
+	case r.err != nil:
+		return "FAILED: " + r.err.Error()
+	}
+	return fmt.Sprintf("%d logs, %s in %s", r.logs, formatBytes(r.bytes), r.elapsed.Round(100*time.Millisecond))
+}
+
+// batchSummary prints one row per query and fails if any query did not
+// complete.
+func batchSummary(results []batchResult, interrupted bool) error {
+	fmt.Fprintln(os.Stderr, "\nBatch summary:")
+	tw := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  NAME\tSTATUS\tLOGS\tSIZE\tTIME\tOUTPUT")
+	failed := 0
+	for _, r := range results {
+		status := "ok"
+		switch {
+		case !r.started:
+			status = "not run"
+		case errors.Is(r.err, ErrInterrupted):
+			status = "interrupted"
+			failed++
+		case r.err != nil:
+			status = "FAILED"
+			failed++
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%d\t%s\t%s\t%s\n", r.query.Name, status, r.logs, formatBytes(r.bytes), r.elapsed.Round(100*time.Millisecond), r.query.Output)
+	}
+	tw.Flush()
+	for _, r := range results {
+		if r.err != nil && !errors.Is(r.err, ErrInterrupted) {
+			fmt.Fprintf(os.Stderr, "  %s: %v\n", r.query.Name, r.err)
+		}
+	}
+	if interrupted {
+		return ErrInterrupted
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d queries failed", failed, len(results))
+	}
+	return nil
+}
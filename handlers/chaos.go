@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// FaultInjection makes a fraction of API requests fail without reaching
+// Datadog, so tools wrapping ddlogs can exercise their retry and alerting
+// paths on demand. Failures are drawn from a generator seeded with Seed, so
+// a run with the same flags fails the same requests every time.
+type FaultInjection struct {
+	// RateLimitRate is the fraction of requests answered with a 429 whose
+	// rate-limit headers ask for a one-second pause.
+	RateLimitRate float64
+
+	// TimeoutRate is the fraction of requests that fail with a network
+	// timeout.
+	TimeoutRate float64
+
+	Seed int64
+}
+
+// InjectFaults routes the handler's log API calls through f.
+func (h *DDHandler) InjectFaults(f FaultInjection) {
+	h.faults = &faultTransport{next: http.DefaultTransport, f: f, rng: rand.New(rand.NewSource(f.Seed))}
+}
+
+// faultTransport fails requests as its FaultInjection says and passes the
+// rest to next.
+type faultTransport struct {
+	next http.RoundTripper
+	f    FaultInjection
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	roll := t.rng.Float64()
+	t.mu.Unlock()
+	switch {
+	case roll < t.f.RateLimitRate:
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		h := http.Header{}
+		h.Set("Content-Type", "application/json")
+		h.Set("X-RateLimit-Remaining", "0")
+		h.Set("X-RateLimit-Reset", "1")
+		return &http.Response{
+			Status:     "429 Too Many Requests (injected)",
+			StatusCode: http.StatusTooManyRequests,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     h,
+			Body:       io.NopCloser(strings.NewReader(`{"errors":["Too many requests (injected by --inject-429-rate)"]}`)),
+			Request:    req,
+		}, nil
+	case roll < t.f.RateLimitRate+t.f.TimeoutRate:
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, injectedTimeout{}
+	}
+	return t.next.RoundTrip(req)
+}
+
+// injectedTimeout is the net.Error of a timeout from --inject-timeout-rate.
+type injectedTimeout struct{}
+
+func (injectedTimeout) Error() string   { return "i/o timeout (injected by --inject-timeout-rate)" }
+func (injectedTimeout) Timeout() bool   { return true }
+func (injectedTimeout) Temporary() bool { return true }
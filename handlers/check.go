@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Check states, which are also ddlogs check's exit codes: those of Nagios
+// plugins, understood by most monitoring agents and cron wrappers.
+const (
+	CheckOK       = 0
+	CheckWarning  = 1
+	CheckCritical = 2
+	CheckUnknown  = 3
+)
+
+var checkStates = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// CheckOptions configures a Check.
+type CheckOptions struct {
+	Query   string
+	From    string
+	To      string
+	Indexes []string
+
+	// Warning and Critical are the counts above which the check warns or
+	// fails; a negative value disables the threshold.
+	Warning  int64
+	Critical int64
+}
+
+// Check counts the logs matching opts like Count and prints the result as
+// the status line of a Nagios plugin, with the count as performance data:
+//
+//	LOGS CRITICAL - 14 logs match "status:error" in the last 5m (critical above 10) | count=14;5;10;0;
+//
+// It returns the state; when the count fails it prints nothing and returns
+// CheckUnknown with the error (see PrintCheckUnknown).
+func (h *DDHandler) Check(opts CheckOptions) (int, error) {
+	count, err := h.CountLogs(context.Background(), CountOptions{Query: opts.Query, From: opts.From, To: opts.To, Indexes: opts.Indexes})
+	if err != nil {
+		return CheckUnknown, err
+	}
+	state, reason := CheckOK, ""
+	switch {
+	case opts.Critical >= 0 && count > opts.Critical:
+		state, reason = CheckCritical, fmt.Sprintf(" (critical above %d)", opts.Critical)
+	case opts.Warning >= 0 && count > opts.Warning:
+		state, reason = CheckWarning, fmt.Sprintf(" (warning above %d)", opts.Warning)
+	}
+	noun := "logs match"
+	if count == 1 {
+		noun = "log matches"
+	}
+	fmt.Fprintf(os.Stdout, "LOGS %s - %d %s %q %s%s | count=%d;%s;%s;0;\n",
+		checkStates[state], count, noun, opts.Query, checkWindow(opts.From, opts.To), reason,
+		count, checkThreshold(opts.Warning), checkThreshold(opts.Critical))
+	return state, nil
+}
+
+// PrintCheckUnknown prints the status line of a check that could not run.
+func PrintCheckUnknown(err error) {
+	fmt.Fprintf(os.Stdout, "LOGS %s - %v\n", checkStates[CheckUnknown], err)
+}
+
+// checkWindow describes the time range of a check for its status line.
+func checkWindow(from, to string) string {
+	if _, err := parseDuration(from); err == nil && to == "now" {
+		return "in the last " + from
+	}
+	return fmt.Sprintf("from %s to %s", from, to)
+}
+
+// checkThreshold is a threshold as performance data: empty when disabled.
+func checkThreshold(n int64) string {
+	if n < 0 {
+		return ""
+	}
+	return strconv.FormatInt(n, 10)
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// exportPosition is where a search export continues: the time windows still
+// to fetch, the cursor into the first of them, and the page number and
+// number of logs written so far.
+type exportPosition struct {
+	Windows []checkpointWindow `json:"windows"`
+	Cursor  string             `json:"cursor,omitempty"`
+	Page    int                `json:"page"`
+	Written int                `json:"written"`
+}
+
+// checkpointWindow is a logWindow as saved in a checkpoint.
+type checkpointWindow struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func newExportPosition(windows []logWindow, cursor *string, page int) exportPosition {
+	pos := exportPosition{Page: page}
+	for _, w := range windows {
+		pos.Windows = append(pos.Windows, checkpointWindow{From: w.from, To: w.to})
+	}
+	if cursor != nil {
+		pos.Cursor = *cursor
+	}
+	return pos
+}
+
+// logWindows restores the saved windows, with absolute bounds where the
+// saved values are absolute times.
+func (p exportPosition) logWindows() []logWindow {
+	windows := make([]logWindow, 0, len(p.Windows))
+	for _, w := range p.Windows {
+		lw := logWindow{from: w.From, to: w.To}
+		start, okStart := ParseAbsoluteTime(w.From)
+		end, okEnd := ParseAbsoluteTime(w.To)
+		if okStart && okEnd {
+			lw.start, lw.end = start, end
+		}
+		windows = append(windows, lw)
+	}
+	return windows
+}
+
+// exportCheckpoint is the --checkpoint file of a search export, rewritten
+// after every page. Bytes is the output size at that point: a resumed export
+// truncates the output to it, discarding anything written after the last
+// checkpoint, and appends from there.
+type exportCheckpoint struct {
+	Query  string `json:"query"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Format string `json:"format"`
+	Output string `json:"output"`
+
+	// Descending records --sort desc; a resumed export keeps the order it
+	// started with.
+	Descending bool `json:"descending,omitempty"`
+
+	Position exportPosition `json:"position"`
+	Bytes    int64          `json:"bytes"`
+
+	// Headers is the CSV header already written, which a resumed export
+	// must keep using.
+	Headers []string `json:"csv_headers,omitempty"`
+
+	// Emitted is how many logs the JSON writer has put in the output. It
+	// can be below Position.Written, which counts logs before --grep,
+	// --ioc-only, transforms or classification dropped any, and decides
+	// whether a resumed array continues with a comma.
+	Emitted int `json:"emitted,omitempty"`
+}
+
+// resumed reports whether cp was saved by an earlier run.
+func (cp *exportCheckpoint) resumed() bool {
+	return cp.Position.Page > 0
+}
+
+// openCheckpoint prepares a checkpointed export of opts: it continues the
+// export recorded in opts.Checkpoint when opts.Resume is set, and otherwise
+// starts a new one with its time range pinned to absolute times, so the
+// saved cursor stays valid however late the export is resumed. opts.From and
+// opts.To are replaced by the pinned range.
+func openCheckpoint(opts *QueryOptions) (*exportCheckpoint, *os.File, error) {
+	cp, err := loadExportCheckpoint(opts.Checkpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case cp != nil && !opts.Resume:
+		return nil, nil, fmt.Errorf("checkpoint %s exists from an earlier export; pass --resume to continue it or delete it to start over", opts.Checkpoint)
+	case cp != nil:
+		if cp.Query != opts.Query || cp.Format != opts.Format || cp.Output != opts.OutputFile {
+			return nil, nil, fmt.Errorf("checkpoint %s is for query %q (%s output to %s), not this export", opts.Checkpoint, cp.Query, cp.Format, cp.Output)
+		}
+		opts.From, opts.To, opts.Descending = cp.From, cp.To, cp.Descending
+		f, err := reopenOutput(cp, opts.OutputFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		return cp, f, nil
+	case opts.Resume:
+		fmt.Fprintf(os.Stderr, "No checkpoint at %s; starting a new export\n", opts.Checkpoint)
+	}
+
+	cp = newExportCheckpoint(opts)
+	f, err := os.Create(opts.OutputFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating output file: %w", err)
+	}
+	return cp, f, nil
+}
+
+// newExportCheckpoint starts the checkpoint of a new export of opts, first
+// pinning opts.From and opts.To to absolute times.
+func newExportCheckpoint(opts *QueryOptions) *exportCheckpoint {
+	now := time.Now()
+	for _, v := range []*string{&opts.From, &opts.To} {
+		if t, err := resolveTime(*v, now); err == nil {
+			*v = t.UTC().Format(apiTimeLayout)
+		}
+	}
+	return &exportCheckpoint{
+		Query:  opts.Query,
+		From:   opts.From,
+		To:     opts.To,
+		Format: opts.Format,
+		Output: opts.OutputFile,
+
+		Descending: opts.Descending,
+	}
+}
+
+// reopenOutput opens the output of an export being resumed from cp,
+// truncated to the size cp recorded and positioned at its end.
+func reopenOutput(cp *exportCheckpoint, path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening output for resume: %w", err)
+	}
+	if err := f.Truncate(cp.Bytes); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("truncating output to the checkpoint: %w", err)
+	}
+	if _, err := f.Seek(cp.Bytes, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "Resuming export at page %d (%d logs already written to %s)\n", cp.Position.Page, cp.Position.Written, path)
+	return f, nil
+}
+
+// resumeWriter primes a freshly built writer to append to output written by
+// an earlier run: no JSON array opener, and the CSV header already fixed.
+func resumeWriter(w logWriter, cp *exportCheckpoint) {
+	switch w := w.(type) {
+	case *csvWriter:
+		w.headers = cp.Headers
+		w.started = true
+		for i, h := range cp.Headers {
+			if i >= len(w.fixed)+len(w.tagCols) {
+				w.attrSet[h] = true
+			}
+		}
+	case *longWriter:
+		w.resumed = true
+	case *jsonWriter:
+		w.count = cp.Emitted
+		w.resumed = true
+	case *rawJSONWriter:
+		w.count = cp.Emitted
+		w.resumed = true
+	}
+}
+
+// emittedLogs returns how many logs w has written, if it is a JSON writer.
+func emittedLogs(w logWriter) int {
+	switch w := w.(type) {
+	case *jsonWriter:
+		return w.count
+	case *rawJSONWriter:
+		return w.count
+	}
+	return 0
+}
+
+// csvHeaders returns the header w has written, if it is a CSV writer.
+func csvHeaders(w logWriter) []string {
+	if c, ok := w.(*csvWriter); ok && c.started {
+		return c.headers
+	}
+	return nil
+}
+
+func loadExportCheckpoint(path string) (*exportCheckpoint, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading checkpoint: %w", err)
+	}
+	var cp exportCheckpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return nil, fmt.Errorf("decoding checkpoint %s: %w", path, err)
+	}
+	return &cp, nil
+}
+
+// saveExportCheckpoint writes cp atomically, like saveTailState.
+func saveExportCheckpoint(path string, cp *exportCheckpoint) error {
+	b, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("writing checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("writing checkpoint: %w", err)
+	}
+	return nil
+}
+
+// countingWriter counts the bytes written through it, starting from n.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"gopkg.in/yaml.v3"
+)
+
+// ClassificationLevel is the sensitivity of a column. Levels are ordered, so
+// a column may be exported only when its level is at most the allowed one.
+type ClassificationLevel int
+
+const (
+	Public ClassificationLevel = iota
+	Internal
+	Confidential
+)
+
+var classificationNames = []string{"public", "internal", "confidential"}
+
+func (l ClassificationLevel) String() string {
+	if l < 0 || int(l) >= len(classificationNames) {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return classificationNames[l]
+}
+
+// ParseClassificationLevel parses public, internal or confidential.
+func ParseClassificationLevel(name string) (ClassificationLevel, error) {
+	for i, n := range classificationNames {
+		if strings.EqualFold(strings.TrimSpace(name), n) {
+			return ClassificationLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown classification %q (want %s)", name, strings.Join(classificationNames, ", "))
+}
+
+// Classification assigns a level to fixed columns and attributes. Columns are
+// named as in CSV output ("message", "host", "usr.email"); names may use *
+// as a wildcard. Nested attributes inherit the level of their parent unless
+// listed themselves, and anything unlisted gets Default.
+type Classification struct {
+	Default ClassificationLevel
+	columns map[string]ClassificationLevel
+	globs   []string
+}
+
+// LoadClassification reads a classification config from a YAML file of the
+// form:
+//
+//	default: internal
+//	columns:
+//	  host: public
+//	  service: public
+//	  usr.email: confidential
+//	  network.client.*: confidential
+//
+// default may be omitted, in which case unlisted columns are public.
+func LoadClassification(path string) (*Classification, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading classification config: %w", err)
+	}
+	var raw struct {
+		Default string            `yaml:"default"`
+		Columns map[string]string `yaml:"columns"`
+	}
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("decoding classification config %s: %w", path, err)
+	}
+
+	c := &Classification{columns: make(map[string]ClassificationLevel)}
+	if raw.Default != "" {
+		if c.Default, err = ParseClassificationLevel(raw.Default); err != nil {
+			return nil, fmt.Errorf("%s: default: %w", path, err)
+		}
+	}
+	for col, name := range raw.Columns {
+		level, err := ParseClassificationLevel(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: column %s: %w", path, col, err)
+		}
+		c.columns[col] = level
+		if strings.Contains(col, "*") {
+			c.globs = append(c.globs, col)
+		}
+	}
+	sort.Strings(c.globs)
+	return c, nil
+}
+
+// level returns the level listed for column, preferring an exact entry over
+// a wildcard one.
+func (c *Classification) level(column string) (ClassificationLevel, bool) {
+	if l, ok := c.columns[column]; ok {
+		return l, true
+	}
+	for _, g := range c.globs {
+		if globMatch(g, column) {
+			return c.columns[g], true
+		}
+	}
+	return 0, false
+}
+
+// allows reports whether column may be exported at max. Unlisted columns
+// get the default level.
+func (c *Classification) allows(column string, max ClassificationLevel) bool {
+	level, ok := c.level(column)
+	if !ok {
+		level = c.Default
+	}
+	return level <= max
+}
+
+// Allows reports whether column, named as in CSV output or as an
+// @attribute facet, may be shown at max. An attribute that is not listed
+// takes the level of its nearest listed parent, as in exports.
+func (c *Classification) Allows(column string, max ClassificationLevel) bool {
+	name := strings.TrimPrefix(column, "@")
+	for {
+		if level, ok := c.level(name); ok {
+			return level <= max
+		}
+		i := strings.LastIndexByte(name, '.')
+		if i < 0 {
+			return c.Default <= max
+		}
+		name = name[:i]
+	}
+}
+
+// exportedFixedColumns returns the fixed columns opts allows, so tabular
+// formats drop a classified column entirely rather than leaving it empty.
+func exportedFixedColumns(opts QueryOptions) []string {
+	if opts.Classification == nil {
+		return fixedColumns
+	}
+	var cols []string
+	for _, col := range fixedColumns {
+		if opts.Classification.allows(col, opts.MaxClassification) {
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+// exportedColumns returns the explicit --columns opts allows, named for the
+// policy as in CSV headers.
+func exportedColumns(opts QueryOptions) []string {
+	if opts.Classification == nil || opts.Columns == nil {
+		return opts.Columns
+	}
+	var cols []string
+	for _, col := range opts.Columns {
+		if opts.Classification.allows(columnHeader(col), opts.MaxClassification) {
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+// classifiedWriter wraps another logWriter and removes every column
+// classified above max before the log reaches it, so no output (including
+// column stats and indicator matches) ever sees the stripped values.
+type classifiedWriter struct {
+	logWriter
+	policy   *Classification
+	max      ClassificationLevel
+	stripped map[string]bool
+}
+
+func newClassifiedWriter(inner logWriter, policy *Classification, max ClassificationLevel) *classifiedWriter {
+	return &classifiedWriter{logWriter: inner, policy: policy, max: max, stripped: make(map[string]bool)}
+}
+
+func (w *classifiedWriter) WriteLog(log datadogV2.Log) error {
+	attrs := log.GetAttributes()
+	w.stripFixed(&attrs)
+	if custom := attrs.GetAttributes(); custom != nil {
+		w.stripAttrs(custom, "", w.policy.Default)
+	}
+	log.SetAttributes(attrs)
+	return w.logWriter.WriteLog(log)
+}
+
+// stripFixed clears the fixed columns classified above the allowed level.
+func (w *classifiedWriter) stripFixed(attrs *datadogV2.LogAttributes) {
+	for _, col := range fixedColumns {
+		if w.policy.allows(col, w.max) {
+			continue
+		}
+		w.stripped[col] = true
+		switch col {
+		case "timestamp":
+			attrs.Timestamp = nil
+		case "host":
+			attrs.Host = nil
+		case "service":
+			attrs.Service = nil
+		case "status":
+			attrs.Status = nil
+		case "message":
+			attrs.Message = nil
+		case "tags":
+			attrs.Tags = nil
+		}
+	}
+}
+
+// stripAttrs deletes attributes of m classified above the allowed level,
+// descending into nested objects. inherited is the level of m itself.
+func (w *classifiedWriter) stripAttrs(m map[string]interface{}, prefix string, inherited ClassificationLevel) {
+	for key, v := range m {
+		path := prefix + key
+		level, ok := w.policy.level(path)
+		if !ok {
+			level = inherited
+		}
+		if level > w.max {
+			delete(m, key)
+			w.stripped[path] = true
+			continue
+		}
+		if nested, ok := v.(map[string]interface{}); ok {
+			w.stripAttrs(nested, path+".", level)
+		}
+	}
+}
+
+func (w *classifiedWriter) End() error {
+	if err := w.logWriter.End(); err != nil {
+		return err
+	}
+	if len(w.stripped) == 0 {
+		return nil
+	}
+	cols := make([]string, 0, len(w.stripped))
+	for c := range w.stripped {
+		cols = append(cols, c)
+	}
+	sort.Strings(cols)
+	fmt.Fprintf(os.Stderr, "\nClassification: removed %d column(s) above %s: %s\n", len(cols), w.max, strings.Join(cols, ", "))
+	return nil
+}
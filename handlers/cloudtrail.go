@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// defaultCorrelateWindow is how far apart a Datadog log and a CloudTrail
+// event may be and still be correlated.
+const defaultCorrelateWindow = 5 * time.Minute
+
+// ddPrincipalAttrs and ddIPAttrs are the log attributes, by dotted path,
+// that identify who made a request and from where.
+var (
+	ddPrincipalAttrs = []string{"usr.id", "usr.name", "usr.email", "user", "userIdentity.arn", "userIdentity.userName"}
+	ddIPAttrs        = []string{"network.client.ip", "client_ip", "ip", "sourceIPAddress"}
+)
+
+// cloudTrailEvent is the part of a CloudTrail record used for correlation.
+type cloudTrailEvent struct {
+	EventID         string    `json:"eventID"`
+	EventTime       time.Time `json:"eventTime"`
+	EventSource     string    `json:"eventSource"`
+	EventName       string    `json:"eventName"`
+	SourceIPAddress string    `json:"sourceIPAddress"`
+	UserIdentity    struct {
+		ARN         string `json:"arn"`
+		UserName    string `json:"userName"`
+		PrincipalID string `json:"principalId"`
+	} `json:"userIdentity"`
+}
+
+// loadCloudTrail reads CloudTrail events from a local file, a directory of
+// files, or an s3://bucket/prefix location. Files may be gzipped and hold
+// either CloudTrail's {"Records": [...]} envelope or one record per line.
+func loadCloudTrail(location string) ([]cloudTrailEvent, error) {
+	if strings.HasPrefix(location, "s3://") {
+		return loadCloudTrailS3(location)
+	}
+
+	info, err := os.Stat(location)
+	if err != nil {
+		return nil, fmt.Errorf("reading CloudTrail events: %w", err)
+	}
+	if !info.IsDir() {
+		b, err := os.ReadFile(location)
+		if err != nil {
+			return nil, fmt.Errorf("reading CloudTrail events: %w", err)
+		}
+		return decodeCloudTrail(location, b)
+	}
+
+	var events []cloudTrailEvent
+	err = filepath.WalkDir(location, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		batch, err := decodeCloudTrail(path, b)
+		if err != nil {
+			return err
+		}
+		events = append(events, batch...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading CloudTrail events: %w", err)
+	}
+	return events, nil
+}
+
+// loadCloudTrailS3 downloads every object under an s3://bucket/prefix
+// location, using the standard AWS credential chain.
+func loadCloudTrailS3(location string) ([]cloudTrailEvent, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", location, err)
+	}
+	bucket, prefix := u.Host, strings.TrimPrefix(u.Path, "/")
+
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	client := s3.NewFromConfig(cfg)
+
+	var events []cloudTrailEvent
+	pages := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{Bucket: &bucket, Prefix: &prefix})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing s3://%s/%s: %w", bucket, prefix, err)
+		}
+		for _, obj := range page.Contents {
+			out, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: &bucket, Key: obj.Key})
+			if err != nil {
+				return nil, fmt.Errorf("downloading s3://%s/%s: %w", bucket, *obj.Key, err)
+			}
+			b, err := io.ReadAll(out.Body)
+			out.Body.Close()
+			if err != nil {
+				return nil, fmt.Errorf("downloading s3://%s/%s: %w", bucket, *obj.Key, err)
+			}
+			batch, err := decodeCloudTrail("s3://"+bucket+"/"+*obj.Key, b)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, batch...)
+		}
+	}
+	return events, nil
+}
+
+// decodeCloudTrail parses one CloudTrail file, gunzipping it if needed.
+func decodeCloudTrail(name string, b []byte) ([]cloudTrailEvent, error) {
+	if len(b) > 2 && b[0] == 0x1f && b[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if b, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	var envelope struct {
+		Records []cloudTrailEvent `json:"Records"`
+	}
+	if err := json.Unmarshal(b, &envelope); err == nil && envelope.Records != nil {
+		return envelope.Records, nil
+	}
+
+	var events []cloudTrailEvent
+	dec := json.NewDecoder(bytes.NewReader(b))
+	for dec.More() {
+		var e cloudTrailEvent
+		if err := dec.Decode(&e); err != nil {
+			return nil, fmt.Errorf("%s: decoding CloudTrail record: %w", name, err)
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// --- Timeline writer ---
+
+// timelineEntry is one row of the combined timeline.
+type timelineEntry struct {
+	Time       time.Time `json:"time"`
+	Source     string    `json:"source"`
+	ID         string    `json:"id"`
+	Principal  string    `json:"principal"`
+	IP         string    `json:"ip"`
+	Event      string    `json:"event"`
+	Matches    []string  `json:"matches"`
+	MatchOn    string    `json:"match_on"`
+	principals map[string]bool
+}
+
+// timelineWriter is a logWriter that merges Datadog logs with CloudTrail
+// events into one chronological timeline. Every entry lists the IDs of the
+// entries from the other source that share an IP or principal within the
+// correlation window. The whole result is held in memory and written by End.
+type timelineWriter struct {
+	bw      *bufio.Writer
+	format  string
+	window  time.Duration
+	logs    []*timelineEntry
+	trail   []*timelineEntry
+	matched int
+
+	excelSafe bool
+}
+
+func newTimelineWriter(bw *bufio.Writer, format string, window time.Duration, events []cloudTrailEvent) *timelineWriter {
+	if window <= 0 {
+		window = defaultCorrelateWindow
+	}
+	w := &timelineWriter{bw: bw, format: format, window: window}
+	for _, e := range events {
+		id := e.UserIdentity
+		event := e.EventName
+		if e.EventSource != "" {
+			event = strings.TrimSuffix(e.EventSource, ".amazonaws.com") + ":" + event
+		}
+		entry := &timelineEntry{
+			Time:       e.EventTime,
+			Source:     "cloudtrail",
+			ID:         e.EventID,
+			Principal:  firstNonEmptyString(id.ARN, id.UserName, id.PrincipalID),
+			IP:         e.SourceIPAddress,
+			Event:      event,
+			principals: principalKeys(id.ARN, id.UserName, id.PrincipalID),
+		}
+		w.trail = append(w.trail, entry)
+	}
+	sort.Slice(w.trail, func(i, j int) bool { return w.trail[i].Time.Before(w.trail[j].Time) })
+	return w
+}
+
+func (w *timelineWriter) Start() {}
+
+func (w *timelineWriter) WriteLog(log datadogV2.Log) error {
+	attrs := log.GetAttributes()
+	custom := attrs.GetAttributes()
+
+	var principals []string
+	for _, path := range ddPrincipalAttrs {
+		if v, ok := lookupAttr(custom, path); ok {
+			principals = append(principals, flattenValue(v))
+		}
+	}
+	var ip string
+	for _, path := range ddIPAttrs {
+		if v, ok := lookupAttr(custom, path); ok {
+			ip = flattenValue(v)
+			break
+		}
+	}
+
+	entry := &timelineEntry{
+		Source:     "datadog",
+		ID:         log.GetId(),
+		Principal:  firstNonEmptyString(principals...),
+		IP:         ip,
+		Event:      attrs.GetService() + ": " + truncateRunes(attrs.GetMessage(), 200),
+		principals: principalKeys(principals...),
+	}
+	if t := attrs.Timestamp; t != nil {
+		entry.Time = *t
+	}
+	w.logs = append(w.logs, entry)
+	return nil
+}
+
+func (w *timelineWriter) FlushPage() error { return nil }
+
+func (w *timelineWriter) End() error {
+	w.correlate()
+
+	entries := append(w.logs, w.trail...)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+
+	var err error
+	switch w.format {
+	case "json", "ndjson":
+		err = w.writeJSON(entries)
+	default:
+		err = w.writeCSV(entries)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "\nTimeline: %d logs, %d CloudTrail events, %d correlated pairs\n", len(w.logs), len(w.trail), w.matched)
+	return nil
+}
+
+// correlate links each log to the CloudTrail events within the window that
+// share its IP or one of its principals.
+func (w *timelineWriter) correlate() {
+	for _, l := range w.logs {
+		lo := sort.Search(len(w.trail), func(i int) bool { return !w.trail[i].Time.Before(l.Time.Add(-w.window)) })
+		for _, t := range w.trail[lo:] {
+			if t.Time.After(l.Time.Add(w.window)) {
+				break
+			}
+			var on []string
+			if l.IP != "" && l.IP == t.IP {
+				on = append(on, "ip")
+			}
+			for p := range l.principals {
+				if t.principals[p] {
+					on = append(on, "principal")
+					break
+				}
+			}
+			if len(on) == 0 {
+				continue
+			}
+			reason := strings.Join(on, "+")
+			l.Matches = append(l.Matches, t.ID)
+			t.Matches = append(t.Matches, l.ID)
+			l.MatchOn = mergeReason(l.MatchOn, reason)
+			t.MatchOn = mergeReason(t.MatchOn, reason)
+			w.matched++
+		}
+	}
+}
+
+func (w *timelineWriter) writeCSV(entries []*timelineEntry) error {
+	cw := csv.NewWriter(w.bw)
+	cw.Write([]string{"time", "source", "id", "principal", "ip", "event", "matches", "match_on"})
+	for _, e := range entries {
+		cw.Write(csvRecord([]string{
+			e.Time.UTC().Format(time.RFC3339Nano), e.Source, e.ID, e.Principal, e.IP, e.Event,
+			strings.Join(e.Matches, ";"), e.MatchOn,
+		}, w.excelSafe))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (w *timelineWriter) writeJSON(entries []*timelineEntry) error {
+	if w.format == "ndjson" {
+		enc := json.NewEncoder(w.bw)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	w.bw.Write(b)
+	_, err = w.bw.WriteString("\n")
+	return err
+}
+
+// principalKeys normalizes identities for comparison. For ARNs the final
+// path segment (user name or role session name) is also a key, so
+// arn:aws:sts::1:assumed-role/Admin/alice matches a log with usr.name alice.
+func principalKeys(values ...string) map[string]bool {
+	keys := make(map[string]bool)
+	for _, v := range values {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v == "" {
+			continue
+		}
+		keys[v] = true
+		if strings.HasPrefix(v, "arn:") {
+			if i := strings.LastIndex(v, "/"); i >= 0 && i < len(v)-1 {
+				keys[v[i+1:]] = true
+			}
+		}
+	}
+	return keys
+}
+
+// mergeReason combines match reasons: an entry matched on IP by one
+// counterpart and on principal by another is reported as ip+principal.
+func mergeReason(current, reason string) string {
+	if current == "" || current == reason {
+		return reason
+	}
+	return "ip+principal"
+}
+
+func firstNonEmptyString(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// truncateRunes shortens s to at most n runes.
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "…"
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+const (
+	// maxTrackedValues caps the distinct values counted per column. Past the
+	// cap the distinct count becomes a lower bound and new values are ignored.
+	maxTrackedValues = 10000
+
+	// topValuesCount is the number of most frequent values reported per column.
+	topValuesCount = 5
+)
+
+// statsWriter wraps another logWriter and profiles every column it sees,
+// writing the profile as JSON to path when the output ends.
+type statsWriter struct {
+	logWriter
+	path    string
+	rows    int
+	columns map[string]*columnStats
+}
+
+func newStatsWriter(inner logWriter, path string) *statsWriter {
+	return &statsWriter{
+		logWriter: inner,
+		path:      path,
+		columns:   make(map[string]*columnStats),
+	}
+}
+
+func (s *statsWriter) WriteLog(log datadogV2.Log) error {
+	s.rows++
+	attrs := log.GetAttributes()
+	customAttrs := attrs.GetAttributes()
+	for _, col := range fixedColumns {
+		if v := columnValue(&attrs, customAttrs, col); v != "" {
+			s.column(col).observe(v)
+		}
+	}
+	for col, v := range customAttrs {
+		if v != nil {
+			s.column(col).observe(v)
+		}
+	}
+	return s.logWriter.WriteLog(log)
+}
+
+func (s *statsWriter) End() error {
+	if err := s.logWriter.End(); err != nil {
+		return err
+	}
+
+	var attrCols []string
+	for col := range s.columns {
+		if !isFixedColumn(col) {
+			attrCols = append(attrCols, col)
+		}
+	}
+	sort.Strings(attrCols)
+
+	profile := columnProfile{Rows: s.rows}
+	for _, col := range append(fixedColumns[:len(fixedColumns):len(fixedColumns)], attrCols...) {
+		profile.Columns = append(profile.Columns, s.column(col).summary(col, s.rows))
+	}
+
+	b, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.path, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing column profile: %w", err)
+	}
+	return nil
+}
+
+func (s *statsWriter) column(name string) *columnStats {
+	c, ok := s.columns[name]
+	if !ok {
+		c = &columnStats{counts: make(map[string]int)}
+		s.columns[name] = c
+	}
+	return c
+}
+
+func isFixedColumn(col string) bool {
+	for _, f := range fixedColumns {
+		if f == col {
+			return true
+		}
+	}
+	return false
+}
+
+// columnStats accumulates the profile of a single column.
+type columnStats struct {
+	nonNull int
+	types   map[string]bool
+
+	numMin, numMax float64
+	hasNum         bool
+	strMin, strMax string
+	hasStr         bool
+
+	counts map[string]int
+	capped bool
+}
+
+func (c *columnStats) observe(v interface{}) {
+	c.nonNull++
+	if c.types == nil {
+		c.types = make(map[string]bool)
+	}
+
+	switch val := v.(type) {
+	case float64:
+		c.types["number"] = true
+		if !c.hasNum || val < c.numMin {
+			c.numMin = val
+		}
+		if !c.hasNum || val > c.numMax {
+			c.numMax = val
+		}
+		c.hasNum = true
+	case bool:
+		c.types["boolean"] = true
+	case string:
+		c.types["string"] = true
+		if !c.hasStr || val < c.strMin {
+			c.strMin = val
+		}
+		if !c.hasStr || val > c.strMax {
+			c.strMax = val
+		}
+		c.hasStr = true
+	default:
+		c.types["object"] = true
+	}
+
+	key := flattenValue(v)
+	if _, seen := c.counts[key]; seen || len(c.counts) < maxTrackedValues {
+		c.counts[key]++
+	} else {
+		c.capped = true
+	}
+}
+
+func (c *columnStats) summary(name string, rows int) columnSummary {
+	s := columnSummary{
+		Name:          name,
+		Type:          "null",
+		Nulls:         rows - c.nonNull,
+		Distinct:      len(c.counts),
+		DistinctExact: !c.capped,
+		TopValues:     []valueCount{},
+	}
+
+	switch len(c.types) {
+	case 0:
+	case 1:
+		for t := range c.types {
+			s.Type = t
+		}
+	default:
+		s.Type = "mixed"
+	}
+
+	// Numeric bounds are only meaningful when every value is a number;
+	// otherwise report lexical bounds of the string values.
+	if s.Type == "number" {
+		s.Min, s.Max = c.numMin, c.numMax
+	} else if c.hasStr {
+		s.Min, s.Max = c.strMin, c.strMax
+	}
+
+	for v, n := range c.counts {
+		s.TopValues = append(s.TopValues, valueCount{Value: v, Count: n})
+	}
+	sort.Slice(s.TopValues, func(i, j int) bool {
+		if s.TopValues[i].Count != s.TopValues[j].Count {
+			return s.TopValues[i].Count > s.TopValues[j].Count
+		}
+		return s.TopValues[i].Value < s.TopValues[j].Value
+	})
+	if len(s.TopValues) > topValuesCount {
+		s.TopValues = s.TopValues[:topValuesCount]
+	}
+	return s
+}
+
+// columnProfile is the JSON document written by statsWriter.
+type columnProfile struct {
+	Rows    int             `json:"rows"`
+	Columns []columnSummary `json:"columns"`
+}
+
+type columnSummary struct {
+	Name          string       `json:"name"`
+	Type          string       `json:"type"`
+	Nulls         int          `json:"nulls"`
+	Distinct      int          `json:"distinct"`
+	DistinctExact bool         `json:"distinct_exact"`
+	Min           interface{}  `json:"min,omitempty"`
+	Max           interface{}  `json:"max,omitempty"`
+	TopValues     []valueCount `json:"top_values"`
+}
+
+type valueCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
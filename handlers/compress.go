@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// OutputCompression returns the codec an output is written with: codec
+// itself when set (gzip or zstd), otherwise gzip for a path ending in .gz,
+// zstd for .zst, and "" for no compression.
+func OutputCompression(path, codec string) (string, error) {
+	switch codec {
+	case "gzip", "zstd":
+		return codec, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unsupported compression %q (supported: gzip, zstd)", codec)
+	}
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		return "gzip", nil
+	case strings.HasSuffix(path, ".zst"):
+		return "zstd", nil
+	}
+	return "", nil
+}
+
+// compressor is the part of gzip.Writer and zstd.Encoder used here.
+type compressor interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+// compressWriter compresses what is written to it into out. Flush pushes
+// everything written so far through the compressor, so the output holds
+// complete, decompressible data up to that point. Close ends the compressed
+// stream and then closes out; closing twice is a no-op.
+type compressWriter struct {
+	compressor
+	out    io.WriteCloser
+	closed bool
+}
+
+// compressOutput wraps out in codec, or returns it as is when codec is "".
+func compressOutput(out io.WriteCloser, codec string) (io.WriteCloser, error) {
+	var c compressor
+	switch codec {
+	case "":
+		return out, nil
+	case "gzip":
+		c = gzip.NewWriter(out)
+	case "zstd":
+		enc, err := zstd.NewWriter(out)
+		if err != nil {
+			return nil, fmt.Errorf("creating zstd writer: %w", err)
+		}
+		c = enc
+	default:
+		return nil, fmt.Errorf("unsupported compression %q (supported: gzip, zstd)", codec)
+	}
+	return &compressWriter{compressor: c, out: out}, nil
+}
+
+func (w *compressWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	err := w.compressor.Close()
+	if cerr := w.out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// flushOutput flushes the compressor of out, if it has one. bufio.Writer
+// flushes only reach the compressor, which keeps its own buffer.
+func flushOutput(out io.Writer) error {
+	if c, ok := out.(*compressWriter); ok {
+		return c.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// CountOptions configures a Count.
+type CountOptions struct {
+	Query string
+	From  string
+	To    string
+
+	// Indexes, when set, restricts the count to these log indexes.
+	Indexes []string
+}
+
+// Count prints the number of logs matching opts.Query in the time range,
+// from a single aggregation request; no events are downloaded.
+func (h *DDHandler) Count(opts CountOptions) error {
+	total, err := h.CountLogs(context.Background(), opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, total)
+	return nil
+}
+
+// CountLogs returns the number of logs matching opts, for serving counts to
+// other programs; cancelling ctx abandons the request.
+func (h *DDHandler) CountLogs(ctx context.Context, opts CountOptions) (int64, error) {
+	return h.countLogs(h.withAPIKeys(ctx), h.logsAPI(), opts.Query, toDatadogTime(opts.From), toDatadogTime(opts.To), opts.Indexes)
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// curlCommand renders a curl invocation equivalent to the first ListLogs
+// request for opts. Keys are referenced as $DD_API_KEY / $DD_APP_KEY rather
+// than embedded, so the command can be pasted into tickets and replayed.
+func (h *DDHandler) curlCommand(opts QueryOptions) (string, error) {
+	body, err := json.Marshal(listRequest(opts, nil))
+	if err != nil {
+		return "", fmt.Errorf("encoding request body: %w", err)
+	}
+
+	url := fmt.Sprintf("https://api.%s/api/v2/logs/events/search", h.Site)
+	lines := []string{
+		"curl -X POST " + shellQuote(url),
+		"-H 'Content-Type: application/json'",
+		`-H "DD-API-KEY: ${DD_API_KEY}"`,
+		`-H "DD-APPLICATION-KEY: ${DD_APP_KEY}"`,
+		"-d " + shellQuote(string(body)),
+	}
+	return strings.Join(lines, " \\\n  "), nil
+}
+
+// shellQuote wraps s in single quotes for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
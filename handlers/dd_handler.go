@@ -2,15 +2,22 @@ package handlers
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
+	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
@@ -19,204 +26,1133 @@ import (
 
 const maxLogsPerRequest int32 = 1000
 
+// ErrInterrupted is returned by an export stopped by Ctrl-C or SIGTERM, after
+// the logs fetched so far have been written and the output closed out.
+var ErrInterrupted = errors.New("export interrupted")
+
 type DDHandler struct {
 	Site   string
 	ApiKey string
 	AppKey string
+
+	// MaxRetries is how many times a ListLogs call failing with 429, 5xx or
+	// a network error is retried before giving up.
+	MaxRetries int
+
+	// FallbackSites are sites the org is replicated to. When a log query
+	// still fails with 5xx after MaxRetries, it is retried against the next
+	// of them, which then replaces Site for every query started afterwards.
+	// Pages continuing a cursor stay on the site that issued it.
+	FallbackSites []string
+
+	// siteMu guards Site and FallbackSites, which fail over while parallel
+	// shards are being fetched.
+	siteMu sync.Mutex
+
+	// faults, when set by InjectFaults, is the transport of log API calls.
+	faults *faultTransport
 }
 
 func NewDDHandler(site, apiKey, appKey string) *DDHandler {
 	return &DDHandler{
-		Site:   site,
-		ApiKey: apiKey,
-		AppKey: appKey,
+		Site:       site,
+		ApiKey:     apiKey,
+		AppKey:     appKey,
+		MaxRetries: DefaultMaxRetries,
 	}
 }
 
-func toDatadogTime(value string) string {
-	if value == "now" {
-		return "now"
-	}
-	return "now-" + value
-}
-
 // fetchResult is sent from the fetch goroutine to the write goroutine.
 type fetchResult struct {
 	logs []datadogV2.Log
+	// raw holds the same events exactly as the API returned them; it is only
+	// populated for raw output.
+	raw  []json.RawMessage
 	page int
+
+	// next is where the export continues after this page.
+	next exportPosition
+}
+
+// QueryOptions configures a search export.
+type QueryOptions struct {
+	Query      string
+	From       string
+	To         string
+	OutputFile string
+	Format     string
+
+	// ColumnStatsFile, when set, receives a JSON profile of every column
+	// seen in the results (null counts, min/max, distinct values, top values).
+	ColumnStatsFile string
+
+	// StableJSON canonicalizes JSON output so object keys are always sorted,
+	// making exports diffable.
+	StableJSON bool
+
+	// Raw writes each event byte-for-byte as the API returned it, bypassing
+	// the SDK structs. Only valid with the json format.
+	Raw bool
+
+	// PrintCurl prints an equivalent curl command for the first page to
+	// stderr before fetching.
+	PrintCurl bool
+
+	// Explain prints how each option was translated into the API request,
+	// including the resolved time range, to stderr before fetching.
+	Explain bool
+
+	// Strict makes any lossy write fatal: events the SDK could not parse,
+	// invalid UTF-8, or (for CSV) attributes missing from the frozen header.
+	// The offending event is appended to RejectFile. Without Strict, a
+	// RejectFile collects logs a transform plugin fails on instead, and
+	// the export carries on.
+	Strict     bool
+	RejectFile string
+
+	// RouteBy, when set, sends each log to the file RouteMap assigns to its
+	// value of this field ("@org_id" for an attribute, or a fixed column
+	// such as "service") instead of OutputFile.
+	RouteBy  string
+	RouteMap *RouteMap
+
+	// SplitBy, when set, writes one file per distinct value of this field
+	// (same syntax as RouteBy) into the OutputFile directory, named after
+	// the value: web.csv, api.csv, ...
+	SplitBy string
+
+	// TagColumns are tag keys (e.g. pod_name) exported as their own CSV and
+	// Parquet columns, after the fixed columns, holding the tag's value.
+	TagColumns []string
+
+	// CorrelateCloudTrail, when set, is a file, directory or s3:// location
+	// of CloudTrail events. The output becomes a combined timeline of logs
+	// and events, each linked to counterparts sharing an IP or principal
+	// within CorrelateWindow.
+	CorrelateCloudTrail string
+	CorrelateWindow     time.Duration
+
+	// IOCFile, when set, is a list of IPs, CIDRs and domains. Every log gets
+	// an ioc_match attribute naming the attributes that matched; with
+	// IOCOnly, logs that match nothing are dropped.
+	IOCFile string
+	IOCOnly bool
+
+	// Grep and GrepV, when set, keep only the logs whose message matches
+	// Grep and drop those whose message matches GrepV, before any other
+	// per-log processing.
+	Grep  *regexp.Regexp
+	GrepV *regexp.Regexp
+
+	// Transforms are WebAssembly plugins every log passes through, in
+	// order, after Classification and before indicator matching, column
+	// stats and truncation; see transform.go.
+	Transforms []string
+
+	// Classification, when set, assigns columns a sensitivity level; any
+	// column above MaxClassification is removed before the log reaches the
+	// output, column stats or indicator matching.
+	Classification    *Classification
+	MaxClassification ClassificationLevel
+
+	// Redact, for StreamLogs and StreamOutput, masks every match of these
+	// patterns in the message, the tags and string attributes with
+	// "[REDACTED]" before any other per-log processing. Redacted, when set,
+	// receives how many values were masked once the export ends.
+	Redact   []*regexp.Regexp
+	Redacted *int
+
+	// Checkpoint, when set, is a file recording the pagination cursor, page
+	// and output size after every page. With Resume, an export interrupted
+	// earlier continues from it, appending to OutputFile.
+	Checkpoint string
+	Resume     bool
+
+	// ResumeToken continues an export from the token printed when an
+	// earlier run of it failed, without a checkpoint file.
+	ResumeToken string
+
+	// Indexes, when set, restricts the search to these log indexes instead
+	// of all of them.
+	Indexes []string
+
+	// Limit, when positive, stops the export once this many logs have been
+	// fetched; the last page is requested no larger than needed.
+	Limit int
+
+	// Descending fetches the newest logs first instead of the oldest, so a
+	// Limit keeps the most recent matches.
+	Descending bool
+
+	// Parallel, when above 1, splits the time range into ShardSize windows
+	// (DefaultShardSize when zero) and fetches that many at once, each with
+	// its own cursor. Output order is unchanged. Not used with Limit or a
+	// resumed checkpoint.
+	Parallel  int
+	ShardSize time.Duration
+
+	// Columns, when set, are the exact CSV columns in order: fixed columns
+	// by name, custom attributes as @path, looked up through nested
+	// objects, and tags as tag:key. Attribute discovery is skipped and the
+	// header is written before the first log.
+	Columns []string
+
+	// Locale, when set, formats CSV output for spreadsheets of that locale:
+	// its field delimiter, decimal separator and date layout.
+	Locale *CSVLocale
+
+	// FlattenDepth, when positive, turns nested attribute objects into
+	// dotted CSV columns (http.status_code) down to this many levels,
+	// instead of one JSON cell per top-level attribute.
+	FlattenDepth int
+
+	// EmitSchema, when set, receives the CSV header as a schema file once
+	// the export finishes, for pinning later exports with --schema.
+	EmitSchema string
+
+	// Excel writes CSV for opening in Excel by double-click: a UTF-8 byte
+	// order mark, CRLF line endings, and long or zero-padded numeric IDs
+	// written so Excel keeps every digit.
+	Excel bool
+
+	// LongFormat writes CSV with one row per log ID, column and non-empty
+	// value instead of one column per attribute.
+	LongFormat bool
+
+	// AutoPrune holds CSV output back until the export ends and drops the
+	// columns that are empty, or hold the same value, in every row. The
+	// pruned columns are listed on stderr.
+	AutoPrune bool
+
+	// ExcelSafe prefixes CSV cells that a spreadsheet would run as a formula
+	// (starting with =, +, -, @) with a single quote and writes control
+	// characters as \u escapes, see csvCell.
+	ExcelSafe bool
+
+	// MaxMessageLen and MaxCellLen, when positive, cut the message and every
+	// string attribute to that many characters. Cuts are counted per column
+	// in the summary and, with TruncationLog, listed there by log ID.
+	MaxMessageLen int
+	MaxCellLen    int
+	TruncationLog string
+
+	// MaxColWidth, when positive, cuts every cell of a Markdown table, or
+	// every column but message of a terminal table, to this many
+	// characters. TableWidth is the terminal width a table is laid out for
+	// (DefaultTableWidth when zero).
+	MaxColWidth int
+	TableWidth  int
+
+	// MaxTokens is the budget of --format llm output, in tokens as
+	// Tokenizer counts them (DefaultMaxTokens and cl100k when unset).
+	MaxTokens int
+	Tokenizer *Tokenizer
+
+	// TimeFormat, when set, is how the text formats and templates write
+	// timestamps instead of RFC 3339; it takes precedence over Locale's
+	// date layout.
+	TimeFormat *TimeFormat
+
+	// TimeZone, when set, is the zone timestamps are rendered in by the
+	// text formats, the spreadsheet and templates, instead of UTC.
+	TimeZone *time.Location
+
+	// Template renders each log with --format template; see templateLog
+	// for what it can use.
+	Template *template.Template
+
+	// RotateSize and RotateRows, when positive, split the export into
+	// numbered files next to OutputFile, starting a new one once the
+	// current file holds that many bytes (before compression) or logs.
+	RotateSize int64
+	RotateRows int
+
+	// Dataset makes OutputFile a Parquet dataset directory the export is
+	// added to as a new part file. The part keeps the columns of the parts
+	// already there, in their order and with their types, and adds any new
+	// ones as nullable columns after them.
+	Dataset bool
+
+	// DuckDBTable is the table a duckdb export replaces in the OutputFile
+	// database (DefaultDuckDBTable when empty).
+	DuckDBTable string
+
+	// TypeConflicts is how Parquet output handles an attribute with values
+	// of several types: TypeConflictWiden (the default when empty),
+	// TypeConflictSplit or TypeConflictError.
+	TypeConflicts string
+
+	// Compress is the codec output is compressed with, gzip or zstd; when
+	// empty, OutputFile's extension (.gz, .zst) decides. Route files are
+	// compressed by their own extensions.
+	Compress string
+
+	// SchemaCacheDir, when set, keeps the CSV attribute columns of each
+	// query there: an export starts from the columns cached for its query
+	// and saves its header back, so repeated exports of a query get the same
+	// header even when their first pages differ. RefreshSchema ignores what
+	// is cached and replaces it.
+	SchemaCacheDir string
+	RefreshSchema  bool
+
+	// SummarizeCmd, when set, is a shell command run once the export ends
+	// with the output, uncompressed, on its stdin; what it prints is added
+	// to the summary on stderr. Text formats only, one output.
+	SummarizeCmd string
+
+	// Quiet keeps the progress line and the summary after the export off
+	// stderr (--quiet). Otherwise the progress line is redrawn in place on
+	// a terminal and printed every few seconds anywhere else.
+	Quiet bool
+
+	// ProgressJSON replaces the progress line and the closing "Done" line
+	// with one JSON object per line on stderr (see progressEvent), for
+	// wrappers tracking the export.
+	ProgressJSON bool
+
+	// DiscoverSchema samples the time range before streaming so the CSV
+	// header includes attributes that first appear after the first page.
+	// DiscoverSample is how many logs are sampled, spread across the range
+	// (DefaultDiscoverSample when zero).
+	DiscoverSchema bool
+	DiscoverSample int
+
+	// Watch, when set, keeps the export running after the time range is
+	// fetched: every Watch it fetches the logs that arrived since the
+	// newest one written and appends them, until interrupted (see watch).
+	Watch time.Duration
+
+	// written, when set, receives the number of logs written, for the
+	// summary of a batch.
+	written *int
 }
 
-func (h *DDHandler) Query(query, from, to, outputFile, format string) error {
-	fromStr := toDatadogTime(from)
-	toStr := toDatadogTime(to)
+func (h *DDHandler) Query(opts QueryOptions) error {
+	var out io.WriteCloser
+	var cp *exportCheckpoint
+	var err error
+	rotating := opts.RotateSize > 0 || opts.RotateRows > 0
+	switch {
+	case opts.Checkpoint != "":
+		cp, out, err = openCheckpoint(&opts)
+	case opts.ResumeToken != "":
+		cp, out, err = openResumeToken(&opts)
+	case tokenResumable(opts):
+		// Tracked like a checkpoint but kept in memory, for the resume
+		// token printed if the export fails.
+		cp = newExportCheckpoint(&opts)
+		out, err = createOutput(opts.OutputFile, "")
+	case rotating || opts.SplitBy != "" || opts.Format == "duckdb" || opts.Dataset:
+		// The rotating, routing, DuckDB or dataset writer opens its files
+		// itself.
+		out = nopWriteCloser{io.Discard}
+	default:
+		out, err = createOutput(opts.OutputFile, opts.Compress)
+	}
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	counter := &countingWriter{w: out}
+	if cp != nil {
+		counter.n = cp.Bytes
+	}
+	var spool *summarySpool
+	var dest io.Writer = counter
+	if opts.SummarizeCmd != "" {
+		if spool, err = newSummarySpool(); err != nil {
+			return err
+		}
+		defer spool.cleanup()
+		dest = io.MultiWriter(counter, spool)
+	}
+	bw := bufio.NewWriterSize(dest, 256*1024)
+	defer bw.Flush()
+
+	if opts.Explain {
+		h.explain(os.Stderr, opts)
+	}
+	if opts.PrintCurl {
+		curl, err := h.curlCommand(opts)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%s\n\n", curl)
+	}
 
-	ctx := context.Background()
+	var writer logWriter
+	var rot *rotatingWriter
+	switch {
+	case opts.CorrelateCloudTrail != "":
+		events, err := loadCloudTrail(opts.CorrelateCloudTrail)
+		if err != nil {
+			return err
+		}
+		if !opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Loaded %d CloudTrail events from %s\n", len(events), opts.CorrelateCloudTrail)
+		}
+		timeline := newTimelineWriter(bw, opts.Format, opts.CorrelateWindow, events)
+		timeline.excelSafe = opts.ExcelSafe
+		writer = timeline
+	case opts.RouteBy != "" || opts.SplitBy != "":
+		writer = newRoutingWriter(opts)
+	case opts.Format == "duckdb":
+		dw, err := newDuckDBWriter(opts)
+		if err != nil {
+			return err
+		}
+		defer dw.cleanup()
+		writer = dw
+	case opts.Dataset:
+		dw, err := newDatasetWriter(opts)
+		if err != nil {
+			return err
+		}
+		defer dw.cleanup()
+		writer = dw
+	case rotating:
+		if rot, err = newRotatingWriter(opts); err != nil {
+			return err
+		}
+		writer = rot
+		if opts.Raw {
+			writer = &rawRotatingWriter{rot}
+		}
+	case opts.AutoPrune:
+		// Rows are spooled until the export ends, when every column's
+		// values are known.
+		pruner, err := newCSVPruner(bw)
+		if err != nil {
+			return err
+		}
+		defer pruner.cleanup()
+		c := newLogWriter(opts, pruner.spool).(*csvWriter)
+		c.prune = pruner
+		writer = c
+	default:
+		writer = newLogWriter(opts, bw)
+	}
+	base := writer
+	if rot != nil {
+		base = rot.current
+	}
+	var resume *exportPosition
+	if cp != nil && cp.resumed() {
+		resumeWriter(base, cp)
+		resume = &cp.Position
+	}
+	if opts.DiscoverSchema && resume == nil {
+		schema, err := h.discoverSchema(opts)
+		if err != nil {
+			return err
+		}
+		seedColumns(base, schema.attributes(opts))
+		seedTypes(base, schema, opts)
+	}
+	var schemaCache string
+	if c, ok := base.(*csvWriter); ok && c.columns == nil && opts.SchemaCacheDir != "" && resume == nil {
+		schemaCache = schemaCachePath(opts.SchemaCacheDir, opts)
+		if !opts.RefreshSchema {
+			attrs, err := cachedAttributes(schemaCache)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: ignoring schema cache: %v\n", err)
+			}
+			var allowed []string
+			for _, a := range attrs {
+				if opts.Classification == nil || opts.Classification.allows(a, opts.MaxClassification) {
+					allowed = append(allowed, a)
+				}
+			}
+			if len(allowed) > 0 {
+				if !opts.Quiet {
+					fmt.Fprintf(os.Stderr, "Schema cache: starting from %d column(s) seen in earlier exports of this query (--refresh-schema to rediscover)\n", len(allowed))
+				}
+				seedColumns(base, allowed)
+			}
+		}
+	}
+	// Stats wrap the output directly, so the profile describes exactly the
+	// logs written: after --ioc-only drops any and with ioc_match added.
+	if opts.ColumnStatsFile != "" {
+		writer = newStatsWriter(writer, opts.ColumnStatsFile)
+	}
+	if opts.IOCFile != "" {
+		iocs, err := loadIOCs(opts.IOCFile)
+		if err != nil {
+			return err
+		}
+		if !opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Loaded %d indicators from %s\n", iocs.size(), opts.IOCFile)
+		}
+		iw := newIOCWriter(writer, iocs, opts.IOCOnly)
+		iw.quiet = opts.Quiet
+		writer = iw
+	}
+	if opts.MaxMessageLen > 0 || opts.MaxCellLen > 0 {
+		tw, err := newTruncateWriter(writer, opts.MaxMessageLen, opts.MaxCellLen, opts.TruncationLog)
+		if err != nil {
+			return err
+		}
+		tw.quiet = opts.Quiet
+		writer = tw
+	}
+	if len(opts.Transforms) > 0 {
+		transforms, err := loadTransforms(opts.Transforms)
+		if err != nil {
+			return err
+		}
+		defer transforms.close()
+		tw := newTransformWriter(writer, transforms)
+		tw.quiet = opts.Quiet
+		if !opts.Strict {
+			tw.rejectFile = opts.RejectFile
+		}
+		writer = tw
+	}
+	if opts.TimeZone != nil {
+		writer = newZoneWriter(writer, opts.TimeZone)
+	}
+	if opts.Classification != nil {
+		writer = newClassifiedWriter(writer, opts.Classification, opts.MaxClassification)
+	}
+	if opts.Grep != nil || opts.GrepV != nil {
+		gw := newGrepWriter(writer, opts.Grep, opts.GrepV)
+		gw.quiet = opts.Quiet
+		writer = gw
+	}
+
+	afterPage := func(next exportPosition) error {
+		if err := bw.Flush(); err != nil {
+			return fmt.Errorf("flushing output: %w", err)
+		}
+		if err := flushOutput(out); err != nil {
+			return fmt.Errorf("flushing output: %w", err)
+		}
+		if cp == nil {
+			return nil
+		}
+		cp.Position = next
+		cp.Bytes = counter.n
+		cp.Headers = csvHeaders(base)
+		cp.Emitted = emittedLogs(base)
+		if opts.Checkpoint == "" {
+			return nil
+		}
+		return saveExportCheckpoint(opts.Checkpoint, cp)
+	}
+
+	if err := h.stream(h.apiContext(), opts, writer, resume, afterPage); err != nil {
+		if cp != nil && opts.Checkpoint == "" {
+			printResumeToken(cp)
+		}
+		var rej *rejectError
+		if errors.As(err, &rej) && opts.RejectFile != "" {
+			if werr := writeReject(opts.RejectFile, rej); werr != nil {
+				return fmt.Errorf("%w (and saving it failed: %v)", err, werr)
+			}
+			return fmt.Errorf("%w (event written to %s)", err, opts.RejectFile)
+		}
+		return err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("flushing output: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("closing output: %w", err)
+	}
+	if rot != nil {
+		// The last file has every column the earlier ones carried over.
+		base = rot.current
+	}
+	if schemaCache != "" {
+		if err := cacheSchema(schemaCache, base); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: saving schema cache: %v\n", err)
+		}
+	}
+	if opts.EmitSchema != "" {
+		if err := writeCSVSchema(opts.EmitSchema, base); err != nil {
+			return err
+		}
+		if !opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Schema written to %s\n", opts.EmitSchema)
+		}
+	}
+	if opts.OutputFile != "" && !opts.Quiet && !rotating && opts.SplitBy == "" && opts.Format != "duckdb" && !opts.Dataset {
+		fmt.Fprintf(os.Stderr, "Output written to %s\n", opts.OutputFile)
+	}
+	if opts.ColumnStatsFile != "" && !opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Column profile written to %s\n", opts.ColumnStatsFile)
+	}
+	if opts.Checkpoint != "" {
+		if err := os.Remove(opts.Checkpoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("removing checkpoint: %w", err)
+		}
+	}
+	if spool != nil {
+		return spool.summarize(opts.SummarizeCmd, opts)
+	}
+	return nil
+}
+
+// apiContext returns a context carrying the handler's credentials and site.
+func (h *DDHandler) apiContext() context.Context {
+	return h.withAPIKeys(context.Background())
+}
+
+// withAPIKeys returns ctx carrying h's credentials and site for API calls.
+func (h *DDHandler) withAPIKeys(ctx context.Context) context.Context {
 	ctx = context.WithValue(ctx, datadog.ContextAPIKeys, map[string]datadog.APIKey{
 		"apiKeyAuth": {Key: h.ApiKey},
 		"appKeyAuth": {Key: h.AppKey},
 	})
-	ctx = context.WithValue(ctx, datadog.ContextServerVariables, map[string]string{
-		"site": h.Site,
-	})
+	return withSite(ctx, h.currentSite())
+}
 
+func (h *DDHandler) logsAPI() *datadogV2.LogsApi {
 	configuration := datadog.NewConfiguration()
+	if h.faults != nil {
+		configuration.HTTPClient = &http.Client{Transport: h.faults}
+	}
 	apiClient := datadog.NewAPIClient(configuration)
-	api := datadogV2.NewLogsApi(apiClient)
+	return datadogV2.NewLogsApi(apiClient)
+}
 
-	storageTier := datadogV2.LOGSSTORAGETIER_FLEX
+// stream runs the concurrent fetch/write pipeline: pages are fetched on a
+// background goroutine and every log is handed to writer on the calling
+// goroutine. afterPage is called after each page, with the position the
+// export would continue from, so output reaches its destination (and a
+// checkpoint can be saved) page-by-page. resume, when non-nil, is a position
+// saved by an earlier run to continue from. Cancelling ctx, which must
+// carry the API keys (see withAPIKeys), stops the export like Ctrl-C.
+//
+// With opts.Parallel, pages come from concurrently fetched shards of the
+// time range instead, merged back into time order (see fetchShards).
+//
+// Ctrl-C (SIGINT) or SIGTERM stops fetching: pages already fetched are
+// written, the output is closed out so it stays valid, and ErrInterrupted is
+// returned after printing where the export stopped.
+func (h *DDHandler) stream(ctx context.Context, opts QueryOptions, writer logWriter, resume *exportPosition, afterPage func(next exportPosition) error) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// fetchCtx also ends when the writer is full.
+	fetchCtx, stopFetch := context.WithCancel(ctx)
+	defer stopFetch()
+	api := h.logsAPI()
 
 	// Channel to send fetched pages to the writer goroutine.
 	// Buffer of 2 so the fetcher can stay one page ahead of the writer.
 	pageCh := make(chan fetchResult, 2)
 
-	// Shared state for progress reporting
-	var mu sync.Mutex
-	totalLogs := 0
-	lastPage := 0
 	start := time.Now()
+	progress := &fetchProgress{start: start, quiet: opts.Quiet, json: opts.ProgressJSON}
 
 	// Fetch error from the fetcher goroutine
 	var fetchErr error
 
-	// --- Fetcher goroutine: fetches pages sequentially, sends to channel ---
-	go func() {
-		defer close(pageCh)
-
-		var cursor *string
-		page := 1
-
-		for {
-			body := datadogV2.LogsListRequest{
-				Filter: &datadogV2.LogsQueryFilter{
-					Query:       datadog.PtrString(query),
-					From:        datadog.PtrString(fromStr),
-					To:          datadog.PtrString(toStr),
-					StorageTier: &storageTier,
-				},
-				Sort: datadogV2.LOGSSORT_TIMESTAMP_ASCENDING.Ptr(),
-				Page: &datadogV2.LogsListRequestPage{
-					Limit: datadog.PtrInt32(maxLogsPerRequest),
-				},
-			}
-			if cursor != nil {
-				body.Page.Cursor = cursor
+	written := 0
+	var fetchers []*fetcher
+	if opts.Parallel > 1 && resume == nil {
+		var err error
+		if fetchers, err = h.fetchShards(fetchCtx, api, opts, start, progress, pageCh, &fetchErr); err != nil {
+			return err
+		}
+	} else {
+		window := logWindow{from: toDatadogTime(opts.From), to: toDatadogTime(opts.To)}
+		f := newFetcher(h, api, opts, window, newWindowNarrower(opts.From, opts.To, start), progress)
+		if resume != nil {
+			f.windows = resume.logWindows()
+			if resume.Cursor != "" {
+				f.cursor = &resume.Cursor
 			}
+			f.page = resume.Page
+			f.fetched = resume.Written
+			progress.pages = resume.Page - 1
+			written = resume.Written
+		}
+		fetchers = []*fetcher{f}
 
-			resp, r, err := api.ListLogs(ctx, *datadogV2.NewListLogsOptionalParameters().WithBody(body))
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "\nFull HTTP response: %v\n", r)
-				fetchErr = fmt.Errorf("calling LogsApi.ListLogs: %w", err)
+		// --- Fetcher goroutine: fetches pages sequentially, sends to channel ---
+		go func() {
+			defer close(pageCh)
+			emit := func(r fetchResult) bool {
+				select {
+				case pageCh <- r:
+					return true
+				case <-fetchCtx.Done():
+					return false
+				}
+			}
+			if opts.Watch > 0 {
+				fetchErr = h.watch(fetchCtx, api, opts, f, progress, emit)
 				return
 			}
+			fetchErr = f.run(fetchCtx, emit)
+		}()
+	}
 
-			logs := resp.GetData()
+	// --- Writer: runs on the calling goroutine, reads from channel ---
+	writer.Start()
 
-			pageCh <- fetchResult{logs: logs, page: page}
+	rw, raw := writer.(rawLogWriter)
 
-			// Update progress
-			mu.Lock()
-			totalLogs += len(logs)
-			lastPage = page
-			elapsed := time.Since(start).Seconds()
-			rate := float64(totalLogs) / elapsed
-			fmt.Fprintf(os.Stderr, "\rFetching... page %d | %d logs | %.1fs | %.0f logs/sec", lastPage, totalLogs, elapsed, rate)
-			mu.Unlock()
+	// Where the export stands after the last page written.
+	var position exportPosition
+	if resume != nil {
+		position = *resume
+	}
 
-			// Check for next page
-			meta, ok := resp.GetMetaOk()
-			if !ok {
-				return
-			}
-			respPage, ok := meta.GetPageOk()
-			if !ok {
-				return
-			}
-			after, ok := respPage.GetAfterOk()
-			if !ok || *after == "" {
-				return
+	full := false
+	for result := range pageCh {
+		if raw {
+			for _, event := range result.raw {
+				if err := rw.WriteRaw(event); err != nil {
+					return fmt.Errorf("writing log: %w", err)
+				}
 			}
-			if int32(len(logs)) < maxLogsPerRequest {
-				return
+			written += len(result.raw)
+		} else {
+			for _, log := range result.logs {
+				if err := writer.WriteLog(log); err != nil {
+					return fmt.Errorf("writing log: %w", err)
+				}
 			}
-			cursor = after
-			page++
+			written += len(result.logs)
 		}
-	}()
 
-	// --- Writer: runs on main goroutine, reads from channel ---
-	var dest io.Writer = os.Stdout
-	if outputFile != "" {
-		f, err := os.Create(outputFile)
-		if err != nil {
-			return fmt.Errorf("creating output file: %w", err)
+		// Page boundary: CSV writes its header after the first page,
+		// Parquet may close a row group.
+		if err := writer.FlushPage(); errors.Is(err, errOutputFull) {
+			full = true
+		} else if err != nil {
+			return fmt.Errorf("flushing page %d: %w", result.page, err)
+		}
+
+		result.next.Written = written
+		if err := afterPage(result.next); err != nil {
+			return err
+		}
+		position = result.next
+		if full {
+			break
 		}
-		defer f.Close()
-		dest = f
 	}
-	bw := bufio.NewWriterSize(dest, 256*1024)
-	defer bw.Flush()
+	if full {
+		stopFetch()
+		for range pageCh {
+		}
+		fetchErr = nil
+	}
+	if opts.written != nil {
+		*opts.written = written
+	}
 
-	var writer logWriter
-	if format == "json" {
-		writer = newJSONWriter(bw)
+	// Check if fetcher hit an error
+	if fetchErr != nil {
+		return fetchErr
+	}
+
+	if err := writer.End(); err != nil {
+		return fmt.Errorf("finishing output: %w", err)
+	}
+
+	if ctx.Err() != nil && opts.Watch > 0 {
+		// Ctrl-C is how watching ends.
+		if !opts.Quiet {
+			fmt.Fprintf(os.Stderr, "\nStopped watching after %d logs\n", written)
+		}
+		return nil
+	}
+	if ctx.Err() != nil && opts.Quiet {
+		return ErrInterrupted
+	}
+	if ctx.Err() != nil {
+		fmt.Fprintf(os.Stderr, "\nInterrupted after %d logs; output closed cleanly\n", written)
+		if position.Cursor != "" {
+			fmt.Fprintf(os.Stderr, "Resume cursor: %s\n", position.Cursor)
+		}
+		if opts.Checkpoint != "" {
+			fmt.Fprintf(os.Stderr, "Re-run with --resume to continue from checkpoint %s\n", opts.Checkpoint)
+		}
+		return ErrInterrupted
+	}
+
+	if opts.Quiet {
+		return nil
+	}
+	progress.mu.Lock()
+	elapsed := time.Since(start).Seconds()
+	if progress.json {
+		progress.event("done", "", 0)
 	} else {
-		writer = newCSVWriter(bw)
+		fmt.Fprintf(os.Stderr, lineStart()+"Done: %d logs retrieved in %.1fs across %d page(s)\n", progress.logs, elapsed, progress.pages)
+	}
+	progress.mu.Unlock()
+	if fetchers[0].limitReached {
+		fmt.Fprintf(os.Stderr, "Stopped at --limit %d\n", opts.Limit)
+	}
+	splits := 0
+	var smallest time.Duration
+	for _, f := range fetchers {
+		splits += f.narrower.splits
+		if n := f.narrower.smallest; n > 0 && (smallest == 0 || n < smallest) {
+			smallest = n
+		}
+	}
+	if splits > 0 {
+		fmt.Fprintf(os.Stderr, "Timeouts: split the time range %d time(s), down to %s windows; logs on split points were deduplicated\n", splits, smallest.Round(time.Second))
 	}
 
-	writer.Start()
+	return nil
+}
 
-	firstPage := true
-	for result := range pageCh {
-		for _, log := range result.logs {
-			if err := writer.WriteLog(log); err != nil {
-				return fmt.Errorf("writing log: %w", err)
+// fetcher follows the pagination of a list of windows in order, one page
+// at a time, splitting windows whose requests keep timing out.
+type fetcher struct {
+	h        *DDHandler
+	api      *datadogV2.LogsApi
+	opts     QueryOptions
+	progress *fetchProgress
+
+	windows  []logWindow
+	cursor   *string
+	site     string
+	page     int
+	fetched  int
+	narrower *windowNarrower
+
+	// seen, when watching, drops logs an earlier fetch already returned.
+	seen *seenSet
+
+	limitReached bool
+}
+
+// newFetcher returns a fetcher for w. narrower resolves w when it first
+// needs splitting.
+func newFetcher(h *DDHandler, api *datadogV2.LogsApi, opts QueryOptions, w logWindow, narrower *windowNarrower, progress *fetchProgress) *fetcher {
+	narrower.descending = opts.Descending
+	return &fetcher{
+		h:        h,
+		api:      api,
+		opts:     opts,
+		progress: progress,
+		windows:  []logWindow{w},
+		page:     1,
+		narrower: narrower,
+	}
+}
+
+// run fetches every page, handing each to emit, until the windows are
+// exhausted, the limit is reached, ctx is cancelled or emit returns false.
+func (f *fetcher) run(ctx context.Context, emit func(fetchResult) bool) error {
+	opts := f.opts
+
+	// The last timestamp fetched from the current window (the newest, or
+	// the oldest when descending), and the IDs of the logs at exactly
+	// that time, for resuming after a split.
+	var last time.Time
+	var lastIDs []string
+
+	for len(f.windows) > 0 && ctx.Err() == nil {
+		if opts.Limit > 0 && f.fetched >= opts.Limit {
+			f.limitReached = true
+			return nil
+		}
+		body := newListRequest(opts.Query, f.windows[0].from, f.windows[0].to, f.cursor)
+		body.Filter.Indexes = opts.Indexes
+		if opts.Descending {
+			body.Sort = datadogV2.LOGSSORT_TIMESTAMP_DESCENDING.Ptr()
+		}
+		pageSize := maxLogsPerRequest
+		if opts.Limit > 0 {
+			pageSize = min(pageSize, int32(opts.Limit-f.fetched))
+			body.Page.Limit = &pageSize
+		}
+
+		resp, r, err := f.h.listLogs(ctx, f.api, body, &f.site, f.progress)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
 			}
+			if isTimeout(r, err) {
+				if halves, ok := f.narrower.split(f.windows[0], last, lastIDs); ok {
+					lo, hi := halves[0].from, halves[1].to
+					if opts.Descending {
+						lo, hi = halves[1].from, halves[0].to
+					}
+					fmt.Fprintf(os.Stderr, "\nRequests for %s → %s keep timing out; splitting it in two\n", lo, hi)
+					f.windows = append(halves, f.windows[1:]...)
+					f.cursor, last, lastIDs = nil, time.Time{}, nil
+					continue
+				}
+			}
+			fmt.Fprintf(os.Stderr, "\nFull HTTP response: %v\n", r)
+			return fmt.Errorf("calling LogsApi.ListLogs: %w", err)
 		}
 
-		// For CSV: after the first page, flush the buffered logs and write headers
-		if firstPage {
-			if err := writer.FlushPage(); err != nil {
-				return fmt.Errorf("flushing first page: %w", err)
+		logs := resp.GetData()
+		var raw []json.RawMessage
+		if opts.Raw {
+			raw, err = rawEvents(r)
+			if err != nil {
+				return err
 			}
-			firstPage = false
 		}
+		fetched := len(logs)
+		for _, log := range logs {
+			attrs := log.GetAttributes()
+			if ts, ok := attrs.GetTimestampOk(); ok {
+				if !ts.Equal(last) {
+					last, lastIDs = *ts, nil
+				}
+				lastIDs = append(lastIDs, log.GetId())
+			}
+		}
+		logs, raw = f.narrower.dedupe(logs, raw)
+		if f.seen != nil {
+			logs, raw = f.seen.filter(logs, raw)
+		}
+		f.fetched += len(logs)
+		thisPage := f.page
 
-		if err := bw.Flush(); err != nil {
-			return fmt.Errorf("flushing output: %w", err)
+		// Check for next page; without one, move on to the next window.
+		f.page++
+		after := resp.GetMeta().Page.GetAfter()
+		if after == "" || int32(fetched) < pageSize {
+			f.windows = f.windows[1:]
+			f.cursor, last, lastIDs = nil, time.Time{}, nil
+		} else {
+			f.cursor = &after
+		}
+		if !emit(fetchResult{logs: logs, raw: raw, page: thisPage, next: newExportPosition(f.windows, f.cursor, f.page)}) {
+			return nil
 		}
+		f.progress.add(len(logs), after)
 	}
+	return nil
+}
 
-	// Check if fetcher hit an error
-	if fetchErr != nil {
-		return fetchErr
+// fetchProgress is the live status line shared by every fetcher of an
+// export.
+type fetchProgress struct {
+	mu    sync.Mutex
+	start time.Time
+	logs  int
+	pages int
+
+	// shards and shardsDone are set for a parallel export.
+	shards     int
+	shardsDone int
+
+	// quiet keeps the status line off stderr; json replaces it with a
+	// progressEvent per page.
+	quiet bool
+	json  bool
+	line  progressLine
+}
+
+// add counts a fetched page of n logs, after which the export continues
+// from cursor, and redraws the status line.
+func (p *fetchProgress) add(n int, cursor string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.logs += n
+	p.pages++
+	elapsed := time.Since(p.start).Seconds()
+	rate := float64(p.logs) / elapsed
+	if p.quiet {
+		return
+	}
+	if p.json {
+		p.event("page", cursor, 0)
+		return
 	}
+	if p.shards > 0 {
+		p.line.update("Fetching... %d/%d shards | page %d | %d logs | %.1fs | %.0f logs/sec", p.shardsDone, p.shards, p.pages, p.logs, elapsed, rate)
+		return
+	}
+	p.line.update("Fetching... page %d | %d logs | %.1fs | %.0f logs/sec", p.pages, p.logs, elapsed, rate)
+}
 
-	writer.End()
+// listRequest builds the ListLogs request body for one page of opts,
+// continuing from cursor when it is non-nil.
+func listRequest(opts QueryOptions, cursor *string) datadogV2.LogsListRequest {
+	body := newListRequest(opts.Query, toDatadogTime(opts.From), toDatadogTime(opts.To), cursor)
+	body.Filter.Indexes = opts.Indexes
+	if opts.Descending {
+		body.Sort = datadogV2.LOGSSORT_TIMESTAMP_DESCENDING.Ptr()
+	}
+	return body
+}
 
-	mu.Lock()
-	elapsed := time.Since(start).Seconds()
-	fmt.Fprintf(os.Stderr, "\rDone: %d logs retrieved in %.1fs across %d page(s)\n", totalLogs, elapsed, lastPage)
-	if outputFile != "" {
-		fmt.Fprintf(os.Stderr, "Output written to %s\n", outputFile)
+// newListRequest builds a ListLogs request body from from/to values already
+// in Datadog's format ("now-1h", ISO 8601, ...).
+func newListRequest(query, from, to string, cursor *string) datadogV2.LogsListRequest {
+	storageTier := datadogV2.LOGSSTORAGETIER_FLEX
+	body := datadogV2.LogsListRequest{
+		Filter: &datadogV2.LogsQueryFilter{
+			Query:       datadog.PtrString(query),
+			From:        datadog.PtrString(from),
+			To:          datadog.PtrString(to),
+			StorageTier: &storageTier,
+		},
+		Sort: datadogV2.LOGSSORT_TIMESTAMP_ASCENDING.Ptr(),
+		Page: &datadogV2.LogsListRequestPage{
+			Limit: datadog.PtrInt32(maxLogsPerRequest),
+		},
+	}
+	if cursor != nil {
+		body.Page.Cursor = cursor
 	}
-	mu.Unlock()
+	return body
+}
 
-	return nil
+// rawEvents extracts the data array from a ListLogs response body without
+// decoding the events themselves. The SDK rewinds the body after reading it,
+// so these are the exact bytes the server sent.
+func rawEvents(r *http.Response) ([]json.RawMessage, error) {
+	var body struct {
+		Data []json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("reading raw response: %w", err)
+	}
+	return body.Data, nil
+}
+
+// createOutput opens outputFile for writing, or returns stdout when it is
+// empty, compressed with codec or the codec its extension implies (see
+// OutputCompression).
+func createOutput(outputFile, codec string) (io.WriteCloser, error) {
+	codec, err := OutputCompression(outputFile, codec)
+	if err != nil {
+		return nil, err
+	}
+	if outputFile == "" {
+		return compressOutput(nopWriteCloser{os.Stdout}, codec)
+	}
+	f, err := os.Create(outputFile)
+	if err != nil {
+		return nil, fmt.Errorf("creating output file: %w", err)
+	}
+	out, err := compressOutput(f, codec)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return out, nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// newLogWriter returns the streaming writer for opts.Format.
+func newLogWriter(opts QueryOptions, bw *bufio.Writer) logWriter {
+	switch opts.Format {
+	case "parquet":
+		w := newParquetWriter(bw)
+		w.fixed = exportedFixedColumns(opts)
+		w.tagCols = opts.TagColumns
+		w.conflicts = opts.TypeConflicts
+		return w
+	case "table":
+		w := newTableWriter(bw, exportedFixedColumns(opts), opts.TagColumns, opts.MaxColWidth, opts.TableWidth)
+		w.timeFormat = opts.TimeFormat
+		return w
+	case "template":
+		w := newTemplateWriter(bw, opts.Template)
+		w.timeFormat = opts.TimeFormat
+		return w
+	case "llm":
+		w := newLLMWriter(bw, opts.MaxTokens, opts.Tokenizer)
+		w.fixed = exportedFixedColumns(opts)
+		w.tagCols = opts.TagColumns
+		w.timeFormat = opts.TimeFormat
+		return w
+	case "md":
+		w := newMarkdownWriter(bw)
+		w.strict = opts.Strict
+		w.fixed = exportedFixedColumns(opts)
+		w.tagCols = opts.TagColumns
+		w.maxWidth = opts.MaxColWidth
+		w.timeFormat = opts.TimeFormat
+		return w
+	case "xlsx":
+		w := newXLSXWriter(bw)
+		w.strict = opts.Strict
+		w.fixed = exportedFixedColumns(opts)
+		w.tagCols = opts.TagColumns
+		return w
+	case "json", "ndjson":
+		w := newJSONWriter(bw)
+		w.lines = opts.Format == "ndjson"
+		if opts.Raw {
+			return &rawJSONWriter{jsonWriter: w}
+		}
+		w.stable = opts.StableJSON
+		w.strict = opts.Strict
+		w.timeFormat = opts.TimeFormat
+		return w
+	}
+	if opts.LongFormat {
+		w := newLongWriter(bw)
+		w.strict = opts.Strict
+		w.fixed = exportedFixedColumns(opts)
+		w.tagCols = opts.TagColumns
+		w.columns = exportedColumns(opts)
+		w.excelSafe = opts.ExcelSafe
+		w.excel = opts.Excel
+		w.w.UseCRLF = opts.Excel
+		w.flattenDepth = opts.FlattenDepth
+		w.timeFormat = opts.TimeFormat
+		if opts.Locale != nil {
+			w.locale = opts.Locale
+			w.w.Comma = opts.Locale.Comma
+		}
+		return w
+	}
+	w := newCSVWriter(bw)
+	w.strict = opts.Strict
+	w.fixed = exportedFixedColumns(opts)
+	w.tagCols = opts.TagColumns
+	w.columns = exportedColumns(opts)
+	w.excelSafe = opts.ExcelSafe
+	w.flattenDepth = opts.FlattenDepth
+	w.timeFormat = opts.TimeFormat
+	w.excel = opts.Excel
+	w.w.UseCRLF = opts.Excel
+	if opts.Locale != nil {
+		w.locale = opts.Locale
+		w.w.Comma = opts.Locale.Comma
+	}
+	return w
 }
 
-// logWriter abstracts CSV vs JSON streaming output.
+// logWriter abstracts the streaming output formats. FlushPage is called
+// after every fetched page.
 type logWriter interface {
 	Start()
 	WriteLog(log datadogV2.Log) error
 	FlushPage() error
-	End()
+	End() error
 }
 
 // --- JSON writer ---
 
+// jsonWriter writes either one pretty-printed JSON array or, in lines mode,
+// NDJSON: one compact object per line.
 type jsonWriter struct {
-	bw    *bufio.Writer
-	count int
+	bw     *bufio.Writer
+	count  int
+	lines  bool
+	stable bool
+	strict bool
+
+	timeFormat *TimeFormat
+
+	// resumed is set when appending to an array an earlier run started.
+	resumed bool
 }
 
 func newJSONWriter(bw *bufio.Writer) *jsonWriter {
@@ -224,14 +1160,44 @@ func newJSONWriter(bw *bufio.Writer) *jsonWriter {
 }
 
 func (w *jsonWriter) Start() {
-	w.bw.WriteString("[\n")
+	if !w.lines && !w.resumed {
+		w.bw.WriteString("[\n")
+	}
 }
 
 func (w *jsonWriter) WriteLog(log datadogV2.Log) error {
+	if w.strict {
+		if err := validateLog(log); err != nil {
+			return err
+		}
+	}
+	var v interface{} = log
+	if w.stable || w.timeFormat != nil {
+		canonical, err := canonicalJSON(log)
+		if err != nil {
+			return err
+		}
+		v = canonical
+	}
+	if w.timeFormat != nil {
+		setJSONTimestamp(v, log, w.timeFormat)
+	}
+
+	if w.lines {
+		entry, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		w.bw.Write(entry)
+		w.bw.WriteByte('\n')
+		w.count++
+		return nil
+	}
+
 	if w.count > 0 {
 		w.bw.WriteString(",\n")
 	}
-	entry, err := json.MarshalIndent(log, "  ", "  ")
+	entry, err := json.MarshalIndent(v, "  ", "  ")
 	if err != nil {
 		return err
 	}
@@ -243,34 +1209,186 @@ func (w *jsonWriter) WriteLog(log datadogV2.Log) error {
 
 func (w *jsonWriter) FlushPage() error { return nil }
 
-func (w *jsonWriter) End() {
-	w.bw.WriteString("\n]\n")
+// canonicalJSON round-trips v through generic JSON values so that re-encoding
+// it sorts every object's keys, independent of how the SDK serializes its
+// structs. Numbers keep their original text.
+func canonicalJSON(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var out interface{}
+	if err := dec.Decode(&out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// setJSONTimestamp replaces the timestamp of v, the generic JSON of log, with
+// its rendering in f.
+func setJSONTimestamp(v interface{}, log datadogV2.Log, f *TimeFormat) {
+	attrs := log.GetAttributes()
+	t, ok := attrs.GetTimestampOk()
+	if !ok || t == nil {
+		return
+	}
+	obj, _ := v.(map[string]interface{})
+	if a, ok := obj["attributes"].(map[string]interface{}); ok {
+		a["timestamp"] = f.jsonValue(*t)
+	}
+}
+
+func (w *jsonWriter) End() error {
+	if w.lines {
+		return nil
+	}
+	_, err := w.bw.WriteString("\n]\n")
+	return err
+}
+
+// --- Raw JSON writer ---
+
+// rawLogWriter is implemented by writers that consume events exactly as the
+// API returned them rather than decoded SDK structs.
+type rawLogWriter interface {
+	logWriter
+	WriteRaw(event json.RawMessage) error
+}
+
+// rawJSONWriter writes unmodified API events as a JSON array or NDJSON.
+type rawJSONWriter struct {
+	*jsonWriter
+}
+
+func (w *rawJSONWriter) WriteRaw(event json.RawMessage) error {
+	if w.lines {
+		w.bw.Write(event)
+		w.bw.WriteByte('\n')
+		w.count++
+		return nil
+	}
+	if w.count > 0 {
+		w.bw.WriteString(",\n")
+	}
+	w.bw.WriteString("  ")
+	w.bw.Write(event)
+	w.count++
+	return nil
 }
 
 // --- CSV writer ---
 
 var fixedColumns = []string{"timestamp", "host", "service", "status", "message", "tags"}
 
+// ParseColumns parses a comma-separated --columns list. Each column is a
+// fixed column (timestamp, host, service, status, message, tags), a custom
+// attribute path prefixed with @, or tag:key for the value of a tag.
+func ParseColumns(list string) ([]string, error) {
+	var cols []string
+	seen := make(map[string]bool)
+	for _, col := range strings.Split(list, ",") {
+		col = strings.TrimSpace(col)
+		switch {
+		case col == "":
+			continue
+		case col == "@" || col == "tag:":
+			return nil, fmt.Errorf("column %q needs an attribute path or tag key", col)
+		case !strings.HasPrefix(col, "@") && !strings.HasPrefix(col, "tag:") && !containsString(fixedColumns, col):
+			return nil, fmt.Errorf("unknown column %q: use one of %s, @path for an attribute or tag:key", col, strings.Join(fixedColumns, ", "))
+		case seen[col]:
+			return nil, fmt.Errorf("column %q listed twice", col)
+		}
+		seen[col] = true
+		cols = append(cols, col)
+	}
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("no columns given")
+	}
+	return cols, nil
+}
+
+// columnHeader is the CSV header of an explicit column: attributes and tags
+// are headed like discovered ones, without the @ or tag: prefix.
+func columnHeader(col string) string {
+	if key, ok := strings.CutPrefix(col, "tag:"); ok {
+		return key
+	}
+	return strings.TrimPrefix(col, "@")
+}
+
+// explicitColumnValue renders one explicit column of log.
+func explicitColumnValue(log datadogV2.Log, col string) string {
+	if key, ok := strings.CutPrefix(col, "tag:"); ok {
+		attrs := log.GetAttributes()
+		return tagValue(attrs.GetTags(), key)
+	}
+	return routeValue(log, col)
+}
+
 type csvWriter struct {
 	w       *csv.Writer
 	headers []string
 	attrSet map[string]bool
 	buffer  []datadogV2.Log
 	started bool
+	strict  bool
+	fixed   []string
+	tagCols []string
+
+	// columns, when set, replaces the discovered header (see
+	// QueryOptions.Columns).
+	columns []string
+
+	excelSafe  bool
+	excel      bool
+	locale     *CSVLocale
+	timeFormat *TimeFormat
+
+	// flattenDepth, when positive, expands nested attribute objects into
+	// dotted columns down to this many levels.
+	flattenDepth int
+
+	// prune, when set, receives the CSV once it is complete and writes it
+	// to the output without its empty and constant columns.
+	prune *csvPruner
 }
 
 func newCSVWriter(bw *bufio.Writer) *csvWriter {
 	return &csvWriter{
 		w:       csv.NewWriter(bw),
 		attrSet: make(map[string]bool),
+		fixed:   fixedColumns,
 	}
 }
 
-func (c *csvWriter) Start() {}
+func (c *csvWriter) Start() {
+	if c.columns != nil && !c.started {
+		c.headers = make([]string, len(c.columns))
+		for i, col := range c.columns {
+			c.headers[i] = columnHeader(col)
+		}
+		c.w.Write(c.headerRecord())
+		c.started = true
+	}
+}
 
 func (c *csvWriter) WriteLog(log datadogV2.Log) error {
+	if c.strict {
+		if err := validateLog(log); err != nil {
+			return err
+		}
+	}
+	if c.columns != nil {
+		return c.writeRow(log)
+	}
+
 	attrs := log.GetAttributes()
-	for key := range attrs.GetAttributes() {
+	for key := range c.customAttributes(&attrs) {
+		if c.strict && c.started && !c.attrSet[key] {
+			return &rejectError{Log: log, Reason: fmt.Sprintf("attribute %q first appeared after the CSV header was written", key)}
+		}
 		c.attrSet[key] = true
 	}
 
@@ -282,14 +1400,9 @@ func (c *csvWriter) WriteLog(log datadogV2.Log) error {
 }
 
 func (c *csvWriter) flushBuffer() error {
-	var attrCols []string
-	for k := range c.attrSet {
-		attrCols = append(attrCols, k)
-	}
-	sort.Strings(attrCols)
-	c.headers = append(fixedColumns, attrCols...)
+	c.headers = headerColumns(c.fixed, c.tagCols, c.attrSet)
 
-	if err := c.w.Write(c.headers); err != nil {
+	if err := c.w.Write(c.headerRecord()); err != nil {
 		return err
 	}
 	for _, log := range c.buffer {
@@ -304,35 +1417,164 @@ func (c *csvWriter) flushBuffer() error {
 }
 
 func (c *csvWriter) writeRow(log datadogV2.Log) error {
+	if c.columns != nil {
+		row := make([]string, len(c.columns))
+		for i, col := range c.columns {
+			value := explicitColumnValue(log, col)
+			if col == "timestamp" && c.timeFormat != nil {
+				attrs := log.GetAttributes()
+				value = c.timeFormat.timestamp(&attrs)
+			} else if c.locale != nil {
+				attrs := log.GetAttributes()
+				if v, ok := c.locale.cell(&attrs, attrs.GetAttributes(), col, true); ok {
+					value = v
+				}
+			}
+			row[i] = c.cell(value)
+		}
+		return c.w.Write(row)
+	}
+
 	attrs := log.GetAttributes()
-	customAttrs := attrs.GetAttributes()
+	customAttrs := c.customAttributes(&attrs)
 
 	row := make([]string, len(c.headers))
 	for i, col := range c.headers {
-		switch col {
-		case "timestamp":
-			if t, ok := attrs.GetTimestampOk(); ok && t != nil {
-				row[i] = t.Format(time.RFC3339)
-			}
-		case "host":
-			row[i] = attrs.GetHost()
-		case "service":
-			row[i] = attrs.GetService()
-		case "status":
-			row[i] = attrs.GetStatus()
-		case "message":
-			row[i] = attrs.GetMessage()
-		case "tags":
-			row[i] = strings.Join(attrs.GetTags(), ";")
-		default:
-			if v, ok := customAttrs[col]; ok {
-				row[i] = flattenValue(v)
+		if i >= len(c.fixed) && i < len(c.fixed)+len(c.tagCols) {
+			row[i] = tagValue(attrs.GetTags(), col)
+			continue
+		}
+		if col == "timestamp" && c.timeFormat != nil {
+			row[i] = c.timeFormat.timestamp(&attrs)
+			continue
+		}
+		if c.locale != nil {
+			if v, ok := c.locale.cell(&attrs, customAttrs, col, false); ok {
+				row[i] = v
+				continue
 			}
 		}
+		row[i] = columnValue(&attrs, customAttrs, col)
+	}
+	for i, v := range row {
+		row[i] = c.cell(v)
 	}
 	return c.w.Write(row)
 }
 
+// headerRecord is the header row as written, led by a byte order mark in
+// Excel mode.
+func (c *csvWriter) headerRecord() []string {
+	rec := csvRecord(c.headers, c.excelSafe)
+	if c.excel && len(rec) > 0 {
+		rec[0] = utf8BOM + rec[0]
+	}
+	return rec
+}
+
+// cell makes one value safe to write, see csvCell and excelDigits.
+func (c *csvWriter) cell(s string) string {
+	s = csvCell(s, c.excelSafe)
+	if c.excel {
+		s = excelDigits(s)
+	}
+	return s
+}
+
+// customAttributes returns the custom attributes of a log as CSV columns:
+// as they are, or with nested objects expanded into dotted keys down to
+// flattenDepth levels.
+func (c *csvWriter) customAttributes(attrs *datadogV2.LogAttributes) map[string]interface{} {
+	custom := attrs.GetAttributes()
+	if c.flattenDepth <= 0 {
+		return custom
+	}
+	return flattenAttributes(custom, c.flattenDepth)
+}
+
+// flattenAttributes expands nested objects in attrs into dotted keys
+// ({"http":{"status_code":500}} becomes http.status_code), descending at
+// most depth levels. Objects below that, arrays and scalars stay whole
+// values, rendered as one cell. Empty objects are kept as they are.
+func flattenAttributes(attrs map[string]interface{}, depth int) map[string]interface{} {
+	flat := make(map[string]interface{}, len(attrs))
+	var walk func(prefix string, m map[string]interface{}, level int)
+	walk = func(prefix string, m map[string]interface{}, level int) {
+		for k, v := range m {
+			key := prefix + k
+			if nested, ok := v.(map[string]interface{}); ok && len(nested) > 0 && level < depth {
+				walk(key+".", nested, level+1)
+				continue
+			}
+			flat[key] = v
+		}
+	}
+	walk("", attrs, 0)
+	return flat
+}
+
+// headerColumns is the header of a CSV-like export: the fixed columns, the
+// tag columns, then the attributes seen, sorted.
+func headerColumns(fixed, tagCols []string, attrSet map[string]bool) []string {
+	var attrCols []string
+	for k := range attrSet {
+		if !containsString(tagCols, k) {
+			attrCols = append(attrCols, k)
+		}
+	}
+	sort.Strings(attrCols)
+	return append(append(fixed[:len(fixed):len(fixed)], tagCols...), attrCols...)
+}
+
+// tagValue returns the value of every key:value tag with the given key,
+// joined with ";" when a log carries the key more than once.
+func tagValue(tags []string, key string) string {
+	var values []string
+	for _, tag := range tags {
+		if k, v, ok := strings.Cut(tag, ":"); ok && k == key {
+			values = append(values, v)
+		}
+	}
+	return strings.Join(values, ";")
+}
+
+// containsString reports whether list contains s.
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// columnValue renders one CSV column of a log: a fixed column, or a custom
+// attribute flattened to a string.
+func columnValue(attrs *datadogV2.LogAttributes, customAttrs map[string]interface{}, col string) string {
+	switch col {
+	case "timestamp":
+		if t, ok := attrs.GetTimestampOk(); ok && t != nil {
+			return t.Format(time.RFC3339)
+		}
+		return ""
+	case "host":
+		return attrs.GetHost()
+	case "service":
+		return attrs.GetService()
+	case "status":
+		return attrs.GetStatus()
+	case "message":
+		return attrs.GetMessage()
+	case "tags":
+		return strings.Join(attrs.GetTags(), ";")
+	default:
+		if v, ok := customAttrs[col]; ok {
+			return flattenValue(v)
+		}
+		return ""
+	}
+}
+
 func (c *csvWriter) FlushPage() error {
 	if !c.started {
 		return c.flushBuffer()
@@ -341,25 +1583,41 @@ func (c *csvWriter) FlushPage() error {
 	return c.w.Error()
 }
 
-func (c *csvWriter) End() {
+func (c *csvWriter) End() error {
 	if !c.started {
-		c.flushBuffer()
+		if err := c.flushBuffer(); err != nil {
+			return err
+		}
 	}
 	c.w.Flush()
+	if err := c.w.Error(); err != nil {
+		return err
+	}
+	if c.prune != nil {
+		return c.prune.finish(c.w.Comma, c.w.UseCRLF)
+	}
+	return nil
 }
 
 func flattenValue(v interface{}) string {
+	s, _ := encodeValue(v)
+	return s
+}
+
+// encodeValue renders an attribute value as a single CSV cell, JSON-encoding
+// anything that is not a scalar.
+func encodeValue(v interface{}) (string, error) {
 	switch val := v.(type) {
 	case string:
-		return val
+		return val, nil
 	case float64:
-		return fmt.Sprintf("%g", val)
+		return fmt.Sprintf("%g", val), nil
 	case bool:
-		return fmt.Sprintf("%t", val)
+		return fmt.Sprintf("%t", val), nil
 	case nil:
-		return ""
+		return "", nil
 	default:
-		b, _ := json.Marshal(val)
-		return string(b)
+		b, err := json.Marshal(val)
+		return string(b), err
 	}
 }
@@ -0,0 +1,682 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/parquet-go/parquet-go"
+)
+
+// deltaCommitAttempts is how many times a chunk's commit is retried against
+// a table other writers keep committing to.
+const deltaCommitAttempts = 10
+
+// deltaInvalidChars are the characters Delta Lake does not allow in column
+// names; attribute names with them are written with "_" instead.
+const deltaInvalidChars = " ,;{}()\n\t="
+
+// deltaSink adds each chunk to a Delta Lake table: the chunk is written as
+// one Parquet data file and added to the table in a commit of its own, so
+// every engine reading the table sees the chunk, and only the chunk, once
+// Commit returns. Each commit is created only if no other writer created
+// that version first; on a conflict the sink reads the commits it missed
+// and tries the next version. Columns new to the table are added to its
+// schema in the same commit, and attributes keep the types the table
+// already has, as with --dataset.
+type deltaSink struct {
+	store deltaStore
+	table *deltaTable
+
+	chunk SinkChunk
+	tmp   string
+	f     *os.File
+	bw    *bufio.Writer
+	w     *parquetWriter
+
+	rows     int64
+	min, max time.Time
+}
+
+// deltaStore is where a Delta table lives. Names are relative to the table
+// root, e.g. _delta_log/00000000000000000000.json.
+type deltaStore interface {
+	// logNames lists the file names in _delta_log.
+	logNames() ([]string, error)
+	read(name string) ([]byte, error)
+	// tempDir is where data files are written before putFile; "" is the
+	// system default.
+	tempDir() string
+	// putFile moves the local file at path to name.
+	putFile(name, path string) error
+	// putIfAbsent writes data to name unless name exists, and reports
+	// whether it did.
+	putIfAbsent(name string, data []byte) (bool, error)
+	location(name string) string
+}
+
+// newLocalDeltaSink creates the delta://<dir> sink, creating the table if
+// dir holds none.
+func newLocalDeltaSink(cfg SinkConfig) (Sink, error) {
+	dir := cfg.URL.Host + cfg.URL.Path
+	if dir == "" {
+		return nil, fmt.Errorf("--sink delta:// needs a directory")
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "_delta_log"), 0o755); err != nil {
+		return nil, fmt.Errorf("creating Delta table directory: %w", err)
+	}
+	return newDeltaSink(cfg, localDeltaStore{dir: dir})
+}
+
+// newS3DeltaSink creates the delta+s3://<bucket>/<prefix> sink, using the
+// AWS credentials and region of the environment.
+func newS3DeltaSink(cfg SinkConfig) (Sink, error) {
+	bucket := cfg.URL.Host
+	if bucket == "" {
+		return nil, fmt.Errorf("--sink delta+s3:// needs a bucket")
+	}
+	prefix := strings.Trim(cfg.URL.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	ctx := context.Background()
+	awsCfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return newDeltaSink(cfg, &s3DeltaStore{ctx: ctx, client: s3.NewFromConfig(awsCfg), bucket: bucket, prefix: prefix})
+}
+
+func newDeltaSink(cfg SinkConfig, store deltaStore) (Sink, error) {
+	if cfg.Format != "parquet" {
+		return nil, fmt.Errorf("Delta tables hold Parquet files; add -f parquet")
+	}
+	table := newDeltaTable()
+	if err := table.refresh(store); err != nil {
+		return nil, err
+	}
+	return &deltaSink{store: store, table: table}, nil
+}
+
+// DefaultCheckpoint keeps the checkpoint in the table directory of a local
+// table, where Delta readers ignore it.
+func (s *deltaSink) DefaultCheckpoint() string {
+	if local, ok := s.store.(localDeltaStore); ok {
+		return filepath.Join(local.dir, ".backfill.json")
+	}
+	return ""
+}
+
+// Committed reports whether the table has a data file added for chunk.
+func (s *deltaSink) Committed(chunk SinkChunk) (string, bool, error) {
+	if err := s.table.refresh(s.store); err != nil {
+		return "", false, err
+	}
+	path, ok := s.table.chunks[chunk.Name]
+	return path, ok, nil
+}
+
+func (s *deltaSink) Open(chunk SinkChunk) error {
+	if err := s.table.refresh(s.store); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(s.store.tempDir(), ".part-*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating data file: %w", err)
+	}
+	s.chunk, s.tmp, s.f = chunk, f.Name(), f
+	s.bw = bufio.NewWriterSize(f, 256*1024)
+	s.w = newLogWriter(QueryOptions{Format: "parquet"}, s.bw).(*parquetWriter)
+	s.w.dataset = append([]datasetColumn(nil), s.table.columns...)
+	s.rows, s.min, s.max = 0, time.Time{}, time.Time{}
+	s.w.Start()
+	return nil
+}
+
+func (s *deltaSink) WriteBatch(logs []datadogV2.Log) error {
+	for _, log := range logs {
+		attrs := log.GetAttributes()
+		if renamed, ok := deltaAttributes(attrs.GetAttributes()); ok {
+			attrs.SetAttributes(renamed)
+			log.SetAttributes(attrs)
+		}
+		if err := s.w.WriteLog(log); err != nil {
+			return fmt.Errorf("writing log: %w", err)
+		}
+		s.rows++
+		if t, ok := attrs.GetTimestampOk(); ok && t != nil {
+			ts := t.UTC().Truncate(time.Millisecond)
+			if s.min.IsZero() || ts.Before(s.min) {
+				s.min = ts
+			}
+			if ts.After(s.max) {
+				s.max = ts
+			}
+		}
+	}
+	return s.w.FlushPage()
+}
+
+// deltaAttributes renames the attributes whose names Delta does not allow
+// as column names, and reports whether there were any.
+func deltaAttributes(attrs map[string]interface{}) (map[string]interface{}, bool) {
+	renamed := false
+	for k := range attrs {
+		if strings.ContainsAny(k, deltaInvalidChars) {
+			renamed = true
+			break
+		}
+	}
+	if !renamed {
+		return attrs, false
+	}
+	out := make(map[string]interface{}, len(attrs))
+	for k, v := range attrs {
+		name := strings.Map(func(r rune) rune {
+			if strings.ContainsRune(deltaInvalidChars, r) {
+				return '_'
+			}
+			return r
+		}, k)
+		if _, dup := out[name]; !dup || name == k {
+			out[name] = v
+		}
+	}
+	return out, true
+}
+
+// Commit uploads the chunk's data file and adds it to the table, removing
+// the file of an earlier delivery of the same chunk in the same commit. A
+// chunk without logs adds nothing.
+func (s *deltaSink) Commit() (string, error) {
+	if err := s.w.End(); err != nil {
+		return "", fmt.Errorf("finishing output: %w", err)
+	}
+	if err := s.bw.Flush(); err != nil {
+		return "", fmt.Errorf("writing data file: %w", err)
+	}
+	f := s.f
+	s.f = nil
+	defer os.Remove(s.tmp)
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("writing data file: %w", err)
+	}
+	if s.rows == 0 {
+		return "", nil
+	}
+	fields, err := parquetFileFields(s.tmp)
+	if err != nil {
+		return "", fmt.Errorf("reading data file schema: %w", err)
+	}
+	info, err := os.Stat(s.tmp)
+	if err != nil {
+		return "", fmt.Errorf("writing data file: %w", err)
+	}
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+	path := "part-" + s.chunk.Name + "-" + id + ".parquet"
+	if err := s.store.putFile(path, s.tmp); err != nil {
+		return "", fmt.Errorf("uploading data file: %w", err)
+	}
+	add := map[string]interface{}{
+		"path":             path,
+		"partitionValues":  map[string]string{},
+		"size":             info.Size(),
+		"modificationTime": time.Now().UnixMilli(),
+		"dataChange":       true,
+		"stats":            s.stats(),
+	}
+
+	for attempt := 1; ; attempt++ {
+		version, data, err := s.table.commit(fields, add, s.table.chunks[s.chunk.Name])
+		if err != nil {
+			return "", err
+		}
+		name := deltaLogName(version)
+		ok, err := s.store.putIfAbsent(name, data)
+		if err != nil {
+			return "", fmt.Errorf("writing Delta commit %d: %w", version, err)
+		}
+		if ok {
+			if err := s.table.apply(version, data); err != nil {
+				return "", err
+			}
+			return path, nil
+		}
+		if attempt == deltaCommitAttempts {
+			return "", fmt.Errorf("Delta commit for chunk %s lost to other writers %d times; %s is uploaded but not in the table", s.chunk.Name, attempt, path)
+		}
+		if err := s.table.refresh(s.store); err != nil {
+			return "", err
+		}
+	}
+}
+
+// stats are the add action's file statistics: the row count and, for
+// data skipping on time, the timestamp range.
+func (s *deltaSink) stats() string {
+	stats := map[string]interface{}{"numRecords": s.rows}
+	if !s.min.IsZero() {
+		layout := "2006-01-02T15:04:05.000Z07:00"
+		stats["minValues"] = map[string]string{"timestamp": s.min.Format(layout)}
+		stats["maxValues"] = map[string]string{"timestamp": s.max.Format(layout)}
+	}
+	b, _ := json.Marshal(stats)
+	return string(b)
+}
+
+func (s *deltaSink) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	s.f.Close()
+	s.f = nil
+	return os.Remove(s.tmp)
+}
+
+// deltaTable is what a sink knows of a table from its transaction log.
+type deltaTable struct {
+	// version is the latest commit read, -1 before the table exists.
+	version int64
+	meta    map[string]interface{}
+	columns []datasetColumn
+
+	// chunks maps the chunks added by backfills to their data files.
+	chunks map[string]string
+}
+
+func newDeltaTable() *deltaTable {
+	return &deltaTable{version: -1, chunks: make(map[string]string)}
+}
+
+var deltaCommitName = regexp.MustCompile(`^(\d{20})\.json$`)
+
+// deltaLogName is the log file of commit version.
+func deltaLogName(version int64) string {
+	return fmt.Sprintf("_delta_log/%020d.json", version)
+}
+
+// refresh reads the commits made since t was last read.
+func (t *deltaTable) refresh(store deltaStore) error {
+	names, err := store.logNames()
+	if err != nil {
+		return fmt.Errorf("listing Delta log: %w", err)
+	}
+	var versions []int64
+	for _, name := range names {
+		if m := deltaCommitName.FindStringSubmatch(name); m != nil {
+			v, _ := strconv.ParseInt(m[1], 10, 64)
+			if v > t.version {
+				versions = append(versions, v)
+			}
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	for _, v := range versions {
+		if v != t.version+1 {
+			if t.version < 0 {
+				return fmt.Errorf("the Delta log of %s starts at version %d; tables whose early commits were cleaned up after a checkpoint are not supported", store.location(""), v)
+			}
+			return fmt.Errorf("the Delta log of %s has no commit %d", store.location(""), t.version+1)
+		}
+		data, err := store.read(deltaLogName(v))
+		if err != nil {
+			return fmt.Errorf("reading Delta commit %d: %w", v, err)
+		}
+		if err := t.apply(v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply reads the actions of commit version.
+func (t *deltaTable) apply(version int64, data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var action struct {
+			Protocol *struct {
+				MinReaderVersion int `json:"minReaderVersion"`
+				MinWriterVersion int `json:"minWriterVersion"`
+			} `json:"protocol"`
+			MetaData map[string]interface{} `json:"metaData"`
+			Add      *struct {
+				Path string `json:"path"`
+			} `json:"add"`
+			Remove *struct {
+				Path string `json:"path"`
+			} `json:"remove"`
+		}
+		if err := dec.Decode(&action); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("reading Delta commit %d: %w", version, err)
+		}
+		switch {
+		case action.Protocol != nil:
+			if action.Protocol.MinReaderVersion > 1 || action.Protocol.MinWriterVersion > 2 {
+				return fmt.Errorf("the Delta table needs reader version %d and writer version %d; only tables up to 1 and 2 are supported",
+					action.Protocol.MinReaderVersion, action.Protocol.MinWriterVersion)
+			}
+		case action.MetaData != nil:
+			if parts, _ := action.MetaData["partitionColumns"].([]interface{}); len(parts) > 0 {
+				return fmt.Errorf("the Delta table is partitioned; only unpartitioned tables are supported")
+			}
+			schema, _ := action.MetaData["schemaString"].(string)
+			cols, err := deltaColumns(schema)
+			if err != nil {
+				return err
+			}
+			t.meta, t.columns = action.MetaData, cols
+		case action.Add != nil:
+			if rest, ok := strings.CutPrefix(action.Add.Path, "part-"); ok {
+				if i := strings.Index(rest, "-"); i > 0 {
+					t.chunks[rest[:i]] = action.Add.Path
+				}
+			}
+		case action.Remove != nil:
+			for name, path := range t.chunks {
+				if path == action.Remove.Path {
+					delete(t.chunks, name)
+				}
+			}
+		}
+	}
+	t.version = version
+	return nil
+}
+
+// commit is the next commit of t adding the data file with schema fields,
+// and removing the data file replaced when it is set: its version and log
+// file. The table's schema gains the file's new columns.
+func (t *deltaTable) commit(fields []parquet.Field, add map[string]interface{}, replaced string) (int64, []byte, error) {
+	cols := append([]datasetColumn(nil), t.columns...)
+	kinds := make(map[string]string, len(cols))
+	for _, col := range cols {
+		kinds[col.name] = col.kind
+	}
+	for _, field := range fields {
+		kind := parquetNodeKind(field)
+		if have, ok := kinds[field.Name()]; ok {
+			if have != kind {
+				return 0, nil, fmt.Errorf("column %s is %s in the Delta table but %s in this chunk", field.Name(), deltaType(have), deltaType(kind))
+			}
+			continue
+		}
+		kinds[field.Name()] = kind
+		cols = append(cols, datasetColumn{name: field.Name(), kind: kind})
+	}
+
+	now := time.Now().UnixMilli()
+	actions := []map[string]interface{}{{
+		"commitInfo": map[string]interface{}{
+			"timestamp":           now,
+			"operation":           "WRITE",
+			"operationParameters": map[string]string{"mode": "Append", "partitionBy": "[]"},
+			"isBlindAppend":       replaced == "",
+			"engineInfo":          "ddlogs backfill",
+		},
+	}}
+	if t.version < 0 {
+		actions = append(actions, map[string]interface{}{
+			"protocol": map[string]int{"minReaderVersion": 1, "minWriterVersion": 2},
+		})
+	}
+	if t.meta == nil || len(cols) > len(t.columns) {
+		meta := make(map[string]interface{}, len(t.meta)+1)
+		for k, v := range t.meta {
+			meta[k] = v
+		}
+		if t.meta == nil {
+			id, err := newUUID()
+			if err != nil {
+				return 0, nil, err
+			}
+			meta["id"] = id
+			meta["format"] = map[string]interface{}{"provider": "parquet", "options": map[string]string{}}
+			meta["partitionColumns"] = []string{}
+			meta["configuration"] = map[string]string{}
+			meta["createdTime"] = now
+		}
+		meta["schemaString"] = deltaSchemaString(cols)
+		actions = append(actions, map[string]interface{}{"metaData": meta})
+	}
+	if replaced != "" {
+		actions = append(actions, map[string]interface{}{
+			"remove": map[string]interface{}{"path": replaced, "deletionTimestamp": now, "dataChange": true},
+		})
+	}
+	actions = append(actions, map[string]interface{}{"add": add})
+
+	var buf bytes.Buffer
+	for _, action := range actions {
+		line, err := json.Marshal(action)
+		if err != nil {
+			return 0, nil, fmt.Errorf("encoding Delta commit: %w", err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return t.version + 1, buf.Bytes(), nil
+}
+
+// deltaType is the Delta Lake type of a column kind.
+func deltaType(kind string) string {
+	switch kind {
+	case "timestamp":
+		return "timestamp"
+	case "number":
+		return "double"
+	case "boolean":
+		return "boolean"
+	}
+	return "string"
+}
+
+// deltaSchemaString is the schemaString of a table with columns: a Spark
+// struct of nullable fields.
+func deltaSchemaString(cols []datasetColumn) string {
+	type field struct {
+		Name     string            `json:"name"`
+		Type     string            `json:"type"`
+		Nullable bool              `json:"nullable"`
+		Metadata map[string]string `json:"metadata"`
+	}
+	schema := struct {
+		Type   string  `json:"type"`
+		Fields []field `json:"fields"`
+	}{Type: "struct", Fields: []field{}}
+	for _, col := range cols {
+		schema.Fields = append(schema.Fields, field{Name: col.name, Type: deltaType(col.kind), Nullable: true, Metadata: map[string]string{}})
+	}
+	b, _ := json.Marshal(schema)
+	return string(b)
+}
+
+// deltaColumns reads the columns of a schemaString. Only the types this
+// tool writes are supported.
+func deltaColumns(schemaString string) ([]datasetColumn, error) {
+	var schema struct {
+		Fields []struct {
+			Name string          `json:"name"`
+			Type json.RawMessage `json:"type"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal([]byte(schemaString), &schema); err != nil {
+		return nil, fmt.Errorf("reading Delta table schema: %w", err)
+	}
+	var cols []datasetColumn
+	for _, f := range schema.Fields {
+		var typ string
+		json.Unmarshal(f.Type, &typ)
+		kind := ""
+		switch typ {
+		case "timestamp":
+			kind = "timestamp"
+		case "double":
+			kind = "number"
+		case "boolean":
+			kind = "boolean"
+		case "string":
+			kind = "string"
+		default:
+			return nil, fmt.Errorf("column %s of the Delta table has type %s; only string, double, boolean and timestamp columns are supported", f.Name, f.Type)
+		}
+		cols = append(cols, datasetColumn{name: f.Name, kind: kind})
+	}
+	return cols, nil
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("generating id: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// localDeltaStore is a table in a local directory.
+type localDeltaStore struct {
+	dir string
+}
+
+func (s localDeltaStore) logNames() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, "_delta_log"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.Name()
+	}
+	return names, nil
+}
+
+func (s localDeltaStore) read(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.dir, name))
+}
+
+func (s localDeltaStore) tempDir() string { return s.dir }
+
+func (s localDeltaStore) putFile(name, path string) error {
+	if err := os.Chmod(path, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path, filepath.Join(s.dir, name))
+}
+
+// putIfAbsent writes data to a temporary file and links it to name, which
+// fails if name exists.
+func (s localDeltaStore) putIfAbsent(name string, data []byte) (bool, error) {
+	path := filepath.Join(s.dir, name)
+	f, err := os.CreateTemp(filepath.Dir(path), ".commit-*.tmp")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return false, err
+	}
+	if err := f.Close(); err != nil {
+		return false, err
+	}
+	os.Chmod(f.Name(), 0o644)
+	if err := os.Link(f.Name(), path); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s localDeltaStore) location(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// s3DeltaStore is a table under a prefix of an S3 bucket. Commits are made
+// with conditional writes (If-None-Match), so concurrent writers need no
+// lock table.
+type s3DeltaStore struct {
+	ctx    context.Context
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func (s *s3DeltaStore) logNames() ([]string, error) {
+	prefix := s.prefix + "_delta_log/"
+	var names []string
+	pages := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{Bucket: &s.bucket, Prefix: &prefix})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(s.ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			names = append(names, strings.TrimPrefix(*obj.Key, prefix))
+		}
+	}
+	return names, nil
+}
+
+func (s *s3DeltaStore) read(name string) ([]byte, error) {
+	key := s.prefix + name
+	out, err := s.client.GetObject(s.ctx, &s3.GetObjectInput{Bucket: &s.bucket, Key: &key})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+func (s *s3DeltaStore) tempDir() string { return "" }
+
+func (s *s3DeltaStore) putFile(name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	key := s.prefix + name
+	_, err = s.client.PutObject(s.ctx, &s3.PutObjectInput{Bucket: &s.bucket, Key: &key, Body: f})
+	return err
+}
+
+// putIfAbsent reports false when S3 refuses the write because name exists
+// (412) or another conditional write to it is in flight (409).
+func (s *s3DeltaStore) putIfAbsent(name string, data []byte) (bool, error) {
+	key := s.prefix + name
+	ifNoneMatch := "*"
+	_, err := s.client.PutObject(s.ctx, &s3.PutObjectInput{Bucket: &s.bucket, Key: &key, Body: bytes.NewReader(data), IfNoneMatch: &ifNoneMatch})
+	var status interface{ HTTPStatusCode() int }
+	if errors.As(err, &status) && (status.HTTPStatusCode() == 412 || status.HTTPStatusCode() == 409) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *s3DeltaStore) location(name string) string {
+	return "s3://" + s.bucket + "/" + s.prefix + name
+}
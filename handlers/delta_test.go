@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// deltaFields are the fields of a data file with the given columns.
+func deltaFields(group parquet.Group) []parquet.Field {
+	return parquet.NewSchema("log", group).Fields()
+}
+
+// commitDelta makes the next commit of t adding path, writes it to store
+// and reads it back.
+func commitDelta(t *testing.T, table *deltaTable, store localDeltaStore, fields []parquet.Field, path, replaced string) {
+	t.Helper()
+	version, data, err := table.commit(fields, map[string]interface{}{"path": path}, replaced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := store.putIfAbsent(deltaLogName(version), data)
+	if err != nil || !ok {
+		t.Fatalf("writing commit %d: %v, %v", version, ok, err)
+	}
+	if err := table.apply(version, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeltaLogRoundTrip(t *testing.T) {
+	store := localDeltaStore{dir: t.TempDir()}
+	if err := os.MkdirAll(filepath.Join(store.dir, "_delta_log"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	table := newDeltaTable()
+	commitDelta(t, table, store, deltaFields(parquet.Group{
+		"message":   parquet.String(),
+		"timestamp": parquet.Timestamp(parquet.Millisecond),
+	}), "part-20240501T000000Z-a.parquet", "")
+	commitDelta(t, table, store, deltaFields(parquet.Group{
+		"message": parquet.String(),
+		"n":       parquet.Leaf(parquet.DoubleType),
+		"ok":      parquet.Leaf(parquet.BooleanType),
+	}), "part-20240501T010000Z-b.parquet", "")
+	// A chunk delivered again replaces its first data file.
+	commitDelta(t, table, store, deltaFields(parquet.Group{
+		"message": parquet.String(),
+	}), "part-20240501T000000Z-c.parquet", table.chunks["20240501T000000Z"])
+
+	// A reader starting from nothing sees what the writer saw.
+	read := newDeltaTable()
+	if err := read.refresh(store); err != nil {
+		t.Fatal(err)
+	}
+	if read.version != 2 {
+		t.Errorf("version = %d, want 2", read.version)
+	}
+	wantChunks := map[string]string{
+		"20240501T000000Z": "part-20240501T000000Z-c.parquet",
+		"20240501T010000Z": "part-20240501T010000Z-b.parquet",
+	}
+	if !reflect.DeepEqual(read.chunks, wantChunks) {
+		t.Errorf("chunks = %v, want %v", read.chunks, wantChunks)
+	}
+	kinds := make(map[string]string)
+	for _, col := range read.columns {
+		kinds[col.name] = col.kind
+	}
+	wantKinds := map[string]string{"message": "string", "timestamp": "timestamp", "n": "number", "ok": "boolean"}
+	if !reflect.DeepEqual(kinds, wantKinds) {
+		t.Errorf("columns = %v, want %v", kinds, wantKinds)
+	}
+	if !reflect.DeepEqual(read.columns, table.columns) {
+		t.Errorf("reader columns %v differ from writer columns %v", read.columns, table.columns)
+	}
+
+	// Nothing new to read is not an error.
+	if err := read.refresh(store); err != nil {
+		t.Fatal(err)
+	}
+
+	// A column changing type is refused.
+	if _, _, err := table.commit(deltaFields(parquet.Group{"n": parquet.String()}), map[string]interface{}{"path": "x"}, ""); err == nil {
+		t.Error("commit changing n from double to string succeeded")
+	}
+}
+
+func TestDeltaLogGap(t *testing.T) {
+	store := localDeltaStore{dir: t.TempDir()}
+	if err := os.MkdirAll(filepath.Join(store.dir, "_delta_log"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	_, data, err := newDeltaTable().commit(deltaFields(parquet.Group{"message": parquet.String()}), map[string]interface{}{"path": "x"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.putIfAbsent(deltaLogName(1), data); err != nil {
+		t.Fatal(err)
+	}
+	if err := newDeltaTable().refresh(store); err == nil || !strings.Contains(err.Error(), "starts at version 1") {
+		t.Errorf("refresh of a log starting at 1: %v", err)
+	}
+}
+
+func TestDeltaApplyRejects(t *testing.T) {
+	for _, tc := range []struct{ name, commit, want string }{
+		{"reader version", `{"protocol":{"minReaderVersion":3,"minWriterVersion":7}}`, "reader version 3"},
+		{"partitioned", `{"metaData":{"partitionColumns":["date"],"schemaString":"{\"type\":\"struct\",\"fields\":[]}"}}`, "partitioned"},
+		{"column type", `{"metaData":{"partitionColumns":[],"schemaString":"{\"type\":\"struct\",\"fields\":[{\"name\":\"n\",\"type\":\"long\"}]}"}}`, "type \"long\""},
+		{"bad schema", `{"metaData":{"partitionColumns":[],"schemaString":"{"}}`, "schema"},
+		{"bad JSON", `{"add":`, "commit 0"},
+	} {
+		err := newDeltaTable().apply(0, []byte(tc.commit+"\n"))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: apply = %v, want an error containing %q", tc.name, err, tc.want)
+		}
+	}
+}
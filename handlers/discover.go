@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+const (
+	// discoverSlices is how many evenly spaced slices of the time range the
+	// schema pre-pass samples, so attributes that only appear late in the
+	// range are still found.
+	discoverSlices = 10
+
+	// DefaultDiscoverSample is how many logs the schema pre-pass samples
+	// when no sample size is given.
+	DefaultDiscoverSample = 1000
+)
+
+// discoverSliceCount is how many slices a sample of n logs is spread over:
+// discoverSlices, fewer when n is smaller, and more once a slice would need
+// more than one page.
+func discoverSliceCount(n int) int {
+	pages := (n + int(maxLogsPerRequest) - 1) / int(maxLogsPerRequest)
+	return max(min(n, discoverSlices), pages)
+}
+
+// sampledSchema is what the schema pre-pass saw: how many logs it sampled
+// and, for every column, how many of them had a value of each type (see
+// observeKind).
+type sampledSchema struct {
+	logs    int
+	slices  int
+	columns map[string]map[string]int
+}
+
+// present is how many sampled logs had a value for col.
+func (s *sampledSchema) present(col string) int {
+	n := 0
+	for _, count := range s.columns[col] {
+		n += count
+	}
+	return n
+}
+
+// attributes returns the sampled attribute columns that opts allows, sorted.
+func (s *sampledSchema) attributes(opts QueryOptions) []string {
+	keys := make([]string, 0, len(s.columns))
+	for k := range s.columns {
+		if isFixedColumn(k) || containsString(opts.TagColumns, k) {
+			continue
+		}
+		if opts.Classification == nil || opts.Classification.allows(k, opts.MaxClassification) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// discoverSchema samples opts' time range before the export streams:
+// opts.DiscoverSample logs (DefaultDiscoverSample if unset) spread over
+// equal slices of the range, taken from the start of each. The CSV header
+// or Parquet schema built from it then covers attributes that first appear
+// long after the first page.
+func (h *DDHandler) discoverSchema(opts QueryOptions) (*sampledSchema, error) {
+	schema := &sampledSchema{columns: make(map[string]map[string]int)}
+	slices, err := h.sampleLogs(opts, "Discovering schema", func(log datadogV2.Log) {
+		attrs := log.GetAttributes()
+		custom := attrs.GetAttributes()
+		for _, col := range fixedColumns {
+			if v := columnValue(&attrs, custom, col); v != "" {
+				observeKind(schema.columns, col, v)
+			} else {
+				observeKind(schema.columns, col, nil)
+			}
+		}
+		for _, key := range opts.TagColumns {
+			if v := tagValue(attrs.GetTags(), key); v != "" {
+				observeKind(schema.columns, key, v)
+			} else {
+				observeKind(schema.columns, key, nil)
+			}
+		}
+		if opts.FlattenDepth > 0 {
+			custom = flattenAttributes(custom, opts.FlattenDepth)
+		}
+		for key, v := range custom {
+			if !isFixedColumn(key) && !containsString(opts.TagColumns, key) {
+				observeKind(schema.columns, key, v)
+			}
+		}
+		schema.logs++
+	})
+	if err != nil {
+		return nil, err
+	}
+	schema.slices = slices
+	if slices == 0 || opts.Quiet {
+		return schema, nil
+	}
+	fmt.Fprintf(os.Stderr, lineStart()+"Schema discovery: %d attribute(s) in %d logs sampled across %d slices\n", len(schema.attributes(opts)), schema.logs, slices)
+	return schema, nil
+}
+
+// sampleLogs calls fn with opts.DiscoverSample logs (DefaultDiscoverSample
+// if unset) matching opts, spread over equal slices of its time range and
+// taken from the start of each, and returns how many slices it used: none
+// when the range is empty. activity names the work on the progress line.
+func (h *DDHandler) sampleLogs(opts QueryOptions, activity string, fn func(datadogV2.Log)) (int, error) {
+	now := time.Now()
+	start, err := resolveTime(opts.From, now)
+	if err != nil {
+		return 0, err
+	}
+	end, err := resolveTime(opts.To, now)
+	if err != nil {
+		return 0, err
+	}
+	if !end.After(start) {
+		return 0, nil
+	}
+
+	sample := opts.DiscoverSample
+	if sample <= 0 {
+		sample = DefaultDiscoverSample
+	}
+	slices := discoverSliceCount(sample)
+	ctx := h.apiContext()
+	api := h.logsAPI()
+	step := end.Sub(start) / time.Duration(slices)
+	var line progressLine
+	for i := 0; i < slices; i++ {
+		w := absoluteWindow(start.Add(time.Duration(i)*step), start.Add(time.Duration(i+1)*step))
+		if i == slices-1 {
+			w = absoluteWindow(w.start, end)
+		}
+		// This slice's share of the sample; shares differ by at most one.
+		limit := int32(sample*(i+1)/slices - sample*i/slices)
+		if !opts.Quiet {
+			line.update("%s... slice %d/%d", activity, i+1, slices)
+		}
+		body := newListRequest(opts.Query, w.from, w.to, nil)
+		body.Filter.Indexes = opts.Indexes
+		body.Page.Limit = datadog.PtrInt32(limit)
+		resp, _, err := h.listLogs(ctx, api, body, nil, &fetchProgress{quiet: opts.Quiet || opts.ProgressJSON})
+		if err != nil {
+			fmt.Fprintln(os.Stderr)
+			return 0, fmt.Errorf("sampling logs: calling LogsApi.ListLogs: %w", err)
+		}
+		for _, log := range resp.GetData() {
+			fn(log)
+		}
+	}
+	return slices, nil
+}
+
+// seedTypes gives w the attribute types of schema, if it is a Parquet (or
+// DuckDB or dataset) writer whose schema is not yet fixed, so it covers attributes
+// missing from the first page. Values on the first page are counted on top.
+func seedTypes(w logWriter, schema *sampledSchema, opts QueryOptions) {
+	switch d := w.(type) {
+	case *duckdbWriter:
+		w = d.parquetWriter
+	case *datasetWriter:
+		w = d.parquetWriter
+	}
+	p, ok := w.(*parquetWriter)
+	if !ok || p.w != nil {
+		return
+	}
+	for _, a := range schema.attributes(opts) {
+		if p.kinds[a] == nil {
+			p.kinds[a] = make(map[string]int)
+		}
+		for kind, n := range schema.columns[a] {
+			p.kinds[a][kind] += n
+		}
+	}
+}
+
+// seedColumns adds attrs to the columns w will discover, if it is a CSV
+// writer whose header is not yet written.
+func seedColumns(w logWriter, attrs []string) {
+	c, ok := w.(*csvWriter)
+	if !ok || c.started || c.columns != nil {
+		return
+	}
+	for _, a := range attrs {
+		c.attrSet[a] = true
+	}
+}
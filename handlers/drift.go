@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// schemaChange is one event in a tail --schema-log sidecar: attributes that
+// first appeared after the CSV header was written.
+type schemaChange struct {
+	Time    time.Time `json:"time"`
+	LogID   string    `json:"log_id"`
+	Columns []string  `json:"new_columns"`
+
+	// File is the rotated file started with the extended header, when the
+	// tail rotates on schema changes.
+	File string `json:"file,omitempty"`
+}
+
+// lateAttributes returns the attributes of log that w has no column for,
+// sorted. It is always empty for writers other than a CSV writer whose
+// header is already written.
+func lateAttributes(w logWriter, log datadogV2.Log) []string {
+	c, ok := w.(*csvWriter)
+	if !ok || !c.started {
+		return nil
+	}
+	var late []string
+	attrs := log.GetAttributes()
+	for key := range c.customAttributes(&attrs) {
+		if !c.attrSet[key] {
+			late = append(late, key)
+		}
+	}
+	sort.Strings(late)
+	return late
+}
+
+// successor returns a CSV writer for the file following c after a schema
+// change: its header will carry every column c has seen, so the next file
+// extends the previous one rather than starting from scratch.
+func (c *csvWriter) successor(bw *bufio.Writer) *csvWriter {
+	next := newCSVWriter(bw)
+	next.strict, next.fixed, next.tagCols, next.excelSafe = c.strict, c.fixed, c.tagCols, c.excelSafe
+	next.locale, next.w.Comma, next.flattenDepth = c.locale, c.w.Comma, c.flattenDepth
+	next.timeFormat, next.excel, next.w.UseCRLF = c.timeFormat, c.excel, c.w.UseCRLF
+	for k := range c.attrSet {
+		next.attrSet[k] = true
+	}
+	return next
+}
+
+// resume makes c continue a CSV file whose header is already written:
+// rows follow the columns of header, and attributes it lacks are late.
+func (c *csvWriter) resume(header []string) error {
+	if len(header) < len(c.fixed)+len(c.tagCols) || !slices.Equal(header[:len(c.fixed)], c.fixed) {
+		return fmt.Errorf("its header does not start with the columns %s", strings.Join(c.fixed, ", "))
+	}
+	c.headers = header
+	for _, col := range header[len(c.fixed)+len(c.tagCols):] {
+		c.attrSet[col] = true
+	}
+	c.started = true
+	return nil
+}
+
+// readCSVHeader returns the first record of the CSV file at path, or nil
+// if the file is missing or empty.
+func readCSVHeader(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading output file: %w", err)
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading the header of %s: %w", path, err)
+	}
+	return header, nil
+}
+
+// rotatedPath returns the name of the n-th file rotated from path:
+// logs.csv becomes logs.1.csv, logs.2.csv, ...
+func rotatedPath(path string, n int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(path, ext), n, ext)
+}
+
+// appendSchemaChange adds ev as one NDJSON line to the sidecar at path.
+func appendSchemaChange(path string, ev schemaChange) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening schema log: %w", err)
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("writing schema log: %w", err)
+	}
+	return f.Close()
+}
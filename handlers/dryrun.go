@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// DryRun writes the first ListLogs request body an export with opts would
+// send, as indented JSON, and calls nothing. Relative times are sent as they
+// are and resolved by Datadog; what they resolve to on this machine's clock
+// is noted on stderr along with the endpoint.
+func (h *DDHandler) DryRun(w io.Writer, opts QueryOptions) error {
+	body := listRequest(opts, nil)
+	if opts.Limit > 0 && opts.Limit < int(maxLogsPerRequest) {
+		limit := int32(opts.Limit)
+		body.Page.Limit = &limit
+	}
+	out, err := json.MarshalIndent(body, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding request body: %w", err)
+	}
+	fmt.Fprintf(w, "%s\n", out)
+
+	fmt.Fprintf(os.Stderr, "Dry run: not sent to POST https://api.%s/api/v2/logs/events/search\n", h.Site)
+	now := time.Now()
+	filter := body.GetFilter()
+	for _, t := range []struct{ name, flag, sent string }{
+		{"from", opts.From, filter.GetFrom()},
+		{"to", opts.To, filter.GetTo()},
+	} {
+		if at, err := resolveTime(t.flag, now); err == nil && t.sent != at.UTC().Format(apiTimeLayout) {
+			fmt.Fprintf(os.Stderr, "  %-4s %q resolves to %s now\n", t.name, t.sent, at.UTC().Format(time.RFC3339))
+		}
+	}
+	if opts.Parallel > 1 {
+		fmt.Fprintln(os.Stderr, "  with --parallel the range is split into shards, each requested like this with its own from and to")
+	}
+	return nil
+}
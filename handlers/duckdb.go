@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultDuckDBTable is the table a --format duckdb export is loaded into.
+const DefaultDuckDBTable = "logs"
+
+// duckdbWriter loads an export into a table of a DuckDB database file. Logs
+// are streamed as Parquet to a temporary file next to the database, which
+// the duckdb CLI loads in a single statement when the export ends, so the
+// table has the Parquet writer's types (TIMESTAMP, DOUBLE, BOOLEAN,
+// VARCHAR). The table is replaced; other tables in the database are left
+// alone.
+//
+// The CLI is looked up when the writer is built, before any log is
+// fetched, so an export without it fails at once rather than after the
+// download.
+type duckdbWriter struct {
+	*parquetWriter
+	cli   string
+	db    string
+	table string
+	quiet bool
+
+	tmp  string
+	file *os.File
+	bw   *bufio.Writer
+}
+
+func newDuckDBWriter(opts QueryOptions) (*duckdbWriter, error) {
+	cli, err := exec.LookPath("duckdb")
+	if err != nil {
+		return nil, fmt.Errorf("--format duckdb needs the duckdb CLI on PATH (https://duckdb.org/docs/installation): %w", err)
+	}
+	table := opts.DuckDBTable
+	if table == "" {
+		table = DefaultDuckDBTable
+	}
+	f, err := os.CreateTemp(filepath.Dir(opts.OutputFile), "."+filepath.Base(opts.OutputFile)+"-*.parquet")
+	if err != nil {
+		return nil, fmt.Errorf("creating staging file: %w", err)
+	}
+	bw := bufio.NewWriterSize(f, 256*1024)
+	pq := opts
+	pq.Format = "parquet"
+	return &duckdbWriter{
+		parquetWriter: newLogWriter(pq, bw).(*parquetWriter),
+		cli:           cli,
+		db:            opts.OutputFile,
+		table:         table,
+		quiet:         opts.Quiet,
+		tmp:           f.Name(),
+		file:          f,
+		bw:            bw,
+	}, nil
+}
+
+func (w *duckdbWriter) FlushPage() error {
+	if err := w.parquetWriter.FlushPage(); err != nil {
+		return err
+	}
+	return w.bw.Flush()
+}
+
+func (w *duckdbWriter) End() error {
+	defer w.cleanup()
+	if err := w.parquetWriter.End(); err != nil {
+		return err
+	}
+	if err := w.bw.Flush(); err != nil {
+		return fmt.Errorf("writing staging file: %w", err)
+	}
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("writing staging file: %w", err)
+	}
+	w.file = nil
+
+	stmt := fmt.Sprintf("CREATE OR REPLACE TABLE %s AS SELECT * FROM read_parquet(%s)", duckdbIdent(w.table), duckdbString(w.tmp))
+	var stderr bytes.Buffer
+	cmd := exec.Command(w.cli, w.db, "-c", stmt)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("loading into %s: %w: %s", w.db, err, strings.TrimSpace(stderr.String()))
+	}
+	if !w.quiet {
+		fmt.Fprintf(os.Stderr, "\nLoaded into table %s of %s\n", w.table, w.db)
+	}
+	return nil
+}
+
+// cleanup removes the staging file, whether or not the export finished.
+func (w *duckdbWriter) cleanup() {
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+	os.Remove(w.tmp)
+}
+
+// duckdbIdent quotes a table name for DuckDB SQL.
+func duckdbIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// duckdbString quotes a string literal for DuckDB SQL.
+func duckdbString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// validString replaces invalid UTF-8 in s with U+FFFD, as the JSON encoder
+// does, so text formats and Parquet string columns never carry bytes a
+// reader may reject.
+func validString(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	return strings.ToValidUTF8(s, "\uFFFD")
+}
+
+// csvCell makes s safe to write as one CSV cell. Invalid UTF-8 is replaced;
+// everything else is written as it is, quotes and delimiters being left to
+// the CSV encoder.
+//
+// With excelSafe (--csv-safe), the cell is also made safe to open in a
+// spreadsheet. Control characters other than tab, newline and carriage
+// return are written as \u escapes, since a NUL or form feed silently
+// truncates or splits rows in many importers. A cell a spreadsheet would
+// evaluate as a formula — one starting with =, +, -, @, a tab or a carriage
+// return — is prefixed with a single quote, so attacker-controlled log text
+// cannot run as a formula when the export is opened. Plain numbers such as
+// -42 are left alone.
+func csvCell(s string, excelSafe bool) string {
+	s = validString(s)
+	if !excelSafe {
+		return s
+	}
+	s = escapeControls(s)
+	if formulaLike(s) {
+		return "'" + s
+	}
+	return s
+}
+
+// textCell makes s safe for an output that cannot carry control
+// characters, such as a terminal, Markdown or XML: invalid UTF-8 is
+// replaced and control characters other than tab, newline and carriage
+// return are written as \u escapes.
+func textCell(s string) string {
+	return escapeControls(validString(s))
+}
+
+// escapeControls writes the control characters of s that isEscapedControl
+// reports as \uXXXX.
+func escapeControls(s string) string {
+	if strings.IndexFunc(s, isEscapedControl) < 0 {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if isEscapedControl(r) {
+			fmt.Fprintf(&b, `\u%04x`, r)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// csvRecord returns the cells of rec made safe by csvCell, leaving rec
+// itself unchanged.
+func csvRecord(rec []string, excelSafe bool) []string {
+	out := make([]string, len(rec))
+	for i, s := range rec {
+		out[i] = csvCell(s, excelSafe)
+	}
+	return out
+}
+
+// isEscapedControl reports whether r is a control character escapeControls
+// escapes: C0 and C1 controls and DEL, except tab, newline and carriage
+// return, which CSV quoting preserves.
+func isEscapedControl(r rune) bool {
+	switch r {
+	case '\t', '\n', '\r':
+		return false
+	}
+	return r < 0x20 || (r >= 0x7f && r < 0xa0)
+}
+
+// formulaLike reports whether a spreadsheet would treat s as a formula.
+func formulaLike(s string) bool {
+	if s == "" {
+		return false
+	}
+	switch s[0] {
+	case '=', '@', '\t', '\r':
+		return true
+	case '+', '-':
+		// Only decimal numbers are exempt, including those with a --locale
+		// decimal comma; ParseFloat alone would also pass Inf, NaN and hex
+		// floats.
+		if strings.Trim(s, "0123456789.,eE+-") != "" {
+			return true
+		}
+		_, err := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+		return err != nil
+	}
+	return false
+}
+
+// utf8BOM starts --excel CSV output, so Excel reads it as UTF-8 rather than
+// the system code page.
+const utf8BOM = "\uFEFF"
+
+// excelDigits wraps s as ="s" when it is a run of digits Excel would
+// mangle on opening: more than 15 digits, which it rounds and shows in
+// scientific notation, or a leading zero, which it drops. Excel shows the
+// formula's result, the digits as text. Anything else is returned as is.
+func excelDigits(s string) string {
+	if len(s) < 2 || strings.Trim(s, "0123456789") != "" {
+		return s
+	}
+	if len(s) > 15 || s[0] == '0' {
+		return `="` + s + `"`
+	}
+	return s
+}
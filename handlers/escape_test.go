@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// csvReadBack is the cell as a CSV reader returns it: the reader turns a
+// quoted \r\n into \n.
+func csvReadBack(s string) string {
+	return strings.ReplaceAll(s, "\r\n", "\n")
+}
+
+func FuzzCSVCell(f *testing.F) {
+	for _, s := range []string{"", "plain", "=1+1", "+SUM(A1)", "-42", "-1e5", "@cmd", "\tx", "\rx", "a,\"b\"\nc", "nul\x00ff\x0c", "\x7f\u0085", "bad\xff\xfeutf8", "\\u0000"} {
+		f.Add(s, false)
+		f.Add(s, true)
+	}
+	f.Fuzz(func(t *testing.T, s string, safe bool) {
+		cell := csvCell(s, safe)
+		if !utf8.ValidString(cell) {
+			t.Fatalf("csvCell(%q, %v) = %q: invalid UTF-8", s, safe, cell)
+		}
+		if safe {
+			if formulaLike(cell) {
+				t.Fatalf("csvCell(%q, true) = %q: still a formula", s, cell)
+			}
+			if strings.IndexFunc(cell, isEscapedControl) >= 0 {
+				t.Fatalf("csvCell(%q, true) = %q: unescaped control character", s, cell)
+			}
+		} else if utf8.ValidString(s) && cell != s {
+			t.Fatalf("csvCell(%q, false) = %q: valid text changed", s, cell)
+		}
+
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.Write([]string{"a", cell, "z"})
+		w.Flush()
+		if err := w.Error(); err != nil {
+			t.Fatalf("writing %q: %v", cell, err)
+		}
+		rec, err := csv.NewReader(&buf).Read()
+		if err != nil {
+			t.Fatalf("reading back %q: %v", buf.String(), err)
+		}
+		if len(rec) != 3 || rec[0] != "a" || rec[1] != csvReadBack(cell) || rec[2] != "z" {
+			t.Fatalf("%q read back as %q", cell, rec)
+		}
+	})
+}
+
+func FuzzCSVWriter(f *testing.F) {
+	f.Add("hello", "world", false)
+	f.Add("=HYPERLINK(\"x\")", "@SUM(A1)", true)
+	f.Add("line\r\nbreak", "tab\tand\x00nul", true)
+	f.Add("bad\xffutf8", "-3.5", false)
+	f.Fuzz(func(t *testing.T, msg, val string, safe bool) {
+		var buf bytes.Buffer
+		bw := bufio.NewWriter(&buf)
+		c := newCSVWriter(bw)
+		c.excelSafe = safe
+		attrs := datadogV2.NewLogAttributes()
+		attrs.SetMessage(msg)
+		attrs.SetAttributes(map[string]interface{}{"k": val})
+		log := datadogV2.NewLog()
+		log.SetAttributes(*attrs)
+
+		c.Start()
+		if err := c.WriteLog(*log); err != nil {
+			t.Fatalf("WriteLog: %v", err)
+		}
+		if err := c.End(); err != nil {
+			t.Fatalf("End: %v", err)
+		}
+		if err := bw.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		recs, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatalf("reading back %q: %v", buf.String(), err)
+		}
+		if len(recs) != 2 {
+			t.Fatalf("got %d records, want a header and one row: %q", len(recs), recs)
+		}
+		header, row := recs[0], recs[1]
+		for i, cell := range row {
+			if !utf8.ValidString(cell) {
+				t.Fatalf("column %s: invalid UTF-8 %q", header[i], cell)
+			}
+			if safe && formulaLike(cell) {
+				t.Fatalf("column %s: formula %q survived --csv-safe", header[i], cell)
+			}
+		}
+		for i, col := range header {
+			switch col {
+			case "message":
+				if want := csvReadBack(csvCell(msg, safe)); row[i] != want {
+					t.Fatalf("message = %q, want %q", row[i], want)
+				}
+			case "k":
+				if want := csvReadBack(csvCell(val, safe)); row[i] != want {
+					t.Fatalf("k = %q, want %q", row[i], want)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// explain writes how opts translate into the ListLogs request: what is sent
+// for every flag and which absolute instants the time range resolves to.
+func (h *DDHandler) explain(w io.Writer, opts QueryOptions) {
+	body := listRequest(opts, nil)
+	filter := body.GetFilter()
+	now := time.Now()
+
+	fmt.Fprintln(w, "Request plan:")
+	fmt.Fprintf(w, "  endpoint      POST https://api.%s/api/v2/logs/events/search\n", h.Site)
+	fmt.Fprintf(w, "  query         %s\n", filter.GetQuery())
+	fmt.Fprintf(w, "  storage tier  %s\n", filter.GetStorageTier())
+	if indexes := filter.GetIndexes(); len(indexes) > 0 {
+		fmt.Fprintf(w, "  indexes       %s\n", strings.Join(indexes, ", "))
+	} else {
+		fmt.Fprintf(w, "  indexes       (all indexes)\n")
+	}
+	order := "oldest first"
+	if opts.Descending {
+		order = "newest first"
+	}
+	fmt.Fprintf(w, "  sort          %s (%s)\n", body.GetSort(), order)
+	fmt.Fprintf(w, "  page size     %d logs per request, all pages followed\n", body.Page.GetLimit())
+	if opts.Parallel > 1 {
+		size := opts.ShardSize
+		if size <= 0 {
+			size = DefaultShardSize
+		}
+		fmt.Fprintf(w, "  parallel      %s shards, %d fetched at a time, merged in timestamp order\n", size, opts.Parallel)
+	}
+
+	from, fromErr := resolveTime(opts.From, now)
+	to, toErr := resolveTime(opts.To, now)
+	explainTime(w, "from", opts.From, filter.GetFrom(), from, fromErr)
+	explainTime(w, "to", opts.To, filter.GetTo(), to, toErr)
+	if fromErr == nil && toErr == nil {
+		fmt.Fprintf(w, "  window        %s\n", to.Sub(from))
+		if !from.Before(to) {
+			fmt.Fprintln(w, "                warning: --from is not before --to, no logs can match")
+		}
+	}
+	fmt.Fprintln(w, "  Relative times are resolved by Datadog when each request is made;")
+	fmt.Fprintln(w, "  the instants above use this machine's clock. Absolute times are sent in UTC.")
+	fmt.Fprintln(w)
+}
+
+func explainTime(w io.Writer, name, flag, sent string, t time.Time, err error) {
+	fmt.Fprintf(w, "  %-13s --%s %s -> %q\n", name, name, flag, sent)
+	if err != nil {
+		fmt.Fprintf(w, "                not a recognized duration, sent to Datadog as-is\n")
+		return
+	}
+	fmt.Fprintf(w, "                %s UTC\n", t.UTC().Format(time.RFC3339))
+	fmt.Fprintf(w, "                %s local\n", t.Local().Format("2006-01-02 15:04:05 MST"))
+}
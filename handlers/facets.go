@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// Where a facet's values come from.
+const (
+	facetReserved  = "reserved"
+	facetTag       = "tag"
+	facetAttribute = "attribute"
+)
+
+// facetTableServices is how many of a facet's services the table lists.
+const facetTableServices = 3
+
+// FacetsOptions configures Facets.
+type FacetsOptions struct {
+	Query   string
+	From    string
+	To      string
+	Indexes []string
+
+	// Sample is how many logs are sampled (DefaultDiscoverSample if unset).
+	Sample int
+
+	// Format is table, csv or json.
+	Format     string
+	OutputFile string
+	Quiet      bool
+}
+
+// facet is one field seen while sampling: a reserved attribute, a tag key
+// or an attribute path, named as a query would use it.
+type facet struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Type   string `json:"type"`
+	Logs   int    `json:"logs"`
+
+	// Coverage is the percentage of the sampled logs with a value.
+	Coverage float64  `json:"coverage"`
+	Services []string `json:"services"`
+	Example  string   `json:"example,omitempty"`
+
+	types    map[string]bool
+	services map[string]int
+}
+
+// Facets lists the fields of the logs matching opts: the reserved
+// attributes, tag keys and attribute paths, each with its types, the share
+// of logs carrying it, the services it comes from and an example value.
+//
+// The Logs API has no endpoint listing an org's facets, so they are
+// discovered from a sample of logs spread over the time range like
+// --discover-schema does; fields only rare logs carry may be missed.
+func (h *DDHandler) Facets(opts FacetsOptions) error {
+	seen := make(map[string]*facet)
+	observe := func(name, source string, v interface{}, service string, found map[string]bool) {
+		// Tags such as service:web repeat reserved attributes; they are
+		// listed apart.
+		key := source + ":" + name
+		f := seen[key]
+		if f == nil {
+			f = &facet{Name: name, Source: source, types: make(map[string]bool), services: make(map[string]int)}
+			seen[key] = f
+		}
+		if t := facetType(v); t != "" {
+			f.types[t] = true
+		}
+		if f.Example == "" {
+			f.Example = flattenValue(v)
+		}
+		if found[key] {
+			return
+		}
+		found[key] = true
+		f.Logs++
+		if service != "" {
+			f.services[service]++
+		}
+	}
+
+	logs := 0
+	slices, err := h.sampleLogs(QueryOptions{
+		Query:          opts.Query,
+		From:           opts.From,
+		To:             opts.To,
+		Indexes:        opts.Indexes,
+		DiscoverSample: opts.Sample,
+		Quiet:          opts.Quiet,
+	}, "Sampling logs", func(log datadogV2.Log) {
+		logs++
+		attrs := log.GetAttributes()
+		service := attrs.GetService()
+		found := make(map[string]bool)
+		for name, v := range map[string]string{"host": attrs.GetHost(), "service": service, "status": attrs.GetStatus()} {
+			if v != "" {
+				observe(name, facetReserved, v, service, found)
+			}
+		}
+		for _, tag := range attrs.GetTags() {
+			if key, value, ok := strings.Cut(tag, ":"); ok && key != "" {
+				observe(key, facetTag, value, service, found)
+			}
+		}
+		var walk func(prefix string, m map[string]interface{})
+		walk = func(prefix string, m map[string]interface{}) {
+			for k, v := range m {
+				if nested, ok := v.(map[string]interface{}); ok && len(nested) > 0 {
+					walk(prefix+k+".", nested)
+					continue
+				}
+				observe("@"+prefix+k, facetAttribute, v, service, found)
+			}
+		}
+		walk("", attrs.GetAttributes())
+	})
+	if err != nil {
+		return err
+	}
+
+	facets := make([]*facet, 0, len(seen))
+	for _, f := range seen {
+		f.Type = facetTypes(f.types)
+		f.Coverage = math.Round(float64(f.Logs)/float64(logs)*1000) / 10
+		f.Services = make([]string, 0, len(f.services))
+		for s := range f.services {
+			f.Services = append(f.Services, s)
+		}
+		sort.Slice(f.Services, func(i, j int) bool {
+			a, b := f.Services[i], f.Services[j]
+			if f.services[a] != f.services[b] {
+				return f.services[a] > f.services[b]
+			}
+			return a < b
+		})
+		facets = append(facets, f)
+	}
+	order := map[string]int{facetReserved: 0, facetTag: 1, facetAttribute: 2}
+	sort.Slice(facets, func(i, j int) bool {
+		if facets[i].Source != facets[j].Source {
+			return order[facets[i].Source] < order[facets[j].Source]
+		}
+		return facets[i].Name < facets[j].Name
+	})
+
+	out, err := createOutput(opts.OutputFile, "")
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	bw := bufio.NewWriter(out)
+	if err := writeFacets(bw, opts.Format, facets); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+	if opts.Quiet {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, lineStart()+"%d facet(s) in %d logs sampled across %d slices\n", len(facets), logs, slices)
+	if opts.OutputFile != "" {
+		fmt.Fprintf(os.Stderr, "Output written to %s\n", opts.OutputFile)
+	}
+	return nil
+}
+
+// writeFacets writes facets as an aligned table, a CSV table or a JSON
+// array.
+func writeFacets(bw *bufio.Writer, format string, facets []*facet) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(bw)
+		enc.SetIndent("", "  ")
+		return enc.Encode(facets)
+	case "csv":
+		w := csv.NewWriter(bw)
+		w.Write([]string{"name", "source", "type", "logs", "coverage", "services", "example"})
+		for _, f := range facets {
+			w.Write(csvRecord([]string{f.Name, f.Source, f.Type, strconv.Itoa(f.Logs),
+				strconv.FormatFloat(f.Coverage, 'f', -1, 64), strings.Join(f.Services, ";"), f.Example}, false))
+		}
+		w.Flush()
+		return w.Error()
+	}
+	tw := tabwriter.NewWriter(bw, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSOURCE\tTYPE\tLOGS\tSERVICES\tEXAMPLE")
+	for _, f := range facets {
+		services := strings.Join(f.Services[:min(len(f.Services), facetTableServices)], ",")
+		if more := len(f.Services) - facetTableServices; more > 0 {
+			services += fmt.Sprintf(" +%d", more)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%g%%\t%s\t%s\n", f.Name, f.Source, f.Type, f.Coverage, services,
+			tableText(truncateRunes(f.Example, 40)))
+	}
+	return tw.Flush()
+}
+
+// facetType is the type of an attribute value: number, boolean, string,
+// array or (when empty) object, or "" for null.
+func facetType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return ""
+	case float64, json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "string"
+}
+
+// facetTypes lists the types seen for a facet, sorted; several mean its
+// values disagree across logs.
+func facetTypes(types map[string]bool) string {
+	list := make([]string, 0, len(types))
+	for t := range types {
+		list = append(list, t)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
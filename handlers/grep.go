@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// grepWriter wraps another logWriter, passing on only the logs whose
+// message matches include (when set) and does not match exclude (when set).
+type grepWriter struct {
+	logWriter
+	include  *regexp.Regexp
+	exclude  *regexp.Regexp
+	filtered int
+	quiet    bool
+}
+
+func newGrepWriter(inner logWriter, include, exclude *regexp.Regexp) *grepWriter {
+	return &grepWriter{logWriter: inner, include: include, exclude: exclude}
+}
+
+func (w *grepWriter) WriteLog(log datadogV2.Log) error {
+	attrs := log.GetAttributes()
+	msg := attrs.GetMessage()
+	if (w.include != nil && !w.include.MatchString(msg)) || (w.exclude != nil && w.exclude.MatchString(msg)) {
+		w.filtered++
+		return nil
+	}
+	return w.logWriter.WriteLog(log)
+}
+
+func (w *grepWriter) End() error {
+	if err := w.logWriter.End(); err != nil {
+		return err
+	}
+	if !w.quiet {
+		fmt.Fprintf(os.Stderr, "\nGrep: %d log(s) filtered out\n", w.filtered)
+	}
+	return nil
+}
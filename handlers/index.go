@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// indexBatchSize is the number of documents sent to Bleve per batch.
+const indexBatchSize = 1000
+
+// rawField holds the original log JSON so local searches can hand complete
+// logs back to the CSV/JSON writers. It is stored but not indexed.
+const rawField = "_raw"
+
+// Index downloads every log matching query into a local Bleve full-text index
+// at dir, creating the index if it does not exist. Logs are keyed by their
+// Datadog ID, so re-indexing an overlapping window does not duplicate them.
+// quiet keeps progress and the summary off stderr.
+func (h *DDHandler) Index(query, from, to, dir string, quiet bool) error {
+	idx, err := openIndex(dir)
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+
+	writer := &indexWriter{index: idx, batch: idx.NewBatch()}
+	opts := QueryOptions{Query: query, From: from, To: to, Quiet: quiet}
+	if err := h.stream(h.apiContext(), opts, writer, nil, func(exportPosition) error { return nil }); err != nil {
+		return err
+	}
+
+	count, err := idx.DocCount()
+	if err != nil {
+		return fmt.Errorf("counting indexed logs: %w", err)
+	}
+	if !quiet {
+		fmt.Fprintf(os.Stderr, "Index %s now holds %d logs\n", dir, count)
+	}
+	return nil
+}
+
+// SearchIndex runs a Bleve query-string search against a local index built by
+// Index and writes up to limit matching logs, oldest first, in the given
+// format. An empty query matches every log; a limit of 0 returns all matches.
+// quiet keeps the summary off stderr.
+func SearchIndex(dir, queryString string, limit int, outputFile, format string, quiet bool) error {
+	idx, err := bleve.Open(dir)
+	if err != nil {
+		return fmt.Errorf("opening index %s: %w", dir, err)
+	}
+	defer idx.Close()
+
+	var q query.Query = bleve.NewMatchAllQuery()
+	if queryString != "" {
+		q = bleve.NewQueryStringQuery(queryString)
+	}
+
+	out, err := createOutput(outputFile, "")
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	bw := bufio.NewWriterSize(out, 256*1024)
+	defer bw.Flush()
+
+	writer := newLogWriter(QueryOptions{Format: format}, bw)
+	writer.Start()
+
+	written := 0
+	var total uint64
+	for {
+		size := int(maxLogsPerRequest)
+		if limit > 0 && limit-written < size {
+			size = limit - written
+		}
+		req := bleve.NewSearchRequestOptions(q, size, written, false)
+		req.Fields = []string{rawField}
+		req.SortBy([]string{"timestamp", "_id"})
+
+		res, err := idx.Search(req)
+		if err != nil {
+			return fmt.Errorf("searching index: %w", err)
+		}
+		total = res.Total
+
+		for _, hit := range res.Hits {
+			raw, ok := hit.Fields[rawField].(string)
+			if !ok {
+				return fmt.Errorf("index document %s has no stored log", hit.ID)
+			}
+			var log datadogV2.Log
+			if err := json.Unmarshal([]byte(raw), &log); err != nil {
+				return fmt.Errorf("decoding indexed log %s: %w", hit.ID, err)
+			}
+			if err := writer.WriteLog(log); err != nil {
+				return fmt.Errorf("writing log: %w", err)
+			}
+		}
+		if written == 0 {
+			if err := writer.FlushPage(); err != nil {
+				return fmt.Errorf("flushing first page: %w", err)
+			}
+		}
+		written += len(res.Hits)
+
+		if len(res.Hits) < size || (limit > 0 && written >= limit) {
+			break
+		}
+	}
+
+	if err := writer.End(); err != nil {
+		return fmt.Errorf("finishing output: %w", err)
+	}
+	if !quiet {
+		fmt.Fprintf(os.Stderr, "Done: %d of %d matching logs written\n", written, total)
+	}
+	return nil
+}
+
+// openIndex opens the Bleve index at dir, creating it when it does not exist.
+func openIndex(dir string) (bleve.Index, error) {
+	idx, err := bleve.Open(dir)
+	if err == bleve.ErrorIndexPathDoesNotExist {
+		idx, err = bleve.New(dir, newIndexMapping())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening index %s: %w", dir, err)
+	}
+	return idx, nil
+}
+
+// newIndexMapping indexes every field dynamically, types timestamp as a date so
+// results can be sorted chronologically, and keeps only the raw log stored.
+func newIndexMapping() mapping.IndexMapping {
+	raw := bleve.NewTextFieldMapping()
+	raw.Index = false
+	raw.Store = true
+	raw.IncludeInAll = false
+	raw.IncludeTermVectors = false
+
+	timestamp := bleve.NewDateTimeFieldMapping()
+
+	im := bleve.NewIndexMapping()
+	im.StoreDynamic = false
+	im.DefaultMapping.AddFieldMappingsAt(rawField, raw)
+	im.DefaultMapping.AddFieldMappingsAt("timestamp", timestamp)
+	return im
+}
+
+// --- Index writer ---
+
+// indexWriter is a logWriter that adds each log to a Bleve index in batches.
+type indexWriter struct {
+	index bleve.Index
+	batch *bleve.Batch
+}
+
+func (w *indexWriter) Start() {}
+
+func (w *indexWriter) WriteLog(log datadogV2.Log) error {
+	doc, err := newIndexDoc(log)
+	if err != nil {
+		return err
+	}
+	if err := w.batch.Index(log.GetId(), doc); err != nil {
+		return err
+	}
+	if w.batch.Size() >= indexBatchSize {
+		return w.flushBatch()
+	}
+	return nil
+}
+
+func (w *indexWriter) FlushPage() error { return w.flushBatch() }
+
+func (w *indexWriter) End() error { return w.flushBatch() }
+
+func (w *indexWriter) flushBatch() error {
+	if w.batch.Size() == 0 {
+		return nil
+	}
+	if err := w.index.Batch(w.batch); err != nil {
+		return fmt.Errorf("indexing batch: %w", err)
+	}
+	w.batch.Reset()
+	return nil
+}
+
+// newIndexDoc flattens a log into the document Bleve indexes. Custom
+// attributes sit at the top level, so they are searched as e.g.
+// http.status_code:500, alongside the fixed columns.
+func newIndexDoc(log datadogV2.Log) (map[string]interface{}, error) {
+	raw, err := json.Marshal(log)
+	if err != nil {
+		return nil, fmt.Errorf("encoding log %s: %w", log.GetId(), err)
+	}
+
+	attrs := log.GetAttributes()
+	doc := make(map[string]interface{}, len(attrs.GetAttributes())+7)
+	for k, v := range attrs.GetAttributes() {
+		doc[k] = v
+	}
+	if t, ok := attrs.GetTimestampOk(); ok && t != nil {
+		doc["timestamp"] = t.Format(time.RFC3339Nano)
+	}
+	doc["host"] = attrs.GetHost()
+	doc["service"] = attrs.GetService()
+	doc["status"] = attrs.GetStatus()
+	doc["message"] = attrs.GetMessage()
+	doc["tags"] = attrs.GetTags()
+	doc[rawField] = string(raw)
+	return doc, nil
+}
@@ -0,0 +1,486 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// DefaultInvestigateLimit is how many of the newest matching logs an
+// investigation reads.
+const DefaultInvestigateLimit = 10000
+
+// InvestigateOptions configures an Investigate.
+type InvestigateOptions struct {
+	Query      string
+	From       string
+	To         string
+	OutputFile string
+
+	// Template renders the brief (DefaultBriefTemplate when nil); see Brief
+	// for what it can use.
+	Template *template.Template
+
+	// Limit is how many of the newest logs are read
+	// (DefaultInvestigateLimit when zero); the match count covers them all.
+	Limit int
+
+	// Top is how many error patterns, hosts and services are listed
+	// (10 when zero).
+	Top int
+
+	// Bucket is the timeline interval; when zero one is picked for about
+	// 24 rows.
+	Bucket time.Duration
+
+	// Redact masks matches in messages, tags and attributes before
+	// anything is counted or quoted.
+	Redact []*regexp.Regexp
+
+	// TimeZone is the zone times are shown in (UTC when nil).
+	TimeZone *time.Location
+}
+
+// Brief is what an investigation template is executed with.
+type Brief struct {
+	Query     string
+	From      time.Time
+	To        time.Time
+	Generated time.Time
+
+	// Matched is how many logs match in the window; Analyzed how many of
+	// the newest were read, fewer than Matched when Truncated.
+	Matched   int64
+	Analyzed  int
+	Truncated bool
+
+	// Errors is how many analyzed logs have status error, critical,
+	// alert, emergency or fatal, seen first at FirstError and last at
+	// LastError.
+	Errors     int
+	FirstError time.Time
+	LastError  time.Time
+
+	// Timeline has one row per Bucket from From to To; PeakLogs and
+	// PeakErrors are its largest counts, for scaling bars.
+	Bucket     time.Duration
+	Timeline   []BriefBucket
+	PeakLogs   int
+	PeakErrors int
+
+	// TopErrors groups error messages with numbers, IDs, addresses and
+	// quoted values masked, most frequent first.
+	TopErrors []BriefError
+
+	Statuses []BriefGroup
+	Hosts    []BriefGroup
+	Services []BriefGroup
+}
+
+// BriefBucket is one timeline row.
+type BriefBucket struct {
+	Start  time.Time
+	Count  int
+	Errors int
+}
+
+// BriefError is one group of error messages.
+type BriefError struct {
+	Pattern  string
+	Count    int
+	Services []string
+	Hosts    []string
+	First    time.Time
+	Last     time.Time
+
+	// Example is the newest message of the group, ExampleID its log.
+	Example   string
+	ExampleID string
+}
+
+// BriefGroup counts the logs of one status, host or service.
+type BriefGroup struct {
+	Name     string
+	Count    int
+	Errors   int
+	Services []string
+}
+
+// errorStatuses are the statuses a brief counts as errors.
+var errorStatuses = map[string]bool{"error": true, "critical": true, "alert": true, "emergency": true, "fatal": true}
+
+// Investigate reads the newest logs matching opts and renders a Markdown
+// investigation brief: a timeline, the top error patterns and the hosts
+// and services they come from, for pasting into an LLM or a postmortem.
+func (h *DDHandler) Investigate(opts InvestigateOptions) error {
+	start, end, err := ResolveTimeRange(opts.From, opts.To)
+	if err != nil {
+		return err
+	}
+	if !start.Before(end) {
+		return fmt.Errorf("--from must be before --to")
+	}
+	from, to := start.UTC().Format(time.RFC3339Nano), end.UTC().Format(time.RFC3339Nano)
+	if opts.Bucket > 0 && end.Sub(start)/opts.Bucket > 1000 {
+		return fmt.Errorf("--bucket %s would draw over 1000 timeline rows; use a longer one", opts.Bucket)
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultInvestigateLimit
+	}
+	tmpl := opts.Template
+	if tmpl == nil {
+		tmpl = template.Must(ParseBriefTemplate(DefaultBriefTemplate))
+	}
+
+	ctx := h.apiContext()
+	matched, err := h.countLogs(ctx, h.logsAPI(), opts.Query, toDatadogTime(from), toDatadogTime(to), nil)
+	if err != nil {
+		return err
+	}
+	b := newBriefBuilder(opts, start, end)
+	redactedValues := 0
+	fmt.Fprintf(os.Stderr, "Reading up to %d of %d matching logs, newest first\n", min(int64(limit), matched), matched)
+	err = h.StreamLogs(context.Background(), QueryOptions{
+		Query:      opts.Query,
+		From:       from,
+		To:         to,
+		Limit:      limit,
+		Descending: true,
+		Redact:     opts.Redact,
+		Redacted:   &redactedValues,
+	}, func(logs []datadogV2.Log) error {
+		for _, log := range logs {
+			b.add(log)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	brief := b.finish(matched)
+
+	out, err := createOutput(opts.OutputFile, "")
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if err := tmpl.Execute(out, brief); err != nil {
+		return fmt.Errorf("rendering brief: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("closing output: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Brief: analyzed %d of %d matching logs, %d error(s) in %d pattern(s)\n", brief.Analyzed, brief.Matched, brief.Errors, len(b.errors))
+	if len(opts.Redact) > 0 {
+		fmt.Fprintf(os.Stderr, "Redaction: %d value(s) redacted\n", redactedValues)
+	}
+	if opts.OutputFile != "" {
+		fmt.Fprintf(os.Stderr, "Output written to %s\n", opts.OutputFile)
+	}
+	return nil
+}
+
+// briefBuilder accumulates a Brief one log at a time.
+type briefBuilder struct {
+	brief *Brief
+	top   int
+	loc   *time.Location
+
+	errors   map[string]*BriefError
+	statuses map[string]*BriefGroup
+	hosts    map[string]*BriefGroup
+	services map[string]*BriefGroup
+}
+
+func newBriefBuilder(opts InvestigateOptions, start, end time.Time) *briefBuilder {
+	loc := opts.TimeZone
+	if loc == nil {
+		loc = time.UTC
+	}
+	bucket := opts.Bucket
+	if bucket <= 0 {
+		bucket = timelineBucket(end.Sub(start))
+	}
+	top := opts.Top
+	if top <= 0 {
+		top = 10
+	}
+	brief := &Brief{
+		Query:     opts.Query,
+		From:      start.In(loc),
+		To:        end.In(loc),
+		Generated: time.Now().In(loc),
+		Bucket:    bucket,
+	}
+	for t := start.Truncate(bucket); t.Before(end); t = t.Add(bucket) {
+		brief.Timeline = append(brief.Timeline, BriefBucket{Start: t.In(loc)})
+	}
+	return &briefBuilder{
+		brief:    brief,
+		top:      top,
+		loc:      loc,
+		errors:   make(map[string]*BriefError),
+		statuses: make(map[string]*BriefGroup),
+		hosts:    make(map[string]*BriefGroup),
+		services: make(map[string]*BriefGroup),
+	}
+}
+
+// timelineBuckets are the intervals a timeline is drawn in.
+var timelineBuckets = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute, 30 * time.Minute, time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour, day, week}
+
+// timelineBucket returns the shortest interval that draws span in at most
+// 24 rows.
+func timelineBucket(span time.Duration) time.Duration {
+	for _, d := range timelineBuckets {
+		if span <= 24*d {
+			return d
+		}
+	}
+	return timelineBuckets[len(timelineBuckets)-1]
+}
+
+func (b *briefBuilder) add(log datadogV2.Log) {
+	attrs := log.GetAttributes()
+	var ts time.Time
+	if t, ok := attrs.GetTimestampOk(); ok && t != nil {
+		ts = t.In(b.loc)
+	}
+	status := strings.ToLower(attrs.GetStatus())
+	isError := errorStatuses[status]
+	service := attrs.GetService()
+
+	b.brief.Analyzed++
+	group(b.statuses, status, "", isError)
+	group(b.hosts, attrs.GetHost(), service, isError)
+	group(b.services, service, "", isError)
+	if timeline := b.brief.Timeline; len(timeline) > 0 && !ts.IsZero() {
+		i := int(ts.Sub(timeline[0].Start) / b.brief.Bucket)
+		if i >= 0 && i < len(timeline) {
+			timeline[i].Count++
+			if isError {
+				timeline[i].Errors++
+			}
+		}
+	}
+	if !isError {
+		return
+	}
+
+	b.brief.Errors++
+	if b.brief.FirstError.IsZero() || ts.Before(b.brief.FirstError) {
+		b.brief.FirstError = ts
+	}
+	if ts.After(b.brief.LastError) {
+		b.brief.LastError = ts
+	}
+	pattern := messagePattern(attrs.GetMessage())
+	e := b.errors[pattern]
+	if e == nil {
+		e = &BriefError{Pattern: pattern, First: ts, Last: ts}
+		b.errors[pattern] = e
+	}
+	e.Count++
+	if ts.Before(e.First) {
+		e.First = ts
+	}
+	if !ts.Before(e.Last) || e.Example == "" {
+		e.Last = ts
+		e.Example = truncateRunes(strings.TrimSpace(attrs.GetMessage()), 2000)
+		e.ExampleID = log.GetId()
+	}
+	if service != "" && !containsString(e.Services, service) {
+		e.Services = append(e.Services, service)
+	}
+	if host := attrs.GetHost(); host != "" && !containsString(e.Hosts, host) {
+		e.Hosts = append(e.Hosts, host)
+	}
+}
+
+// group counts one log under name in groups, noting service when set.
+func group(groups map[string]*BriefGroup, name, service string, isError bool) {
+	if name == "" {
+		name = "(none)"
+	}
+	g := groups[name]
+	if g == nil {
+		g = &BriefGroup{Name: name}
+		groups[name] = g
+	}
+	g.Count++
+	if isError {
+		g.Errors++
+	}
+	if service != "" && !containsString(g.Services, service) {
+		g.Services = append(g.Services, service)
+	}
+}
+
+func (b *briefBuilder) finish(matched int64) *Brief {
+	brief := b.brief
+	brief.Matched = max(matched, int64(brief.Analyzed))
+	brief.Truncated = brief.Matched > int64(brief.Analyzed)
+	for _, bucket := range brief.Timeline {
+		brief.PeakLogs = max(brief.PeakLogs, bucket.Count)
+		brief.PeakErrors = max(brief.PeakErrors, bucket.Errors)
+	}
+	for _, e := range b.errors {
+		sort.Strings(e.Services)
+		sort.Strings(e.Hosts)
+		brief.TopErrors = append(brief.TopErrors, *e)
+	}
+	sort.Slice(brief.TopErrors, func(i, j int) bool {
+		a, c := brief.TopErrors[i], brief.TopErrors[j]
+		if a.Count != c.Count {
+			return a.Count > c.Count
+		}
+		return a.Pattern < c.Pattern
+	})
+	if len(brief.TopErrors) > b.top {
+		brief.TopErrors = brief.TopErrors[:b.top]
+	}
+	// Statuses are few; all of them are listed.
+	brief.Statuses = topGroups(b.statuses, len(b.statuses), false)
+	brief.Hosts = topGroups(b.hosts, b.top, brief.Errors > 0)
+	brief.Services = topGroups(b.services, b.top, brief.Errors > 0)
+	return brief
+}
+
+// topGroups returns the n largest groups, by errors first when byErrors
+// is set.
+func topGroups(groups map[string]*BriefGroup, n int, byErrors bool) []BriefGroup {
+	list := make([]BriefGroup, 0, len(groups))
+	for _, g := range groups {
+		sort.Strings(g.Services)
+		list = append(list, *g)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if byErrors && a.Errors != b.Errors {
+			return a.Errors > b.Errors
+		}
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Name < b.Name
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// messageVariables are the parts of a message that differ between logs of
+// the same error, most specific first.
+var messageVariables = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`"[^"]*"`), `"{s}"`},
+	{regexp.MustCompile(`'[^']*'`), `'{s}'`},
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), "{uuid}"},
+	{regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}(?::\d+)?\b`), "{ip}"},
+	{regexp.MustCompile(`(?i)\b(?:0x[0-9a-f]+|[0-9a-f]{8,})\b`), "{hex}"},
+	{regexp.MustCompile(`\d+(?:\.\d+)?`), "{n}"},
+}
+
+// messagePattern returns the first line of message with its variable parts
+// masked, so occurrences of one error group together.
+func messagePattern(message string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(message), "\n")
+	for _, v := range messageVariables {
+		line = v.re.ReplaceAllString(line, v.repl)
+	}
+	if line = truncateRunes(strings.TrimSpace(line), 200); line == "" {
+		return "(empty message)"
+	}
+	return line
+}
+
+// briefFuncs are available to investigation templates, with those of
+// --template.
+var briefFuncs = template.FuncMap{
+	// md escapes a value for a Markdown table cell.
+	"md": func(v interface{}) string { return markdownCell(fmt.Sprint(v)) },
+	// join joins strings with ", ".
+	"join": func(s []string) string { return strings.Join(s, ", ") },
+	// inc adds one, for numbering from a range index.
+	"inc": func(i int) int { return i + 1 },
+	// pct renders n as a percentage of total.
+	"pct": func(n int, total int) string {
+		if total == 0 {
+			return "0%"
+		}
+		return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(total))
+	},
+	// bar draws n as a bar of up to width blocks, scaled to peak.
+	"bar": func(n, peak, width int) string {
+		if n <= 0 || peak <= 0 {
+			return ""
+		}
+		return strings.Repeat("█", max(1, n*width/peak))
+	},
+}
+
+// ParseBriefTemplate parses an investigation --prompt-template.
+func ParseBriefTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("brief").Funcs(templateFuncs).Funcs(briefFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// DefaultBriefTemplate is the brief investigate writes without
+// --prompt-template.
+const DefaultBriefTemplate = `# Investigation: {{.Query}}
+
+- **Window:** {{.From.Format "2006-01-02 15:04:05 MST"}} to {{.To.Format "2006-01-02 15:04:05 MST"}}
+- **Logs:** {{.Matched}} matched{{if .Truncated}}; the newest {{.Analyzed}} were analyzed{{end}}
+- **Errors:** {{.Errors}} ({{pct .Errors .Analyzed}} of analyzed){{if .Errors}}, first at {{.FirstError.Format "15:04:05"}}, last at {{.LastError.Format "15:04:05"}}{{end}}
+- **Statuses:** {{range $i, $s := .Statuses}}{{if $i}}, {{end}}{{$s.Name}} {{$s.Count}}{{end}}
+
+## Timeline
+
+Logs per {{.Bucket}}{{if .Truncated}} (analyzed logs only){{end}}.
+
+| Start | Logs | Errors | |
+|---|---:|---:|---|
+{{range .Timeline}}| {{.Start.Format "2006-01-02 15:04"}} | {{.Count}} | {{.Errors}} | {{bar .Errors $.PeakErrors 20}} |
+{{end}}
+## Top Errors
+{{if not .TopErrors}}
+No logs with status error, critical, alert, emergency or fatal.
+{{else}}
+| # | Count | Pattern | Services | Hosts | First seen | Last seen |
+|---:|---:|---|---|---|---|---|
+{{range $i, $e := .TopErrors}}| {{inc $i}} | {{.Count}} | {{md .Pattern}} | {{md (join .Services)}} | {{len .Hosts}} | {{.First.Format "15:04:05"}} | {{.Last.Format "15:04:05"}} |
+{{end}}{{range $i, $e := .TopErrors}}
+### {{inc $i}}. {{.Pattern}}
+
+Newest occurrence, log {{.ExampleID}}:
+
+` + "```" + `
+{{.Example}}
+` + "```" + `
+{{end}}{{end}}
+## Affected Hosts
+
+| Host | Logs | Errors | Services |
+|---|---:|---:|---|
+{{range .Hosts}}| {{md .Name}} | {{.Count}} | {{.Errors}} | {{md (join .Services)}} |
+{{end}}
+## Services
+
+| Service | Logs | Errors |
+|---|---:|---:|
+{{range .Services}}| {{md .Name}} | {{.Count}} | {{.Errors}} |
+{{end}}`
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// iocMatchAttr is the attribute added to every log when matching
+// indicators, so it shows up as a column in CSV/Parquet and inside the
+// attributes of JSON output.
+const iocMatchAttr = "ioc_match"
+
+// iocSet is a list of threat-intel indicators: IP addresses, CIDR ranges
+// and domains. A domain also matches its subdomains.
+type iocSet struct {
+	ips     map[string]bool
+	nets    []*net.IPNet
+	domains map[string]bool
+}
+
+// loadIOCs reads one indicator per line. Blank lines and lines starting
+// with # are ignored; anything after whitespace on a line is a comment.
+func loadIOCs(path string) (*iocSet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading indicators: %w", err)
+	}
+	defer f.Close()
+
+	set := &iocSet{ips: make(map[string]bool), domains: make(map[string]bool)}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		ioc := strings.ToLower(fields[0])
+		switch {
+		case strings.Contains(ioc, "/"):
+			_, n, err := net.ParseCIDR(ioc)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid CIDR %q", path, line, ioc)
+			}
+			set.nets = append(set.nets, n)
+		case net.ParseIP(ioc) != nil:
+			set.ips[net.ParseIP(ioc).String()] = true
+		default:
+			set.domains[strings.TrimSuffix(strings.TrimPrefix(ioc, "*."), ".")] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading indicators: %w", err)
+	}
+	return set, nil
+}
+
+func (s *iocSet) size() int { return len(s.ips) + len(s.nets) + len(s.domains) }
+
+// match reports which indicator, if any, value matches. value may be an IP,
+// a host name or a URL.
+func (s *iocSet) match(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" || len(value) > 2048 {
+		return "", false
+	}
+	if ip := net.ParseIP(value); ip != nil {
+		if s.ips[ip.String()] {
+			return "ip " + ip.String(), true
+		}
+		for _, n := range s.nets {
+			if n.Contains(ip) {
+				return "cidr " + n.String(), true
+			}
+		}
+		return "", false
+	}
+
+	host := value
+	if strings.Contains(value, "://") {
+		u, err := url.Parse(value)
+		if err != nil {
+			return "", false
+		}
+		host = u.Hostname()
+		if ip := net.ParseIP(host); ip != nil {
+			return s.match(host)
+		}
+	}
+	if strings.ContainsAny(host, " /:@") || !strings.Contains(host, ".") {
+		return "", false
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for d := host; ; {
+		if s.domains[d] {
+			return "domain " + d, true
+		}
+		i := strings.IndexByte(d, '.')
+		if i < 0 {
+			return "", false
+		}
+		d = d[i+1:]
+	}
+}
+
+// matchLog checks every string attribute of log, at any depth, and returns
+// the reasons for each match as "path=value (indicator)", sorted.
+func (s *iocSet) matchLog(attrs map[string]interface{}) []string {
+	var reasons []string
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		switch val := v.(type) {
+		case string:
+			if why, ok := s.match(val); ok {
+				reasons = append(reasons, fmt.Sprintf("%s=%s (%s)", prefix, val, why))
+			}
+		case map[string]interface{}:
+			for k, child := range val {
+				if prefix == "" {
+					walk(k, child)
+				} else {
+					walk(prefix+"."+k, child)
+				}
+			}
+		case []interface{}:
+			for _, child := range val {
+				walk(prefix, child)
+			}
+		}
+	}
+	walk("", attrs)
+	sort.Strings(reasons)
+	return reasons
+}
+
+// iocWriter wraps another logWriter, recording in the ioc_match attribute
+// why each log matched the indicator list (empty when it did not). With
+// only set, logs that match nothing are dropped instead.
+type iocWriter struct {
+	logWriter
+	iocs    *iocSet
+	only    bool
+	matched int
+	quiet   bool
+}
+
+func newIOCWriter(inner logWriter, iocs *iocSet, only bool) *iocWriter {
+	return &iocWriter{logWriter: inner, iocs: iocs, only: only}
+}
+
+func (w *iocWriter) WriteLog(log datadogV2.Log) error {
+	attrs := log.GetAttributes()
+	reasons := w.iocs.matchLog(attrs.GetAttributes())
+	if len(reasons) > 0 {
+		w.matched++
+	} else if w.only {
+		return nil
+	}
+
+	custom := attrs.GetAttributes()
+	if custom == nil {
+		custom = make(map[string]interface{})
+	}
+	custom[iocMatchAttr] = strings.Join(reasons, "; ")
+	attrs.SetAttributes(custom)
+	log.SetAttributes(attrs)
+	return w.logWriter.WriteLog(log)
+}
+
+func (w *iocWriter) End() error {
+	if err := w.logWriter.End(); err != nil {
+		return err
+	}
+	if !w.quiet {
+		fmt.Fprintf(os.Stderr, "\nIndicators: %d log(s) matched\n", w.matched)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package handlers
+
+import "strings"
+
+// K8sTagColumns are the tags the Datadog Agent attaches to container logs
+// that identify where a log came from, exported as columns by --k8s-columns.
+var K8sTagColumns = []string{"kube_namespace", "pod_name", "kube_container_name"}
+
+// K8sQuery builds the query terms for the --k8s-namespace and
+// --k8s-deployment shortcuts, using the Agent's standard kube_* tags.
+// Empty values are skipped.
+func K8sQuery(namespace, deployment string) string {
+	var terms []string
+	if namespace != "" {
+		terms = append(terms, "kube_namespace:"+namespace)
+	}
+	if deployment != "" {
+		terms = append(terms, "kube_deployment:"+deployment)
+	}
+	return strings.Join(terms, " ")
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// lintWideWindow is the window above which unselective queries are flagged:
+// over a day of Flex logs they scan far more data than they return.
+const lintWideWindow = 24 * time.Hour
+
+// QueryLint is one expensive pattern found in a query, with a narrower
+// alternative.
+type QueryLint struct {
+	Problem    string
+	Suggestion string
+}
+
+func (l QueryLint) String() string {
+	return l.Problem + "; " + l.Suggestion
+}
+
+// queryTerm is one search term: an optional key (tag or @attribute) and the
+// value matched against it. Terms without a key are free text.
+type queryTerm struct {
+	key     string
+	value   string
+	negated bool
+}
+
+// LintQuery checks query for patterns known to be slow on Flex storage —
+// leading wildcards, and free text without any key:value filter or no
+// filter at all over windows longer than a day — for the range from..to
+// given as --from/--to values.
+func LintQuery(query, from, to string) []QueryLint {
+	var lints []QueryLint
+	terms := queryTerms(query)
+
+	var window time.Duration
+	now := time.Now()
+	f, fromErr := resolveTime(from, now)
+	t, toErr := resolveTime(to, now)
+	if fromErr == nil && toErr == nil {
+		window = t.Sub(f)
+	}
+	wide := window > lintWideWindow
+
+	positive := false
+	for _, term := range terms {
+		if !term.negated && term.key != "" && term.value != "*" {
+			positive = true
+		}
+	}
+	for _, term := range terms {
+		name := term.value
+		if term.key != "" {
+			name = term.key + ":" + term.value
+		}
+		if len(term.value) > 1 && strings.HasPrefix(term.value, "*") {
+			lints = append(lints, QueryLint{
+				Problem:    fmt.Sprintf("leading wildcard in %s has to test every value", name),
+				Suggestion: fmt.Sprintf("match a prefix (%s*) or the exact value instead", strings.Trim(term.value, "*")),
+			})
+		}
+		if term.key == "" && term.value != "*" && !term.negated && !positive && wide {
+			lints = append(lints, QueryLint{
+				Problem:    fmt.Sprintf("free-text term %q is matched against every message over %s", term.value, datadogDuration(window)),
+				Suggestion: "add a service:, source: or @attribute filter, or shorten --from",
+			})
+		}
+	}
+	if !positive && wide && !hasFreeText(terms) {
+		lints = append(lints, QueryLint{
+			Problem:    fmt.Sprintf("query has no positive filter, so it reads nearly every log over %s", datadogDuration(window)),
+			Suggestion: "add service:, source: or env: filters, or shorten --from",
+		})
+	}
+	return lints
+}
+
+// hasFreeText reports whether any positive term is free text (already
+// reported on its own).
+func hasFreeText(terms []queryTerm) bool {
+	for _, t := range terms {
+		if t.key == "" && t.value != "*" && !t.negated {
+			return true
+		}
+	}
+	return false
+}
+
+// queryTerms splits a Datadog search query into terms. Boolean operators
+// are dropped, quoted phrases are kept whole, and values inside a
+// key:(a OR b) group take the group's key.
+func queryTerms(query string) []queryTerm {
+	type group struct {
+		key     string
+		negated bool
+	}
+	var (
+		terms   []queryTerm
+		groups  []group
+		buf     strings.Builder
+		inQuote bool
+	)
+	current := func() group {
+		if len(groups) == 0 {
+			return group{}
+		}
+		return groups[len(groups)-1]
+	}
+	flush := func() {
+		tok := buf.String()
+		buf.Reset()
+		if tok == "" || tok == "AND" || tok == "OR" || tok == "NOT" {
+			return
+		}
+		g := current()
+		term := queryTerm{key: g.key, negated: g.negated}
+		if strings.HasPrefix(tok, "-") || strings.HasPrefix(tok, "!") {
+			term.negated = !term.negated
+			tok = tok[1:]
+		}
+		if i := keySeparator(tok); i > 0 {
+			term.key, tok = tok[:i], tok[i+1:]
+		}
+		term.value = strings.Trim(tok, `"`)
+		if term.value != "" {
+			terms = append(terms, term)
+		}
+	}
+
+	prev := rune(0)
+	for _, r := range query {
+		switch {
+		case r == '"' && prev != '\\':
+			inQuote = !inQuote
+			buf.WriteRune(r)
+		case inQuote:
+			buf.WriteRune(r)
+		case r == '(':
+			g := current()
+			tok := buf.String()
+			if strings.HasSuffix(tok, ":") {
+				buf.Reset()
+				g.key = strings.TrimSuffix(tok, ":")
+				if strings.HasPrefix(g.key, "-") || strings.HasPrefix(g.key, "!") {
+					g.key = g.key[1:]
+					g.negated = !g.negated
+				}
+			} else {
+				flush()
+			}
+			groups = append(groups, g)
+		case r == ')':
+			flush()
+			if len(groups) > 0 {
+				groups = groups[:len(groups)-1]
+			}
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			buf.WriteRune(r)
+		}
+		prev = r
+	}
+	flush()
+	return terms
+}
+
+// keySeparator returns the index of the colon separating a key from its
+// value in tok, ignoring escaped colons and colons inside a phrase, or -1.
+func keySeparator(tok string) int {
+	if strings.HasPrefix(tok, `"`) {
+		return -1
+	}
+	for i := 0; i < len(tok); i++ {
+		switch tok[i] {
+		case '\\':
+			i++
+		case ':':
+			return i
+		}
+	}
+	return -1
+}
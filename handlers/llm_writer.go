@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// DefaultMaxTokens is the --max-tokens budget of --llm output.
+const DefaultMaxTokens = 32000
+
+// errOutputFull is returned by a writer's FlushPage once it will take no
+// more logs. The export stops fetching and ends cleanly, as if the range
+// had run out.
+var errOutputFull = errors.New("output full")
+
+// llmWriter writes CSV sized for a language model's context window. Logs
+// are held until the output is laid out: columns empty in every log are
+// dropped, columns and tags with the same value in every log move to a
+// "# same in every log:" line above the header, and rows are written while
+// the estimated token count stays within budget. Fetching stops once the
+// buffered logs alone would overrun it. Long values are cut before they
+// get here, by the --max-message-len and --max-cell-len defaults of --llm.
+type llmWriter struct {
+	bw        *bufio.Writer
+	fixed     []string
+	tagCols   []string
+	budget    int
+	tokenizer *Tokenizer
+
+	timeFormat *TimeFormat
+
+	attrSet map[string]bool
+	logs    []datadogV2.Log
+	full    bool
+}
+
+func newLLMWriter(bw *bufio.Writer, budget int, tokenizer *Tokenizer) *llmWriter {
+	if budget <= 0 {
+		budget = DefaultMaxTokens
+	}
+	if tokenizer == nil {
+		tokenizer = Tokenizers[0]
+	}
+	return &llmWriter{bw: bw, fixed: fixedColumns, budget: budget, tokenizer: tokenizer, attrSet: make(map[string]bool)}
+}
+
+func (w *llmWriter) Start() {}
+
+func (w *llmWriter) WriteLog(log datadogV2.Log) error {
+	attrs := log.GetAttributes()
+	for key := range attrs.GetAttributes() {
+		w.attrSet[key] = true
+	}
+	w.logs = append(w.logs, log)
+	return nil
+}
+
+func (w *llmWriter) FlushPage() error {
+	if l := w.layout(w.logs); l.fits(w.budget) < len(w.logs) {
+		w.full = true
+		return errOutputFull
+	}
+	return nil
+}
+
+func (w *llmWriter) End() error {
+	l := w.layout(w.logs)
+	shown := l.fits(w.budget)
+	cut := w.full || shown < len(w.logs)
+	if cut {
+		// Leave room for the closing note. Fewer rows may share more
+		// values, which only makes them cheaper.
+		shown = l.fits(w.budget - w.tokenizer.Estimate(w.stopNote(len(w.logs))))
+		l = w.layout(w.logs[:shown])
+	}
+	for _, line := range l.preamble {
+		w.bw.WriteString(line)
+	}
+	if len(l.rows) > 0 {
+		w.bw.WriteString(l.header)
+	}
+	for _, row := range l.rows {
+		w.bw.WriteString(row.line)
+	}
+	note := ""
+	if cut {
+		note = w.stopNote(shown)
+		w.bw.WriteString(note)
+	}
+	tokens := l.tokens() + w.tokenizer.Estimate(note)
+	fmt.Fprintf(os.Stderr, "\nLLM: ~%d tokens (%s estimate) for %d log(s)", tokens, w.tokenizer.Name, shown)
+	if len(l.dropped) > 0 {
+		fmt.Fprintf(os.Stderr, "; dropped empty: %s", strings.Join(l.dropped, ", "))
+	}
+	if len(l.constant) > 0 {
+		fmt.Fprintf(os.Stderr, "; same in every log: %s", strings.Join(l.constant, ", "))
+	}
+	fmt.Fprintln(os.Stderr)
+	return nil
+}
+
+// stopNote is the last line of output cut short after shown logs.
+func (w *llmWriter) stopNote(shown int) string {
+	return fmt.Sprintf("# stopped after %d logs to stay within %d tokens; more logs matched\n", shown, w.budget)
+}
+
+// llmLayout is the output for a set of logs, line by line with the
+// estimated tokens of each.
+type llmLayout struct {
+	preamble []string
+	header   string
+	rows     []llmRow
+
+	fixedTokens int
+	dropped     []string
+	constant    []string
+}
+
+type llmRow struct {
+	line   string
+	tokens int
+}
+
+// fits returns how many rows fit in budget tokens with the preamble and
+// header.
+func (l *llmLayout) fits(budget int) int {
+	used := l.fixedTokens
+	for i, row := range l.rows {
+		if used += row.tokens; used > budget {
+			return i
+		}
+	}
+	return len(l.rows)
+}
+
+func (l *llmLayout) tokens() int {
+	n := l.fixedTokens
+	for _, row := range l.rows {
+		n += row.tokens
+	}
+	return n
+}
+
+// layout chooses the columns for logs and renders them.
+func (w *llmWriter) layout(logs []datadogV2.Log) *llmLayout {
+	l := &llmLayout{}
+	headers := headerColumns(w.fixed, w.tagCols, w.attrSet)
+	cells := make([][]string, len(logs))
+	tagCount := make(map[string]int)
+	for i, log := range logs {
+		attrs := log.GetAttributes()
+		custom := attrs.GetAttributes()
+		row := make([]string, len(headers))
+		for j, col := range headers {
+			if j >= len(w.fixed) && j < len(w.fixed)+len(w.tagCols) {
+				row[j] = tagValue(attrs.GetTags(), col)
+				continue
+			}
+			if col == "timestamp" && w.timeFormat != nil {
+				row[j] = w.timeFormat.timestamp(&attrs)
+				continue
+			}
+			row[j] = columnValue(&attrs, custom, col)
+		}
+		cells[i] = row
+		seen := make(map[string]bool)
+		for _, tag := range attrs.GetTags() {
+			if !seen[tag] {
+				seen[tag] = true
+				tagCount[tag]++
+			}
+		}
+	}
+
+	// Tags every log carries say nothing about any one of them.
+	tagsCol := -1
+	for j, col := range headers[:len(w.fixed)] {
+		if col == "tags" {
+			tagsCol = j
+		}
+	}
+	var sharedTags []string
+	if tagsCol >= 0 && len(logs) > 1 {
+		for _, tag := range logs[0].GetAttributes().Tags {
+			if tagCount[tag] == len(logs) && !containsString(sharedTags, tag) {
+				sharedTags = append(sharedTags, tag)
+			}
+		}
+	}
+	if len(sharedTags) > 0 {
+		for i, log := range logs {
+			var rest []string
+			for _, tag := range log.GetAttributes().Tags {
+				if !containsString(sharedTags, tag) {
+					rest = append(rest, tag)
+				}
+			}
+			cells[i][tagsCol] = strings.Join(rest, ";")
+		}
+	}
+
+	var keep []int
+	var same []string
+	for j, col := range headers {
+		empty, constant := true, len(logs) > 1
+		for _, row := range cells {
+			if row[j] != "" {
+				empty = false
+			}
+			if row[j] != cells[0][j] {
+				constant = false
+			}
+		}
+		switch {
+		case j == tagsCol && empty && len(sharedTags) > 0:
+			// Every tag is shared; they are all above the header.
+		case empty:
+			l.dropped = append(l.dropped, col)
+		case constant:
+			l.constant = append(l.constant, col)
+			same = append(same, col+"="+cells[0][j])
+		default:
+			keep = append(keep, j)
+		}
+	}
+	if len(sharedTags) > 0 {
+		l.constant = append(l.constant, "tags "+strings.Join(sharedTags, ";"))
+		same = append(same, "tags="+strings.Join(sharedTags, ";"))
+	}
+	if len(same) > 0 {
+		l.preamble = append(l.preamble, "# same in every log: "+strings.Join(same, ", ")+"\n")
+	}
+
+	record := make([]string, len(keep))
+	for k, j := range keep {
+		record[k] = headers[j]
+	}
+	l.header = csvLine(record)
+	for _, line := range l.preamble {
+		l.fixedTokens += w.tokenizer.Estimate(line)
+	}
+	l.fixedTokens += w.tokenizer.Estimate(l.header)
+	for _, row := range cells {
+		for k, j := range keep {
+			record[k] = row[j]
+		}
+		line := csvLine(record)
+		l.rows = append(l.rows, llmRow{line: line, tokens: w.tokenizer.Estimate(line)})
+	}
+	return l
+}
+
+// csvLine encodes one CSV record, line break included.
+func csvLine(record []string) string {
+	var b strings.Builder
+	cw := csv.NewWriter(&b)
+	cw.Write(csvRecord(record, false))
+	cw.Flush()
+	return b.String()
+}
@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"github.com/parquet-go/parquet-go"
+)
+
+// LocalQueryOptions configures a query of an archive kept by "ddlogs
+// archive run".
+type LocalQueryOptions struct {
+	// Dir is the archive: a local directory or s3://<bucket>/<prefix>.
+	Dir string
+
+	// Query is a Datadog search query; see compileQuery for the syntax
+	// supported. Empty matches everything.
+	Query string
+
+	// From and To bound the logs' timestamps like --from/--to. An empty
+	// From starts at the beginning of the archive.
+	From string
+	To   string
+
+	Limit      int
+	OutputFile string
+	Format     string
+	Quiet      bool
+}
+
+// LocalQuery searches the parts of an archive for the logs matching
+// opts.Query, oldest first, and writes them like search does. Only the
+// days overlapping the time range are read.
+func LocalQuery(opts LocalQueryOptions) error {
+	match, err := compileQuery(opts.Query)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	var from time.Time
+	if opts.From != "" {
+		if from, err = resolveTime(opts.From, now); err != nil {
+			return fmt.Errorf("--from: %w", err)
+		}
+	}
+	to, err := resolveTime(opts.To, now)
+	if err != nil {
+		return fmt.Errorf("--to: %w", err)
+	}
+	if !strings.HasPrefix(opts.Dir, "s3://") {
+		if _, err := os.Stat(opts.Dir); err != nil {
+			return fmt.Errorf("opening archive: %w", err)
+		}
+	}
+	store, err := openArchiveStore(opts.Dir)
+	if err != nil {
+		return err
+	}
+	state, err := loadArchiveState(store)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("%s is not an archive written by ddlogs archive run (no %s)", store.location(""), archiveStateFile)
+	}
+	objects, err := store.list()
+	if err != nil {
+		return err
+	}
+	objects = archiveQueryParts(objects, state)
+
+	var days []time.Time
+	partsByDay := archiveDays(objects)
+	for day := range partsByDay {
+		if day.Add(24*time.Hour).After(from) && day.Before(to) {
+			days = append(days, day)
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	if !opts.Quiet {
+		parts := 0
+		for _, day := range days {
+			parts += len(partsByDay[day])
+		}
+		fmt.Fprintf(os.Stderr, "Scanning %d parts over %d days of %s\n", parts, len(days), store.location(""))
+	}
+
+	out, err := createOutput(opts.OutputFile, "")
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	bw := bufio.NewWriterSize(out, 256*1024)
+	defer bw.Flush()
+	writer := newLogWriter(QueryOptions{Format: opts.Format}, bw)
+	writer.Start()
+
+	start := time.Now()
+	scanned, written := 0, 0
+	full := false
+	for _, day := range days {
+		// Parts of a day are not in time order once some are compacted,
+		// so each day's matches are sorted before they are written.
+		var matches []datadogV2.Log
+		for _, obj := range partsByDay[day] {
+			err := readArchivePart(store, obj.name, func(log datadogV2.Log) error {
+				scanned++
+				attrs := log.GetAttributes()
+				ts := attrs.GetTimestamp()
+				if ts.Before(from) || !ts.Before(to) || !match(&attrs) {
+					return nil
+				}
+				matches = append(matches, log)
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", store.location(obj.name), err)
+			}
+		}
+		sort.SliceStable(matches, func(i, j int) bool {
+			a, b := matches[i].GetAttributes(), matches[j].GetAttributes()
+			return a.GetTimestamp().Before(b.GetTimestamp())
+		})
+		for _, log := range matches {
+			if err := writer.WriteLog(log); err != nil {
+				return fmt.Errorf("writing log: %w", err)
+			}
+			written++
+			// Page boundaries as the API would have them: CSV writes its
+			// header after the first.
+			if written%int(maxLogsPerRequest) == 0 {
+				if err := writer.FlushPage(); err != nil {
+					return err
+				}
+			}
+			if opts.Limit > 0 && written >= opts.Limit {
+				full = true
+				break
+			}
+		}
+		if full {
+			break
+		}
+	}
+	if err := writer.FlushPage(); err != nil {
+		return err
+	}
+	if err := writer.End(); err != nil {
+		return fmt.Errorf("finishing output: %w", err)
+	}
+	if !opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Done: %d of %d logs matched in %.1fs\n", written, scanned, time.Since(start).Seconds())
+		if full {
+			fmt.Fprintf(os.Stderr, "Stopped at --limit %d\n", opts.Limit)
+		}
+		if opts.OutputFile != "" {
+			fmt.Fprintf(os.Stderr, "Output written to %s\n", opts.OutputFile)
+		}
+	}
+	return nil
+}
+
+// archiveQueryParts is the parts of objects to read. Of a compaction a run
+// did not finish, only the parts are read until its file is complete, and
+// only the file after.
+func archiveQueryParts(objects []archiveObject, state *archiveState) []archiveObject {
+	skip := make(map[string]bool)
+	if c := state.Compacting; c != nil {
+		written := false
+		for _, obj := range objects {
+			if obj.name == c.File {
+				written = true
+			}
+		}
+		if written {
+			for _, part := range c.Parts {
+				skip[part] = true
+			}
+		} else {
+			skip[c.File] = true
+		}
+	}
+	var parts []archiveObject
+	for _, obj := range objects {
+		if skip[obj.name] || !strings.HasSuffix(obj.name, ".parquet") && !strings.HasSuffix(obj.name, ".ndjson.gz") {
+			continue
+		}
+		parts = append(parts, obj)
+	}
+	return parts
+}
+
+// readArchivePart calls fn with each log of the part name.
+func readArchivePart(store archiveStore, name string, fn func(datadogV2.Log) error) error {
+	if strings.HasSuffix(name, ".parquet") {
+		path, cleanup, err := store.localPath(name)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		return readParquetLogs(path, fn)
+	}
+	body, err := store.get(name)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	// Compacted parts are several gzip members, which the reader reads
+	// as one stream.
+	zr, err := gzip.NewReader(bufio.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	dec := json.NewDecoder(zr)
+	for {
+		var log datadogV2.Log
+		if err := dec.Decode(&log); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(log); err != nil {
+			return err
+		}
+	}
+}
+
+// readParquetLogs calls fn with each row of a Parquet export as a log: the
+// fixed columns fill its fields, tags are split back apart, and every other
+// column is an attribute. Nested objects and arrays, written as JSON text,
+// are decoded again.
+func readParquetLogs(path string, fn func(datadogV2.Log) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	pf, err := parquet.OpenFile(f, info.Size())
+	if err != nil {
+		return err
+	}
+	fields := pf.Schema().Fields()
+	names := make([]string, len(fields))
+	kinds := make([]string, len(fields))
+	for i, field := range fields {
+		names[i], kinds[i] = field.Name(), parquetNodeKind(field)
+	}
+	buf := make([]parquet.Row, 256)
+	for _, rg := range pf.RowGroups() {
+		rows := rg.Rows()
+		for {
+			n, err := rows.ReadRows(buf)
+			for _, row := range buf[:n] {
+				if ferr := fn(parquetRowLog(row, names, kinds)); ferr != nil {
+					rows.Close()
+					return ferr
+				}
+			}
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+	}
+	return nil
+}
+
+// parquetRowLog rebuilds the log a parquetWriter row was written from.
+func parquetRowLog(row parquet.Row, names, kinds []string) datadogV2.Log {
+	attrs := datadogV2.NewLogAttributes()
+	custom := make(map[string]interface{})
+	for _, v := range row {
+		if v.IsNull() || v.Column() >= len(names) {
+			continue
+		}
+		name := names[v.Column()]
+		switch {
+		case name == "timestamp" && kinds[v.Column()] == "timestamp":
+			attrs.SetTimestamp(time.UnixMilli(v.Int64()).UTC())
+		case name == "host":
+			attrs.SetHost(string(v.ByteArray()))
+		case name == "service":
+			attrs.SetService(string(v.ByteArray()))
+		case name == "status":
+			attrs.SetStatus(string(v.ByteArray()))
+		case name == "message":
+			attrs.SetMessage(string(v.ByteArray()))
+		case name == "tags":
+			attrs.SetTags(strings.Split(string(v.ByteArray()), ";"))
+		default:
+			switch kinds[v.Column()] {
+			case "number":
+				custom[name] = v.Double()
+			case "boolean":
+				custom[name] = v.Boolean()
+			default:
+				custom[name] = parquetStringValue(string(v.ByteArray()))
+			}
+		}
+	}
+	if len(custom) > 0 {
+		attrs.SetAttributes(custom)
+	}
+	log := datadogV2.NewLogWithDefaults()
+	log.SetAttributes(*attrs)
+	return *log
+}
+
+// parquetStringValue is s, or the object or array it holds as JSON.
+func parquetStringValue(s string) interface{} {
+	if strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[") {
+		var v interface{}
+		if err := json.Unmarshal([]byte(s), &v); err == nil {
+			return v
+		}
+	}
+	return s
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// CSVLocale is a --locale output profile for CSV opened in locale-sensitive
+// spreadsheets: the field delimiter, the decimal separator for numeric
+// attributes, and the layout of the timestamp column (in UTC unless --tz
+// is set).
+type CSVLocale struct {
+	Name       string
+	Comma      rune
+	Decimal    string
+	TimeLayout string
+}
+
+// csvLocales are the supported profiles. Locales with a decimal comma use
+// ";" between fields, as their spreadsheets expect.
+var csvLocales = []CSVLocale{
+	{Name: "en-US", Comma: ',', Decimal: ".", TimeLayout: "01/02/2006 15:04:05"},
+	{Name: "en-GB", Comma: ',', Decimal: ".", TimeLayout: "02/01/2006 15:04:05"},
+	{Name: "de-DE", Comma: ';', Decimal: ",", TimeLayout: "02.01.2006 15:04:05"},
+	{Name: "fr-FR", Comma: ';', Decimal: ",", TimeLayout: "02/01/2006 15:04:05"},
+	{Name: "es-ES", Comma: ';', Decimal: ",", TimeLayout: "02/01/2006 15:04:05"},
+	{Name: "it-IT", Comma: ';', Decimal: ",", TimeLayout: "02/01/2006 15:04:05"},
+	{Name: "nl-NL", Comma: ';', Decimal: ",", TimeLayout: "02-01-2006 15:04:05"},
+	{Name: "pt-BR", Comma: ';', Decimal: ",", TimeLayout: "02/01/2006 15:04:05"},
+	{Name: "ja-JP", Comma: ',', Decimal: ".", TimeLayout: "2006/01/02 15:04:05"},
+}
+
+// ParseCSVLocale returns the profile named name, matched case-insensitively
+// and accepting "_" for "-" (de_DE).
+func ParseCSVLocale(name string) (*CSVLocale, error) {
+	want := strings.ReplaceAll(name, "_", "-")
+	var names []string
+	for i := range csvLocales {
+		if strings.EqualFold(csvLocales[i].Name, want) {
+			return &csvLocales[i], nil
+		}
+		names = append(names, csvLocales[i].Name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unsupported locale %q (supported: %s)", name, strings.Join(names, ", "))
+}
+
+// number formats f with the locale's decimal separator, without exponent
+// notation, which spreadsheets would read as text.
+func (l *CSVLocale) number(f float64) string {
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if l.Decimal != "." {
+		s = strings.Replace(s, ".", l.Decimal, 1)
+	}
+	return s
+}
+
+// cell renders the CSV value of col for log under l: the timestamp in the
+// locale's layout and numeric attributes with its decimal separator. ok is
+// false for any other column, which is rendered as usual. col is a header
+// name looked up in custom, or an explicit column when explicit is set.
+func (l *CSVLocale) cell(attrs *datadogV2.LogAttributes, custom map[string]interface{}, col string, explicit bool) (string, bool) {
+	if col == "timestamp" {
+		if t, ok := attrs.GetTimestampOk(); ok && t != nil {
+			return t.Format(l.TimeLayout), true
+		}
+		return "", true
+	}
+	var v interface{}
+	var found bool
+	switch {
+	case !explicit:
+		v, found = custom[col]
+	case strings.HasPrefix(col, "@"):
+		v, found = lookupAttr(custom, col[1:])
+	}
+	if f, ok := v.(float64); found && ok {
+		return l.number(f), true
+	}
+	return "", false
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"sort"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// longHeader is the header of --long-format CSV.
+var longHeader = []string{"log_id", "attribute", "value"}
+
+// longWriter writes CSV in long form: one row per log, column and value,
+// instead of one column per attribute. Empty values are left out, so a
+// log only has rows for what it carries, and the header never changes
+// however many attributes the services in an export use.
+type longWriter struct {
+	w       *csv.Writer
+	strict  bool
+	fixed   []string
+	tagCols []string
+
+	// columns, when set, are the only columns written (see
+	// QueryOptions.Columns).
+	columns []string
+
+	excelSafe    bool
+	excel        bool
+	locale       *CSVLocale
+	timeFormat   *TimeFormat
+	flattenDepth int
+
+	// resumed is set when appending to a file an earlier run started.
+	resumed bool
+}
+
+func newLongWriter(bw *bufio.Writer) *longWriter {
+	return &longWriter{w: csv.NewWriter(bw), fixed: fixedColumns}
+}
+
+func (w *longWriter) Start() {
+	if w.resumed {
+		return
+	}
+	rec := csvRecord(longHeader, w.excelSafe)
+	if w.excel {
+		rec[0] = utf8BOM + rec[0]
+	}
+	w.w.Write(rec)
+}
+
+func (w *longWriter) WriteLog(log datadogV2.Log) error {
+	if w.strict {
+		if err := validateLog(log); err != nil {
+			return err
+		}
+	}
+	id := log.GetId()
+	attrs := log.GetAttributes()
+	custom := attrs.GetAttributes()
+	if w.flattenDepth > 0 {
+		custom = flattenAttributes(custom, w.flattenDepth)
+	}
+	write := func(col, value string) error {
+		if value == "" {
+			return nil
+		}
+		return w.w.Write([]string{w.cell(id), w.cell(col), w.cell(value)})
+	}
+
+	if w.columns != nil {
+		for _, col := range w.columns {
+			if err := write(columnHeader(col), w.value(log, &attrs, custom, col, true)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, col := range w.fixed {
+		if err := write(col, w.value(log, &attrs, custom, col, false)); err != nil {
+			return err
+		}
+	}
+	for _, key := range w.tagCols {
+		if err := write(key, tagValue(attrs.GetTags(), key)); err != nil {
+			return err
+		}
+	}
+	keys := make([]string, 0, len(custom))
+	for key := range custom {
+		if !containsString(w.tagCols, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := write(key, w.value(log, &attrs, custom, key, false)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// value renders one column of log as the wide CSV would.
+func (w *longWriter) value(log datadogV2.Log, attrs *datadogV2.LogAttributes, custom map[string]interface{}, col string, explicit bool) string {
+	if col == "timestamp" && w.timeFormat != nil {
+		return w.timeFormat.timestamp(attrs)
+	}
+	if w.locale != nil {
+		if v, ok := w.locale.cell(attrs, custom, col, explicit); ok {
+			return v
+		}
+	}
+	if explicit {
+		return explicitColumnValue(log, col)
+	}
+	return columnValue(attrs, custom, col)
+}
+
+// cell makes one value safe to write, see csvCell and excelDigits.
+func (w *longWriter) cell(s string) string {
+	s = csvCell(s, w.excelSafe)
+	if w.excel {
+		s = excelDigits(s)
+	}
+	return s
+}
+
+func (w *longWriter) FlushPage() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func (w *longWriter) End() error {
+	w.w.Flush()
+	return w.w.Error()
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// markdownWriter writes a GitHub-flavored Markdown table, for pasting into
+// incident docs and pull request descriptions. Columns are chosen like
+// CSV's, from the first page. Pipes are escaped and line breaks become
+// <br>, so every log stays on one table row; maxWidth, when positive, cuts
+// longer cells to that many characters, ending them with "…".
+type markdownWriter struct {
+	bw       *bufio.Writer
+	strict   bool
+	fixed    []string
+	tagCols  []string
+	maxWidth int
+
+	timeFormat *TimeFormat
+
+	headers []string
+	attrSet map[string]bool
+	buffer  []datadogV2.Log
+	started bool
+}
+
+func newMarkdownWriter(bw *bufio.Writer) *markdownWriter {
+	return &markdownWriter{
+		bw:      bw,
+		attrSet: make(map[string]bool),
+		fixed:   fixedColumns,
+	}
+}
+
+func (w *markdownWriter) Start() {}
+
+func (w *markdownWriter) WriteLog(log datadogV2.Log) error {
+	if w.strict {
+		if err := validateLog(log); err != nil {
+			return err
+		}
+	}
+	attrs := log.GetAttributes()
+	for key := range attrs.GetAttributes() {
+		if w.strict && w.started && !w.attrSet[key] {
+			return &rejectError{Log: log, Reason: fmt.Sprintf("attribute %q first appeared after the table header was written", key)}
+		}
+		w.attrSet[key] = true
+	}
+	if !w.started {
+		w.buffer = append(w.buffer, log)
+		return nil
+	}
+	return w.writeRow(log)
+}
+
+// begin writes the header and delimiter rows and the buffered first page.
+func (w *markdownWriter) begin() error {
+	w.headers = headerColumns(w.fixed, w.tagCols, w.attrSet)
+	w.row(w.headers)
+	delim := make([]string, len(w.headers))
+	for i := range delim {
+		delim[i] = "---"
+	}
+	w.bw.WriteString("|" + strings.Join(delim, "|") + "|\n")
+	for _, log := range w.buffer {
+		if err := w.writeRow(log); err != nil {
+			return err
+		}
+	}
+	w.buffer = nil
+	w.started = true
+	return nil
+}
+
+func (w *markdownWriter) writeRow(log datadogV2.Log) error {
+	attrs := log.GetAttributes()
+	custom := attrs.GetAttributes()
+	cells := make([]string, len(w.headers))
+	for i, col := range w.headers {
+		if i >= len(w.fixed) && i < len(w.fixed)+len(w.tagCols) {
+			cells[i] = tagValue(attrs.GetTags(), col)
+			continue
+		}
+		if col == "timestamp" && w.timeFormat != nil {
+			cells[i] = w.timeFormat.timestamp(&attrs)
+			continue
+		}
+		cells[i] = columnValue(&attrs, custom, col)
+	}
+	w.row(cells)
+	return nil
+}
+
+// row writes one table row, escaping and cutting each cell.
+func (w *markdownWriter) row(cells []string) {
+	w.bw.WriteString("|")
+	for _, c := range cells {
+		if w.maxWidth > 0 {
+			c = truncateRunes(c, w.maxWidth)
+		}
+		w.bw.WriteString(" " + markdownCell(c) + " |")
+	}
+	w.bw.WriteString("\n")
+}
+
+// markdownCell escapes s for a table cell: pipes and backslashes are
+// escaped, < cannot start an HTML tag, and line breaks become <br>.
+func markdownCell(s string) string {
+	return markdownEscaper.Replace(textCell(s))
+}
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "|", `\|`, "<", "&lt;", "\r\n", "<br>", "\n", "<br>", "\r", "<br>")
+
+func (w *markdownWriter) FlushPage() error {
+	if !w.started {
+		return w.begin()
+	}
+	return nil
+}
+
+func (w *markdownWriter) End() error {
+	if !w.started {
+		return w.begin()
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// minNarrowWindow is the smallest window a timed-out request is split into.
+// A window this small that still times out fails the export.
+const minNarrowWindow = time.Minute
+
+// logWindow is one time range fetched with its own pagination, with from/to
+// as sent to the API. start and end are set once the window has been
+// resolved to absolute times.
+type logWindow struct {
+	from, to   string
+	start, end time.Time
+}
+
+// windowNarrower splits windows whose requests keep timing out into halves,
+// so an export over a range too large for the API to answer in time still
+// completes. Logs exactly on a split point may be returned by both halves;
+// they are deduplicated by ID.
+type windowNarrower struct {
+	// from/to are the export's --from/--to values, resolved against began
+	// the first time a window needs splitting.
+	from, to string
+	began    time.Time
+
+	// descending is set when windows are fetched newest first: what is left
+	// of a window after a timeout ends at the last log fetched, and the
+	// newer half is fetched first.
+	descending bool
+
+	boundaries map[int64]bool
+	seen       map[string]bool
+
+	splits   int
+	smallest time.Duration
+}
+
+func newWindowNarrower(from, to string, began time.Time) *windowNarrower {
+	return &windowNarrower{
+		from:       from,
+		to:         to,
+		began:      began,
+		boundaries: make(map[int64]bool),
+		seen:       make(map[string]bool),
+	}
+}
+
+// split returns the two halves of what is left of w, in fetch order. resume,
+// when not zero, is the timestamp of the last log already fetched from w,
+// and resumeIDs the IDs of the fetched logs at exactly that time; only the
+// range from resume on (up to resume, when descending) is split. ok is false when w cannot be narrowed further.
+func (n *windowNarrower) split(w logWindow, resume time.Time, resumeIDs []string) ([]logWindow, bool) {
+	if w.start.IsZero() {
+		var err error
+		if w.start, err = resolveTime(n.from, n.began); err != nil {
+			return nil, false
+		}
+		if w.end, err = resolveTime(n.to, n.began); err != nil {
+			return nil, false
+		}
+	}
+	switch {
+	case resume.IsZero():
+	case n.descending && resume.Before(w.end):
+		// to is exclusive; keep the logs at resume itself, deduplicated below.
+		w.end = resume.Add(time.Millisecond)
+	case !n.descending && resume.After(w.start):
+		w.start = resume
+	}
+	if w.end.Sub(w.start) < 2*minNarrowWindow {
+		return nil, false
+	}
+	if !resume.IsZero() {
+		n.boundaries[resume.UnixMilli()] = true
+		for _, id := range resumeIDs {
+			n.seen[id] = true
+		}
+	}
+
+	mid := w.start.Add(w.end.Sub(w.start) / 2).Truncate(time.Millisecond)
+	n.boundaries[mid.UnixMilli()] = true
+	n.splits++
+	if half := mid.Sub(w.start); n.smallest == 0 || half < n.smallest {
+		n.smallest = half
+	}
+	if n.descending {
+		return []logWindow{absoluteWindow(mid, w.end), absoluteWindow(w.start, mid)}, true
+	}
+	return []logWindow{absoluteWindow(w.start, mid), absoluteWindow(mid, w.end)}, true
+}
+
+func absoluteWindow(start, end time.Time) logWindow {
+	return logWindow{
+		from:  start.UTC().Format(apiTimeLayout),
+		to:    end.UTC().Format(apiTimeLayout),
+		start: start,
+		end:   end,
+	}
+}
+
+// dedupe drops logs on a split point that an earlier window already
+// returned. raw, when non-nil, holds the same events and is filtered alike.
+func (n *windowNarrower) dedupe(logs []datadogV2.Log, raw []json.RawMessage) ([]datadogV2.Log, []json.RawMessage) {
+	if len(n.boundaries) == 0 {
+		return logs, raw
+	}
+	keptLogs := logs[:0]
+	var keptRaw []json.RawMessage
+	for i, log := range logs {
+		attrs := log.GetAttributes()
+		if ts, ok := attrs.GetTimestampOk(); ok && n.boundaries[ts.UnixMilli()] {
+			if n.seen[log.GetId()] {
+				continue
+			}
+			n.seen[log.GetId()] = true
+		}
+		keptLogs = append(keptLogs, log)
+		if raw != nil {
+			keptRaw = append(keptRaw, raw[i])
+		}
+	}
+	return keptLogs, keptRaw
+}
+
+// isTimeout reports whether a failed call timed out, either at the API
+// (504, 408) or in the client.
+func isTimeout(r *http.Response, err error) bool {
+	if r != nil {
+		return r.StatusCode == http.StatusGatewayTimeout || r.StatusCode == http.StatusRequestTimeout
+	}
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
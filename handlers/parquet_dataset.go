@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"github.com/parquet-go/parquet-go"
+)
+
+// datasetWriter adds an export to a Parquet dataset: a directory of part
+// files read as one table, e.g. with DuckDB's read_parquet('dir/*.parquet',
+// union_by_name = true). Each export is a new part whose schema starts with
+// every column of the parts already there, in their order and with their
+// types, followed by the columns this export adds, all nullable. Logs are
+// written to a hidden file in the directory and renamed into place when the
+// export ends, so readers never see half a part.
+type datasetWriter struct {
+	*parquetWriter
+	dir   string
+	parts int
+	rows  int
+
+	tmp  string
+	file *os.File
+	bw   *bufio.Writer
+}
+
+// datasetColumn is a column of a dataset's parts: its name and kind
+// (timestamp, number, boolean or string).
+type datasetColumn struct {
+	name string
+	kind string
+}
+
+func newDatasetWriter(opts QueryOptions) (*datasetWriter, error) {
+	dir := opts.OutputFile
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating dataset directory: %w", err)
+	}
+	cols, parts, err := datasetColumns(dir)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, ".part-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("creating dataset part: %w", err)
+	}
+	bw := bufio.NewWriterSize(f, 256*1024)
+	pq := opts
+	pq.Format = "parquet"
+	p := newLogWriter(pq, bw).(*parquetWriter)
+	p.dataset = cols
+	return &datasetWriter{parquetWriter: p, dir: dir, parts: parts, tmp: f.Name(), file: f, bw: bw}, nil
+}
+
+func (w *datasetWriter) WriteLog(log datadogV2.Log) error {
+	w.rows++
+	return w.parquetWriter.WriteLog(log)
+}
+
+func (w *datasetWriter) FlushPage() error {
+	if err := w.parquetWriter.FlushPage(); err != nil {
+		return err
+	}
+	return w.bw.Flush()
+}
+
+func (w *datasetWriter) End() error {
+	defer w.cleanup()
+	if w.rows == 0 {
+		fmt.Fprintf(os.Stderr, "\nNo logs matched; dataset %s left as it was\n", w.dir)
+		return nil
+	}
+	if err := w.parquetWriter.End(); err != nil {
+		return err
+	}
+	if err := w.bw.Flush(); err != nil {
+		return fmt.Errorf("writing dataset part: %w", err)
+	}
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("writing dataset part: %w", err)
+	}
+	w.file = nil
+
+	name := "part-" + time.Now().UTC().Format("20060102T150405.000Z")
+	path := filepath.Join(w.dir, name+".parquet")
+	for i := 2; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		}
+		path = filepath.Join(w.dir, fmt.Sprintf("%s-%d.parquet", name, i))
+	}
+	// CreateTemp makes the file private; parts are shared like any export.
+	if err := os.Chmod(w.tmp, 0o644); err != nil {
+		return fmt.Errorf("adding dataset part: %w", err)
+	}
+	if err := os.Rename(w.tmp, path); err != nil {
+		return fmt.Errorf("adding dataset part: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "\nAdded %s to dataset %s (%d part(s))", filepath.Base(path), w.dir, w.parts+1)
+	if w.parts > 0 && len(w.added) > 0 {
+		fmt.Fprintf(os.Stderr, "; new column(s): %s", strings.Join(w.added, ", "))
+	}
+	fmt.Fprintln(os.Stderr)
+	return nil
+}
+
+// cleanup removes the unfinished part, whether or not the export finished.
+func (w *datasetWriter) cleanup() {
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+	os.Remove(w.tmp)
+}
+
+// datasetColumns returns the columns of the parts in dir, those of the
+// oldest part first and then any a later part added, and how many parts
+// there are. Parts are the .parquet files in dir, oldest first by name.
+func datasetColumns(dir string) ([]datasetColumn, int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading dataset directory: %w", err)
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".parquet") && !strings.HasPrefix(e.Name(), ".") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	var cols []datasetColumn
+	seen := make(map[string]bool)
+	for _, name := range names {
+		fields, err := parquetFileFields(filepath.Join(dir, name))
+		if err != nil {
+			return nil, 0, fmt.Errorf("reading dataset part %s: %w", name, err)
+		}
+		for _, field := range fields {
+			if !seen[field.Name()] {
+				seen[field.Name()] = true
+				cols = append(cols, datasetColumn{name: field.Name(), kind: parquetNodeKind(field)})
+			}
+		}
+	}
+	return cols, len(names), nil
+}
+
+// parquetFileFields returns the top-level fields of the Parquet file at path.
+func parquetFileFields(path string) ([]parquet.Field, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	pf, err := parquet.OpenFile(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	return pf.Schema().Fields(), nil
+}
+
+// parquetNodeKind is the column kind a Parquet leaf was written from.
+func parquetNodeKind(n parquet.Node) string {
+	switch n.Type().Kind() {
+	case parquet.Double:
+		return "number"
+	case parquet.Boolean:
+		return "boolean"
+	case parquet.Int64:
+		if lt := n.Type().LogicalType(); lt != nil && lt.Timestamp != nil {
+			return "timestamp"
+		}
+	}
+	return "string"
+}
+
+// datasetSchema fixes the schema of a part added to p.dataset: the
+// dataset's columns first, keeping their types, then the fixed, tag and
+// attribute columns of this export the dataset lacks, which are recorded
+// in p.added. An attribute already in the dataset keeps its columns even
+// if this export would type it differently; values that do not fit are
+// handled like any other type mismatch.
+func (p *parquetWriter) datasetSchema(attrCols map[string]parquetColumn) *parquet.Schema {
+	group := orderedGroup{Group: parquet.Group{}}
+	have := make(map[string]bool)
+	// attrs records how the dataset holds each attribute: in one column,
+	// or split into one per type.
+	attrs := make(map[string]string)
+	add := func(col parquetColumn, kind string) {
+		group.add(col.name, parquetLeaf(kind))
+		p.columns = append(p.columns, col)
+		have[col.name] = true
+	}
+
+	for _, dc := range p.dataset {
+		col := parquetColumn{name: dc.name, kind: dc.kind, attr: dc.name}
+		switch {
+		case isFixedColumn(dc.name) && containsString(p.fixed, dc.name):
+			col = parquetColumn{name: dc.name, kind: "fixed"}
+		case isFixedColumn(dc.name):
+			// Removed by classification in this export.
+			col = parquetColumn{name: dc.name, kind: "absent"}
+		case containsString(p.tagCols, dc.name):
+			col = parquetColumn{name: dc.name, kind: "tag"}
+		case dc.kind == "timestamp":
+			// Not a column this tool writes.
+			col = parquetColumn{name: dc.name, kind: "absent"}
+		default:
+			// attr__kind is one of the columns --type-conflicts split
+			// attr into, unless this export has an attribute by that name.
+			i := strings.LastIndex(dc.name, "__")
+			if i > 0 && dc.name[i+2:] == dc.kind && attrCols[dc.name].attr != dc.name {
+				col = parquetColumn{name: dc.name, kind: dc.kind, attr: dc.name[:i], split: true}
+				attrs[col.attr] = "split"
+			} else {
+				attrs[dc.name] = "plain"
+			}
+		}
+		add(col, dc.kind)
+	}
+
+	for _, name := range p.fixed {
+		if !have[name] {
+			kind := "string"
+			if name == "timestamp" {
+				kind = "timestamp"
+			}
+			add(parquetColumn{name: name, kind: "fixed"}, kind)
+			p.added = append(p.added, name)
+		}
+	}
+	for _, name := range p.tagCols {
+		if !have[name] {
+			add(parquetColumn{name: name, kind: "tag"}, "string")
+			p.added = append(p.added, name)
+		}
+	}
+	names := make([]string, 0, len(attrCols))
+	for name := range attrCols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		col := attrCols[name]
+		switch {
+		case have[name]:
+		case !col.split && attrs[col.attr] != "":
+		case col.split && attrs[col.attr] == "plain":
+		default:
+			add(col, col.kind)
+			p.added = append(p.added, name)
+		}
+	}
+	// An attribute the dataset splits gets a column for any new type.
+	var splits []string
+	for name, how := range attrs {
+		if how == "split" {
+			splits = append(splits, name)
+		}
+	}
+	sort.Strings(splits)
+	for _, name := range splits {
+		for _, kind := range []string{"number", "boolean", "string"} {
+			split := name + "__" + kind
+			if p.kinds[name][kind] > 0 && !have[split] {
+				add(parquetColumn{name: split, kind: kind, attr: name, split: true}, kind)
+				p.added = append(p.added, split)
+			}
+		}
+	}
+	return parquet.NewSchema("log", group)
+}
+
+// orderedGroup is a parquet.Group whose fields keep the order they were
+// added in; parquet.Group sorts them by name.
+type orderedGroup struct {
+	parquet.Group
+	names []string
+}
+
+func (g *orderedGroup) add(name string, node parquet.Node) {
+	g.Group[name] = node
+	g.names = append(g.names, name)
+}
+
+func (g orderedGroup) Fields() []parquet.Field {
+	fields := make([]parquet.Field, len(g.names))
+	for i, name := range g.names {
+		fields[i] = &orderedField{Node: g.Group[name], name: name}
+	}
+	return fields
+}
+
+type orderedField struct {
+	parquet.Node
+	name string
+}
+
+func (f *orderedField) Name() string { return f.name }
+
+// Value is the field of a map[string]interface{} row, as for parquet.Group.
+func (f *orderedField) Value(base reflect.Value) reflect.Value {
+	if base.Kind() == reflect.Interface {
+		if base.IsNil() {
+			return reflect.ValueOf(nil)
+		}
+		base = base.Elem()
+	}
+	return base.MapIndex(reflect.ValueOf(f.name))
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// PrintSchema samples opts' time range like --discover-schema and prints
+// the Parquet schema an export with it would write, without exporting:
+// every column with its type, nullability and how often it was null in the
+// sample. Attributes whose sampled values have more than one type are
+// flagged along with how opts.TypeConflicts resolves them.
+func (h *DDHandler) PrintSchema(opts QueryOptions) error {
+	sampled, err := h.discoverSchema(opts)
+	if err != nil {
+		return err
+	}
+	kinds := make(map[string]map[string]int)
+	for _, a := range sampled.attributes(opts) {
+		kinds[a] = sampled.columns[a]
+	}
+	policy := opts.TypeConflicts
+	attrCols, err := parquetColumns(kinds, opts.TagColumns, policy)
+	if err != nil {
+		// Show what widening would write; the conflicts are listed below.
+		attrCols, _ = parquetColumns(kinds, opts.TagColumns, TypeConflictWiden)
+	}
+	types := make(map[string]string, len(attrCols))
+	for name, col := range attrCols {
+		types[name] = col.kind
+	}
+	schema := parquet.NewSchema("log", parquetGroup(exportedFixedColumns(opts), opts.TagColumns, types))
+
+	fmt.Printf("Parquet schema for %q (%d logs sampled across %d slices)\n\n", opts.Query, sampled.logs, sampled.slices)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "COLUMN\tTYPE\tNULLABLE\tNULL IN SAMPLE")
+	var conflicts []string
+	for _, path := range schema.Columns() {
+		name := strings.Join(path, ".")
+		leaf, _ := schema.Lookup(path...)
+		present, counted := 0, false
+		if col, ok := attrCols[name]; ok && col.split {
+			present, counted = sampled.columns[col.attr][col.kind], true
+		} else if _, ok := sampled.columns[name]; ok {
+			present, counted = sampled.present(name), true
+		}
+		nulls := "-"
+		if counted && sampled.logs > 0 {
+			nulls = fmt.Sprintf("%.0f%%", 100*float64(sampled.logs-present)/float64(sampled.logs))
+		}
+		nullable := "no"
+		if leaf.Node.Optional() {
+			nullable = "yes"
+		}
+		typ := parquetTypeName(leaf.Node)
+		if col, ok := attrCols[name]; ok && len(sampled.columns[col.attr]) > 1 {
+			typ += "  !"
+			if !containsString(conflicts, col.attr) {
+				conflicts = append(conflicts, col.attr)
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", name, typ, nullable, nulls)
+	}
+	tw.Flush()
+
+	if len(conflicts) == 0 {
+		fmt.Println("\nNo type conflicts in the sample.")
+		return nil
+	}
+	switch policy {
+	case TypeConflictSplit:
+		fmt.Printf("\n%d type conflict(s) (!): these attributes hold values of several types and are split into\none column per type (attr__number, attr__boolean, attr__string), null where the value has another type.\n", len(conflicts))
+	case TypeConflictError:
+		fmt.Printf("\n%d type conflict(s) (!): these attributes hold values of several types, so an export with\n--type-conflicts error would fail. The schema above shows them widened to STRING.\n", len(conflicts))
+	default:
+		fmt.Printf("\n%d type conflict(s) (!): these attributes hold values of several types and are written as STRING,\nwith numbers and booleans as their text. Confirm that is what the target expects before exporting,\nor use --type-conflicts split or error.\n", len(conflicts))
+	}
+	for _, name := range conflicts {
+		fmt.Printf("  %s: %s\n", name, kindCounts(sampled.columns[name]))
+	}
+	return nil
+}
+
+// parquetTypeName describes a leaf column as physical type plus logical
+// type, e.g. BYTE_ARRAY (STRING).
+func parquetTypeName(n parquet.Node) string {
+	physical := n.Type().Kind().String()
+	if lt := n.Type().LogicalType(); lt != nil {
+		return fmt.Sprintf("%s (%s)", physical, lt)
+	}
+	return physical
+}
@@ -0,0 +1,389 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetRowGroupSize is the number of rows buffered before a row group is
+// written. Row groups are only cut at page boundaries.
+const parquetRowGroupSize = 100_000
+
+// Type conflict policies: what a typed export does with an attribute whose
+// values have more than one type (e.g. 42 in some logs and "n/a" in others).
+const (
+	// TypeConflictWiden writes the attribute as one STRING column, with
+	// numbers and booleans as their text. This is the default.
+	TypeConflictWiden = "widen"
+
+	// TypeConflictSplit writes one column per type, named attr__number,
+	// attr__boolean and attr__string, each null where the value has
+	// another type.
+	TypeConflictSplit = "split"
+
+	// TypeConflictError fails the export instead.
+	TypeConflictError = "error"
+)
+
+// parquetWriter writes logs as an Apache Parquet file. Like the CSV writer it
+// buffers the first page to discover attributes, then fixes a typed schema:
+// timestamp is a TIMESTAMP(MILLIS), other fixed columns are strings, and each
+// attribute is a DOUBLE, BOOLEAN or STRING depending on the values seen on
+// the first page and any types seeded by schema discovery. An attribute
+// seen with values of several types is handled by the conflicts policy. All
+// columns are optional.
+type parquetWriter struct {
+	out    io.Writer
+	w      *parquet.Writer
+	buffer []datadogV2.Log
+
+	// fixed are the fixed columns written; classification may remove some.
+	fixed []string
+
+	// tagCols are tag keys written as string columns of their own.
+	tagCols []string
+
+	// kinds counts, per attribute, the values of each type (number, boolean
+	// or string) seen before the schema is fixed.
+	kinds map[string]map[string]int
+
+	// conflicts is the TypeConflict policy for attributes in kinds with
+	// values of several types.
+	conflicts string
+
+	// columns are the schema's leaf columns, in the writer's column order.
+	columns []parquetColumn
+	pending int
+
+	// dataset, when set, are the columns of the dataset the export is
+	// added to (see datasetSchema); added are the columns it adds.
+	dataset []datasetColumn
+	added   []string
+
+	// mismatches counts attribute values written as null because they did
+	// not match the column type inferred from the first page.
+	mismatches int
+}
+
+// parquetColumn is a column of the schema. kind is fixed, tag, absent (a
+// dataset column the export has no values for) or the attribute's type.
+type parquetColumn struct {
+	name string
+	kind string
+
+	// attr is the attribute the column holds. split marks one of the
+	// columns an attribute was split into, holding only its values of kind.
+	attr  string
+	split bool
+}
+
+func newParquetWriter(out io.Writer) *parquetWriter {
+	return &parquetWriter{out: out, fixed: fixedColumns, kinds: make(map[string]map[string]int)}
+}
+
+func (p *parquetWriter) Start() {}
+
+func (p *parquetWriter) WriteLog(log datadogV2.Log) error {
+	if p.w == nil {
+		attrs := log.GetAttributes()
+		for k, v := range attrs.GetAttributes() {
+			observeKind(p.kinds, k, v)
+		}
+		p.buffer = append(p.buffer, log)
+		return nil
+	}
+	return p.writeRow(log)
+}
+
+func (p *parquetWriter) FlushPage() error {
+	if p.w == nil {
+		return p.flushBuffer()
+	}
+	if p.pending >= parquetRowGroupSize {
+		p.pending = 0
+		return p.w.Flush()
+	}
+	return nil
+}
+
+func (p *parquetWriter) End() error {
+	if p.w == nil {
+		if err := p.flushBuffer(); err != nil {
+			return err
+		}
+	}
+	if p.mismatches > 0 {
+		fmt.Fprintf(os.Stderr, "\nwarning: %d attribute values did not match their Parquet column type and were written as null\n", p.mismatches)
+	}
+	return p.w.Close()
+}
+
+// flushBuffer fixes the schema from the buffered first page and writes it.
+func (p *parquetWriter) flushBuffer() error {
+	attrCols, err := parquetColumns(p.kinds, p.tagCols, p.conflicts)
+	if err != nil {
+		return err
+	}
+	var schema *parquet.Schema
+	if len(p.dataset) > 0 {
+		schema = p.datasetSchema(attrCols)
+	} else {
+		types := make(map[string]string, len(attrCols))
+		for name, col := range attrCols {
+			types[name] = col.kind
+		}
+		schema = parquet.NewSchema("log", parquetGroup(p.fixed, p.tagCols, types))
+		for _, path := range schema.Columns() {
+			name := strings.Join(path, ".")
+			col, ok := attrCols[name]
+			switch {
+			case isFixedColumn(name):
+				col = parquetColumn{name: name, kind: "fixed"}
+			case containsString(p.tagCols, name):
+				col = parquetColumn{name: name, kind: "tag"}
+			case !ok:
+				col = parquetColumn{name: name, attr: name}
+			}
+			p.columns = append(p.columns, col)
+		}
+	}
+	p.w = parquet.NewWriter(p.out, schema)
+
+	for _, log := range p.buffer {
+		if err := p.writeRow(log); err != nil {
+			return err
+		}
+	}
+	p.buffer = nil
+	return nil
+}
+
+// parquetGroup is the schema of a Parquet export: timestamp is a
+// TIMESTAMP(MILLIS), the other fixed and tag columns are strings, and each
+// attribute in types is a DOUBLE, BOOLEAN or STRING. All are optional.
+func parquetGroup(fixed, tagCols []string, types map[string]string) parquet.Group {
+	group := parquet.Group{}
+	for _, col := range fixed {
+		if col == "timestamp" {
+			group[col] = parquetLeaf("timestamp")
+		} else {
+			group[col] = parquetLeaf("string")
+		}
+	}
+	for _, col := range tagCols {
+		group[col] = parquetLeaf("string")
+	}
+	for name, kind := range types {
+		if isFixedColumn(name) || containsString(tagCols, name) {
+			continue
+		}
+		group[name] = parquetLeaf(kind)
+	}
+	return group
+}
+
+// parquetLeaf is the optional column of a kind: TIMESTAMP(MILLIS) for
+// timestamp, DOUBLE for number, BOOLEAN for boolean, STRING otherwise.
+func parquetLeaf(kind string) parquet.Node {
+	switch kind {
+	case "timestamp":
+		return parquet.Optional(parquet.Timestamp(parquet.Millisecond))
+	case "number":
+		return parquet.Optional(parquet.Leaf(parquet.DoubleType))
+	case "boolean":
+		return parquet.Optional(parquet.Leaf(parquet.BooleanType))
+	}
+	return parquet.Optional(parquet.String())
+}
+
+func (p *parquetWriter) writeRow(log datadogV2.Log) error {
+	attrs := log.GetAttributes()
+	customAttrs := attrs.GetAttributes()
+
+	row := make(parquet.Row, len(p.columns))
+	for i, col := range p.columns {
+		v, fits := p.value(&attrs, customAttrs, col)
+		if !fits {
+			if p.conflicts == TypeConflictError {
+				return fmt.Errorf("attribute %q of log %s: a %s value in a %s column (--type-conflicts error)", col.attr, log.GetId(), widenType("", customAttrs[col.attr]), parquetKindName(col.kind))
+			}
+			p.mismatches++
+		}
+		if v.IsNull() {
+			row[i] = v.Level(0, 0, i)
+		} else {
+			row[i] = v.Level(0, 1, i)
+		}
+	}
+	if _, err := p.w.WriteRows([]parquet.Row{row}); err != nil {
+		return err
+	}
+	p.pending++
+	return nil
+}
+
+// value returns the value of col for a log. fits is false when the
+// attribute's value does not match the column type and is dropped.
+func (p *parquetWriter) value(attrs *datadogV2.LogAttributes, customAttrs map[string]interface{}, col parquetColumn) (v parquet.Value, fits bool) {
+	if col.kind == "absent" {
+		return parquet.NullValue(), true
+	}
+	if col.name == "timestamp" {
+		if t, ok := attrs.GetTimestampOk(); ok && t != nil {
+			return parquet.Int64Value(t.UnixMilli()), true
+		}
+		return parquet.NullValue(), true
+	}
+	if col.kind == "tag" {
+		if s := tagValue(attrs.GetTags(), col.name); s != "" {
+			return parquet.ByteArrayValue([]byte(validString(s))), true
+		}
+		return parquet.NullValue(), true
+	}
+	if col.kind == "fixed" {
+		if s := columnValue(attrs, customAttrs, col.name); s != "" {
+			return parquet.ByteArrayValue([]byte(validString(s))), true
+		}
+		return parquet.NullValue(), true
+	}
+
+	raw, ok := customAttrs[col.attr]
+	if !ok || raw == nil {
+		return parquet.NullValue(), true
+	}
+	if col.split && widenType("", raw) != col.kind {
+		// A sibling column of the split holds this value.
+		return parquet.NullValue(), true
+	}
+	switch col.kind {
+	case "number":
+		if f, ok := raw.(float64); ok {
+			return parquet.DoubleValue(f), true
+		}
+	case "boolean":
+		if b, ok := raw.(bool); ok {
+			return parquet.BooleanValue(b), true
+		}
+	default:
+		return parquet.ByteArrayValue([]byte(validString(flattenValue(raw)))), true
+	}
+	return parquet.NullValue(), false
+}
+
+// observeKind counts the type of v (see widenType) for attr in kinds. Null
+// values only register the attribute.
+func observeKind(kinds map[string]map[string]int, attr string, v interface{}) {
+	seen, ok := kinds[attr]
+	if !ok {
+		seen = make(map[string]int)
+		kinds[attr] = seen
+	}
+	if kind := widenType("", v); kind != "" {
+		seen[kind]++
+	}
+}
+
+// mergedKind is the single type of the values counted in seen, string when
+// they disagree, or "" when every value was null.
+func mergedKind(seen map[string]int) string {
+	kind := ""
+	for k := range seen {
+		if kind == "" {
+			kind = k
+		} else if kind != k {
+			kind = "string"
+		}
+	}
+	return kind
+}
+
+// parquetColumns returns the attribute columns for the types counted in
+// kinds, keyed by column name, resolving attributes with values of several
+// types by policy. With TypeConflictError those attributes are an error
+// listing each of them.
+func parquetColumns(kinds map[string]map[string]int, tagCols []string, policy string) (map[string]parquetColumn, error) {
+	cols := make(map[string]parquetColumn, len(kinds))
+	var conflicts []string
+	for attr, seen := range kinds {
+		if isFixedColumn(attr) || containsString(tagCols, attr) {
+			continue
+		}
+		if len(seen) > 1 {
+			switch policy {
+			case TypeConflictSplit:
+				for kind := range seen {
+					name := attr + "__" + kind
+					cols[name] = parquetColumn{name: name, kind: kind, attr: attr, split: true}
+				}
+				continue
+			case TypeConflictError:
+				conflicts = append(conflicts, fmt.Sprintf("%s (%s)", attr, kindCounts(seen)))
+				continue
+			}
+		}
+		cols[attr] = parquetColumn{name: attr, kind: mergedKind(seen), attr: attr}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return nil, fmt.Errorf("attributes with values of several types (--type-conflicts error): %s", strings.Join(conflicts, ", "))
+	}
+	return cols, nil
+}
+
+// kindCounts describes the counted types, most frequent first:
+// "number 912, string 48".
+func kindCounts(seen map[string]int) string {
+	kinds := make([]string, 0, len(seen))
+	for k := range seen {
+		kinds = append(kinds, k)
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		if seen[kinds[i]] != seen[kinds[j]] {
+			return seen[kinds[i]] > seen[kinds[j]]
+		}
+		return kinds[i] < kinds[j]
+	})
+	parts := make([]string, len(kinds))
+	for i, k := range kinds {
+		parts[i] = fmt.Sprintf("%s %d", k, seen[k])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// parquetKindName is the Parquet type of an attribute column kind.
+func parquetKindName(kind string) string {
+	switch kind {
+	case "number":
+		return "DOUBLE"
+	case "boolean":
+		return "BOOLEAN"
+	}
+	return "STRING"
+}
+
+// widenType merges the JSON type of v into the type inferred so far: a
+// column stays a number or boolean only while every value agrees, and falls
+// back to string otherwise. Nulls do not affect the type.
+func widenType(current string, v interface{}) string {
+	var kind string
+	switch v.(type) {
+	case nil:
+		return current
+	case float64:
+		kind = "number"
+	case bool:
+		kind = "boolean"
+	default:
+		kind = "string"
+	}
+	if current == "" || current == kind {
+		return kind
+	}
+	return "string"
+}
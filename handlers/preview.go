@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// previewPageSize is the size of the single page a preview fetches. It is
+// larger than the number of events shown so attribute discovery sees more
+// of the data.
+const previewPageSize int32 = 200
+
+// PreviewOptions configures a Preview.
+type PreviewOptions struct {
+	Query string
+	From  string
+	To    string
+
+	// Events is how many of the fetched events are printed.
+	Events int
+}
+
+// Preview fetches a single page of the newest matching logs and prints the
+// first opts.Events of them in a readable layout, followed by every
+// attribute path seen on the page and the total number of matches. It is
+// meant for iterating on a query before running a full export.
+func (h *DDHandler) Preview(opts PreviewOptions) error {
+	ctx := h.apiContext()
+	api := h.logsAPI()
+	from, to := toDatadogTime(opts.From), toDatadogTime(opts.To)
+
+	body := newListRequest(opts.Query, from, to, nil)
+	body.Sort = datadogV2.LOGSSORT_TIMESTAMP_DESCENDING.Ptr()
+	body.Page.Limit = datadog.PtrInt32(previewPageSize)
+	resp, _, err := h.listLogs(ctx, api, body, nil, nil)
+	if err != nil {
+		return fmt.Errorf("calling LogsApi.ListLogs: %w", err)
+	}
+	logs := resp.GetData()
+
+	total, err := h.countLogs(ctx, api, opts.Query, from, to, nil)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	shown := min(opts.Events, len(logs))
+	for _, log := range logs[:shown] {
+		printPreviewLog(out, log)
+	}
+
+	fmt.Fprintf(out, "Attributes (%d events sampled):\n", len(logs))
+	paths := previewAttributes(logs)
+	if len(paths) == 0 {
+		fmt.Fprintln(out, "  (none)")
+	}
+	for _, p := range paths {
+		fmt.Fprintf(out, "  @%-40s %-8s %d/%d\n", p.path, p.kind, p.count, len(logs))
+	}
+
+	fmt.Fprintf(out, "\nShowing %d of ~%d matching logs (newest first)\n", shown, total)
+	return nil
+}
+
+// printPreviewLog writes one log as a header line (time, status, service,
+// host), the message, and its top-level attributes.
+func printPreviewLog(w io.Writer, log datadogV2.Log) {
+	attrs := log.GetAttributes()
+	ts := ""
+	if t, ok := attrs.GetTimestampOk(); ok {
+		ts = t.UTC().Format(time.RFC3339Nano)
+	}
+	fmt.Fprintf(w, "%s  %s  %s  %s\n", ts, strings.ToUpper(attrs.GetStatus()), attrs.GetService(), attrs.GetHost())
+	if msg := strings.TrimSpace(attrs.GetMessage()); msg != "" {
+		fmt.Fprintf(w, "  %s\n", truncateRunes(strings.ReplaceAll(msg, "\n", " "), 300))
+	}
+
+	custom := attrs.GetAttributes()
+	keys := make([]string, 0, len(custom))
+	for k := range custom {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "  @%s=%s\n", k, truncateRunes(flattenValue(custom[k]), 200))
+	}
+	fmt.Fprintln(w)
+}
+
+// previewPath is one attribute path seen while previewing.
+type previewPath struct {
+	path  string
+	kind  string
+	count int
+}
+
+// previewAttributes returns every leaf attribute path in logs with its type
+// and the number of logs that have it, sorted by path.
+func previewAttributes(logs []datadogV2.Log) []previewPath {
+	seen := make(map[string]*previewPath)
+	for _, log := range logs {
+		attrs := log.GetAttributes()
+		found := make(map[string]bool)
+		var walk func(prefix string, m map[string]interface{})
+		walk = func(prefix string, m map[string]interface{}) {
+			for k, v := range m {
+				path := prefix + k
+				if nested, ok := v.(map[string]interface{}); ok {
+					walk(path+".", nested)
+					continue
+				}
+				p := seen[path]
+				if p == nil {
+					p = &previewPath{path: path}
+					seen[path] = p
+				}
+				if _, ok := v.([]interface{}); ok {
+					p.kind = "array"
+				} else if p.kind != "array" {
+					p.kind = widenType(p.kind, v)
+				}
+				if !found[path] {
+					found[path] = true
+					p.count++
+				}
+			}
+		}
+		walk("", attrs.GetAttributes())
+	}
+
+	paths := make([]previewPath, 0, len(seen))
+	for _, p := range seen {
+		if p.kind == "" {
+			p.kind = "null"
+		}
+		paths = append(paths, *p)
+	}
+	sort.Slice(paths, func(i, j int) bool { return paths[i].path < paths[j].path })
+	return paths
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// progressTTY reports whether stderr is a terminal. Only there are status
+// lines redrawn in place with \r; in a file or a CI log each redraw would
+// pile onto one ever-growing line.
+var progressTTY = term.IsTerminal(int(os.Stderr.Fd()))
+
+// progressInterval is how often a status line is printed when stderr is
+// not a terminal.
+const progressInterval = 10 * time.Second
+
+// progressLine is a status line on stderr: redrawn in place on a terminal,
+// and otherwise printed as a plain line at most every progressInterval.
+type progressLine struct {
+	last time.Time
+}
+
+// update shows the line; format has no line break.
+func (l *progressLine) update(format string, args ...interface{}) {
+	if progressTTY {
+		fmt.Fprintf(os.Stderr, "\r"+format, args...)
+		return
+	}
+	if now := time.Now(); now.Sub(l.last) >= progressInterval {
+		l.last = now
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+}
+
+// lineStart begins a line that replaces a status line: a carriage return
+// on a terminal, nothing elsewhere, where status lines end in a newline.
+func lineStart() string {
+	if progressTTY {
+		return "\r"
+	}
+	return ""
+}
+
+// progressEvent is one line of --progress json output: a "page" event
+// after every page fetched, a "rate_limit" event when fetching pauses for
+// the rate limit to reset, for Wait seconds, and a "done" event when the
+// export finishes.
+// Total is the logs fetched so far, Elapsed is in seconds and Rate in logs
+// per second. Cursor, on page events of an export that is not sharded, is
+// where the export continues from; it is empty once a window is done.
+type progressEvent struct {
+	Event      string  `json:"event"`
+	Page       int     `json:"page"`
+	Total      int     `json:"total"`
+	Elapsed    float64 `json:"elapsed"`
+	Rate       float64 `json:"rate"`
+	Cursor     string  `json:"cursor,omitempty"`
+	Shards     int     `json:"shards,omitempty"`
+	ShardsDone int     `json:"shards_done,omitempty"`
+	Wait       float64 `json:"wait,omitempty"`
+}
+
+// event writes a progressEvent of p to stderr. p.mu must be held.
+func (p *fetchProgress) event(kind, cursor string, wait time.Duration) {
+	elapsed := time.Since(p.start).Seconds()
+	ev := progressEvent{
+		Event:      kind,
+		Page:       p.pages,
+		Total:      p.logs,
+		Elapsed:    math.Round(elapsed*1000) / 1000,
+		Shards:     p.shards,
+		ShardsDone: p.shardsDone,
+	}
+	if elapsed > 0 {
+		ev.Rate = math.Round(float64(p.logs)/elapsed*10) / 10
+	}
+	if p.shards == 0 {
+		ev.Cursor = cursor
+	}
+	if wait > 0 {
+		ev.Wait = wait.Seconds()
+	}
+	line, _ := json.Marshal(ev)
+	fmt.Fprintf(os.Stderr, "%s\n", line)
+}
+
+// pause reports that fetching waits reset for the rate limit, with
+// remaining requests left in the period: as a "rate_limit" event with
+// --progress json, in place of the status line otherwise, and not at all
+// when quiet. A nil p always prints it.
+func (p *fetchProgress) pause(remaining int, reset time.Duration) {
+	if p != nil {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.quiet {
+			return
+		}
+		if p.json {
+			p.event("rate_limit", "", reset)
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, lineStart()+"Rate limit: %d request(s) left this period, pausing %s...   ", remaining, reset)
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// csvPruner holds a CSV export in a temporary file until it ends, then
+// copies it to the output without the columns that are empty in every row
+// or, across more than one row, hold the same value in all of them.
+type csvPruner struct {
+	out   *bufio.Writer
+	file  *os.File
+	spool *bufio.Writer
+}
+
+func newCSVPruner(out *bufio.Writer) (*csvPruner, error) {
+	f, err := os.CreateTemp("", "ddlogs-prune-*.csv")
+	if err != nil {
+		return nil, fmt.Errorf("creating prune spool: %w", err)
+	}
+	return &csvPruner{out: out, file: f, spool: bufio.NewWriterSize(f, 256*1024)}, nil
+}
+
+func (p *csvPruner) cleanup() {
+	p.file.Close()
+	os.Remove(p.file.Name())
+}
+
+// finish reads the spooled CSV, written with comma, twice: once to find the
+// columns to drop and once to copy the others to the output with crlf line
+// endings or not. A byte order mark before the header stays in front.
+func (p *csvPruner) finish(comma rune, crlf bool) error {
+	if err := p.spool.Flush(); err != nil {
+		return fmt.Errorf("writing prune spool: %w", err)
+	}
+
+	r, err := p.reader(comma)
+	if err != nil {
+		return err
+	}
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading prune spool: %w", err)
+	}
+	header = append([]string(nil), header...)
+	bom := len(header) > 0 && strings.HasPrefix(header[0], utf8BOM)
+	if bom {
+		header[0] = strings.TrimPrefix(header[0], utf8BOM)
+	}
+	empty := make([]bool, len(header))
+	constant := make([]bool, len(header))
+	first := make([]string, len(header))
+	rows := 0
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading prune spool: %w", err)
+		}
+		for i := range header {
+			v := ""
+			if i < len(record) {
+				v = record[i]
+			}
+			if rows == 0 {
+				empty[i], constant[i], first[i] = v == "", true, v
+				continue
+			}
+			if v != "" {
+				empty[i] = false
+			}
+			if v != first[i] {
+				constant[i] = false
+			}
+		}
+		rows++
+	}
+
+	var keep []int
+	var dropped, same []string
+	for i, col := range header {
+		switch {
+		case rows == 0:
+			keep = append(keep, i)
+		case empty[i]:
+			dropped = append(dropped, col)
+		case constant[i] && rows > 1:
+			same = append(same, col+"="+first[i])
+		default:
+			keep = append(keep, i)
+		}
+	}
+
+	r, err = p.reader(comma)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(p.out)
+	w.Comma = comma
+	w.UseCRLF = crlf
+	out := make([]string, len(keep))
+	for row := 0; ; row++ {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading prune spool: %w", err)
+		}
+		for k, i := range keep {
+			out[k] = ""
+			if i < len(record) {
+				out[k] = record[i]
+			}
+		}
+		if row == 0 && bom && len(out) > 0 {
+			out[0] = utf8BOM + strings.TrimPrefix(out[0], utf8BOM)
+		}
+		if err := w.Write(out); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+
+	if len(dropped)+len(same) == 0 {
+		fmt.Fprintf(os.Stderr, "\nAuto-prune: kept all %d column(s)\n", len(header))
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "\nAuto-prune: kept %d of %d column(s)", len(keep), len(header))
+	if len(dropped) > 0 {
+		fmt.Fprintf(os.Stderr, "; empty: %s", strings.Join(dropped, ", "))
+	}
+	if len(same) > 0 {
+		fmt.Fprintf(os.Stderr, "; same in every row: %s", strings.Join(same, ", "))
+	}
+	fmt.Fprintln(os.Stderr)
+	return nil
+}
+
+// reader returns a CSV reader from the start of the spool.
+func (p *csvPruner) reader(comma rune) (*csv.Reader, error) {
+	if _, err := p.file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("reading prune spool: %w", err)
+	}
+	r := csv.NewReader(bufio.NewReaderSize(p.file, 256*1024))
+	r.Comma = comma
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+	return r, nil
+}
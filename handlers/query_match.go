@@ -0,0 +1,515 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// logMatcher reports whether a log matches (part of) a query.
+type logMatcher func(attrs *datadogV2.LogAttributes) bool
+
+// reservedColumns are the keys matched against a log's own fields rather
+// than its tags.
+var reservedColumns = []string{"host", "service", "status", "message"}
+
+// compileQuery turns a Datadog search query into a matcher, evaluated
+// locally. It supports the subset of the syntax that does not need
+// Datadog's indexes:
+//
+//	timeout "connection reset"     free text and phrases in the message
+//	service:web status:error       reserved attributes (host, service,
+//	                               status) and tags (env:prod, source:nginx)
+//	@http.status_code:500          attributes, by dotted path
+//	@http.url:*/checkout*          wildcards (* and ?) in any value
+//	@duration:>=1000 @n:[1 TO 5]   numeric comparisons and ranges
+//	@user.id:*                     the attribute exists
+//	a b, a AND b, a OR b           implicit AND, explicit AND and OR
+//	-a, NOT a, (a OR b)            negation and grouping
+//	service:(web OR api)           a key applied to a group of values
+//
+// Free text matches anywhere in the message and, like reserved attributes
+// and tags, ignores case; attribute values are matched exactly.
+func compileQuery(query string) (logMatcher, error) {
+	tokens, err := tokenizeQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	if len(tokens) == 0 {
+		return func(*datadogV2.LogAttributes) bool { return true }, nil
+	}
+	m, err := p.parseOr("")
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("query: unexpected %s", p.tokens[p.pos])
+	}
+	return m, nil
+}
+
+// queryToken is a parenthesis, a negation (- or !) or a term: an optional
+// key and its value. group marks a key followed by a parenthesized group.
+// A value is a glob: * and ? are wildcards, and a backslash before *, ?
+// or another backslash makes it literal (see globPattern); a wildcard
+// escaped or quoted in the query arrives escaped.
+type queryToken struct {
+	kind   byte // '(', ')', '-' or 'w' for a term
+	key    string
+	value  string
+	quoted bool
+	group  bool
+}
+
+func (t queryToken) String() string {
+	switch {
+	case t.kind != 'w':
+		return fmt.Sprintf("%q", string(t.kind))
+	case t.key != "":
+		return fmt.Sprintf("%q", t.key+":"+t.value)
+	}
+	return fmt.Sprintf("%q", t.value)
+}
+
+// operator reports whether t is the bare boolean operator op.
+func (t queryToken) operator(op string) bool {
+	return t.kind == 'w' && t.key == "" && !t.quoted && t.value == op
+}
+
+// tokenizeQuery splits query into tokens. A backslash escapes the next
+// character, inside quotes too; quoted phrases and [a TO b] ranges are kept
+// whole.
+func tokenizeQuery(query string) ([]queryToken, error) {
+	var tokens []queryToken
+	rs := []rune(query)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '(' || r == ')':
+			tokens = append(tokens, queryToken{kind: byte(r)})
+			i++
+			continue
+		case r == '-' || r == '!':
+			tokens = append(tokens, queryToken{kind: '-'})
+			i++
+			continue
+		}
+		tok := queryToken{kind: 'w'}
+		var buf strings.Builder
+		keyed := false
+	word:
+		for i < len(rs) {
+			r := rs[i]
+			switch {
+			case r == '\\' && i+1 < len(rs):
+				writeGlobLiteral(&buf, rs[i+1])
+				i += 2
+				continue
+			case r == '"':
+				end := i + 1
+				for end < len(rs) && rs[end] != '"' {
+					if rs[end] == '\\' {
+						end++
+					}
+					end++
+				}
+				if end >= len(rs) {
+					return nil, fmt.Errorf("query: unterminated quote")
+				}
+				for j := i + 1; j < end; j++ {
+					if rs[j] == '\\' {
+						j++
+					}
+					writeGlobLiteral(&buf, rs[j])
+				}
+				tok.quoted = true
+				i = end + 1
+				continue
+			case r == ':' && !keyed && !tok.quoted:
+				tok.key = unescapeGlob(buf.String())
+				buf.Reset()
+				keyed = true
+				i++
+				if i < len(rs) && (rs[i] == '[' || rs[i] == '{') {
+					closing := map[rune]rune{'[': ']', '{': '}'}[rs[i]]
+					end := i
+					for end < len(rs) && rs[end] != closing {
+						end++
+					}
+					if end >= len(rs) {
+						return nil, fmt.Errorf("query: unterminated range in %s", tok.key)
+					}
+					buf.WriteString(string(rs[i : end+1]))
+					i = end + 1
+					break word
+				}
+				if i < len(rs) && rs[i] == '(' {
+					tok.group = true
+					break word
+				}
+				continue
+			case unicode.IsSpace(r) || r == '(' || r == ')':
+				break word
+			}
+			buf.WriteRune(r)
+			i++
+		}
+		tok.value = buf.String()
+		if keyed && tok.key == "" {
+			return nil, fmt.Errorf("query: %q has no key", ":"+tok.value)
+		}
+		if keyed && tok.value == "" && !tok.group && !tok.quoted {
+			return nil, fmt.Errorf("query: %s: has no value", tok.key)
+		}
+		tokens = append(tokens, tok)
+	}
+	return tokens, nil
+}
+
+// queryParser parses tokens by precedence: OR binds loosest, then AND
+// (explicit or implied between terms), then negation.
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) peek() (queryToken, bool) {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos], true
+	}
+	return queryToken{}, false
+}
+
+// parseOr parses a disjunction; key applies to the bare values in it, inside
+// a key:( ... ) group.
+func (p *queryParser) parseOr(key string) (logMatcher, error) {
+	left, err := p.parseAnd(key)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.peek()
+		if !ok || !tok.operator("OR") {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseAnd(key)
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(a *datadogV2.LogAttributes) bool { return l(a) || right(a) }
+	}
+}
+
+func (p *queryParser) parseAnd(key string) (logMatcher, error) {
+	var terms []logMatcher
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.kind == ')' || tok.operator("OR") {
+			break
+		}
+		if tok.operator("AND") {
+			if len(terms) == 0 {
+				return nil, fmt.Errorf("query: expected a term before %s", tok)
+			}
+			p.pos++
+			if next, ok := p.peek(); !ok || next.kind == ')' || next.operator("OR") || next.operator("AND") {
+				return nil, fmt.Errorf("query: expected a term after AND")
+			}
+			continue
+		}
+		m, err := p.parseUnary(key)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, m)
+	}
+	if len(terms) == 0 {
+		if tok, ok := p.peek(); ok {
+			return nil, fmt.Errorf("query: expected a term before %s", tok)
+		}
+		return nil, fmt.Errorf("query: expected a term at the end")
+	}
+	return func(a *datadogV2.LogAttributes) bool {
+		for _, m := range terms {
+			if !m(a) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func (p *queryParser) parseUnary(key string) (logMatcher, error) {
+	tok, _ := p.peek()
+	if tok.kind == '-' || tok.operator("NOT") {
+		p.pos++
+		m, err := p.parseUnary(key)
+		if err != nil {
+			return nil, err
+		}
+		return func(a *datadogV2.LogAttributes) bool { return !m(a) }, nil
+	}
+	return p.parsePrimary(key)
+}
+
+func (p *queryParser) parsePrimary(key string) (logMatcher, error) {
+	tok, ok := p.peek()
+	p.pos++
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("query: expected a term at the end")
+	case tok.kind == ')':
+		return nil, fmt.Errorf("query: expected a term before %s", tok)
+	case tok.kind == '(':
+		return p.parseGroup(key)
+	case tok.group:
+		if key != "" {
+			return nil, fmt.Errorf("query: %s:( inside %s:( is not supported", tok.key, key)
+		}
+		p.pos++ // the group's "("
+		return p.parseGroup(tok.key)
+	case tok.key != "":
+		if key != "" {
+			return nil, fmt.Errorf("query: %s:%s inside %s:( is not supported", tok.key, tok.value, key)
+		}
+		return termMatcher(tok.key, tok.value, tok.quoted)
+	}
+	return termMatcher(key, tok.value, tok.quoted)
+}
+
+// parseGroup parses what follows "(" up to its ")".
+func (p *queryParser) parseGroup(key string) (logMatcher, error) {
+	m, err := p.parseOr(key)
+	if err != nil {
+		return nil, err
+	}
+	if tok, ok := p.peek(); !ok || tok.kind != ')' {
+		return nil, fmt.Errorf("query: missing )")
+	}
+	p.pos++
+	return m, nil
+}
+
+// termMatcher matches a single key:value, or free text when key is empty.
+func termMatcher(key, value string, quoted bool) (logMatcher, error) {
+	if key == "" {
+		if value == "*" && !quoted {
+			return func(*datadogV2.LogAttributes) bool { return true }, nil
+		}
+		re, err := globPattern(value, false, true)
+		if err != nil {
+			return nil, err
+		}
+		return func(a *datadogV2.LogAttributes) bool { return re.MatchString(a.GetMessage()) }, nil
+	}
+
+	if path, ok := strings.CutPrefix(key, "@"); ok {
+		if value == "*" && !quoted {
+			return func(a *datadogV2.LogAttributes) bool {
+				v, ok := lookupAttr(a.GetAttributes(), path)
+				return ok && v != nil
+			}, nil
+		}
+		match, err := valueMatcher(value, quoted)
+		if err != nil {
+			return nil, fmt.Errorf("query: %s: %w", key, err)
+		}
+		return func(a *datadogV2.LogAttributes) bool {
+			v, ok := lookupAttr(a.GetAttributes(), path)
+			if !ok {
+				return false
+			}
+			if list, ok := v.([]interface{}); ok {
+				for _, item := range list {
+					if match(item) {
+						return true
+					}
+				}
+				return false
+			}
+			return match(v)
+		}, nil
+	}
+
+	re, err := globPattern(value, true, true)
+	if err != nil {
+		return nil, err
+	}
+	if containsString(reservedColumns, key) {
+		return func(a *datadogV2.LogAttributes) bool {
+			s := columnValue(a, nil, key)
+			return s != "" && re.MatchString(s)
+		}, nil
+	}
+	return func(a *datadogV2.LogAttributes) bool {
+		for _, tag := range a.GetTags() {
+			if k, v, ok := strings.Cut(tag, ":"); ok && strings.EqualFold(k, key) && re.MatchString(v) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// valueMatcher matches a single attribute value: a comparison (>=500), a
+// range ([100 TO 200], or {100 TO 200} excluding its ends), or a value that
+// may contain wildcards. A number matches a numeric value equal to it.
+func valueMatcher(value string, quoted bool) (func(v interface{}) bool, error) {
+	if !quoted {
+		if lo, hi, loIncl, hiIncl, ok, err := parseRange(value); ok || err != nil {
+			if err != nil {
+				return nil, err
+			}
+			return func(v interface{}) bool {
+				f, ok := numericValue(v)
+				if !ok {
+					return false
+				}
+				return (f > lo || loIncl && f == lo) && (f < hi || hiIncl && f == hi)
+			}, nil
+		}
+	}
+	re, err := globPattern(value, true, false)
+	if err != nil {
+		return nil, err
+	}
+	num, numErr := strconv.ParseFloat(value, 64)
+	return func(v interface{}) bool {
+		if f, ok := v.(float64); ok && numErr == nil {
+			return f == num
+		}
+		return v != nil && re.MatchString(flattenValue(v))
+	}, nil
+}
+
+// parseRange parses >, >=, <, <= comparisons and [a TO b] or {a TO b}
+// ranges, with * for an open end. ok is false when value is neither.
+func parseRange(value string) (lo, hi float64, loIncl, hiIncl, ok bool, err error) {
+	lo, hi = negInf, posInf
+	for _, op := range []string{">=", "<=", ">", "<"} {
+		rest, found := strings.CutPrefix(value, op)
+		if !found {
+			continue
+		}
+		f, perr := strconv.ParseFloat(rest, 64)
+		if perr != nil {
+			return 0, 0, false, false, true, fmt.Errorf("%s is not a number", rest)
+		}
+		switch op {
+		case ">=":
+			return f, hi, true, false, true, nil
+		case ">":
+			return f, hi, false, false, true, nil
+		case "<=":
+			return lo, f, false, true, true, nil
+		}
+		return lo, f, false, false, true, nil
+	}
+	if len(value) < 2 || !strings.ContainsAny(value[:1], "[{") {
+		return 0, 0, false, false, false, nil
+	}
+	loIncl, hiIncl = value[0] == '[', value[len(value)-1] == ']'
+	a, b, found := strings.Cut(value[1:len(value)-1], " TO ")
+	if !found {
+		return 0, 0, false, false, true, fmt.Errorf("range %s is not [a TO b]", value)
+	}
+	bound := func(s string, open float64) (float64, error) {
+		if s = strings.TrimSpace(s); s == "*" {
+			return open, nil
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s is not a number", s)
+		}
+		return f, nil
+	}
+	if lo, err = bound(a, negInf); err != nil {
+		return 0, 0, false, false, true, err
+	}
+	if hi, err = bound(b, posInf); err != nil {
+		return 0, 0, false, false, true, err
+	}
+	return lo, hi, loIncl, hiIncl, true, nil
+}
+
+var (
+	negInf = math.Inf(-1)
+	posInf = math.Inf(1)
+)
+
+// numericValue is v as a number: a number, or a string holding one.
+func numericValue(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case float64:
+		return val, true
+	case string:
+		f, err := strconv.ParseFloat(val, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// writeGlobLiteral appends r to a glob as a literal character, escaping
+// the wildcards and the backslash.
+func writeGlobLiteral(buf *strings.Builder, r rune) {
+	if r == '*' || r == '?' || r == '\\' {
+		buf.WriteRune('\\')
+	}
+	buf.WriteRune(r)
+}
+
+// unescapeGlob is glob with its escapes removed, as plain text.
+func unescapeGlob(glob string) string {
+	if !strings.Contains(glob, `\`) {
+		return glob
+	}
+	var buf strings.Builder
+	rs := []rune(glob)
+	for i := 0; i < len(rs); i++ {
+		if rs[i] == '\\' && i+1 < len(rs) {
+			i++
+		}
+		buf.WriteRune(rs[i])
+	}
+	return buf.String()
+}
+
+// globPattern compiles the glob value, with unescaped * and ? as wildcards,
+// into a regexp matching the whole string when anchored or any part of it
+// otherwise.
+func globPattern(value string, anchored, foldCase bool) (*regexp.Regexp, error) {
+	var buf strings.Builder
+	rs := []rune(value)
+	for i := 0; i < len(rs); i++ {
+		switch r := rs[i]; {
+		case r == '\\' && i+1 < len(rs):
+			i++
+			buf.WriteString(regexp.QuoteMeta(string(rs[i])))
+		case r == '*':
+			buf.WriteString(".*")
+		case r == '?':
+			buf.WriteString(".")
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	pattern := buf.String()
+	if anchored {
+		pattern = "^(?s:" + pattern + ")$"
+	}
+	if foldCase {
+		pattern = "(?i)" + pattern
+	}
+	return regexp.Compile(pattern)
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// matchLog is the log the compileQuery cases are matched against.
+func matchLog() *datadogV2.LogAttributes {
+	attrs := datadogV2.NewLogAttributes()
+	attrs.SetMessage("Connection reset by peer at /checkout*")
+	attrs.SetService("web")
+	attrs.SetHost("h1")
+	attrs.SetStatus("error")
+	attrs.SetTags([]string{"env:prod", "source:nginx"})
+	attrs.SetAttributes(map[string]interface{}{
+		"http":     map[string]interface{}{"status_code": float64(500), "url": "/api/checkout"},
+		"duration": float64(1200),
+		"user":     map[string]interface{}{"id": "u1"},
+		"path":     `C:\tmp`,
+		"name":     "a*b",
+		"list":     []interface{}{"x", "y"},
+	})
+	return attrs
+}
+
+func TestCompileQuery(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		want  bool
+	}{
+		{"", true},
+		{"*", true},
+
+		// Free text and phrases, ignoring case.
+		{"connection", true},
+		{"CONNECTION reset", true},
+		{`"reset by peer"`, true},
+		{`"peer by reset"`, false},
+		{"timeout", false},
+
+		// Reserved attributes and tags.
+		{"service:web", true},
+		{"service:WEB", true},
+		{"service:api", false},
+		{"status:error host:h1", true},
+		{"env:prod", true},
+		{"env:dev", false},
+		{"source:ngi*", true},
+
+		// Attributes, wildcards, comparisons and ranges.
+		{"@http.status_code:500", true},
+		{"@http.status_code:501", false},
+		{"@http.url:*/checkout*", true},
+		{"@http.url:/api/check?ut", true},
+		{"@http.url:/api", false},
+		{"@duration:>=1000", true},
+		{"@duration:<1000", false},
+		{"@duration:[1000 TO 1200]", true},
+		{"@duration:{1000 TO 1200}", false},
+		{"@duration:[1000 TO *]", true},
+		{"@user.id:*", true},
+		{"@user.missing:*", false},
+		{"@list:y", true},
+		{"@list:z", false},
+
+		// Boolean operators and grouping.
+		{"service:web AND status:error", true},
+		{"service:api OR status:error", true},
+		{"service:api OR status:info", false},
+		{"-service:web", false},
+		{"!service:api", true},
+		{"NOT service:api", true},
+		{"service:(api OR web)", true},
+		{"service:(api OR worker)", false},
+		{"(service:api OR env:prod) status:error", true},
+		{"service:api OR env:prod status:error", true},
+
+		// Escapes: an escaped or quoted wildcard is literal, and \\ is a
+		// backslash, inside quotes too.
+		{`@name:a\*b`, true},
+		{`@name:a\*`, false},
+		{`@name:a*`, true},
+		{`@user.id:\*`, false},
+		{`@http.url:"*checkout"`, false},
+		{`"/checkout*"`, true},
+		{`"/check*"`, false},
+		{`/check\*`, false},
+		{`/checkout\*`, true},
+		{`@path:"C:\\tmp"`, true},
+		{`@path:C\:\\tmp`, true},
+		{`@path:"C:\tmp"`, false},
+	} {
+		match, err := compileQuery(tc.query)
+		if err != nil {
+			t.Fatalf("compileQuery(%q): %v", tc.query, err)
+		}
+		if got := match(matchLog()); got != tc.want {
+			t.Errorf("compileQuery(%q) matched %v, want %v", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestCompileQueryErrors(t *testing.T) {
+	for _, query := range []string{
+		`"unterminated`,
+		"@duration:[1 TO 5",
+		":value",
+		"service:",
+		"(service:web",
+		"service:web)",
+		"OR",
+		"service:web AND",
+		"AND service:web",
+		"service:web AND OR env:prod",
+		"@duration:>abc",
+		"@duration:[a TO 5]",
+		"@duration:[1 5]",
+		"service:(web service:(api))",
+		"service:(env:prod)",
+	} {
+		if _, err := compileQuery(query); err == nil {
+			t.Errorf("compileQuery(%q) succeeded, want an error", query)
+		}
+	}
+}
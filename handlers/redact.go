@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"regexp"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// redacted replaces every match of a redaction pattern.
+const redacted = "[REDACTED]"
+
+// DefaultRedactions are the patterns ddlogs mcp-serve masks unless told
+// otherwise: email addresses, card-like digit runs, AWS access key IDs,
+// bearer tokens, and the values of key=value pairs whose key names a
+// secret.
+var DefaultRedactions = []*regexp.Regexp{
+	regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	regexp.MustCompile(`\b[3-6](?:\d[ -]?){11,17}\d\b`),
+	regexp.MustCompile(`\b(?:AKIA|ASIA)[A-Z0-9]{16}\b`),
+	regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=-]+`),
+	regexp.MustCompile(`(?i)\b(?:password|passwd|secret|token|api[_-]?key)\s*[=:]\s*[^\s,;&"']+`),
+}
+
+// redactWriter wraps another logWriter and replaces every match of its
+// patterns in the message, the tags and every string attribute, at any
+// depth, with "[REDACTED]". Nothing is printed: the number of values
+// redacted is stored in count, if set, at End for the caller to report.
+type redactWriter struct {
+	logWriter
+	patterns []*regexp.Regexp
+	values   int
+	count    *int
+}
+
+func newRedactWriter(inner logWriter, patterns []*regexp.Regexp, count *int) *redactWriter {
+	return &redactWriter{logWriter: inner, patterns: patterns, count: count}
+}
+
+func (w *redactWriter) WriteLog(log datadogV2.Log) error {
+	attrs := log.GetAttributes()
+	if msg, ok := attrs.GetMessageOk(); ok {
+		attrs.SetMessage(w.redact(*msg))
+	}
+	for i, tag := range attrs.Tags {
+		attrs.Tags[i] = w.redact(tag)
+	}
+	if custom := attrs.GetAttributes(); custom != nil {
+		w.redactMap(custom)
+	}
+	log.SetAttributes(attrs)
+	return w.logWriter.WriteLog(log)
+}
+
+func (w *redactWriter) redactMap(m map[string]interface{}) {
+	for k, v := range m {
+		m[k] = w.redactValue(v)
+	}
+}
+
+func (w *redactWriter) redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return w.redact(v)
+	case map[string]interface{}:
+		w.redactMap(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = w.redactValue(v[i])
+		}
+	}
+	return v
+}
+
+// redact masks s, counting it if anything matched.
+func (w *redactWriter) redact(s string) string {
+	out := RedactString(s, w.patterns)
+	if out != s {
+		w.values++
+	}
+	return out
+}
+
+// RedactString replaces every match of patterns in s with "[REDACTED]".
+func RedactString(s string, patterns []*regexp.Regexp) string {
+	for _, re := range patterns {
+		s = re.ReplaceAllLiteralString(s, redacted)
+	}
+	return s
+}
+
+func (w *redactWriter) End() error {
+	if err := w.logWriter.End(); err != nil {
+		return err
+	}
+	if w.count != nil {
+		*w.count = w.values
+	}
+	return nil
+}
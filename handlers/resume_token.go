@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// tokenResumable reports whether an export of opts can be picked up again
+// from a resume token: a single uncompressed csv, json or ndjson file,
+// fetched in order, which a resumed run can truncate and append to. It is
+// the same set of exports --checkpoint supports.
+func tokenResumable(opts QueryOptions) bool {
+	switch opts.Format {
+	case "csv", "json", "ndjson", "template":
+	default:
+		return false
+	}
+	if codec, err := OutputCompression(opts.OutputFile, opts.Compress); err != nil || codec != "" {
+		return false
+	}
+	return opts.OutputFile != "" && opts.Checkpoint == "" &&
+		opts.Parallel <= 1 && opts.RouteBy == "" && opts.SplitBy == "" &&
+		opts.CorrelateCloudTrail == "" && opts.ColumnStatsFile == "" &&
+		opts.RotateSize == 0 && opts.RotateRows == 0 && !opts.AutoPrune &&
+		opts.Watch == 0
+}
+
+// encodeResumeToken packs cp into a single opaque string: its JSON,
+// gzipped and base64url-encoded so it survives copy and paste into a shell.
+func encodeResumeToken(cp *exportCheckpoint) (string, error) {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(b)
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func decodeResumeToken(token string) (*exportCheckpoint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resume token: %w", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid resume token: %w", err)
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resume token: %w", err)
+	}
+	var cp exportCheckpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return nil, fmt.Errorf("invalid resume token: %w", err)
+	}
+	return &cp, nil
+}
+
+// openResumeToken continues the export recorded in opts.ResumeToken, the
+// way openCheckpoint continues one from a checkpoint file. opts must be the
+// same export; its time range and sort order are replaced by the token's.
+func openResumeToken(opts *QueryOptions) (*exportCheckpoint, *os.File, error) {
+	cp, err := decodeResumeToken(opts.ResumeToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cp.Query != opts.Query || cp.Format != opts.Format || cp.Output != opts.OutputFile {
+		return nil, nil, fmt.Errorf("resume token is for query %q (%s output to %s), not this export", cp.Query, cp.Format, cp.Output)
+	}
+	opts.From, opts.To, opts.Descending = cp.From, cp.To, cp.Descending
+	f, err := reopenOutput(cp, opts.OutputFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cp, f, nil
+}
+
+// printResumeToken tells the user how to pick up a failed export where it
+// stopped. Nothing is printed before the first page has been written, when
+// rerunning the command does the same.
+func printResumeToken(cp *exportCheckpoint) {
+	if !cp.resumed() {
+		return
+	}
+	token, err := encodeResumeToken(cp)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\n%d logs were written before the export stopped. To continue it, rerun the same command with:\n  --resume-token %s\n", cp.Position.Written, token)
+}
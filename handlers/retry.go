@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+const (
+	// DefaultMaxRetries is how many times a failed ListLogs call is retried
+	// before the export gives up.
+	DefaultMaxRetries = 5
+
+	retryBaseDelay = time.Second
+	retryMaxDelay  = time.Minute
+
+	// rateLimitReserve is how many requests of the current rate-limit
+	// period are left untouched: once X-RateLimit-Remaining drops to it, the
+	// fetcher waits for the period to reset rather than running into 429s.
+	rateLimitReserve = 1
+
+	// maxRateLimitPause bounds a wait taken from X-RateLimit-Reset, in case
+	// the header is garbled.
+	maxRateLimitPause = 10 * time.Minute
+)
+
+// listLogs calls ListLogs, retrying rate limits (429), server errors (5xx)
+// and network failures up to h.MaxRetries times with exponential backoff
+// and jitter, so a long export survives a blip mid-pagination. Other errors,
+// such as 400 or 403, are returned at once. Server errors that outlast the
+// retries move the query to the next of h.FallbackSites, if any.
+//
+// site pins a pagination chain to one site, since a cursor is only valid
+// on the site that issued it: a request without a cursor sets *site to the
+// handler's current site, and requests continuing the chain are sent to
+// *site even when another shard has failed over since. It is nil for
+// one-off requests.
+//
+// Successful responses are checked against the rate-limit headers: when
+// the period's requests are nearly used up, listLogs sleeps until it resets
+// so the next call does not 429. A 429 is retried after the advertised
+// reset rather than the backoff. progress reports the pauses; with nil they
+// are printed as plain lines.
+func (h *DDHandler) listLogs(ctx context.Context, api *datadogV2.LogsApi, body datadogV2.LogsListRequest, site *string, progress *fetchProgress) (datadogV2.LogsListResponse, *http.Response, error) {
+	if site == nil {
+		site = new(string)
+	}
+	if body.Page == nil || body.Page.Cursor == nil || *site == "" {
+		*site = h.currentSite()
+	}
+	for attempt := 0; ; attempt++ {
+		resp, r, err := api.ListLogs(withSite(ctx, *site), *datadogV2.NewListLogsOptionalParameters().WithBody(body))
+		if err == nil {
+			if remaining, reset, ok := rateLimit(r); ok && remaining <= rateLimitReserve && reset > 0 {
+				progress.pause(remaining, reset)
+				sleepCtx(ctx, reset)
+			}
+			return resp, r, err
+		}
+		if attempt >= h.MaxRetries || !retryable(ctx, r, err) {
+			if next, ok := h.failover(r, body, *site); ok {
+				*site, attempt = next, -1
+				continue
+			}
+			return resp, r, err
+		}
+
+		delay := backoff(attempt)
+		if _, reset, ok := rateLimit(r); ok && r.StatusCode == http.StatusTooManyRequests && reset > 0 {
+			delay = reset
+		}
+		reason := err.Error()
+		if r != nil {
+			reason = r.Status
+		}
+		fmt.Fprintf(os.Stderr, "\nListLogs failed (%s); retrying in %s (%d/%d)\n", reason, delay.Round(100*time.Millisecond), attempt+1, h.MaxRetries)
+		if !sleepCtx(ctx, delay) {
+			return resp, r, err
+		}
+	}
+}
+
+// rateLimit reads the X-RateLimit-Remaining and X-RateLimit-Reset (seconds
+// until the period resets) headers of r. ok is false when either is missing.
+func rateLimit(r *http.Response) (remaining int, reset time.Duration, ok bool) {
+	if r == nil {
+		return 0, 0, false
+	}
+	remaining, err := strconv.Atoi(r.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return 0, 0, false
+	}
+	seconds, err := strconv.Atoi(r.Header.Get("X-RateLimit-Reset"))
+	if err != nil || seconds < 0 {
+		return 0, 0, false
+	}
+	return remaining, min(time.Duration(seconds)*time.Second, maxRateLimitPause), true
+}
+
+// sleepCtx waits for d, returning false early if ctx is cancelled.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// retryable reports whether a failed call is worth repeating: a 429 or 5xx
+// response, or a transport error while ctx is still live.
+func retryable(ctx context.Context, r *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if r != nil {
+		return r.StatusCode == http.StatusTooManyRequests || r.StatusCode >= 500
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// backoff returns the wait before retry attempt+1: an exponentially growing
+// ceiling, capped at retryMaxDelay, of which a random half is waited so
+// concurrent clients do not retry in lockstep.
+func backoff(attempt int) time.Duration {
+	ceiling := retryMaxDelay
+	if attempt < 6 {
+		ceiling = min(retryBaseDelay<<attempt, retryMaxDelay)
+	}
+	half := ceiling / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// currentSite returns h.Site, which changes when the handler fails over to
+// a fallback site.
+func (h *DDHandler) currentSite() string {
+	h.siteMu.Lock()
+	defer h.siteMu.Unlock()
+	return h.Site
+}
+
+// siteContext returns ctx addressed to the handler's current site.
+func (h *DDHandler) siteContext(ctx context.Context) context.Context {
+	return withSite(ctx, h.currentSite())
+}
+
+// withSite returns ctx addressed to site.
+func withSite(ctx context.Context, site string) context.Context {
+	return context.WithValue(ctx, datadog.ContextServerVariables, map[string]string{
+		"site": site,
+	})
+}
+
+// failover picks the site to repeat a request on after r, a server error
+// from failed that survived every retry, and reports whether there is one.
+// If another request has already failed over from failed, it is the
+// handler's current site; otherwise h moves to the next fallback site for
+// every request starting from then on. Timeouts are left to window
+// narrowing, and a pagination cursor is only valid on the site that issued
+// it, so requests continuing one never fail over.
+func (h *DDHandler) failover(r *http.Response, body datadogV2.LogsListRequest, failed string) (string, bool) {
+	h.siteMu.Lock()
+	defer h.siteMu.Unlock()
+	if r == nil || r.StatusCode < 500 || isTimeout(r, nil) {
+		return "", false
+	}
+	if body.Page != nil && body.Page.Cursor != nil {
+		if len(h.FallbackSites) > 0 || h.Site != failed {
+			fmt.Fprintf(os.Stderr, "\n%s keeps failing (%s) mid-pagination; a cursor cannot move to another site\n", failed, r.Status)
+		}
+		return "", false
+	}
+	if h.Site != failed {
+		return h.Site, true
+	}
+	if len(h.FallbackSites) == 0 {
+		return "", false
+	}
+	h.Site, h.FallbackSites = h.FallbackSites[0], h.FallbackSites[1:]
+	fmt.Fprintf(os.Stderr, "\nWarning: %s keeps failing (%s); querying fallback site %s instead. Results may differ from %s if replication between the sites is behind.\n", failed, r.Status, h.Site, failed)
+	return h.Site, true
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// rotatingWriter is a logWriter that splits an export across numbered files
+// next to OutputFile (logs.csv becomes logs-0001.csv, logs-0002.csv, ...),
+// starting a new one once the current file holds maxRows logs or maxSize
+// bytes. Each file gets its own writer of the export format, so every CSV
+// file has a header and every JSON file is a complete array.
+type rotatingWriter struct {
+	opts    QueryOptions
+	maxSize int64
+	maxRows int
+
+	paths   []string
+	file    io.WriteCloser
+	counter *countingWriter
+	bw      *bufio.Writer
+	current logWriter
+	rows    int
+}
+
+// rawRotatingWriter rotates raw API events, for --raw.
+type rawRotatingWriter struct {
+	*rotatingWriter
+}
+
+// newRotatingWriter opens the first file of a rotated export.
+func newRotatingWriter(opts QueryOptions) (*rotatingWriter, error) {
+	r := &rotatingWriter{opts: opts, maxSize: opts.RotateSize, maxRows: opts.RotateRows}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open creates the next numbered file and a writer for it.
+func (r *rotatingWriter) open() error {
+	path := numberedPath(r.opts.OutputFile, len(r.paths)+1)
+	f, err := createOutput(path, r.opts.Compress)
+	if err != nil {
+		return err
+	}
+	r.paths = append(r.paths, path)
+	r.file = f
+	r.counter = &countingWriter{w: f}
+	r.bw = bufio.NewWriterSize(r.counter, 256*1024)
+	r.current = newLogWriter(r.opts, r.bw)
+	r.rows = 0
+	return nil
+}
+
+// close ends the current file's writer and closes the file.
+func (r *rotatingWriter) close() error {
+	path := r.paths[len(r.paths)-1]
+	if err := r.current.End(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := r.bw.Flush(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// full reports whether the current file has reached a rotation limit. Size
+// counts what has been written plus what is buffered, before compression.
+func (r *rotatingWriter) full() bool {
+	if r.rows == 0 {
+		return false
+	}
+	if r.maxRows > 0 && r.rows >= r.maxRows {
+		return true
+	}
+	return r.maxSize > 0 && r.counter.n+int64(r.bw.Buffered()) >= r.maxSize
+}
+
+// rotate closes the current file and opens the next, which starts from the
+// columns and types the previous one ended with.
+func (r *rotatingWriter) rotate() error {
+	prev := r.current
+	if err := r.close(); err != nil {
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	carryOver(prev, r.current)
+	r.current.Start()
+	return nil
+}
+
+func (r *rotatingWriter) Start() {
+	r.current.Start()
+}
+
+func (r *rotatingWriter) WriteLog(log datadogV2.Log) error {
+	if r.full() {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+	r.rows++
+	return r.current.WriteLog(log)
+}
+
+func (r *rawRotatingWriter) WriteRaw(event json.RawMessage) error {
+	if r.full() {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+	r.rows++
+	return r.current.(rawLogWriter).WriteRaw(event)
+}
+
+func (r *rotatingWriter) FlushPage() error {
+	path := r.paths[len(r.paths)-1]
+	if err := r.current.FlushPage(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := r.bw.Flush(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := flushOutput(r.file); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func (r *rotatingWriter) End() error {
+	if err := r.close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "\nOutput rotated into %d file(s): %s", len(r.paths), r.paths[0])
+	if len(r.paths) > 1 {
+		fmt.Fprintf(os.Stderr, " ... %s", r.paths[len(r.paths)-1])
+	}
+	fmt.Fprintln(os.Stderr)
+	return nil
+}
+
+// carryOver gives next the attribute columns (CSV) or types (Parquet) that
+// prev has seen, so consecutive files share a header or schema as far as
+// the logs allow.
+func carryOver(prev, next logWriter) {
+	switch prev := prev.(type) {
+	case *csvWriter:
+		attrs := make([]string, 0, len(prev.attrSet))
+		for a := range prev.attrSet {
+			attrs = append(attrs, a)
+		}
+		sort.Strings(attrs)
+		seedColumns(next, attrs)
+	case *parquetWriter:
+		if p, ok := next.(*parquetWriter); ok {
+			for attr, seen := range prev.kinds {
+				p.kinds[attr] = make(map[string]int, len(seen))
+				for kind, n := range seen {
+					p.kinds[attr][kind] = n
+				}
+			}
+		}
+	}
+}
+
+// numberedPath numbers path for the n-th file of a rotated export, before
+// its extension and any compression suffix: logs.csv.gz becomes
+// logs-0001.csv.gz.
+func numberedPath(path string, n int) string {
+	suffix := ""
+	for _, s := range []string{".gz", ".zst"} {
+		if strings.HasSuffix(path, s) {
+			path, suffix = strings.TrimSuffix(path, s), s
+			break
+		}
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%04d%s%s", strings.TrimSuffix(path, ext), n, ext, suffix)
+}
+
+// ParseByteSize parses a size such as 500MB, 1.5GB or 1048576 (bytes).
+// Units are powers of 1024 and case-insensitive; the trailing B is
+// optional.
+func ParseByteSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		scale  float64
+	}{
+		{"T", 1 << 40},
+		{"G", 1 << 30},
+		{"M", 1 << 20},
+		{"K", 1 << 10},
+		{"", 1},
+	}
+	num := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	for _, u := range units {
+		if u.suffix != "" && !strings.HasSuffix(num, u.suffix) {
+			continue
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(num, u.suffix)), 64)
+		if err != nil || f <= 0 {
+			return 0, fmt.Errorf("invalid size %q (e.g. 500MB, 2GB)", s)
+		}
+		return int64(f * u.scale), nil
+	}
+	return 0, fmt.Errorf("invalid size %q (e.g. 500MB, 2GB)", s)
+}
+
+// formatBytes formats n in the units ParseByteSize reads, e.g. 1.5MB.
+func formatBytes(n int64) string {
+	units := []string{"KB", "MB", "GB", "TB"}
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	f, unit := float64(n)/1024, units[0]
+	for _, u := range units[1:] {
+		if f < 1024 {
+			break
+		}
+		f, unit = f/1024, u
+	}
+	return fmt.Sprintf("%.1f%s", f, unit)
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"gopkg.in/yaml.v3"
+)
+
+// RouteMap maps values of the --route-by attribute to output files. Events
+// whose value is not listed go to Default, or are dropped when it is empty.
+type RouteMap struct {
+	Routes  map[string]string `yaml:"routes"`
+	Default string            `yaml:"default"`
+}
+
+// LoadRouteMap reads a route map from a YAML file of the form:
+//
+//	routes:
+//	  acme: acme.csv
+//	  globex: globex.csv
+//	default: other.csv
+func LoadRouteMap(path string) (*RouteMap, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading route map: %w", err)
+	}
+	var m RouteMap
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("decoding route map %s: %w", path, err)
+	}
+	if len(m.Routes) == 0 && m.Default == "" {
+		return nil, fmt.Errorf("route map %s has no routes", path)
+	}
+	return &m, nil
+}
+
+// routingWriter is a logWriter that sends each log to one of several output
+// files, chosen by the value of an attribute: the file its route map lists,
+// or with --split-by, a file named after the value in dir. Each file gets
+// its own writer of the export format, opened when its first log arrives,
+// so a single API pass fans out to every tenant.
+type routingWriter struct {
+	opts    QueryOptions
+	field   string
+	routes  *RouteMap
+	dir     string
+	outputs map[string]*routeOutput
+	dropped int
+}
+
+// routeOutput is one destination file and the writer feeding it.
+type routeOutput struct {
+	file   io.WriteCloser
+	bw     *bufio.Writer
+	writer logWriter
+	count  int
+}
+
+func newRoutingWriter(opts QueryOptions) *routingWriter {
+	r := &routingWriter{
+		opts:    opts,
+		field:   opts.RouteBy,
+		routes:  opts.RouteMap,
+		outputs: make(map[string]*routeOutput),
+	}
+	if opts.SplitBy != "" {
+		r.field, r.dir = opts.SplitBy, opts.OutputFile
+	}
+	return r
+}
+
+func (r *routingWriter) Start() {}
+
+func (r *routingWriter) WriteLog(log datadogV2.Log) error {
+	var path string
+	if r.routes == nil {
+		path = splitPath(r.dir, routeValue(log, r.field), r.opts)
+	} else {
+		var ok bool
+		if path, ok = r.routes.Routes[routeValue(log, r.field)]; !ok {
+			path = r.routes.Default
+		}
+	}
+	if path == "" {
+		r.dropped++
+		return nil
+	}
+
+	out, err := r.output(path)
+	if err != nil {
+		return err
+	}
+	out.count++
+	return out.writer.WriteLog(log)
+}
+
+// output returns the writer for path, creating the file on first use.
+// Several route values may share one path.
+func (r *routingWriter) output(path string) (*routeOutput, error) {
+	if out, ok := r.outputs[path]; ok {
+		return out, nil
+	}
+	if r.dir != "" {
+		if err := os.MkdirAll(r.dir, 0o755); err != nil {
+			return nil, fmt.Errorf("creating output directory: %w", err)
+		}
+	}
+	f, err := createOutput(path, "")
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriterSize(f, 64*1024)
+	out := &routeOutput{file: f, bw: bw, writer: newLogWriter(r.opts, bw)}
+	out.writer.Start()
+	r.outputs[path] = out
+	return out, nil
+}
+
+func (r *routingWriter) FlushPage() error {
+	for path, out := range r.outputs {
+		if err := out.writer.FlushPage(); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if err := out.bw.Flush(); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if err := flushOutput(out.file); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func (r *routingWriter) End() error {
+	paths := make([]string, 0, len(r.outputs))
+	for path := range r.outputs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	// End the progress line before reporting.
+	fmt.Fprintln(os.Stderr)
+	for _, path := range paths {
+		out := r.outputs[path]
+		if err := out.writer.End(); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if err := out.bw.Flush(); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if err := out.file.Close(); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		fmt.Fprintf(os.Stderr, "Routed %d logs to %s\n", out.count, path)
+	}
+	if r.dropped > 0 {
+		fmt.Fprintf(os.Stderr, "Dropped %d logs with no matching route\n", r.dropped)
+	}
+	return nil
+}
+
+// splitPath is the --split-by file for value in dir: the value made safe as
+// a file name, with the extension of the export format and compression.
+// Logs without a value go to _none.
+func splitPath(dir, value string, opts QueryOptions) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, value)
+	name = strings.TrimLeft(name, ".")
+	if name == "" {
+		name = "_none"
+	}
+	ext := "." + opts.Format
+	if opts.Format == "template" {
+		ext = ".txt"
+	}
+	switch opts.Compress {
+	case "gzip":
+		ext += ".gz"
+	case "zstd":
+		ext += ".zst"
+	}
+	return filepath.Join(dir, name+ext)
+}
+
+// routeValue renders the value of field for log. "@"-prefixed fields are
+// custom attributes, looked up by dotted path through nested objects
+// (@org.id); anything else is a fixed column such as service or host.
+func routeValue(log datadogV2.Log, field string) string {
+	attrs := log.GetAttributes()
+	if !strings.HasPrefix(field, "@") {
+		return columnValue(&attrs, nil, field)
+	}
+	v, ok := lookupAttr(attrs.GetAttributes(), strings.TrimPrefix(field, "@"))
+	if !ok {
+		return ""
+	}
+	return flattenValue(v)
+}
+
+// lookupAttr finds path in attrs, trying the literal key first and then
+// descending through nested objects one dotted segment at a time.
+func lookupAttr(attrs map[string]interface{}, path string) (interface{}, bool) {
+	if v, ok := attrs[path]; ok {
+		return v, true
+	}
+	head, rest, found := strings.Cut(path, ".")
+	if !found {
+		return nil, false
+	}
+	nested, ok := attrs[head].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	return lookupAttr(nested, rest)
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// RunWindow is a daily time-of-day window, such as 01:00-05:00 UTC, outside
+// which a long-running job starts no new work. A window whose end is not
+// after its start runs past midnight (22:00-04:00).
+type RunWindow struct {
+	startHour, startMin int
+	endHour, endMin     int
+	loc                 *time.Location
+}
+
+// ParseRunWindow parses "HH:MM-HH:MM [ZONE]", where ZONE is UTC, an IANA
+// name such as Europe/Berlin, or omitted for local time.
+func ParseRunWindow(s string) (*RunWindow, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("invalid run window %q (e.g. \"01:00-05:00 UTC\")", s)
+	}
+	rw := &RunWindow{loc: time.Local}
+	if len(fields) == 2 {
+		loc, err := time.LoadLocation(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid run window %q: %w", s, err)
+		}
+		rw.loc = loc
+	}
+	from, to, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid run window %q (e.g. \"01:00-05:00 UTC\")", s)
+	}
+	start, err := time.Parse("15:04", from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid run window start %q (use HH:MM)", from)
+	}
+	end, err := time.Parse("15:04", to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid run window end %q (use HH:MM)", to)
+	}
+	if start.Equal(end) {
+		return nil, fmt.Errorf("run window %q is empty", s)
+	}
+	rw.startHour, rw.startMin = start.Hour(), start.Minute()
+	rw.endHour, rw.endMin = end.Hour(), end.Minute()
+	return rw, nil
+}
+
+func (rw *RunWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d %s", rw.startHour, rw.startMin, rw.endHour, rw.endMin, rw.loc)
+}
+
+// state reports whether t is inside the window, and how long until it
+// closes (inside) or next opens (outside).
+func (rw *RunWindow) state(t time.Time) (bool, time.Duration) {
+	t = t.In(rw.loc)
+	var next time.Time
+	// The window that opened yesterday may still be open, or today's or
+	// tomorrow's may be the next to open.
+	for day := -1; day <= 1; day++ {
+		open := time.Date(t.Year(), t.Month(), t.Day()+day, rw.startHour, rw.startMin, 0, 0, rw.loc)
+		end := time.Date(t.Year(), t.Month(), t.Day()+day, rw.endHour, rw.endMin, 0, 0, rw.loc)
+		if !end.After(open) {
+			end = time.Date(t.Year(), t.Month(), t.Day()+day+1, rw.endHour, rw.endMin, 0, 0, rw.loc)
+		}
+		if !t.Before(open) && t.Before(end) {
+			return true, end.Sub(t)
+		}
+		if open.After(t) && next.IsZero() {
+			next = open
+		}
+	}
+	return false, next.Sub(t)
+}
+
+// wait returns once the window is open, printing when it pauses, or with
+// ctx's error if ctx is done first.
+func (rw *RunWindow) wait(ctx context.Context) error {
+	open, d := rw.state(time.Now())
+	if open {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Outside run window %s; pausing until %s\n", rw, time.Now().Add(d).In(rw.loc).Format("2006-01-02 15:04 MST"))
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		fmt.Fprintf(os.Stderr, "Run window %s open; resuming\n", rw)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Column sources in a CSV schema file.
+const (
+	schemaFixed     = "fixed"
+	schemaTag       = "tag"
+	schemaAttribute = "attribute"
+)
+
+// csvSchema is the --schema / --emit-schema file: the CSV header of an
+// export, column by column, with where each column's values come from.
+type csvSchema struct {
+	Columns []csvSchemaColumn `json:"columns"`
+}
+
+type csvSchemaColumn struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+}
+
+// LoadCSVSchema reads a schema written by --emit-schema (or by hand) and
+// returns it as QueryOptions.Columns, so the export's header is exactly the
+// schema's columns in order.
+func LoadCSVSchema(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading schema: %w", err)
+	}
+	var schema csvSchema
+	if err := json.Unmarshal(b, &schema); err != nil {
+		return nil, fmt.Errorf("decoding schema %s: %w", path, err)
+	}
+	if len(schema.Columns) == 0 {
+		return nil, fmt.Errorf("schema %s has no columns", path)
+	}
+	cols := make([]string, 0, len(schema.Columns))
+	seen := make(map[string]bool)
+	for _, c := range schema.Columns {
+		var col string
+		switch c.Source {
+		case schemaFixed:
+			if !containsString(fixedColumns, c.Name) {
+				return nil, fmt.Errorf("schema %s: %q is not a fixed column", path, c.Name)
+			}
+			col = c.Name
+		case schemaTag:
+			col = "tag:" + c.Name
+		case schemaAttribute, "":
+			col = "@" + c.Name
+		default:
+			return nil, fmt.Errorf("schema %s: column %q has unknown source %q", path, c.Name, c.Source)
+		}
+		if c.Name == "" || seen[col] {
+			return nil, fmt.Errorf("schema %s: empty or duplicate column %q", path, c.Name)
+		}
+		seen[col] = true
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
+
+// writeCSVSchema saves the header w wrote to path, in the format
+// LoadCSVSchema reads, so a later export can be pinned to it.
+func writeCSVSchema(path string, w logWriter) error {
+	c, ok := w.(*csvWriter)
+	if !ok {
+		return fmt.Errorf("--emit-schema needs CSV output")
+	}
+	var schema csvSchema
+	switch {
+	case c.columns != nil:
+		for _, col := range c.columns {
+			schema.Columns = append(schema.Columns, schemaColumn(col))
+		}
+	default:
+		for i, name := range c.headers {
+			source := schemaAttribute
+			switch {
+			case i < len(c.fixed):
+				source = schemaFixed
+			case i < len(c.fixed)+len(c.tagCols):
+				source = schemaTag
+			}
+			schema.Columns = append(schema.Columns, csvSchemaColumn{Name: name, Source: source})
+		}
+	}
+	b, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing schema: %w", err)
+	}
+	return nil
+}
+
+// schemaColumn describes one explicit --columns entry.
+func schemaColumn(col string) csvSchemaColumn {
+	switch {
+	case strings.HasPrefix(col, "@"):
+		return csvSchemaColumn{Name: col[1:], Source: schemaAttribute}
+	case strings.HasPrefix(col, "tag:"):
+		return csvSchemaColumn{Name: strings.TrimPrefix(col, "tag:"), Source: schemaTag}
+	}
+	return csvSchemaColumn{Name: col, Source: schemaFixed}
+}
+
+// DefaultSchemaCacheDir returns $DDLOGS_SCHEMA_DIR, or ~/.ddlogs/schemas
+// when unset.
+func DefaultSchemaCacheDir() (string, error) {
+	if dir := os.Getenv("DDLOGS_SCHEMA_DIR"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("locating home directory: %w", err)
+	}
+	return filepath.Join(home, ".ddlogs", "schemas"), nil
+}
+
+// schemaCachePath returns the file in dir caching the CSV schema of opts'
+// query. The key covers everything that shapes the attribute columns but
+// not the time range, so every window of a query shares one schema.
+func schemaCachePath(dir string, opts QueryOptions) string {
+	key := strings.Join([]string{
+		opts.Query,
+		strings.Join(opts.Indexes, ","),
+		strings.Join(opts.TagColumns, ","),
+		strconv.Itoa(opts.FlattenDepth),
+	}, "\x00")
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])[:16]+".json")
+}
+
+// cachedAttributes returns the attribute columns of the schema cached at
+// path, or nil when nothing is cached for the query yet.
+func cachedAttributes(path string) ([]string, error) {
+	cols, err := LoadCSVSchema(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var attrs []string
+	for _, col := range cols {
+		if strings.HasPrefix(col, "@") {
+			attrs = append(attrs, col[1:])
+		}
+	}
+	return attrs, nil
+}
+
+// cacheSchema saves the header w wrote as the cached schema at path. A
+// writer that never wrote a header leaves the cache as it was.
+func cacheSchema(path string, w logWriter) error {
+	if c, ok := w.(*csvWriter); !ok || !c.started {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("creating schema cache: %w", err)
+	}
+	return writeCSVSchema(path, w)
+}
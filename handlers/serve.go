@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"io"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// StreamLogs runs the fetch pipeline for opts, handing each page of logs to
+// page instead of writing them anywhere, for serving exports to other
+// programs. Nothing is printed but retry warnings and the summaries of
+// opts' truncation and classification guards; redactions are counted in
+// opts.Redacted instead. Cancelling ctx stops the export with ErrInterrupted.
+func (h *DDHandler) StreamLogs(ctx context.Context, opts QueryOptions, page func([]datadogV2.Log) error) error {
+	opts.Quiet = true
+	w, err := guardServed(opts, &pageWriter{page: page})
+	if err != nil {
+		return err
+	}
+	return h.stream(h.withAPIKeys(ctx), opts, w, nil, func(exportPosition) error { return nil })
+}
+
+// StreamOutput is StreamLogs for formatted output: the export is written to
+// out in opts.Format, as Query would write it to a file, and flushed to out
+// after every page. It returns the number of logs written.
+func (h *DDHandler) StreamOutput(ctx context.Context, opts QueryOptions, out io.Writer) (int, error) {
+	opts.Quiet = true
+	bw := bufio.NewWriterSize(out, 256*1024)
+	afterPage := func(exportPosition) error { return bw.Flush() }
+	counted := &countedWriter{logWriter: newLogWriter(opts, bw)}
+	w, err := guardServed(opts, counted)
+	if err != nil {
+		return 0, err
+	}
+	if err := h.stream(h.withAPIKeys(ctx), opts, w, nil, afterPage); err != nil {
+		return counted.n, err
+	}
+	return counted.n, bw.Flush()
+}
+
+// guardServed wraps w in the writers that keep what a served export may
+// show in check. Logs pass Redact, then Classification, then truncation,
+// so values are masked before they are cut.
+func guardServed(opts QueryOptions, w logWriter) (logWriter, error) {
+	if opts.MaxMessageLen > 0 || opts.MaxCellLen > 0 {
+		tw, err := newTruncateWriter(w, opts.MaxMessageLen, opts.MaxCellLen, "")
+		if err != nil {
+			return nil, err
+		}
+		tw.quiet = opts.Quiet
+		w = tw
+	}
+	if opts.Classification != nil {
+		w = newClassifiedWriter(w, opts.Classification, opts.MaxClassification)
+	}
+	if len(opts.Redact) > 0 {
+		w = newRedactWriter(w, opts.Redact, opts.Redacted)
+	}
+	return w, nil
+}
+
+// pageWriter collects the logs of a page and hands them on when the page
+// is done.
+type pageWriter struct {
+	page func([]datadogV2.Log) error
+	logs []datadogV2.Log
+}
+
+func (w *pageWriter) Start() {}
+
+func (w *pageWriter) WriteLog(log datadogV2.Log) error {
+	w.logs = append(w.logs, log)
+	return nil
+}
+
+func (w *pageWriter) FlushPage() error {
+	if len(w.logs) == 0 {
+		return nil
+	}
+	err := w.page(w.logs)
+	w.logs = nil
+	return err
+}
+
+func (w *pageWriter) End() error { return nil }
+
+// countedWriter counts the logs passed on to the writer it wraps.
+type countedWriter struct {
+	logWriter
+	n int
+}
+
+func (w *countedWriter) WriteLog(log datadogV2.Log) error {
+	w.n++
+	return w.logWriter.WriteLog(log)
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// DefaultShardSize is the length of the sub-windows a parallel export is
+// split into.
+const DefaultShardSize = time.Hour
+
+// shard is one sub-window of a parallel export. Its pages are queued as
+// they are fetched, so its fetcher never waits for the writer to reach it.
+type shard struct {
+	f     *fetcher
+	ready chan struct{}
+
+	mu    sync.Mutex
+	pages []fetchResult
+	done  bool
+	err   error
+}
+
+func newShard(f *fetcher) *shard {
+	return &shard{f: f, ready: make(chan struct{}, 1)}
+}
+
+// fetch runs the shard's fetcher, queuing every page.
+func (s *shard) fetch(ctx context.Context) {
+	err := s.f.run(ctx, func(r fetchResult) bool {
+		s.mu.Lock()
+		s.pages = append(s.pages, r)
+		s.mu.Unlock()
+		s.signal()
+		return true
+	})
+	s.mu.Lock()
+	s.done, s.err = true, err
+	s.mu.Unlock()
+	s.signal()
+}
+
+func (s *shard) signal() {
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the shard's next page, waiting for it to be fetched. ok is
+// false once the shard is exhausted or ctx is cancelled.
+func (s *shard) next(ctx context.Context) (fetchResult, bool) {
+	for {
+		s.mu.Lock()
+		if len(s.pages) > 0 {
+			r := s.pages[0]
+			s.pages = s.pages[1:]
+			s.mu.Unlock()
+			return r, true
+		}
+		done := s.done
+		s.mu.Unlock()
+		if done {
+			return fetchResult{}, false
+		}
+		select {
+		case <-s.ready:
+		case <-ctx.Done():
+			return fetchResult{}, false
+		}
+	}
+}
+
+// fetchShards splits the export's time range into opts.ShardSize windows
+// and fetches up to opts.Parallel of them at once, each following its own
+// cursor. Pages are sent to pageCh shard by shard in time order (newest
+// shard first when descending), so the output matches a sequential export.
+// A shard's slot is only given to the next shard once it has been fully
+// sent, which bounds the logs held in memory to opts.Parallel shards.
+// pageCh is closed when every shard is sent or one fails, in which case
+// *fetchErr is set first.
+func (h *DDHandler) fetchShards(ctx context.Context, api *datadogV2.LogsApi, opts QueryOptions, began time.Time, progress *fetchProgress, pageCh chan<- fetchResult, fetchErr *error) ([]*fetcher, error) {
+	start, err := resolveTime(opts.From, began)
+	if err != nil {
+		return nil, fmt.Errorf("--parallel needs a resolvable --from: %w", err)
+	}
+	end, err := resolveTime(opts.To, began)
+	if err != nil {
+		return nil, fmt.Errorf("--parallel needs a resolvable --to: %w", err)
+	}
+	size := opts.ShardSize
+	if size <= 0 {
+		size = DefaultShardSize
+	}
+	windows := shardWindows(start, end, size)
+	if opts.Descending {
+		for i, j := 0, len(windows)-1; i < j; i, j = i+1, j-1 {
+			windows[i], windows[j] = windows[j], windows[i]
+		}
+	}
+
+	shards := make([]*shard, len(windows))
+	fetchers := make([]*fetcher, len(windows))
+	// Logs exactly on a shard boundary may be returned by both shards.
+	merge := newWindowNarrower(opts.From, opts.To, began)
+	for i, w := range windows {
+		fetchers[i] = newFetcher(h, api, opts, w, newWindowNarrower(w.from, w.to, began), progress)
+		shards[i] = newShard(fetchers[i])
+		if i > 0 {
+			merge.boundaries[w.start.UnixMilli()] = true
+			merge.boundaries[w.end.UnixMilli()] = true
+		}
+	}
+	progress.shards = len(shards)
+	fmt.Fprintf(os.Stderr, "Fetching %d shard(s) of %s, %d at a time\n", len(shards), size, opts.Parallel)
+
+	ctx, cancel := context.WithCancel(ctx)
+	slots := make(chan struct{}, opts.Parallel)
+	go func() {
+		for _, s := range shards {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go s.fetch(ctx)
+		}
+	}()
+
+	go func() {
+		defer close(pageCh)
+		defer cancel()
+		page := 0
+		for _, s := range shards {
+			for {
+				r, ok := s.next(ctx)
+				if !ok {
+					break
+				}
+				r.logs, r.raw = merge.dedupe(r.logs, r.raw)
+				page++
+				r.page = page
+				r.next = exportPosition{Page: page + 1}
+				select {
+				case pageCh <- r:
+				case <-ctx.Done():
+					return
+				}
+			}
+			s.mu.Lock()
+			err := s.err
+			s.mu.Unlock()
+			if err != nil {
+				*fetchErr = err
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			progress.mu.Lock()
+			progress.shardsDone++
+			progress.mu.Unlock()
+			<-slots
+		}
+	}()
+	return fetchers, nil
+}
+
+// shardWindows splits start..end into consecutive windows of size, the
+// last one possibly shorter.
+func shardWindows(start, end time.Time, size time.Duration) []logWindow {
+	var windows []logWindow
+	for from := start; from.Before(end); from = from.Add(size) {
+		to := from.Add(size)
+		if to.After(end) {
+			to = end
+		}
+		windows = append(windows, absoluteWindow(from, to))
+	}
+	if len(windows) == 0 {
+		windows = append(windows, absoluteWindow(start, end))
+	}
+	return windows
+}
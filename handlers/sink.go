@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// Sink is a backfill destination. Each chunk is delivered as Open, any
+// number of WriteBatch calls, then Commit, which makes the chunk visible
+// all at once and returns where it went. Close is called once when the
+// backfill ends and must discard a chunk that was opened but not
+// committed, so a sink never holds a partial chunk. A parallel backfill
+// opens one sink per worker, each delivering its own chunks.
+//
+// Batching, retries, checkpointing, count verification and metrics are
+// handled by Backfill for every sink; a sink only moves bytes.
+type Sink interface {
+	Open(chunk SinkChunk) error
+	WriteBatch(logs []datadogV2.Log) error
+	Commit() (string, error)
+	Close() error
+}
+
+// SinkChunk identifies the chunk being delivered. Name is derived from the
+// chunk start (20240501T000000Z) and is unique within a backfill.
+type SinkChunk struct {
+	From time.Time
+	To   time.Time
+	Name string
+}
+
+// SinkConfig is what a sink is created from: the parsed --sink URL and the
+// chunk format.
+type SinkConfig struct {
+	URL    *url.URL
+	Format string
+}
+
+// SinkFactory creates a sink for a --sink URL with a registered scheme.
+type SinkFactory func(cfg SinkConfig) (Sink, error)
+
+// RetryableSinkError marks err as transient: Backfill retries the failed
+// WriteBatch or Commit with the same backoff as API calls instead of
+// failing the chunk.
+func RetryableSinkError(err error) error {
+	return &retryableSinkError{err}
+}
+
+type retryableSinkError struct{ err error }
+
+func (e *retryableSinkError) Error() string { return e.err.Error() }
+func (e *retryableSinkError) Unwrap() error { return e.err }
+
+// defaultCheckpointer is implemented by sinks that can hold the backfill
+// checkpoint themselves; other sinks need --checkpoint.
+type defaultCheckpointer interface {
+	DefaultCheckpoint() string
+}
+
+// commitChecker is implemented by transactional sinks that can tell whether
+// a chunk's Commit took effect, e.g. by recording the chunk name in the same
+// transaction as its rows. After a crash between Commit and the checkpoint
+// being saved, Backfill asks the sink instead of delivering the chunk again.
+type commitChecker interface {
+	Committed(chunk SinkChunk) (location string, ok bool, err error)
+}
+
+// batchSizer is implemented by sinks that want batches of a particular
+// size rather than one batch per API page.
+type batchSizer interface {
+	BatchSize() int
+}
+
+var (
+	sinksMu sync.Mutex
+	sinks   = map[string]SinkFactory{
+		"file":     newFileSink,
+		"delta":    newLocalDeltaSink,
+		"delta+s3": newS3DeltaSink,
+	}
+)
+
+// RegisterSink makes sinks with the given URL scheme available to
+// --sink. Registering a scheme twice replaces the earlier factory.
+func RegisterSink(scheme string, factory SinkFactory) {
+	sinksMu.Lock()
+	defer sinksMu.Unlock()
+	sinks[strings.ToLower(scheme)] = factory
+}
+
+// SinkSchemes returns the registered sink schemes, sorted.
+func SinkSchemes() []string {
+	sinksMu.Lock()
+	defer sinksMu.Unlock()
+	schemes := make([]string, 0, len(sinks))
+	for s := range sinks {
+		schemes = append(schemes, s)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// openSink creates the sink for a --sink URL.
+func openSink(sink, format string) (Sink, error) {
+	u, err := url.Parse(sink)
+	if err != nil {
+		return nil, fmt.Errorf("parsing --sink: %w", err)
+	}
+	sinksMu.Lock()
+	factory, ok := sinks[strings.ToLower(u.Scheme)]
+	sinksMu.Unlock()
+	if !ok {
+		var schemes []string
+		for _, s := range SinkSchemes() {
+			schemes = append(schemes, s+"://")
+		}
+		return nil, fmt.Errorf("unsupported sink %q (supported: %s)", u.Scheme+"://", strings.Join(schemes, ", "))
+	}
+	return factory(SinkConfig{URL: u, Format: format})
+}
+
+// sinkMetrics accumulates what a backfill handed to its sinks, for the
+// completeness report. Parallel workers share it; mu guards the counters.
+type sinkMetrics struct {
+	scheme string
+
+	mu      sync.Mutex
+	batches int
+	commits int
+	retries int
+	elapsed time.Duration
+}
+
+// add increments one of m's counters.
+func (m *sinkMetrics) add(counter *int) {
+	m.mu.Lock()
+	*counter++
+	m.mu.Unlock()
+}
+
+// sinkCall runs op against the sink, retrying errors marked with
+// RetryableSinkError up to h.MaxRetries times, and adds its time to m.
+func (h *DDHandler) sinkCall(ctx context.Context, m *sinkMetrics, what string, op func() error) error {
+	start := time.Now()
+	defer func() {
+		m.mu.Lock()
+		m.elapsed += time.Since(start)
+		m.mu.Unlock()
+	}()
+	for attempt := 0; ; attempt++ {
+		err := op()
+		var retry *retryableSinkError
+		if err == nil || !errors.As(err, &retry) || attempt >= h.MaxRetries || ctx.Err() != nil {
+			return err
+		}
+		delay := backoff(attempt)
+		fmt.Fprintf(os.Stderr, "\nSink %s failed (%v); retrying in %s (%d/%d)\n", what, err, delay.Round(100*time.Millisecond), attempt+1, h.MaxRetries)
+		m.add(&m.retries)
+		if !sleepCtx(ctx, delay) {
+			return err
+		}
+	}
+}
+
+// fileSink writes each chunk to a temporary file in dir and renames it into
+// place on Commit.
+type fileSink struct {
+	dir    string
+	format string
+
+	path string
+	f    *os.File
+	bw   *bufio.Writer
+	w    logWriter
+}
+
+// newFileSink creates the file://<dir> sink, creating dir if needed.
+func newFileSink(cfg SinkConfig) (Sink, error) {
+	dir := cfg.URL.Host + cfg.URL.Path
+	if dir == "" {
+		return nil, fmt.Errorf("--sink file:// needs a directory")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating sink directory: %w", err)
+	}
+	return &fileSink{dir: dir, format: cfg.Format}, nil
+}
+
+func (s *fileSink) DefaultCheckpoint() string {
+	return filepath.Join(s.dir, ".backfill.json")
+}
+
+// Committed reports whether chunk's file was renamed into place.
+func (s *fileSink) Committed(chunk SinkChunk) (string, bool, error) {
+	name := chunk.Name + "." + s.format
+	if _, err := os.Stat(filepath.Join(s.dir, name)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return name, true, nil
+}
+
+func (s *fileSink) Open(chunk SinkChunk) error {
+	s.path = filepath.Join(s.dir, chunk.Name+"."+s.format)
+	f, err := os.Create(s.path + ".tmp")
+	if err != nil {
+		return fmt.Errorf("creating chunk file: %w", err)
+	}
+	s.f = f
+	s.bw = bufio.NewWriterSize(f, 256*1024)
+	s.w = newLogWriter(QueryOptions{Format: s.format}, s.bw)
+	s.w.Start()
+	return nil
+}
+
+func (s *fileSink) WriteBatch(logs []datadogV2.Log) error {
+	for _, log := range logs {
+		if err := s.w.WriteLog(log); err != nil {
+			return fmt.Errorf("writing log: %w", err)
+		}
+	}
+	return s.w.FlushPage()
+}
+
+func (s *fileSink) Commit() (string, error) {
+	if err := s.w.End(); err != nil {
+		return "", fmt.Errorf("finishing output: %w", err)
+	}
+	if err := s.bw.Flush(); err != nil {
+		return "", fmt.Errorf("writing chunk file: %w", err)
+	}
+	f := s.f
+	s.f = nil
+	if err := f.Close(); err != nil {
+		os.Remove(s.path + ".tmp")
+		return "", fmt.Errorf("writing chunk file: %w", err)
+	}
+	if err := os.Rename(s.path+".tmp", s.path); err != nil {
+		os.Remove(s.path + ".tmp")
+		return "", fmt.Errorf("writing chunk file: %w", err)
+	}
+	return filepath.Base(s.path), nil
+}
+
+func (s *fileSink) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	s.f.Close()
+	s.f = nil
+	return os.Remove(s.path + ".tmp")
+}
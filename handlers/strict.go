@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"unicode/utf8"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// rejectError reports a log that strict mode refused to write.
+type rejectError struct {
+	Log    datadogV2.Log
+	Reason string
+}
+
+func (e *rejectError) Error() string {
+	return fmt.Sprintf("strict mode: log %s rejected: %s", e.Log.GetId(), e.Reason)
+}
+
+// validateLog returns a rejectError when log would be written lossily:
+// the SDK could not parse it into its schema, or it carries invalid UTF-8
+// that encoders would silently replace.
+func validateLog(log datadogV2.Log) error {
+	if log.UnparsedObject != nil || (log.Attributes != nil && log.Attributes.UnparsedObject != nil) {
+		return &rejectError{Log: log, Reason: "event does not match the Logs API schema"}
+	}
+
+	attrs := log.GetAttributes()
+	for _, col := range fixedColumns {
+		if !utf8.ValidString(columnValue(&attrs, nil, col)) {
+			return &rejectError{Log: log, Reason: fmt.Sprintf("invalid UTF-8 in %s", col)}
+		}
+	}
+	for k, v := range attrs.GetAttributes() {
+		if !validUTF8(k) || !validUTF8(v) {
+			return &rejectError{Log: log, Reason: fmt.Sprintf("invalid UTF-8 in attribute %q", k)}
+		}
+		if _, err := encodeValue(v); err != nil {
+			return &rejectError{Log: log, Reason: fmt.Sprintf("attribute %q cannot be encoded: %v", k, err)}
+		}
+	}
+	return nil
+}
+
+// validUTF8 reports whether every string inside v is valid UTF-8.
+func validUTF8(v interface{}) bool {
+	switch val := v.(type) {
+	case string:
+		return utf8.ValidString(val)
+	case []interface{}:
+		for _, item := range val {
+			if !validUTF8(item) {
+				return false
+			}
+		}
+	case map[string]interface{}:
+		for k, item := range val {
+			if !utf8.ValidString(k) || !validUTF8(item) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// writeReject appends the rejected log and the reason to path as one NDJSON line.
+func writeReject(path string, rej *rejectError) error {
+	line, err := json.Marshal(struct {
+		Reason string        `json:"reason"`
+		Event  datadogV2.Log `json:"event"`
+	}{rej.Reason, rej.Log})
+	if err != nil {
+		return fmt.Errorf("encoding rejected log: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening reject file: %w", err)
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+const (
+	// MaxSubmitBatch is the most logs the Logs Intake API accepts in one
+	// request.
+	MaxSubmitBatch = 1000
+
+	// maxSubmitBatchBytes is the largest uncompressed request the intake
+	// accepts; batches are sent before they grow past it.
+	maxSubmitBatchBytes = 5 * 1000 * 1000
+
+	// DefaultSubmitFlushInterval is how long a partial batch waits for more
+	// lines before it is sent, so a slow stream is still shipped promptly.
+	DefaultSubmitFlushInterval = 5 * time.Second
+)
+
+// SubmitOptions configures a Submit.
+type SubmitOptions struct {
+	// Input is the file to read, or "" or "-" for stdin.
+	Input string
+
+	// Format is how lines are read: ndjson (each line a JSON object),
+	// text (each line a message) or auto, where lines holding a JSON
+	// object are read as ndjson and any other as text.
+	Format string
+
+	// Service, Source and Host fill the logs that do not set their own;
+	// Tags are added to every log's.
+	Service string
+	Source  string
+	Host    string
+	Tags    []string
+
+	// BatchSize is how many logs a request carries at most
+	// (MaxSubmitBatch if unset), and FlushInterval how long a partial
+	// batch waits (DefaultSubmitFlushInterval if unset).
+	BatchSize     int
+	FlushInterval time.Duration
+
+	Quiet bool
+}
+
+// Submit reads logs line by line from opts.Input and sends them to the Logs
+// Intake API in batches, retrying rate limits and server errors like
+// search does. A batch is sent when it is full, would pass the intake's
+// size limit, or has waited opts.FlushInterval, so "tail -f app.log |
+// ddlogs submit" ships lines as they come. On Ctrl-C the logs read so far
+// are sent before it stops.
+//
+// JSON objects are sent with their attributes as they are: message,
+// ddsource, ddtags, hostname and service are the intake's reserved fields.
+// Logs exported by search as NDJSON are recognised and sent back with the
+// same message, host, service, status, tags and attributes.
+func (h *DDHandler) Submit(opts SubmitOptions) error {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = MaxSubmitBatch
+	}
+	interval := opts.FlushInterval
+	if interval <= 0 {
+		interval = DefaultSubmitFlushInterval
+	}
+
+	in := io.Reader(os.Stdin)
+	if opts.Input != "" && opts.Input != "-" {
+		f, err := os.Open(opts.Input)
+		if err != nil {
+			return fmt.Errorf("opening input: %w", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	// Ctrl-C only stops reading input: batches are sent on sendCtx, which it
+	// does not cancel, so a batch in flight when it arrives still lands.
+	sendCtx := h.apiContext()
+	ctx, stop := signal.NotifyContext(sendCtx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Lines are read on their own goroutine so a partial batch can be sent
+	// while the input is idle.
+	lines := make(chan string)
+	readErr := make(chan error, 1)
+	go func() {
+		r := bufio.NewReaderSize(in, 256*1024)
+		for {
+			line, err := r.ReadString('\n')
+			if line != "" {
+				select {
+				case lines <- line:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					err = nil
+				}
+				readErr <- err
+				close(lines)
+				return
+			}
+		}
+	}()
+
+	api := h.logsAPI()
+	start := time.Now()
+	var (
+		batch     []datadogV2.HTTPLogItem
+		size      int
+		timer     <-chan time.Time
+		lineNo    int
+		sent      int
+		requests  int
+		sentBytes int64
+		line      progressLine
+	)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := h.submitLogs(sendCtx, api, batch); err != nil {
+			return fmt.Errorf("submitting logs %d-%d: %w", sent+1, sent+len(batch), err)
+		}
+		sent += len(batch)
+		sentBytes += int64(size)
+		requests++
+		batch, size, timer = batch[:0], 0, nil
+		if !opts.Quiet {
+			line.update("Submitted %d logs in %d requests", sent, requests)
+		}
+		return nil
+	}
+	done := func() {
+		if opts.Quiet {
+			return
+		}
+		fmt.Fprintf(os.Stderr, lineStart()+"Submitted %d logs in %d requests (%s) in %.1fs\n", sent, requests, formatBytes(sentBytes), time.Since(start).Seconds())
+	}
+
+	for {
+		select {
+		case text, ok := <-lines:
+			if !ok {
+				if err := <-readErr; err != nil {
+					return fmt.Errorf("reading input: %w", err)
+				}
+				if err := flush(); err != nil {
+					return err
+				}
+				done()
+				return nil
+			}
+			lineNo++
+			text = strings.TrimRight(text, "\r\n")
+			if strings.TrimSpace(text) == "" {
+				continue
+			}
+			item, err := submitItem(text, opts)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			encoded, err := json.Marshal(item)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			// The request body is a JSON array: one comma per log after the
+			// first and the brackets.
+			if len(batch) > 0 && size+len(encoded)+3 > maxSubmitBatchBytes {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			if len(batch) == 0 {
+				timer = time.After(interval)
+			}
+			batch = append(batch, item)
+			size += len(encoded) + 1
+			if len(batch) >= batchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		case <-timer:
+			if err := flush(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			if err := flush(); err != nil {
+				return err
+			}
+			done()
+			if !opts.Quiet {
+				fmt.Fprintf(os.Stderr, "Stopped after %d lines\n", lineNo)
+			}
+			return nil
+		}
+	}
+}
+
+// submitItem is the intake log for one input line.
+func submitItem(text string, opts SubmitOptions) (datadogV2.HTTPLogItem, error) {
+	var item datadogV2.HTTPLogItem
+	var obj map[string]interface{}
+	if opts.Format != "text" && strings.HasPrefix(strings.TrimSpace(text), "{") {
+		dec := json.NewDecoder(strings.NewReader(text))
+		dec.UseNumber()
+		if err := dec.Decode(&obj); err != nil && opts.Format == "ndjson" {
+			return item, fmt.Errorf("decoding JSON: %w", err)
+		} else if err != nil {
+			obj = nil
+		}
+	}
+	switch {
+	case obj != nil:
+		item = objectItem(exportedLogObject(obj))
+	case opts.Format == "ndjson":
+		return item, fmt.Errorf("not a JSON object")
+	default:
+		item = *datadogV2.NewHTTPLogItem(text)
+	}
+
+	if item.Service == nil && opts.Service != "" {
+		item.SetService(opts.Service)
+	}
+	if item.Ddsource == nil && opts.Source != "" {
+		item.SetDdsource(opts.Source)
+	}
+	if item.Hostname == nil && opts.Host != "" {
+		item.SetHostname(opts.Host)
+	}
+	if len(opts.Tags) > 0 {
+		tags := opts.Tags
+		if own := item.GetDdtags(); own != "" {
+			tags = append([]string{own}, tags...)
+		}
+		item.SetDdtags(strings.Join(tags, ","))
+	}
+	return item, nil
+}
+
+// objectItem is the intake log for a JSON object: its reserved fields set
+// the log's, and every other key is sent as an attribute.
+func objectItem(obj map[string]interface{}) datadogV2.HTTPLogItem {
+	item := datadogV2.HTTPLogItem{AdditionalProperties: make(map[string]interface{})}
+	for k, v := range obj {
+		s, isString := v.(string)
+		switch {
+		case k == "message" && isString:
+			item.Message = s
+		case k == "message":
+			item.Message = flattenValue(v)
+		case k == "ddsource" && isString:
+			item.SetDdsource(s)
+		case k == "ddtags" && isString:
+			item.SetDdtags(s)
+		case k == "hostname" && isString:
+			item.SetHostname(s)
+		case k == "service" && isString:
+			item.SetService(s)
+		default:
+			item.AdditionalProperties[k] = v
+		}
+	}
+	return item
+}
+
+// exportedLogObject turns a log written by search as JSON ({"id", "type":
+// "log", "attributes": {...}}) into the flat object the intake takes: its
+// attributes at the top, host as hostname, and tags as ddtags. Any other
+// object is returned as it is.
+func exportedLogObject(obj map[string]interface{}) map[string]interface{} {
+	attrs, ok := obj["attributes"].(map[string]interface{})
+	if obj["type"] != "log" || !ok {
+		return obj
+	}
+	flat := make(map[string]interface{})
+	if custom, ok := attrs["attributes"].(map[string]interface{}); ok {
+		for k, v := range custom {
+			flat[k] = v
+		}
+	}
+	for _, k := range []string{"message", "service", "status", "timestamp"} {
+		if v, ok := attrs[k]; ok && v != nil {
+			flat[k] = v
+		}
+	}
+	if host, ok := attrs["host"].(string); ok && host != "" {
+		flat["hostname"] = host
+	}
+	if tags, ok := attrs["tags"].([]interface{}); ok && len(tags) > 0 {
+		list := make([]string, 0, len(tags))
+		for _, t := range tags {
+			if s, ok := t.(string); ok {
+				list = append(list, s)
+			}
+		}
+		flat["ddtags"] = strings.Join(list, ",")
+	}
+	return flat
+}
+
+// submitLogs sends one batch to the Logs Intake API, gzipped, retrying rate
+// limits (429), server errors (5xx) and network failures up to h.MaxRetries
+// times like listLogs. Other errors, such as 400 or 403, are returned at
+// once.
+func (h *DDHandler) submitLogs(ctx context.Context, api *datadogV2.LogsApi, items []datadogV2.HTTPLogItem) error {
+	params := datadogV2.NewSubmitLogOptionalParameters().WithContentEncoding(datadogV2.CONTENTENCODING_GZIP)
+	for attempt := 0; ; attempt++ {
+		_, r, err := api.SubmitLog(h.siteContext(ctx), items, *params)
+		if err == nil {
+			return nil
+		}
+		if attempt >= h.MaxRetries || !retryable(ctx, r, err) {
+			return fmt.Errorf("calling LogsApi.SubmitLog: %w%s", err, submitErrorDetail(err))
+		}
+		delay := backoff(attempt)
+		if _, reset, ok := rateLimit(r); ok && r.StatusCode == http.StatusTooManyRequests && reset > 0 {
+			delay = reset
+		}
+		reason := err.Error()
+		if r != nil {
+			reason = r.Status
+		}
+		fmt.Fprintf(os.Stderr, "\nSubmitLog failed (%s); retrying in %s (%d/%d)\n", reason, delay.Round(100*time.Millisecond), attempt+1, h.MaxRetries)
+		if !sleepCtx(ctx, delay) {
+			return err
+		}
+	}
+}
+
+// submitErrorDetail is the body of an intake error response, which says
+// what was wrong with the payload, for appending to the error.
+func submitErrorDetail(err error) string {
+	var apiErr interface{ Body() []byte }
+	if !errors.As(err, &apiErr) {
+		return ""
+	}
+	body := bytes.TrimSpace(apiErr.Body())
+	if len(body) == 0 {
+		return ""
+	}
+	return ": " + truncateRunes(string(body), 500)
+}
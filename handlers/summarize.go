@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// summarySpool keeps a copy of an export, as written before compression,
+// for the --summarize-cmd command to read once the export ends.
+type summarySpool struct {
+	file *os.File
+}
+
+func newSummarySpool() (*summarySpool, error) {
+	f, err := os.CreateTemp("", "ddlogs-summary-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating summary spool: %w", err)
+	}
+	return &summarySpool{file: f}, nil
+}
+
+func (s *summarySpool) Write(p []byte) (int, error) {
+	return s.file.Write(p)
+}
+
+func (s *summarySpool) cleanup() {
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
+
+// summarize runs command through the shell with the export on stdin and
+// prints what it writes to stdout under the run's summary on stderr. The
+// command's own stderr passes through. DDLOGS_QUERY, DDLOGS_FROM, DDLOGS_TO
+// and DDLOGS_FORMAT describe the export, for prompts that mention them.
+func (s *summarySpool) summarize(command string, opts QueryOptions) error {
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("reading summary spool: %w", err)
+	}
+	var summary bytes.Buffer
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"DDLOGS_QUERY="+opts.Query,
+		"DDLOGS_FROM="+opts.From,
+		"DDLOGS_TO="+opts.To,
+		"DDLOGS_FORMAT="+opts.Format,
+	)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = s.file, &summary, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("--summarize-cmd: %w", err)
+	}
+	text := strings.TrimRight(summary.String(), "\n")
+	if text == "" {
+		fmt.Fprintf(os.Stderr, "Summary: (%s wrote nothing)\n", command)
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "\nSummary:\n%s\n", text)
+	return nil
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"bufio"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+const (
+	// DefaultTableWidth is the terminal width a table is laid out for when
+	// the real one is unknown.
+	DefaultTableWidth = 120
+
+	// defaultTableColumnWidth caps every column but message when
+	// QueryOptions.MaxColWidth is not set.
+	defaultTableColumnWidth = 40
+
+	// minTableMessageWidth is the narrowest the message column gets, even
+	// if the table then wraps.
+	minTableMessageWidth = 20
+)
+
+// tableWriter renders logs as an aligned table for reading in a terminal:
+// timestamp, status, service, host and any tag columns, then the message
+// in whatever width the terminal has left. Column widths are set from the
+// first page; longer values, later or not, are cut and end with "…", and
+// line breaks in messages are shown as spaces. Custom attributes are left
+// out.
+type tableWriter struct {
+	bw       *bufio.Writer
+	columns  []string
+	tagCols  []string
+	maxWidth int
+	width    int
+
+	timeFormat *TimeFormat
+
+	widths  []int
+	buffer  []datadogV2.Log
+	started bool
+}
+
+// tableColumns is the order of the fixed columns in a table; message comes
+// last so it can take the rest of the line.
+var tableColumns = []string{"timestamp", "status", "service", "host"}
+
+func newTableWriter(bw *bufio.Writer, fixed, tagCols []string, maxWidth, width int) *tableWriter {
+	w := &tableWriter{bw: bw, tagCols: tagCols, maxWidth: maxWidth, width: width}
+	if w.maxWidth <= 0 {
+		w.maxWidth = defaultTableColumnWidth
+	}
+	if w.width <= 0 {
+		w.width = DefaultTableWidth
+	}
+	for _, col := range tableColumns {
+		if containsString(fixed, col) {
+			w.columns = append(w.columns, col)
+		}
+	}
+	w.columns = append(w.columns, tagCols...)
+	if containsString(fixed, "message") {
+		w.columns = append(w.columns, "message")
+	}
+	return w
+}
+
+func (w *tableWriter) Start() {}
+
+func (w *tableWriter) WriteLog(log datadogV2.Log) error {
+	if !w.started {
+		w.buffer = append(w.buffer, log)
+		return nil
+	}
+	w.row(w.cells(log))
+	return nil
+}
+
+// begin sizes the columns to the header and the buffered first page, then
+// writes them.
+func (w *tableWriter) begin() {
+	rows := make([][]string, len(w.buffer))
+	for i, log := range w.buffer {
+		rows[i] = w.cells(log)
+	}
+	w.widths = make([]int, len(w.columns))
+	used := 0
+	for i, col := range w.columns {
+		width := len(col)
+		for _, row := range rows {
+			width = max(width, utf8.RuneCountInString(row[i]))
+		}
+		w.widths[i] = min(width, w.maxWidth)
+		if col != "message" {
+			used += w.widths[i] + 2
+		}
+	}
+	if n := len(w.columns); n > 0 && w.columns[n-1] == "message" {
+		w.widths[n-1] = max(w.width-used, minTableMessageWidth)
+	}
+
+	header := make([]string, len(w.columns))
+	for i, col := range w.columns {
+		header[i] = strings.ToUpper(col)
+	}
+	w.row(header)
+	for _, row := range rows {
+		w.row(row)
+	}
+	w.buffer = nil
+	w.started = true
+}
+
+func (w *tableWriter) cells(log datadogV2.Log) []string {
+	attrs := log.GetAttributes()
+	cells := make([]string, len(w.columns))
+	for i, col := range w.columns {
+		if containsString(w.tagCols, col) {
+			cells[i] = tagValue(attrs.GetTags(), col)
+		} else if col == "timestamp" && w.timeFormat != nil {
+			cells[i] = w.timeFormat.timestamp(&attrs)
+		} else {
+			cells[i] = columnValue(&attrs, nil, col)
+		}
+		cells[i] = tableText(cells[i])
+	}
+	return cells
+}
+
+// row writes cells padded to the column widths, cutting longer ones.
+func (w *tableWriter) row(cells []string) {
+	for i, c := range cells {
+		if utf8.RuneCountInString(c) > w.widths[i] {
+			c = truncateRunes(c, w.widths[i]-1)
+		}
+		w.bw.WriteString(c)
+		if i < len(cells)-1 {
+			w.bw.WriteString(strings.Repeat(" ", w.widths[i]-utf8.RuneCountInString(c)+2))
+		}
+	}
+	w.bw.WriteString("\n")
+}
+
+// tableText puts s on one line: line breaks and tabs become spaces, and
+// other control characters are escaped (see textCell).
+func tableText(s string) string {
+	return textCell(strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ", "\t", " ").Replace(s))
+}
+
+func (w *tableWriter) FlushPage() error {
+	if !w.started {
+		w.begin()
+	}
+	return nil
+}
+
+func (w *tableWriter) End() error {
+	if !w.started {
+		w.begin()
+	}
+	return nil
+}
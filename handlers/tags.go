@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV1"
+)
+
+// tagFilter is one key with the value patterns it may match. Values may
+// contain * wildcards.
+type tagFilter struct {
+	Key    string
+	Values []string
+}
+
+// TagExpr is a parsed --tags expression: every key must match (AND), and a
+// key given several times matches any of its values (OR).
+type TagExpr []tagFilter
+
+// ParseTagExpr parses a comma-separated list of key:value tags such as
+// "env:prod,region:us-*,env:staging".
+func ParseTagExpr(expr string) (TagExpr, error) {
+	var tags TagExpr
+	index := make(map[string]int)
+	for _, part := range strings.Split(expr, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, ":")
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("invalid tag %q: want key:value", part)
+		}
+		if i, ok := index[key]; ok {
+			tags[i].Values = append(tags[i].Values, value)
+			continue
+		}
+		index[key] = len(tags)
+		tags = append(tags, tagFilter{Key: key, Values: []string{value}})
+	}
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("no tags given")
+	}
+	return tags, nil
+}
+
+// Query compiles the expression into Datadog query syntax, e.g.
+// env:(prod OR staging) region:us-*.
+func (t TagExpr) Query() string {
+	terms := make([]string, len(t))
+	for i, f := range t {
+		if len(f.Values) == 1 {
+			terms[i] = f.Key + ":" + f.Values[0]
+		} else {
+			terms[i] = f.Key + ":(" + strings.Join(f.Values, " OR ") + ")"
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+// ValidateTags checks every key:value pattern in tags against the tags
+// reported by the org's hosts, so a typo fails before an export runs
+// instead of silently returning nothing. The error lists the values that do
+// exist for each offending key.
+func (h *DDHandler) ValidateTags(tags TagExpr) error {
+	api := datadogV1.NewTagsApi(datadog.NewAPIClient(datadog.NewConfiguration()))
+	resp, _, err := api.ListHostTags(h.apiContext())
+	if err != nil {
+		return fmt.Errorf("calling TagsApi.ListHostTags: %w", err)
+	}
+
+	known := make(map[string][]string)
+	for tag := range resp.GetTags() {
+		key, value, ok := strings.Cut(tag, ":")
+		if ok {
+			known[key] = append(known[key], value)
+		}
+	}
+
+	var problems []string
+	for _, f := range tags {
+		values, ok := known[f.Key]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("  %s: no host reports this tag key", f.Key))
+			continue
+		}
+		for _, pattern := range f.Values {
+			if !anyTagMatches(pattern, values) {
+				sort.Strings(values)
+				problems = append(problems, fmt.Sprintf("  %s:%s matches no value; known values: %s", f.Key, pattern, strings.Join(values, ", ")))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("unknown tags:\n%s", strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// anyTagMatches reports whether pattern, which may contain * wildcards,
+// matches any of values.
+func anyTagMatches(pattern string, values []string) bool {
+	for _, v := range values {
+		if globMatch(pattern, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// globMatch matches s against pattern, where * matches any run of
+// characters, including none.
+func globMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// apiTimeLayout is the absolute timestamp format sent in from/to filters.
+const apiTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// TailOptions configures a Tail run.
+type TailOptions struct {
+	Query  string
+	Format string
+
+	// Interval is the delay between polls.
+	Interval time.Duration
+
+	// Lag is how far behind the newest log each poll re-reads, so logs that
+	// are indexed late still get picked up. Re-read logs are deduplicated by ID.
+	Lag time.Duration
+
+	// StateFile, when set, persists the recently emitted log IDs and the
+	// newest timestamp after every poll. A restarted tail resumes from it
+	// without re-emitting logs near the boundary.
+	StateFile string
+
+	// OutputFile, when set, receives the logs instead of stdout.
+	OutputFile string
+
+	// SchemaLog, when set, is a sidecar file to which every schema change
+	// in CSV output — attributes first seen after the header was written —
+	// is appended as one JSON line.
+	SchemaLog string
+
+	// RotateOnSchemaChange starts a new CSV file, numbered after
+	// OutputFile, whenever new attributes appear, with a header extended by
+	// them. Without it those attributes have no column in the CSV.
+	RotateOnSchemaChange bool
+
+	// ExcelSafe guards CSV cells against formula injection, as for search.
+	ExcelSafe bool
+}
+
+// Tail polls for logs matching the query and streams new ones to stdout
+// until interrupted, similar to Datadog's Live Tail. Each poll re-reads the
+// last Lag of logs to catch late arrivals; logs already emitted are skipped
+// by ID. On SIGINT/SIGTERM the output is closed out cleanly.
+func (h *DDHandler) Tail(opts TailOptions) error {
+	ctx, stop := signal.NotifyContext(h.apiContext(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	api := h.logsAPI()
+
+	seen := newSeenSet()
+	since := time.Now().Add(-opts.Interval)
+	resumed := false
+	if opts.StateFile != "" {
+		state, err := loadTailState(opts.StateFile)
+		if err != nil {
+			return err
+		}
+		if state != nil {
+			seen.ids = state.IDs
+			since = state.Since
+			resumed = true
+			fmt.Fprintf(os.Stderr, "Resuming from %s (%d recent IDs)\n", since.Format(time.RFC3339), len(seen.ids))
+		}
+	}
+
+	// A resumed tail appends to the file the last run was writing, the
+	// highest numbered one if it rotated, under the header already there.
+	out := os.Stdout
+	rotations := 0
+	var header []string
+	if opts.OutputFile != "" {
+		path, flag := opts.OutputFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC
+		if resumed {
+			for n := 1; ; n++ {
+				if _, err := os.Stat(rotatedPath(opts.OutputFile, n)); err != nil {
+					break
+				}
+				rotations = n
+			}
+			if rotations > 0 {
+				path = rotatedPath(opts.OutputFile, rotations)
+			}
+			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+			if opts.Format == "csv" {
+				var err error
+				if header, err = readCSVHeader(path); err != nil {
+					return err
+				}
+			}
+		}
+		f, err := os.OpenFile(path, flag, 0o644)
+		if err != nil {
+			return fmt.Errorf("creating output file: %w", err)
+		}
+		out = f
+		defer func() { out.Close() }()
+		if resumed {
+			fmt.Fprintf(os.Stderr, "Appending to %s\n", path)
+		}
+	}
+	bw := bufio.NewWriterSize(out, 64*1024)
+	defer bw.Flush()
+	writer := newLogWriter(QueryOptions{Format: opts.Format, ExcelSafe: opts.ExcelSafe}, bw)
+	headerWritten := false
+	if header != nil {
+		if err := writer.(*csvWriter).resume(header); err != nil {
+			return fmt.Errorf("appending to %s: %w", out.Name(), err)
+		}
+		headerWritten = true
+	}
+	writer.Start()
+
+	fmt.Fprintf(os.Stderr, "Tailing %q every %s (Ctrl-C to stop)\n", opts.Query, opts.Interval)
+
+	// rotate closes the current CSV file and starts the next one; the new
+	// writer's header is written at the end of the poll, like the first.
+	rotate := func() (string, error) {
+		if err := writer.End(); err != nil {
+			return "", fmt.Errorf("finishing output: %w", err)
+		}
+		if err := bw.Flush(); err != nil {
+			return "", fmt.Errorf("flushing output: %w", err)
+		}
+		if err := out.Close(); err != nil {
+			return "", err
+		}
+		rotations++
+		path := rotatedPath(opts.OutputFile, rotations)
+		f, err := os.Create(path)
+		if err != nil {
+			return "", fmt.Errorf("creating output file: %w", err)
+		}
+		out = f
+		bw.Reset(out)
+		writer = writer.(*csvWriter).successor(bw)
+		headerWritten = false
+		return path, nil
+	}
+
+	// drift handles a log whose attributes are not all in the CSV header.
+	drift := func(log datadogV2.Log, late []string) error {
+		ev := schemaChange{Time: time.Now().UTC(), LogID: log.GetId(), Columns: late}
+		if opts.RotateOnSchemaChange {
+			path, err := rotate()
+			if err != nil {
+				return err
+			}
+			ev.File = path
+			fmt.Fprintf(os.Stderr, "New attribute(s) %s; continuing in %s\n", strings.Join(late, ", "), path)
+		}
+		if opts.SchemaLog != "" {
+			return appendSchemaChange(opts.SchemaLog, ev)
+		}
+		return nil
+	}
+
+	for {
+		now := time.Now()
+		from := since.Add(-opts.Lag)
+
+		err := h.listAll(ctx, api, opts.Query, from.UTC().Format(apiTimeLayout), now.UTC().Format(apiTimeLayout), nil, func(logs []datadogV2.Log) error {
+			for _, log := range logs {
+				if !seen.add(log) {
+					continue
+				}
+				if late := lateAttributes(writer, log); len(late) > 0 {
+					if err := drift(log, late); err != nil {
+						return err
+					}
+				}
+				if err := writer.WriteLog(log); err != nil {
+					return fmt.Errorf("writing log: %w", err)
+				}
+				if t := log.GetAttributes().Timestamp; t != nil && t.After(since) {
+					since = *t
+				}
+			}
+			return nil
+		})
+		// A Ctrl-C mid-poll still flushes and saves what the poll got, so a
+		// restart neither repeats nor skips those logs.
+		interrupted := ctx.Err() != nil
+		if err != nil {
+			var rej *rejectError
+			if errors.As(err, &rej) {
+				return err
+			}
+		}
+		if err != nil && !interrupted {
+			fmt.Fprintf(os.Stderr, "poll failed, retrying in %s: %v\n", opts.Interval, err)
+		}
+
+		// CSV columns come from the first poll that returns logs, or the
+		// first after a rotation.
+		if !headerWritten && seen.total > 0 {
+			if err := writer.FlushPage(); err != nil {
+				return fmt.Errorf("writing header: %w", err)
+			}
+			headerWritten = true
+		}
+		if err := bw.Flush(); err != nil {
+			return fmt.Errorf("flushing output: %w", err)
+		}
+
+		seen.prune(from)
+		if opts.StateFile != "" {
+			if err := saveTailState(opts.StateFile, tailState{Since: since, IDs: seen.ids}); err != nil {
+				return err
+			}
+		}
+		if interrupted {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(opts.Interval):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if err := writer.End(); err != nil {
+		return fmt.Errorf("finishing output: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "\nStopped after %d logs\n", seen.total)
+	return nil
+}
+
+// listAll fetches every page of logs between from and to (Datadog time
+// strings), oldest first, handing each page to fn. progress reports
+// rate-limit pauses, as for listLogs.
+func (h *DDHandler) listAll(ctx context.Context, api *datadogV2.LogsApi, query, from, to string, progress *fetchProgress, fn func([]datadogV2.Log) error) error {
+	var cursor *string
+	var site string
+	for {
+		body := newListRequest(query, from, to, cursor)
+		resp, _, err := h.listLogs(ctx, api, body, &site, progress)
+		if err != nil {
+			return fmt.Errorf("calling LogsApi.ListLogs: %w", err)
+		}
+		logs := resp.GetData()
+		if err := fn(logs); err != nil {
+			return err
+		}
+
+		after := resp.GetMeta().Page.GetAfter()
+		if after == "" || int32(len(logs)) < maxLogsPerRequest {
+			return nil
+		}
+		cursor = &after
+	}
+}
+
+// seenSet remembers the IDs of recently emitted logs so overlapping polls do
+// not emit them twice.
+type seenSet struct {
+	ids   map[string]time.Time
+	total int
+
+	// newest is the latest timestamp added.
+	newest time.Time
+}
+
+func newSeenSet() *seenSet {
+	return &seenSet{ids: make(map[string]time.Time)}
+}
+
+// add records log and reports whether it had not been seen before.
+func (s *seenSet) add(log datadogV2.Log) bool {
+	id := log.GetId()
+	if _, ok := s.ids[id]; ok {
+		return false
+	}
+	var ts time.Time
+	if t := log.GetAttributes().Timestamp; t != nil {
+		ts = *t
+	}
+	s.ids[id] = ts
+	s.total++
+	if ts.After(s.newest) {
+		s.newest = ts
+	}
+	return true
+}
+
+// prune forgets IDs older than cutoff; no later poll can return them.
+func (s *seenSet) prune(cutoff time.Time) {
+	for id, ts := range s.ids {
+		if ts.Before(cutoff) {
+			delete(s.ids, id)
+		}
+	}
+}
+
+// tailState is the dedup window persisted between tail runs.
+type tailState struct {
+	Since time.Time            `json:"since"`
+	IDs   map[string]time.Time `json:"ids"`
+}
+
+// loadTailState reads a state file, returning nil if it does not exist yet.
+func loadTailState(path string) (*tailState, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading state file: %w", err)
+	}
+	var state tailState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, fmt.Errorf("decoding state file %s: %w", path, err)
+	}
+	if state.IDs == nil {
+		state.IDs = make(map[string]time.Time)
+	}
+	return &state, nil
+}
+
+// saveTailState writes state atomically so a crash mid-write never leaves a
+// truncated file behind.
+func saveTailState(path string, state tailState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// serviceDefinitionPageSize is the number of service definitions requested
+// per page when scanning the Service Catalog.
+const serviceDefinitionPageSize = 100
+
+// TeamServices returns the services the Service Catalog lists as owned by
+// team, sorted by name. Teams are matched case-insensitively against the
+// team field of every schema version (and dd-team for v2).
+func (h *DDHandler) TeamServices(team string) ([]string, error) {
+	api := datadogV2.NewServiceDefinitionApi(datadog.NewAPIClient(datadog.NewConfiguration()))
+	params := datadogV2.NewListServiceDefinitionsOptionalParameters().WithPageSize(serviceDefinitionPageSize)
+	items, cancel := api.ListServiceDefinitionsWithPagination(h.apiContext(), *params)
+	defer cancel()
+
+	seen := make(map[string]bool)
+	for item := range items {
+		if item.Error != nil {
+			return nil, fmt.Errorf("calling ServiceDefinitionApi.ListServiceDefinitions: %w", item.Error)
+		}
+		service, owner := serviceOwner(item.Item.GetAttributes().Schema)
+		if service != "" && strings.EqualFold(owner, team) {
+			seen[service] = true
+		}
+	}
+
+	services := make([]string, 0, len(seen))
+	for s := range seen {
+		services = append(services, s)
+	}
+	sort.Strings(services)
+	return services, nil
+}
+
+// serviceOwner extracts the service name and owning team from whichever
+// schema version a definition uses.
+func serviceOwner(schema *datadogV2.ServiceDefinitionSchema) (service, team string) {
+	switch {
+	case schema == nil:
+		return "", ""
+	case schema.ServiceDefinitionV2Dot2 != nil:
+		return schema.ServiceDefinitionV2Dot2.DdService, schema.ServiceDefinitionV2Dot2.GetTeam()
+	case schema.ServiceDefinitionV2Dot1 != nil:
+		return schema.ServiceDefinitionV2Dot1.DdService, schema.ServiceDefinitionV2Dot1.GetTeam()
+	case schema.ServiceDefinitionV2 != nil:
+		team = schema.ServiceDefinitionV2.GetTeam()
+		if team == "" {
+			team = schema.ServiceDefinitionV2.GetDdTeam()
+		}
+		return schema.ServiceDefinitionV2.DdService, team
+	case schema.ServiceDefinitionV1 != nil:
+		org := schema.ServiceDefinitionV1.Org
+		return schema.ServiceDefinitionV1.Info.DdService, org.GetTeam()
+	}
+	return "", ""
+}
+
+// ScopeQuery restricts query to the given services, as
+// service:(a OR b OR c) ANDed with the original query.
+func ScopeQuery(query string, services []string) string {
+	return AndQuery("service:("+strings.Join(services, " OR ")+")", query)
+}
+
+// AndQuery combines scope and query so both must match. query is
+// parenthesized so a top-level OR in it cannot escape the scope; an empty or
+// match-all query leaves just the scope.
+func AndQuery(scope, query string) string {
+	if strings.TrimSpace(query) == "" || strings.TrimSpace(query) == "*" {
+		return scope
+	}
+	return scope + " (" + query + ")"
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// templateLog is what a --template is executed with, once per log:
+//
+//	{{.Timestamp}} {{.Service}} {{.Message}}
+//	{{.Timestamp.Format "15:04:05"}} {{.Attr "http.status_code"}} {{.Tag "env"}}
+//
+// Attributes holds the custom attributes as decoded; Attr looks one up by
+// name or dotted path and renders it like a CSV cell, "" when missing.
+type templateLog struct {
+	ID         string
+	Timestamp  templateTime
+	Host       string
+	Service    string
+	Status     string
+	Message    string
+	Tags       []string
+	Attributes map[string]interface{}
+}
+
+// templateTime prints like the CSV timestamp column, as RFC 3339 or in
+// --time-format, and keeps time.Time's methods, so {{.Timestamp.Unix}} and
+// {{.Timestamp.Format "15:04:05"}} work too.
+type templateTime struct {
+	time.Time
+	format *TimeFormat
+}
+
+func (t templateTime) String() string {
+	if t.IsZero() {
+		return ""
+	}
+	if t.format != nil {
+		return t.format.Format(t.Time)
+	}
+	return t.Format(time.RFC3339)
+}
+
+// Attr renders the custom attribute at path, e.g. "user" or "http.url".
+func (l templateLog) Attr(path string) string {
+	v, ok := lookupAttr(l.Attributes, path)
+	if !ok {
+		return ""
+	}
+	return flattenValue(v)
+}
+
+// Tag returns the value of the key:value tag with this key, joined with
+// ";" when there are several.
+func (l templateLog) Tag(key string) string {
+	return tagValue(l.Tags, key)
+}
+
+// templateFuncs are available to every --template.
+var templateFuncs = template.FuncMap{
+	// json encodes a value compactly, e.g. {{json .Attributes}}.
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// ParseLogTemplate parses a --template for --format template.
+func ParseLogTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("log").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// templateWriter renders each log through a text/template, followed by a
+// newline unless the template ends with one.
+type templateWriter struct {
+	bw      *bufio.Writer
+	tmpl    *template.Template
+	newline bool
+
+	timeFormat *TimeFormat
+}
+
+func newTemplateWriter(bw *bufio.Writer, tmpl *template.Template) *templateWriter {
+	return &templateWriter{bw: bw, tmpl: tmpl, newline: !strings.HasSuffix(tmpl.Root.String(), "\n")}
+}
+
+func (w *templateWriter) Start() {}
+
+func (w *templateWriter) WriteLog(log datadogV2.Log) error {
+	attrs := log.GetAttributes()
+	data := templateLog{
+		ID:         log.GetId(),
+		Host:       attrs.GetHost(),
+		Service:    attrs.GetService(),
+		Status:     attrs.GetStatus(),
+		Message:    attrs.GetMessage(),
+		Tags:       attrs.GetTags(),
+		Attributes: attrs.GetAttributes(),
+	}
+	if t, ok := attrs.GetTimestampOk(); ok && t != nil {
+		data.Timestamp = templateTime{Time: *t, format: w.timeFormat}
+	}
+	if err := w.tmpl.Execute(w.bw, data); err != nil {
+		return fmt.Errorf("rendering template for log %s: %w", log.GetId(), err)
+	}
+	if w.newline {
+		w.bw.WriteByte('\n')
+	}
+	return nil
+}
+
+func (w *templateWriter) FlushPage() error { return nil }
+
+func (w *templateWriter) End() error { return nil }
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// TimeFormat is how exported timestamps are written: a Go layout, or whole
+// seconds or milliseconds since the Unix epoch.
+type TimeFormat struct {
+	Name   string
+	layout string
+	unit   time.Duration
+}
+
+// TimeFormats are the named --time-format values; anything else is taken as
+// a Go layout.
+var TimeFormats = []*TimeFormat{
+	{Name: "rfc3339", layout: time.RFC3339},
+	{Name: "rfc3339nano", layout: time.RFC3339Nano},
+	{Name: "epoch", unit: time.Second},
+	{Name: "epoch-ms", unit: time.Millisecond},
+}
+
+// ParseTimeFormat parses a --time-format value: rfc3339, rfc3339nano,
+// epoch, epoch-ms, or a Go layout such as "2006-01-02 15:04:05.000".
+func ParseTimeFormat(s string) (*TimeFormat, error) {
+	for _, f := range TimeFormats {
+		if strings.EqualFold(s, f.Name) {
+			return f, nil
+		}
+	}
+	// A layout renders any other time differently from its own text; a
+	// typo such as "YYYY-MM-DD" would print the same string for every log.
+	if s == "" || time.Unix(0, 0).UTC().Format(s) == s {
+		return nil, fmt.Errorf("%q is neither rfc3339, rfc3339nano, epoch, epoch-ms nor a Go layout such as \"2006-01-02 15:04:05\"", s)
+	}
+	return &TimeFormat{Name: s, layout: s}, nil
+}
+
+// Format renders t. Layouts keep t's zone (see --tz); epoch values have none.
+func (f *TimeFormat) Format(t time.Time) string {
+	if f.unit > 0 {
+		return strconv.FormatInt(t.UnixNano()/int64(f.unit), 10)
+	}
+	return t.Format(f.layout)
+}
+
+// timestamp renders the timestamp of attrs, or "" when it has none.
+func (f *TimeFormat) timestamp(attrs *datadogV2.LogAttributes) string {
+	if t, ok := attrs.GetTimestampOk(); ok && t != nil {
+		return f.Format(*t)
+	}
+	return ""
+}
+
+// jsonValue is t as a JSON value: a number for epoch formats, so JSON
+// consumers need not parse it, and a string otherwise.
+func (f *TimeFormat) jsonValue(t time.Time) interface{} {
+	if f.unit > 0 {
+		return json.Number(f.Format(t))
+	}
+	return f.Format(t)
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// epochMillisThreshold separates epoch seconds from epoch milliseconds:
+// 1e12 seconds is tens of thousands of years away, while 1e12 milliseconds
+// is 2001.
+const epochMillisThreshold = 1e12
+
+// epochMinDigits is the fewest digits taken as an epoch time. Shorter
+// integers are more likely a mistyped date (20240501) or a duration missing
+// its unit than a time before 2001, so they are rejected instead.
+const epochMinDigits = 10
+
+// Calendar units accepted on top of Go's duration units. A month is a fixed
+// 30 days, so windows are the same length whenever they are resolved.
+const (
+	day   = 24 * time.Hour
+	week  = 7 * day
+	month = 30 * day
+)
+
+// durationUnits lists every unit parseDuration accepts. Longer suffixes come
+// first so "mo" and "ms" are not mistaken for "m".
+var durationUnits = []struct {
+	suffix string
+	unit   time.Duration
+}{
+	{"mo", month},
+	{"ms", time.Millisecond},
+	{"us", time.Microsecond},
+	{"ns", time.Nanosecond},
+	{"w", week},
+	{"d", day},
+	{"h", time.Hour},
+	{"m", time.Minute},
+	{"s", time.Second},
+}
+
+// parseDuration parses a relative --from/--to value. It accepts everything
+// time.ParseDuration does plus d (days), w (weeks) and mo (30-day months),
+// optionally combined: 7d, 2w, 1mo, 1w3d, 1d12h.
+func parseDuration(value string) (time.Duration, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return d, nil
+	}
+	if value == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+
+	var total time.Duration
+	rest := value
+	for rest != "" {
+		i := 0
+		for i < len(rest) && (rest[i] >= '0' && rest[i] <= '9' || rest[i] == '.') {
+			i++
+		}
+		if i == 0 {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		if i == len(value) {
+			return 0, fmt.Errorf("invalid time %q: a duration needs a unit (15m, 7d) and epoch seconds need at least %d digits; write dates as 2024-05-01", value, epochMinDigits)
+		}
+		n, err := strconv.ParseFloat(rest[:i], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		rest = rest[i:]
+
+		matched := false
+		for _, u := range durationUnits {
+			if strings.HasPrefix(rest, u.suffix) {
+				total += time.Duration(n * float64(u.unit))
+				rest = rest[len(u.suffix):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return 0, fmt.Errorf("invalid duration %q: unknown unit (use s, m, h, d, w or mo)", value)
+		}
+	}
+	return total, nil
+}
+
+// datadogDuration renders d in the largest Datadog date-math unit that
+// represents it exactly, e.g. two weeks as "14d" and 36 hours as "36h".
+func datadogDuration(d time.Duration) string {
+	for _, u := range []struct {
+		suffix string
+		unit   time.Duration
+	}{{"d", day}, {"h", time.Hour}, {"m", time.Minute}} {
+		if d%u.unit == 0 {
+			return strconv.FormatInt(int64(d/u.unit), 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(int64(d/time.Second), 10) + "s"
+}
+
+// ParseAbsoluteTime recognizes the absolute forms accepted by --from/--to:
+// RFC 3339 (2024-05-01T00:00:00Z, with optional fractional seconds), a bare
+// date taken as midnight UTC (2024-05-01), and Unix epoch seconds or
+// milliseconds of at least epochMinDigits digits (1714521600,
+// 1714521600000). It reports false for anything else, such as relative
+// durations.
+func ParseAbsoluteTime(value string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, true
+	}
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= 0 && len(value) >= epochMinDigits {
+		if n >= epochMillisThreshold {
+			return time.UnixMilli(n).UTC(), true
+		}
+		return time.Unix(n, 0).UTC(), true
+	}
+	return time.Time{}, false
+}
+
+// resolveTime converts a --from/--to value into the absolute time it refers
+// to at now.
+func resolveTime(value string, now time.Time) (time.Time, error) {
+	if value == "now" {
+		return now, nil
+	}
+	if t, ok := ParseAbsoluteTime(value); ok {
+		return t, nil
+	}
+	d, err := parseDuration(value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return now.Add(-d), nil
+}
+
+// ResolveTimeRange converts a --from/--to pair into the absolute window it
+// refers to now.
+func ResolveTimeRange(from, to string) (time.Time, time.Time, error) {
+	now := time.Now()
+	start, err := resolveTime(from, now)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("from: %w", err)
+	}
+	end, err := resolveTime(to, now)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("to: %w", err)
+	}
+	return start, end, nil
+}
+
+// toDatadogTime converts a --from/--to value into the API's format: "now",
+// "now-<duration>" for relative values, or an absolute RFC 3339 instant in
+// UTC, which Datadog interprets unambiguously. Go durations are passed
+// through unchanged; values using d/w/mo are normalized, so 1mo becomes
+// "now-30d". Anything unrecognized is sent as-is for Datadog to judge.
+func toDatadogTime(value string) string {
+	if value == "now" {
+		return "now"
+	}
+	if t, ok := ParseAbsoluteTime(value); ok {
+		return t.UTC().Format(apiTimeLayout)
+	}
+	if _, err := time.ParseDuration(value); err != nil {
+		if d, err := parseDuration(value); err == nil {
+			return "now-" + datadogDuration(d)
+		}
+	}
+	return "now-" + value
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAbsoluteTime(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  time.Time
+		ok    bool
+	}{
+		{"2024-05-01T00:00:00Z", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), true},
+		{"2024-05-01T02:00:00+02:00", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), true},
+		{"2024-05-01T00:00:00.250Z", time.Date(2024, 5, 1, 0, 0, 0, 250e6, time.UTC), true},
+		{"2024-05-01", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), true},
+		{"1714521600", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), true},
+		{"1714521600000", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), true},
+		{"1714521600250", time.Date(2024, 5, 1, 0, 0, 0, 250e6, time.UTC), true},
+		{"1000000000", time.Unix(1e9, 0), true},
+
+		// Short integers are mistyped dates or durations, not 1970.
+		{"20240501", time.Time{}, false},
+		{"999999999", time.Time{}, false},
+		{"0", time.Time{}, false},
+		{"-1714521600", time.Time{}, false},
+		{"15m", time.Time{}, false},
+		{"now", time.Time{}, false},
+		{"2024-13-01", time.Time{}, false},
+		{"", time.Time{}, false},
+	} {
+		got, ok := ParseAbsoluteTime(tc.value)
+		if ok != tc.ok || !got.Equal(tc.want) {
+			t.Errorf("ParseAbsoluteTime(%q) = %v, %v, want %v, %v", tc.value, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  time.Duration
+	}{
+		{"15m", 15 * time.Minute},
+		{"1h30m", 90 * time.Minute},
+		{"7d", 7 * day},
+		{"2w", 14 * day},
+		{"1mo", 30 * day},
+		{"1w3d", 10 * day},
+		{"1d12h", 36 * time.Hour},
+		{"1.5d", 36 * time.Hour},
+		{"500ms", 500 * time.Millisecond},
+		{"0", 0},
+	} {
+		got, err := parseDuration(tc.value)
+		if err != nil || got != tc.want {
+			t.Errorf("parseDuration(%q) = %v, %v, want %v", tc.value, got, err, tc.want)
+		}
+	}
+	for _, value := range []string{"", "d", "7x", "7 d", "20240501", "1h-5m"} {
+		if d, err := parseDuration(value); err == nil {
+			t.Errorf("parseDuration(%q) = %v, want an error", value, d)
+		}
+	}
+}
+
+func TestResolveTimeRange(t *testing.T) {
+	start, end, err := ResolveTimeRange("2024-05-01", "1714608000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC); !start.Equal(want) {
+		t.Errorf("start = %v, want %v", start, want)
+	}
+	if want := time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC); !end.Equal(want) {
+		t.Errorf("end = %v, want %v", end, want)
+	}
+
+	start, end, err = ResolveTimeRange("1h", "now")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := end.Sub(start); d != time.Hour {
+		t.Errorf("1h..now spans %v, want 1h", d)
+	}
+
+	for _, tc := range [][2]string{{"20240501", "now"}, {"1h", "tomorrow"}} {
+		if _, _, err := ResolveTimeRange(tc[0], tc[1]); err == nil {
+			t.Errorf("ResolveTimeRange(%q, %q) succeeded, want an error", tc[0], tc[1])
+		}
+	}
+}
+
+func TestToDatadogTime(t *testing.T) {
+	for _, tc := range []struct{ value, want string }{
+		{"now", "now"},
+		{"15m", "now-15m"},
+		{"7d", "now-7d"},
+		{"1mo", "now-30d"},
+		{"1w12h", "now-180h"},
+		{"2024-05-01", "2024-05-01T00:00:00.000Z"},
+		{"1714521600000", "2024-05-01T00:00:00.000Z"},
+	} {
+		if got := toDatadogTime(tc.value); got != tc.want {
+			t.Errorf("toDatadogTime(%q) = %q, want %q", tc.value, got, tc.want)
+		}
+	}
+}
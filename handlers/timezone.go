@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // --tz works on hosts without a zoneinfo database
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// ParseTimeZone parses a --tz value: "local" for the machine's zone, "UTC",
+// or an IANA name such as America/New_York.
+func ParseTimeZone(name string) (*time.Location, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "local":
+		return time.Local, nil
+	case "utc", "z":
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(strings.TrimSpace(name))
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q (want local, UTC or an IANA name such as America/New_York)", name)
+	}
+	return loc, nil
+}
+
+// zoneWriter wraps another logWriter and moves every timestamp into loc, so
+// text formats render the zone's wall clock and offset. The instant is
+// unchanged.
+type zoneWriter struct {
+	logWriter
+	loc *time.Location
+}
+
+func newZoneWriter(inner logWriter, loc *time.Location) *zoneWriter {
+	return &zoneWriter{logWriter: inner, loc: loc}
+}
+
+func (w *zoneWriter) WriteLog(log datadogV2.Log) error {
+	attrs := log.GetAttributes()
+	if t, ok := attrs.GetTimestampOk(); ok && t != nil {
+		attrs.SetTimestamp(t.In(w.loc))
+		log.SetAttributes(attrs)
+	}
+	return w.logWriter.WriteLog(log)
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Tokenizer estimates how many tokens a language model's tokenizer splits
+// text into, without its vocabulary. Text is pre-split the way byte-pair
+// tokenizers do it (words with their leading space, digit runs, runs of
+// punctuation, whitespace) and each piece is charged at the tokenizer's
+// typical rate. The result is an estimate; leave headroom below a hard
+// context limit.
+type Tokenizer struct {
+	Name string
+
+	// wordChars is the longest ASCII word that is usually one token;
+	// longer runs of letters (identifiers, encoded data) are charged at
+	// longChars letters per token.
+	wordChars int
+	longChars float64
+
+	// digitGroup is the longest run of digits one token covers.
+	digitGroup int
+}
+
+// Tokenizers are the tokenizers --tokenizer can estimate for.
+var Tokenizers = []*Tokenizer{
+	{Name: "cl100k", wordChars: 6, longChars: 3.5, digitGroup: 3}, // GPT-4, GPT-3.5
+	{Name: "o200k", wordChars: 7, longChars: 3.8, digitGroup: 3},  // GPT-4o and later
+	{Name: "claude", wordChars: 6, longChars: 3.2, digitGroup: 2},
+	{Name: "llama3", wordChars: 6, longChars: 3.6, digitGroup: 3},
+}
+
+// ParseTokenizer returns the tokenizer called name.
+func ParseTokenizer(name string) (*Tokenizer, error) {
+	var names []string
+	for _, t := range Tokenizers {
+		if strings.EqualFold(name, t.Name) {
+			return t, nil
+		}
+		names = append(names, t.Name)
+	}
+	return nil, fmt.Errorf("unknown tokenizer %q (want %s)", name, strings.Join(names, ", "))
+}
+
+// pretokenize splits text into the pieces a byte-pair tokenizer merges
+// within, like the GPT-2 family's pattern.
+var pretokenize = regexp.MustCompile(`(?i:'s|'t|'re|'ve|'m|'ll|'d)| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|\s+`)
+
+// Estimate returns the approximate number of tokens in s.
+func (t *Tokenizer) Estimate(s string) int {
+	n := 0
+	for _, piece := range pretokenize.FindAllString(s, -1) {
+		word := strings.TrimPrefix(piece, " ")
+		r, _ := utf8.DecodeRuneInString(word)
+		switch {
+		case word == "" || unicode.IsSpace(r):
+			n++
+		case piece[0] == '\'' && len(piece) <= 3:
+			n++
+		case unicode.IsLetter(r):
+			ascii, other := 0, 0
+			for _, c := range word {
+				if c < utf8.RuneSelf {
+					ascii++
+				} else {
+					other++
+				}
+			}
+			switch {
+			case ascii == 0:
+			case ascii <= t.wordChars:
+				n++
+			default:
+				n += int(math.Ceil(float64(ascii) / t.longChars))
+			}
+			// Letters outside ASCII are rarely merged: about one token
+			// each.
+			n += other
+		case unicode.IsNumber(r):
+			n += (utf8.RuneCountInString(word) + t.digitGroup - 1) / t.digitGroup
+		default:
+			n += (utf8.RuneCountInString(word) + 1) / 2
+		}
+	}
+	return n
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// Transform plugins are WebAssembly modules that rewrite or drop each log
+// before it is written. They run in-process under wazero, sandboxed: no
+// file system, network, environment or clock beyond what WASI stubs, and
+// memory capped at transformMemoryPages. A plugin exports:
+//
+//	memory                            its linear memory
+//	alloc(size i32) i32               a buffer of size bytes for the host
+//	transform(ptr i32, len i32) i64   the log at ptr as JSON; returns the
+//	                                  result as ptr<<32 | len, len 0 to drop
+//	free(ptr i32, len i32)            optional; called for both buffers
+//	_initialize()                     optional; run once when loaded
+//
+// The JSON is the log as the Logs API returns it ({"id", "type",
+// "attributes": {...}}), and a plugin returns the same shape. Modules built
+// as WASI reactors (Go's -buildmode=c-shared, TinyGo, Rust cdylib) work; a
+// plugin's stderr goes to ddlogs's for debugging.
+
+const (
+	// transformMemoryPages caps a plugin's memory, in 64 KiB pages (64 MiB).
+	transformMemoryPages = 1024
+
+	// transformTimeout bounds one call into a plugin, so one stuck in a
+	// loop fails the export instead of hanging it.
+	transformTimeout = 5 * time.Second
+)
+
+// wasmTransform is one loaded plugin.
+type wasmTransform struct {
+	name      string
+	mod       api.Module
+	alloc     api.Function
+	free      api.Function
+	transform api.Function
+}
+
+// wasmTransforms is a chain of plugins sharing one runtime, applied in
+// order.
+type wasmTransforms struct {
+	runtime wazero.Runtime
+	plugins []*wasmTransform
+}
+
+// loadTransforms compiles and instantiates the plugin at each path.
+func loadTransforms(paths []string) (*wasmTransforms, error) {
+	ctx := context.Background()
+	cfg := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(transformMemoryPages).
+		WithCloseOnContextDone(true)
+	t := &wasmTransforms{runtime: wazero.NewRuntimeWithConfig(ctx, cfg)}
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, t.runtime); err != nil {
+		t.close()
+		return nil, err
+	}
+	for i, path := range paths {
+		p, err := t.load(ctx, i, path)
+		if err != nil {
+			t.close()
+			return nil, fmt.Errorf("loading transform %s: %w", path, err)
+		}
+		t.plugins = append(t.plugins, p)
+	}
+	return t, nil
+}
+
+func (t *wasmTransforms) load(ctx context.Context, i int, path string) (*wasmTransform, error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := t.runtime.CompileModule(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	cfg := wazero.NewModuleConfig().
+		WithName(fmt.Sprintf("transform-%d", i)).
+		WithStartFunctions("_initialize").
+		WithStderr(os.Stderr)
+	mod, err := t.runtime.InstantiateModule(ctx, compiled, cfg)
+	if err != nil {
+		return nil, err
+	}
+	p := &wasmTransform{
+		name:      strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		mod:       mod,
+		alloc:     mod.ExportedFunction("alloc"),
+		free:      mod.ExportedFunction("free"),
+		transform: mod.ExportedFunction("transform"),
+	}
+	if p.alloc == nil || p.transform == nil || mod.Memory() == nil {
+		return nil, fmt.Errorf("module must export memory, alloc and transform")
+	}
+	return p, nil
+}
+
+func (t *wasmTransforms) close() {
+	t.runtime.Close(context.Background())
+}
+
+// apply passes one log's JSON through the plugin; nil means drop it.
+func (p *wasmTransform) apply(in []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), transformTimeout)
+	defer cancel()
+	mem := p.mod.Memory()
+
+	res, err := p.alloc.Call(ctx, uint64(len(in)))
+	if err != nil {
+		return nil, fmt.Errorf("alloc: %w", err)
+	}
+	inPtr := uint32(res[0])
+	if !mem.Write(inPtr, in) {
+		return nil, fmt.Errorf("alloc returned a buffer outside memory")
+	}
+	res, err = p.transform.Call(ctx, uint64(inPtr), uint64(len(in)))
+	if err != nil {
+		return nil, err
+	}
+	outPtr, outLen := uint32(res[0]>>32), uint32(res[0])
+	var out []byte
+	if outLen > 0 {
+		b, ok := mem.Read(outPtr, outLen)
+		if !ok {
+			return nil, fmt.Errorf("result is outside memory")
+		}
+		out = bytes.Clone(b)
+	}
+
+	if p.free != nil {
+		if _, err := p.free.Call(ctx, uint64(inPtr), uint64(len(in))); err != nil {
+			return nil, fmt.Errorf("free: %w", err)
+		}
+		if outLen > 0 && outPtr != inPtr {
+			if _, err := p.free.Call(ctx, uint64(outPtr), uint64(outLen)); err != nil {
+				return nil, fmt.Errorf("free: %w", err)
+			}
+		}
+	}
+	return out, nil
+}
+
+// transformWriter wraps another logWriter, passing every log through the
+// transform plugins first. A log any plugin drops is not written. A log a
+// plugin fails on, or whose result is not a log, stops the export unless
+// rejectFile is set, when it is appended there and skipped.
+type transformWriter struct {
+	logWriter
+	transforms *wasmTransforms
+	rejectFile string
+	dropped    int
+	rejected   int
+	quiet      bool
+}
+
+func newTransformWriter(inner logWriter, transforms *wasmTransforms) *transformWriter {
+	return &transformWriter{logWriter: inner, transforms: transforms}
+}
+
+func (w *transformWriter) WriteLog(log datadogV2.Log) error {
+	b, err := json.Marshal(log)
+	if err != nil {
+		return fmt.Errorf("encoding log %s for transform: %w", log.GetId(), err)
+	}
+	for _, p := range w.transforms.plugins {
+		if b, err = p.apply(b); err != nil {
+			return w.reject(log, fmt.Sprintf("transform %s failed: %v", p.name, err))
+		}
+		if b == nil {
+			w.dropped++
+			return nil
+		}
+	}
+	var out datadogV2.Log
+	if err := json.Unmarshal(b, &out); err != nil {
+		return w.reject(log, fmt.Sprintf("transform output is not a log: %v", err))
+	}
+	return w.logWriter.WriteLog(out)
+}
+
+// reject handles a log the transforms failed on: appended to the reject
+// file and skipped when there is one, fatal otherwise.
+func (w *transformWriter) reject(log datadogV2.Log, reason string) error {
+	if w.rejectFile == "" {
+		return fmt.Errorf("log %s: %s", log.GetId(), reason)
+	}
+	if err := writeReject(w.rejectFile, &rejectError{Log: log, Reason: reason}); err != nil {
+		return err
+	}
+	w.rejected++
+	return nil
+}
+
+func (w *transformWriter) End() error {
+	if err := w.logWriter.End(); err != nil {
+		return err
+	}
+	if w.quiet {
+		return nil
+	}
+	if w.dropped > 0 {
+		fmt.Fprintf(os.Stderr, "\nTransforms: %d log(s) dropped\n", w.dropped)
+	}
+	if w.rejected > 0 {
+		fmt.Fprintf(os.Stderr, "\nTransforms: %d log(s) failed, written to %s\n", w.rejected, w.rejectFile)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// truncateWriter wraps another logWriter and shortens the message to
+// maxMessage characters and every string attribute, at any depth, to
+// maxCell characters, marking each cut with "…". Cuts are counted per
+// column for the summary printed when the output ends, and with a
+// sidecar, listed one per row as log ID, column and original length.
+type truncateWriter struct {
+	logWriter
+	maxMessage int
+	maxCell    int
+
+	counts map[string]int
+	logs   int
+
+	sidecarPath string
+	sidecarFile *os.File
+	sidecarBuf  *bufio.Writer
+	sidecar     *csv.Writer
+
+	// quiet keeps the summary off stderr.
+	quiet bool
+}
+
+func newTruncateWriter(inner logWriter, maxMessage, maxCell int, sidecar string) (*truncateWriter, error) {
+	w := &truncateWriter{
+		logWriter:  inner,
+		maxMessage: maxMessage,
+		maxCell:    maxCell,
+		counts:     make(map[string]int),
+	}
+	if sidecar != "" {
+		f, err := os.Create(sidecar)
+		if err != nil {
+			return nil, fmt.Errorf("creating truncation log: %w", err)
+		}
+		w.sidecarPath = sidecar
+		w.sidecarFile = f
+		w.sidecarBuf = bufio.NewWriter(f)
+		w.sidecar = csv.NewWriter(w.sidecarBuf)
+		w.sidecar.Write([]string{"id", "column", "original_length"})
+	}
+	return w, nil
+}
+
+func (w *truncateWriter) WriteLog(log datadogV2.Log) error {
+	cut := false
+	attrs := log.GetAttributes()
+	if msg, ok := attrs.GetMessageOk(); ok && w.maxMessage > 0 {
+		if s, n := w.truncate(*msg, w.maxMessage); n > 0 {
+			attrs.SetMessage(s)
+			w.record(log.GetId(), "message", n)
+			cut = true
+		}
+	}
+	if custom := attrs.GetAttributes(); custom != nil && w.maxCell > 0 {
+		if w.truncateAttrs(log.GetId(), custom, "") {
+			cut = true
+		}
+	}
+	if cut {
+		w.logs++
+		log.SetAttributes(attrs)
+	}
+	return w.logWriter.WriteLog(log)
+}
+
+// truncateAttrs shortens the string values of m longer than maxCell,
+// descending into nested objects and arrays, and reports whether any was.
+// Columns are named by their dotted path, as in CSV output.
+func (w *truncateWriter) truncateAttrs(id string, m map[string]interface{}, prefix string) bool {
+	cut := false
+	for key, v := range m {
+		path := prefix + key
+		switch val := v.(type) {
+		case string:
+			if s, n := w.truncate(val, w.maxCell); n > 0 {
+				m[key] = s
+				w.record(id, path, n)
+				cut = true
+			}
+		case map[string]interface{}:
+			if w.truncateAttrs(id, val, path+".") {
+				cut = true
+			}
+		case []interface{}:
+			for i, item := range val {
+				switch e := item.(type) {
+				case string:
+					if s, n := w.truncate(e, w.maxCell); n > 0 {
+						val[i] = s
+						w.record(id, path, n)
+						cut = true
+					}
+				case map[string]interface{}:
+					if w.truncateAttrs(id, e, path+".") {
+						cut = true
+					}
+				}
+			}
+		}
+	}
+	return cut
+}
+
+// truncate returns s cut to max characters and its original length, or s
+// unchanged and 0 when it fits.
+func (w *truncateWriter) truncate(s string, max int) (string, int) {
+	n := utf8.RuneCountInString(s)
+	if n <= max {
+		return s, 0
+	}
+	return truncateRunes(s, max), n
+}
+
+func (w *truncateWriter) record(id, column string, length int) {
+	w.counts[column]++
+	if w.sidecar != nil {
+		w.sidecar.Write([]string{id, column, strconv.Itoa(length)})
+	}
+}
+
+func (w *truncateWriter) FlushPage() error {
+	if err := w.logWriter.FlushPage(); err != nil {
+		return err
+	}
+	return w.flushSidecar()
+}
+
+func (w *truncateWriter) flushSidecar() error {
+	if w.sidecar == nil {
+		return nil
+	}
+	w.sidecar.Flush()
+	if err := w.sidecar.Error(); err != nil {
+		return fmt.Errorf("writing truncation log: %w", err)
+	}
+	if err := w.sidecarBuf.Flush(); err != nil {
+		return fmt.Errorf("writing truncation log: %w", err)
+	}
+	return nil
+}
+
+func (w *truncateWriter) End() error {
+	if err := w.logWriter.End(); err != nil {
+		return err
+	}
+	if w.sidecar != nil {
+		err := w.flushSidecar()
+		if cerr := w.sidecarFile.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("writing truncation log: %w", cerr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if w.quiet {
+		return nil
+	}
+	if len(w.counts) == 0 {
+		fmt.Fprintf(os.Stderr, "\nTruncation: no values were cut\n")
+		return nil
+	}
+
+	cols := make([]string, 0, len(w.counts))
+	total := 0
+	for c, n := range w.counts {
+		cols = append(cols, c)
+		total += n
+	}
+	sort.Slice(cols, func(i, j int) bool {
+		if w.counts[cols[i]] != w.counts[cols[j]] {
+			return w.counts[cols[i]] > w.counts[cols[j]]
+		}
+		return cols[i] < cols[j]
+	})
+	parts := make([]string, len(cols))
+	for i, c := range cols {
+		parts[i] = fmt.Sprintf("%s %d", c, w.counts[c])
+	}
+	fmt.Fprintf(os.Stderr, "\nTruncation: cut %d value(s) in %d log(s): %s\n", total, w.logs, strings.Join(parts, ", "))
+	if w.sidecarPath != "" {
+		fmt.Fprintf(os.Stderr, "Truncated log IDs written to %s\n", w.sidecarPath)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// watchLag is how far before the newest log written each watch poll
+// starts, so logs indexed late are still picked up. Logs read again are
+// dropped by ID.
+const watchLag = 30 * time.Second
+
+// watch runs f, the fetch of the export's time range, and then polls every
+// opts.Watch for the logs since the newest one fetched, handing every page
+// to emit, until ctx ends. A failed poll is reported and retried at the
+// next interval; the logs it missed are fetched then.
+func (h *DDHandler) watch(ctx context.Context, api *datadogV2.LogsApi, opts QueryOptions, f *fetcher, progress *fetchProgress, emit func(fetchResult) bool) error {
+	seen := newSeenSet()
+	f.seen = seen
+	since := time.Now()
+	if err := f.run(ctx, emit); err != nil || ctx.Err() != nil {
+		return err
+	}
+	if !opts.Quiet && !opts.ProgressJSON {
+		progress.mu.Lock()
+		fmt.Fprintf(os.Stderr, lineStart()+"Fetched %d logs; watching for new ones every %s (Ctrl-C to stop)\n", progress.logs, opts.Watch)
+		progress.mu.Unlock()
+	}
+	page := f.page
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(opts.Watch):
+		}
+		if seen.newest.After(since) {
+			since = seen.newest
+		}
+		from, now := since.Add(-watchLag).UTC().Format(apiTimeLayout), time.Now().UTC().Format(apiTimeLayout)
+		poll := newFetcher(h, api, opts, logWindow{from: from, to: now}, newWindowNarrower(from, now, time.Now()), progress)
+		poll.seen = seen
+		poll.page = page
+		err := poll.run(ctx, emit)
+		page = poll.page
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\npoll failed, retrying in %s: %v\n", opts.Watch, err)
+		}
+	}
+}
+
+// filter drops the logs (and their raw events) already in s, adds the rest
+// and forgets the logs older than any later watch poll can return.
+func (s *seenSet) filter(logs []datadogV2.Log, raw []json.RawMessage) ([]datadogV2.Log, []json.RawMessage) {
+	keptLogs := logs[:0]
+	var keptRaw []json.RawMessage
+	for i, log := range logs {
+		if !s.add(log) {
+			continue
+		}
+		keptLogs = append(keptLogs, log)
+		if raw != nil {
+			keptRaw = append(keptRaw, raw[i])
+		}
+	}
+	s.prune(s.newest.Add(-watchLag))
+	return keptLogs, keptRaw
+}
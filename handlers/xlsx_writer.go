@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"github.com/xuri/excelize/v2"
+)
+
+// xlsxSheet is the name of the worksheet an xlsx export is written to.
+const xlsxSheet = "logs"
+
+// xlsxMaxLogs is how many logs fit on one worksheet below the header row.
+const xlsxMaxLogs = excelize.TotalRows - 1
+
+// xlsxMaxWidth caps an autosized column, so a long message column does not
+// push the rest of the sheet off screen.
+const xlsxMaxWidth = 80
+
+// xlsxWriter writes an Excel workbook with one worksheet: a bold header row
+// kept frozen while scrolling, and columns sized to the header and the
+// first page of logs. timestamp cells are real Excel date-times (UTC, or
+// the --tz zone), and numeric and boolean attributes are numbers and
+// booleans rather than text.
+//
+// Columns are chosen like CSV's: the fixed columns, tag columns, then every
+// attribute seen on the first page. Rows are streamed through excelize to a
+// temporary file and the workbook is written to bw by End, since an xlsx
+// file is a zip archive that cannot be written incrementally.
+type xlsxWriter struct {
+	bw      *bufio.Writer
+	file    *excelize.File
+	sheet   *excelize.StreamWriter
+	strict  bool
+	fixed   []string
+	tagCols []string
+
+	headers []string
+	attrSet map[string]bool
+	buffer  []datadogV2.Log
+	started bool
+	rows    int
+
+	dateStyle int
+}
+
+func newXLSXWriter(bw *bufio.Writer) *xlsxWriter {
+	return &xlsxWriter{
+		bw:      bw,
+		attrSet: make(map[string]bool),
+		fixed:   fixedColumns,
+	}
+}
+
+func (w *xlsxWriter) Start() {}
+
+func (w *xlsxWriter) WriteLog(log datadogV2.Log) error {
+	if w.strict {
+		if err := validateLog(log); err != nil {
+			return err
+		}
+	}
+	attrs := log.GetAttributes()
+	for key := range attrs.GetAttributes() {
+		if w.strict && w.started && !w.attrSet[key] {
+			return &rejectError{Log: log, Reason: fmt.Sprintf("attribute %q first appeared after the xlsx header was written", key)}
+		}
+		w.attrSet[key] = true
+	}
+	if !w.started {
+		w.buffer = append(w.buffer, log)
+		return nil
+	}
+	return w.writeRow(log)
+}
+
+// begin creates the workbook and writes the header and the buffered first
+// page, sizing the columns to them.
+func (w *xlsxWriter) begin() error {
+	w.headers = headerColumns(w.fixed, w.tagCols, w.attrSet)
+
+	w.file = excelize.NewFile()
+	if err := w.file.SetSheetName("Sheet1", xlsxSheet); err != nil {
+		return err
+	}
+	sheet, err := w.file.NewStreamWriter(xlsxSheet)
+	if err != nil {
+		return err
+	}
+	w.sheet = sheet
+	layout := "yyyy-mm-dd hh:mm:ss.000"
+	if w.dateStyle, err = w.file.NewStyle(&excelize.Style{CustomNumFmt: &layout}); err != nil {
+		return err
+	}
+	bold, err := w.file.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]interface{}, len(w.buffer))
+	for i, log := range w.buffer {
+		rows[i] = w.cells(log)
+	}
+	for col, name := range w.headers {
+		width := utf8.RuneCountInString(name)
+		for _, row := range rows {
+			width = max(width, xlsxCellWidth(row[col]))
+		}
+		if err := w.sheet.SetColWidth(col+1, col+1, float64(min(width+2, xlsxMaxWidth))); err != nil {
+			return err
+		}
+	}
+	if err := w.sheet.SetPanes(&excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"}); err != nil {
+		return err
+	}
+
+	header := make([]interface{}, len(w.headers))
+	for i, name := range w.headers {
+		header[i] = excelize.Cell{StyleID: bold, Value: name}
+	}
+	if err := w.sheet.SetRow("A1", header); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := w.setRow(row); err != nil {
+			return err
+		}
+	}
+	w.buffer = nil
+	w.started = true
+	return nil
+}
+
+func (w *xlsxWriter) writeRow(log datadogV2.Log) error {
+	return w.setRow(w.cells(log))
+}
+
+func (w *xlsxWriter) setRow(row []interface{}) error {
+	if w.rows >= xlsxMaxLogs {
+		return fmt.Errorf("an xlsx worksheet holds at most %d logs; narrow the range or use --limit or --rotate-rows", xlsxMaxLogs)
+	}
+	w.rows++
+	cell, err := excelize.CoordinatesToCellName(1, w.rows+1)
+	if err != nil {
+		return err
+	}
+	return w.sheet.SetRow(cell, row)
+}
+
+// cells renders a log as worksheet cells, in header order. Empty values are
+// left as blank cells.
+func (w *xlsxWriter) cells(log datadogV2.Log) []interface{} {
+	attrs := log.GetAttributes()
+	custom := attrs.GetAttributes()
+	row := make([]interface{}, len(w.headers))
+	for i, col := range w.headers {
+		if i >= len(w.fixed) && i < len(w.fixed)+len(w.tagCols) {
+			row[i] = xlsxText(tagValue(attrs.GetTags(), col))
+			continue
+		}
+		if col == "timestamp" {
+			if t, ok := attrs.GetTimestampOk(); ok && t != nil {
+				// Excel date-times have no zone; write the wall clock of
+				// the timestamp's, which is UTC unless --tz moved it.
+				row[i] = excelize.Cell{StyleID: w.dateStyle, Value: *t}
+			}
+			continue
+		}
+		if i >= len(w.fixed) {
+			switch v := custom[col].(type) {
+			case float64, bool:
+				row[i] = v
+				continue
+			}
+		}
+		row[i] = xlsxText(columnValue(&attrs, custom, col))
+	}
+	return row
+}
+
+// xlsxText returns s as a cell value, nil for a blank cell. Control
+// characters XML cannot carry are escaped (see textCell).
+func xlsxText(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return textCell(s)
+}
+
+// xlsxCellWidth estimates how many characters wide a cell displays.
+func xlsxCellWidth(v interface{}) int {
+	switch v := v.(type) {
+	case nil:
+		return 0
+	case string:
+		return utf8.RuneCountInString(v)
+	case excelize.Cell:
+		if _, ok := v.Value.(time.Time); ok {
+			return len("2006-01-02 15:04:05.000")
+		}
+		return xlsxCellWidth(v.Value)
+	default:
+		return len(fmt.Sprint(v))
+	}
+}
+
+func (w *xlsxWriter) FlushPage() error {
+	if !w.started {
+		return w.begin()
+	}
+	return nil
+}
+
+func (w *xlsxWriter) End() error {
+	if !w.started {
+		if err := w.begin(); err != nil {
+			return err
+		}
+	}
+	defer w.file.Close()
+	if err := w.sheet.Flush(); err != nil {
+		return fmt.Errorf("writing worksheet: %w", err)
+	}
+	if err := w.file.Write(w.bw); err != nil {
+		return fmt.Errorf("writing workbook: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+// Package httpapi serves Datadog log searches over HTTP for ddlogs
+// http-serve: POST /query streams the matching logs back as NDJSON or
+// Server-Sent Events.
+package httpapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"github.com/dneil5648/dd-logs-cli/handlers"
+)
+
+// maxRequestBody caps the JSON body of a query request.
+const maxRequestBody = 1 << 20
+
+// Request is the body of POST /query.
+type Request struct {
+	// Query is the Datadog logs query, e.g. "service:api status:error".
+	Query string `json:"query"`
+
+	// From and To are the window, as for ddlogs search --from/--to: a
+	// duration ago (15m, 24h, 7d) or an absolute time. Default 15m and now.
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	// Indexes limits the search to these log indexes.
+	Indexes []string `json:"indexes"`
+
+	// Limit stops after this many logs; 0 means the server's maximum.
+	Limit int `json:"limit"`
+
+	// Descending returns the newest logs first.
+	Descending bool `json:"descending"`
+
+	// Format is "ndjson" or "sse"; when empty, an Accept header of
+	// text/event-stream picks SSE and anything else NDJSON.
+	Format string `json:"format"`
+}
+
+// Config holds the limits and access rules of a Server.
+type Config struct {
+	// Tokens, when not empty, are the bearer tokens a request must carry
+	// one of in its Authorization header.
+	Tokens []string
+
+	// MaxLimit, when positive, caps the logs returned per request; a
+	// request asking for more, or for no limit, gets MaxLimit.
+	MaxLimit int
+
+	// MaxDuration, when positive, ends a request that has been streaming
+	// this long.
+	MaxDuration time.Duration
+}
+
+// Server is the HTTP handler of ddlogs http-serve. Every request searches
+// with the one DDHandler, so clients need no Datadog keys of their own.
+type Server struct {
+	handler *handlers.DDHandler
+	config  Config
+	mux     *http.ServeMux
+}
+
+// NewServer returns a Server searching through h.
+func NewServer(h *handlers.DDHandler, config Config) *Server {
+	s := &Server{handler: h, config: config, mux: http.NewServeMux()}
+	s.mux.HandleFunc("POST /query", s.query)
+	s.mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok\n")
+	})
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// authorized reports whether r carries one of the configured tokens.
+func (s *Server) authorized(r *http.Request) bool {
+	if len(s.config.Tokens) == 0 {
+		return true
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	for _, token := range s.config.Tokens {
+		if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(got)), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) query(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ddlogs"`)
+		httpError(w, http.StatusUnauthorized, "missing or wrong bearer token")
+		return
+	}
+	var req Request
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, fmt.Sprintf("decoding request: %v", err))
+		return
+	}
+	opts, err := s.queryOptions(req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	format := req.Format
+	if format == "" {
+		format = "ndjson"
+		if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			format = "sse"
+		}
+	}
+	if format != "ndjson" && format != "sse" {
+		httpError(w, http.StatusBadRequest, fmt.Sprintf("format must be ndjson or sse, not %q", req.Format))
+		return
+	}
+
+	ctx := r.Context()
+	if s.config.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.config.MaxDuration)
+		defer cancel()
+	}
+	start := time.Now()
+	out := &responseStream{w: w, sse: format == "sse"}
+	out.begin()
+	err = s.handler.StreamLogs(ctx, opts, func(logs []datadogV2.Log) error {
+		for _, log := range logs {
+			if err := out.log(log); err != nil {
+				return err
+			}
+		}
+		return out.flush()
+	})
+	switch {
+	case err == nil:
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		err = fmt.Errorf("request exceeded the server's %s limit", s.config.MaxDuration)
+	case r.Context().Err() != nil:
+		err = errors.New("client disconnected")
+	}
+	out.end(err)
+
+	result := "ok"
+	if err != nil {
+		result = err.Error()
+	}
+	fmt.Fprintf(os.Stderr, "%s POST /query %q %s..%s %s: %d logs in %.1fs, %s\n",
+		r.RemoteAddr, opts.Query, opts.From, opts.To, format, out.count, time.Since(start).Seconds(), result)
+}
+
+// queryOptions checks req and turns it into the options of an export,
+// applying the server's limit.
+func (s *Server) queryOptions(req Request) (handlers.QueryOptions, error) {
+	if req.Query == "" {
+		return handlers.QueryOptions{}, errors.New("query is required")
+	}
+	if req.Limit < 0 {
+		return handlers.QueryOptions{}, errors.New("limit must not be negative")
+	}
+	opts := handlers.QueryOptions{
+		Query:      req.Query,
+		From:       req.From,
+		To:         req.To,
+		Indexes:    req.Indexes,
+		Limit:      req.Limit,
+		Descending: req.Descending,
+	}
+	if opts.From == "" {
+		opts.From = "15m"
+	}
+	if opts.To == "" {
+		opts.To = "now"
+	}
+	// The window is checked here, while a bad one can still be a 400:
+	// once the stream starts the status is already 200.
+	start, end, err := handlers.ResolveTimeRange(opts.From, opts.To)
+	if err != nil {
+		return handlers.QueryOptions{}, err
+	}
+	if !start.Before(end) {
+		return handlers.QueryOptions{}, fmt.Errorf("from %s is not before to %s", opts.From, opts.To)
+	}
+	if limit := s.config.MaxLimit; limit > 0 && (opts.Limit == 0 || opts.Limit > limit) {
+		opts.Limit = limit
+	}
+	return opts, nil
+}
+
+// responseStream writes logs to an HTTP response as NDJSON lines or SSE
+// events, flushing after every page.
+type responseStream struct {
+	w     http.ResponseWriter
+	sse   bool
+	count int
+}
+
+func (s *responseStream) begin() {
+	h := s.w.Header()
+	if s.sse {
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+	} else {
+		h.Set("Content-Type", "application/x-ndjson")
+	}
+	h.Set("X-Accel-Buffering", "no")
+	s.w.WriteHeader(http.StatusOK)
+	s.flush()
+}
+
+func (s *responseStream) log(log datadogV2.Log) error {
+	b, err := json.Marshal(log)
+	if err != nil {
+		return fmt.Errorf("encoding log %s: %w", log.GetId(), err)
+	}
+	if s.sse {
+		_, err = fmt.Fprintf(s.w, "event: log\nid: %s\ndata: %s\n\n", log.GetId(), b)
+	} else {
+		_, err = fmt.Fprintf(s.w, "%s\n", b)
+	}
+	s.count++
+	return err
+}
+
+func (s *responseStream) flush() error {
+	return http.NewResponseController(s.w).Flush()
+}
+
+// end closes the stream. The status line is sent before the first log, so
+// an export that fails partway reports it in the body: an "error" event
+// for SSE, a final {"error": ...} line for NDJSON. A complete SSE stream
+// ends with a "done" event carrying the count.
+func (s *responseStream) end(err error) {
+	switch {
+	case err != nil && s.sse:
+		b, _ := json.Marshal(map[string]string{"error": err.Error()})
+		fmt.Fprintf(s.w, "event: error\ndata: %s\n\n", b)
+	case err != nil:
+		json.NewEncoder(s.w).Encode(map[string]string{"error": err.Error()})
+	case s.sse:
+		fmt.Fprintf(s.w, "event: done\ndata: {\"count\":%d}\n\n", s.count)
+	}
+	s.flush()
+}
+
+// httpError writes a JSON error response.
+func httpError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
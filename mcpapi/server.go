@@ -0,0 +1,328 @@
+// Package mcpapi serves Datadog log searches to AI assistants over the Model
+// Context Protocol for ddlogs mcp-serve. Its tools search, count and
+// aggregate logs with the server's credentials, under row caps and
+// redaction the assistant cannot lift.
+package mcpapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dneil5648/dd-logs-cli/handlers"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// Config holds the guardrails of a Server.
+type Config struct {
+	// MaxRows caps the logs a search returns and the values kept per
+	// group-by facet of an aggregation; a call asking for more, or for no
+	// limit, gets MaxRows.
+	MaxRows int
+
+	// MaxCellLen, when positive, cuts the message and every string
+	// attribute of a search result to this many characters.
+	MaxCellLen int
+
+	// MaxRange, when positive, is the longest from..to window a call may
+	// ask for.
+	MaxRange time.Duration
+
+	// MaxDuration, when positive, ends a call that has been running this
+	// long.
+	MaxDuration time.Duration
+
+	// Redact masks every match of these patterns in search results and
+	// aggregation tables.
+	Redact []*regexp.Regexp
+
+	// Classification, when set, removes the columns classified above
+	// MaxClassification from search results and refuses to aggregate by
+	// them.
+	Classification    *handlers.Classification
+	MaxClassification handlers.ClassificationLevel
+}
+
+// SearchInput is the input of the search_logs tool.
+type SearchInput struct {
+	Query      string   `json:"query" jsonschema:"Datadog logs query, e.g. service:api status:error"`
+	From       string   `json:"from,omitempty" jsonschema:"Start of the window: a duration ago such as 15m, 24h or 7d, or an absolute time (default 15m)"`
+	To         string   `json:"to,omitempty" jsonschema:"End of the window, in the same syntax as from (default now)"`
+	Indexes    []string `json:"indexes,omitempty" jsonschema:"Only search these log indexes"`
+	Limit      int      `json:"limit,omitempty" jsonschema:"Most logs to return; capped by the server"`
+	Descending bool     `json:"descending,omitempty" jsonschema:"Return the newest logs first"`
+	Format     string   `json:"format,omitempty" jsonschema:"csv (the default, most compact) or ndjson"`
+}
+
+// CountInput is the input of the count_logs tool.
+type CountInput struct {
+	Query   string   `json:"query" jsonschema:"Datadog logs query, e.g. service:api status:error"`
+	From    string   `json:"from,omitempty" jsonschema:"Start of the window: a duration ago such as 15m, 24h or 7d, or an absolute time (default 15m)"`
+	To      string   `json:"to,omitempty" jsonschema:"End of the window, in the same syntax as from (default now)"`
+	Indexes []string `json:"indexes,omitempty" jsonschema:"Only count these log indexes"`
+}
+
+// AggregateInput is the input of the aggregate_logs tool.
+type AggregateInput struct {
+	Query   string   `json:"query" jsonschema:"Datadog logs query, e.g. service:api status:error"`
+	From    string   `json:"from,omitempty" jsonschema:"Start of the window: a duration ago such as 15m, 24h or 7d, or an absolute time (default 15m)"`
+	To      string   `json:"to,omitempty" jsonschema:"End of the window, in the same syntax as from (default now)"`
+	Indexes []string `json:"indexes,omitempty" jsonschema:"Only aggregate these log indexes"`
+	GroupBy []string `json:"group_by,omitempty" jsonschema:"Facets to split buckets by, e.g. service or @http.status_code"`
+	Compute []string `json:"compute,omitempty" jsonschema:"Values per bucket: count, or a function and measure such as avg:@duration or pc99:@duration (default count)"`
+	Limit   int      `json:"limit,omitempty" jsonschema:"Values kept per group_by facet, the top ones by the first compute; capped by the server"`
+	Rollup  string   `json:"rollup,omitempty" jsonschema:"Interval such as 5m or 1h that turns every compute into a timeseries"`
+}
+
+// Server exposes a DDHandler's searches as MCP tools.
+type Server struct {
+	handler *handlers.DDHandler
+	config  Config
+}
+
+// NewServer returns an MCP server whose tools search through h within
+// config's guardrails.
+func NewServer(h *handlers.DDHandler, config Config) *mcp.Server {
+	s := &Server{handler: h, config: config}
+	server := mcp.NewServer(&mcp.Implementation{Name: "ddlogs", Version: "1"}, &mcp.ServerOptions{
+		Instructions: "Tools for reading Datadog logs. Start with count_logs or aggregate_logs to " +
+			"size a query, then fetch examples with search_logs. Results are capped and " +
+			"redacted by the server; narrow the query or window rather than raising limits.",
+	})
+	readOnly := &mcp.ToolAnnotations{ReadOnlyHint: true}
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "search_logs",
+		Description: s.searchDescription(),
+		Annotations: readOnly,
+	}, s.search)
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "count_logs",
+		Description: "Count the Datadog logs matching a query in a time window, without fetching any.",
+		Annotations: readOnly,
+	}, s.count)
+	mcp.AddTool(server, &mcp.Tool{
+		Name: "aggregate_logs",
+		Description: "Aggregate the Datadog logs matching a query as a CSV table: counts or measures " +
+			"(avg, sum, min, max, percentiles, cardinality) per group of facet values, optionally " +
+			"as a timeseries.",
+		Annotations: readOnly,
+	}, s.aggregate)
+	return server
+}
+
+// searchDescription describes search_logs with the server's caps, so the
+// assistant knows them before it asks.
+func (s *Server) searchDescription() string {
+	d := "Fetch the Datadog logs matching a query in a time window, as CSV (one row per log, " +
+		"attributes as columns) or NDJSON."
+	if s.config.MaxRows > 0 {
+		d += fmt.Sprintf(" At most %d logs are returned per call.", s.config.MaxRows)
+	}
+	if len(s.config.Redact) > 0 || s.config.Classification != nil {
+		d += " Sensitive values are redacted."
+	}
+	return d
+}
+
+func (s *Server) search(ctx context.Context, req *mcp.CallToolRequest, in SearchInput) (*mcp.CallToolResult, any, error) {
+	from, to, err := s.window(in.Query, in.From, in.To)
+	if err != nil {
+		return nil, nil, err
+	}
+	format := in.Format
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "ndjson" {
+		return nil, nil, fmt.Errorf("format must be csv or ndjson, not %q", in.Format)
+	}
+	if in.Limit < 0 {
+		return nil, nil, errors.New("limit must not be negative")
+	}
+	limit := capped(in.Limit, s.config.MaxRows)
+	redacted := 0
+	opts := handlers.QueryOptions{
+		Query:             in.Query,
+		From:              from,
+		To:                to,
+		Indexes:           in.Indexes,
+		Limit:             limit,
+		Descending:        in.Descending,
+		Format:            format,
+		MaxMessageLen:     s.config.MaxCellLen,
+		MaxCellLen:        s.config.MaxCellLen,
+		Redact:            s.config.Redact,
+		Redacted:          &redacted,
+		Classification:    s.config.Classification,
+		MaxClassification: s.config.MaxClassification,
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+	var buf bytes.Buffer
+	n, err := s.handler.StreamOutput(ctx, opts, &buf)
+	result := fmt.Sprintf("%d logs", n)
+	if redacted > 0 {
+		result += fmt.Sprintf(", %d value(s) redacted", redacted)
+	}
+	s.logCall("search_logs", in.Query, from, to, result, start, err)
+	if err != nil {
+		return nil, nil, s.callError(ctx, err)
+	}
+
+	text := buf.String()
+	if n == 0 {
+		text = "No logs matched."
+	} else if limit > 0 && n >= limit {
+		text += fmt.Sprintf("\n(%d logs returned; more may match. Narrow the query or window, or use count_logs or aggregate_logs.)\n", n)
+	}
+	return textResult(text), nil, nil
+}
+
+func (s *Server) count(ctx context.Context, req *mcp.CallToolRequest, in CountInput) (*mcp.CallToolResult, any, error) {
+	from, to, err := s.window(in.Query, in.From, in.To)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+	n, err := s.handler.CountLogs(ctx, handlers.CountOptions{Query: in.Query, From: from, To: to, Indexes: in.Indexes})
+	s.logCall("count_logs", in.Query, from, to, fmt.Sprintf("%d", n), start, err)
+	if err != nil {
+		return nil, nil, s.callError(ctx, err)
+	}
+	return textResult(fmt.Sprintf("%d", n)), nil, nil
+}
+
+func (s *Server) aggregate(ctx context.Context, req *mcp.CallToolRequest, in AggregateInput) (*mcp.CallToolResult, any, error) {
+	from, to, err := s.window(in.Query, in.From, in.To)
+	if err != nil {
+		return nil, nil, err
+	}
+	if in.Limit < 0 {
+		return nil, nil, errors.New("limit must not be negative")
+	}
+	opts := handlers.AggregateOptions{
+		Query:   in.Query,
+		From:    from,
+		To:      to,
+		Format:  "csv",
+		GroupBy: in.GroupBy,
+		Limit:   int64(capped(in.Limit, s.config.MaxRows)),
+		Indexes: in.Indexes,
+		Rollup:  in.Rollup,
+	}
+	if len(in.Compute) == 0 {
+		in.Compute = []string{"count"}
+	}
+	for _, spec := range in.Compute {
+		c, err := handlers.ParseCompute(spec)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts.Compute = append(opts.Compute, c)
+	}
+	if err := s.checkFacets(opts); err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+	var buf bytes.Buffer
+	n, err := s.handler.AggregateTo(ctx, opts, &buf)
+	s.logCall("aggregate_logs", in.Query, from, to, fmt.Sprintf("%d buckets", n), start, err)
+	if err != nil {
+		return nil, nil, s.callError(ctx, err)
+	}
+	return textResult(handlers.RedactString(buf.String(), s.config.Redact)), nil, nil
+}
+
+// checkFacets refuses an aggregation over a facet or measure the
+// classification keeps out of search results, since its buckets would show
+// the values anyway.
+func (s *Server) checkFacets(opts handlers.AggregateOptions) error {
+	if s.config.Classification == nil {
+		return nil
+	}
+	fields := append([]string(nil), opts.GroupBy...)
+	for _, c := range opts.Compute {
+		if c.Metric != "" {
+			fields = append(fields, c.Metric)
+		}
+	}
+	for _, f := range fields {
+		if !s.config.Classification.Allows(f, s.config.MaxClassification) {
+			return fmt.Errorf("%s is classified above %s and cannot be aggregated", f, s.config.MaxClassification)
+		}
+	}
+	return nil
+}
+
+// window checks a call's query and time window, applying the defaults and
+// MaxRange.
+func (s *Server) window(query, from, to string) (string, string, error) {
+	if strings.TrimSpace(query) == "" {
+		return "", "", errors.New("query is required")
+	}
+	if from == "" {
+		from = "15m"
+	}
+	if to == "" {
+		to = "now"
+	}
+	if s.config.MaxRange > 0 {
+		start, end, err := handlers.ResolveTimeRange(from, to)
+		if err != nil {
+			return "", "", err
+		}
+		if end.Sub(start) > s.config.MaxRange {
+			return "", "", fmt.Errorf("the window %s..%s is longer than the server's %s limit; narrow it", from, to, s.config.MaxRange)
+		}
+	}
+	return from, to, nil
+}
+
+func (s *Server) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.config.MaxDuration > 0 {
+		return context.WithTimeout(ctx, s.config.MaxDuration)
+	}
+	return context.WithCancel(ctx)
+}
+
+// callError explains a failed call, naming the time limit when it was hit.
+func (s *Server) callError(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("the call exceeded the server's %s limit; narrow the query or window", s.config.MaxDuration)
+	}
+	return err
+}
+
+// logCall logs a finished tool call to stderr; stdout carries the protocol.
+func (s *Server) logCall(tool, query, from, to, result string, start time.Time, err error) {
+	status := "ok"
+	if err != nil {
+		status = err.Error()
+	}
+	fmt.Fprintf(os.Stderr, "%s %q %s..%s: %s in %.1fs, %s\n", tool, query, from, to, result, time.Since(start).Seconds(), status)
+}
+
+// capped applies the server's cap to a requested limit, where 0 asks for
+// no limit.
+func capped(limit, ceiling int) int {
+	if ceiling > 0 && (limit == 0 || limit > ceiling) {
+		return ceiling
+	}
+	return limit
+}
+
+func textResult(text string) *mcp.CallToolResult {
+	return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: text}}}
+}
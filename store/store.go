@@ -0,0 +1,177 @@
+// Package store keeps finished exports in a local directory keyed by a
+// fingerprint of the query, time window, format and options that produced
+// them.
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	metaFile = "meta.json"
+
+	// fingerprintLen is how many hex characters of the SHA-256 digest are kept.
+	fingerprintLen = 16
+)
+
+// Entry describes one stored export.
+type Entry struct {
+	Fingerprint string `json:"fingerprint"`
+	Query       string `json:"query"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Format      string `json:"format"`
+	// Options are the other settings that shaped the export, as
+	// "name=value", in the order they were fingerprinted.
+	Options   []string  `json:"options,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// DataFile is the export's file name inside the entry directory.
+	DataFile string `json:"data_file"`
+	Size     int64  `json:"size"`
+}
+
+// Store is a directory of exports, one subdirectory per fingerprint.
+type Store struct {
+	Dir string
+}
+
+// DefaultDir returns $DDLOGS_RESULTS_DIR, or ~/.ddlogs/results when unset.
+func DefaultDir() (string, error) {
+	if dir := os.Getenv("DDLOGS_RESULTS_DIR"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("locating home directory: %w", err)
+	}
+	return filepath.Join(home, ".ddlogs", "results"), nil
+}
+
+// New returns a Store rooted at dir.
+func New(dir string) *Store {
+	return &Store{Dir: dir}
+}
+
+// Fingerprint identifies an export by the exact inputs that produced it:
+// the query, the window, the format and every other option that changes
+// what is written. The window must be absolute; a relative one such as
+// --from 1h names different logs on every run, so callers resolve it
+// first.
+func Fingerprint(query, from, to, format string, options []string) string {
+	parts := append([]string{query, from, to, format}, options...)
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])[:fingerprintLen]
+}
+
+// Path returns the data file path for a new entry, creating its directory.
+func (s *Store) Path(e *Entry) (string, error) {
+	dir := filepath.Join(s.Dir, e.Fingerprint)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("creating result directory: %w", err)
+	}
+	e.DataFile = "data." + e.Format
+	return filepath.Join(dir, e.DataFile), nil
+}
+
+// DataPath returns the path of an existing entry's export.
+func (s *Store) DataPath(e *Entry) string {
+	return filepath.Join(s.Dir, e.Fingerprint, e.DataFile)
+}
+
+// Save records e's metadata once its data file has been written.
+func (s *Store) Save(e *Entry) error {
+	info, err := os.Stat(s.DataPath(e))
+	if err != nil {
+		return fmt.Errorf("stat result data: %w", err)
+	}
+	e.Size = info.Size()
+	e.CreatedAt = time.Now().UTC()
+
+	b, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.Dir, e.Fingerprint, metaFile), b, 0o644)
+}
+
+// Get returns the entry with the given fingerprint, or nil if none is stored.
+func (s *Store) Get(fingerprint string) (*Entry, error) {
+	b, err := os.ReadFile(filepath.Join(s.Dir, fingerprint, metaFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading result %s: %w", fingerprint, err)
+	}
+	var e Entry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, fmt.Errorf("decoding result %s: %w", fingerprint, err)
+	}
+	return &e, nil
+}
+
+// Find resolves a fingerprint or unique fingerprint prefix to its entry.
+func (s *Store) Find(prefix string) (*Entry, error) {
+	entries, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	var match *Entry
+	for i := range entries {
+		if !strings.HasPrefix(entries[i].Fingerprint, prefix) {
+			continue
+		}
+		if match != nil {
+			return nil, fmt.Errorf("result prefix %q is ambiguous", prefix)
+		}
+		match = &entries[i]
+	}
+	if match == nil {
+		return nil, fmt.Errorf("no stored result matches %q", prefix)
+	}
+	return match, nil
+}
+
+// List returns every complete entry, newest first. Directories without
+// metadata (interrupted exports) are skipped.
+func (s *Store) List() ([]Entry, error) {
+	dirs, err := os.ReadDir(s.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading results directory: %w", err)
+	}
+
+	var entries []Entry
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		e, err := s.Get(d.Name())
+		if err != nil {
+			return nil, err
+		}
+		if e != nil {
+			entries = append(entries, *e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.After(entries[j].CreatedAt)
+	})
+	return entries, nil
+}
+
+// Remove deletes an entry and its data.
+func (s *Store) Remove(e *Entry) error {
+	return os.RemoveAll(filepath.Join(s.Dir, e.Fingerprint))
+}